@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/cloudwego/hertz/pkg/app/server"
 	"github.com/joho/godotenv"
@@ -13,7 +14,9 @@ import (
 	myhttp "example.com/agent-server/internal/http"
 	"example.com/agent-server/internal/middleware"
 	"example.com/agent-server/internal/service"
+	"example.com/agent-server/internal/service/integration"
 	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/runner"
 	"example.com/agent-server/internal/store"
 )
 
@@ -48,19 +51,74 @@ func main() {
 	if t, err := strconv.ParseFloat(tempStr, 32); err == nil {
 		temp = t
 	}
-	llmConfig := llm.LLMConfig{
-		ApiKey:      os.Getenv("LLM_API_KEY"),
-		BaseURL:     os.Getenv("LLM_BASE_URL"),
-		ModelName:   os.Getenv("LLM_MODEL_NAME"),
-		Temperature: float32(temp),
+	// 默认 Provider 来自 LLM_* 环境变量；LLM_PROVIDERS 可追加多个具名端点 (JSON 数组)
+	providers := llm.NewRegistry()
+	defaultProvider := os.Getenv("LLM_PROVIDER_NAME")
+	if defaultProvider == "" {
+		defaultProvider = "default"
 	}
-	log.Printf("Init LLM Client: model name:=%s", llmConfig.ModelName)
-	llmClient := llm.NewClient(llmConfig)
+	// 模型上下文窗口（Token），Runner 据此裁剪历史；未配置时用 Runner 的默认值
+	contextWindow := 0
+	if raw := os.Getenv("LLM_CONTEXT_WINDOW"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid LLM_CONTEXT_WINDOW: %q", raw)
+		}
+		contextWindow = n
+	}
+	if err := providers.Register(llm.ProviderConfig{
+		Name:          defaultProvider,
+		Type:          os.Getenv("LLM_PROVIDER_TYPE"),
+		ApiKey:        os.Getenv("LLM_API_KEY"),
+		BaseURL:       os.Getenv("LLM_BASE_URL"),
+		DefaultModel:  os.Getenv("LLM_MODEL_NAME"),
+		Temperature:   float32(temp),
+		ContextWindow: contextWindow,
+	}); err != nil {
+		log.Fatalf("Invalid LLM config: %v", err)
+	}
+	if raw := os.Getenv("LLM_PROVIDERS"); raw != "" {
+		cfgs, err := llm.ParseProviderConfigs(raw)
+		if err != nil {
+			log.Fatalf("Invalid LLM_PROVIDERS: %v", err)
+		}
+		for _, cfg := range cfgs {
+			if err := providers.Register(cfg); err != nil {
+				log.Fatalf("Invalid LLM_PROVIDERS: %v", err)
+			}
+		}
+	}
+	log.Printf("Init LLM Providers: %v (default: %s, model name:=%s)", providers.Names(), defaultProvider, os.Getenv("LLM_MODEL_NAME"))
 	bootstrap.SeedMCPServers(db)
 
 	// 3. 初始化 Handler (注入 db)
 	// 注意：jwt-secret 应该从环境变量读取
-	svc := service.NewService(db, llmClient)
+	// 集成凭证的主密钥：32 字节，hex 或 base64 编码；未配置时集成相关接口不可用
+	var cipher *integration.Cipher
+	if raw := os.Getenv("INTEGRATION_MASTER_KEY"); raw != "" {
+		key, err := integration.ParseMasterKey(raw)
+		if err != nil {
+			log.Fatalf("Invalid INTEGRATION_MASTER_KEY: %v", err)
+		}
+		if cipher, err = integration.NewCipher(key); err != nil {
+			log.Fatalf("Invalid INTEGRATION_MASTER_KEY: %v", err)
+		}
+	} else {
+		log.Println("INTEGRATION_MASTER_KEY not set, user integrations disabled")
+	}
+	svc := service.NewService(db, providers, cipher)
+
+	// MCP Server 健康检查：MCP_HEALTH_INTERVAL 为 Go duration (e.g. 30s)，设为 0 关闭
+	if raw := os.Getenv("MCP_HEALTH_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("Invalid MCP_HEALTH_INTERVAL: %v", err)
+		}
+		svc.MCP.Health.Interval = interval
+	}
+	if svc.MCP.Health.Interval > 0 {
+		svc.MCP.Health.Start()
+	}
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8888"
@@ -73,6 +131,41 @@ func main() {
 
 	h := handler.New(db, jwtSecret, svc)
 
+	// Run 队列：RUN_WORKERS 为同时执行的 Run 数，RUN_USER_CONCURRENCY 为每个用户的上限（0 不限制）
+	if raw := os.Getenv("RUN_WORKERS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid RUN_WORKERS: %q", raw)
+		}
+		h.Engine.Queue.Workers = n
+	}
+	if raw := os.Getenv("RUN_USER_CONCURRENCY"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid RUN_USER_CONCURRENCY: %q", raw)
+		}
+		h.Engine.Queue.PerUser = n
+	}
+	// 模型价格表（JSON，每百万 Token 的美元价格），用于折算 Run 的花费和花费预算
+	if raw := os.Getenv("LLM_PRICING"); raw != "" {
+		prices, err := runner.ParsePriceTable(raw)
+		if err != nil {
+			log.Fatalf("Invalid LLM_PRICING: %v", err)
+		}
+		h.Engine.Prices = prices
+	}
+	// 上次退出时还在执行的 Run：RUN_RECOVERY=resume（默认，重新入队）/ fail（标记失败）/ off（多实例部署时关闭）
+	recovery := os.Getenv("RUN_RECOVERY")
+	switch recovery {
+	case "":
+		recovery = runner.RecoveryResume
+	case runner.RecoveryResume, runner.RecoveryFail, runner.RecoveryOff:
+	default:
+		log.Fatalf("Invalid RUN_RECOVERY: %q", recovery)
+	}
+	h.Engine.RecoverRuns(recovery)
+	h.Engine.Queue.Start()
+
 	// 4. 初始化 Hertz Server，绑定到 127.0.0.1:8888
 	srv := server.New(server.WithHostPorts("127.0.0.1:" + port))
 
@@ -215,6 +215,8 @@ export default function ChatPage({ params }: ChatPageProps) {
 
       const decoder = new TextDecoder();
       let buffer = "";
+      // handoff 后子 Agent 的事件也走这条流，run_id 变化时另起一段
+      let contentRunId = "";
 
       while (true) {
         const { done, value } = await reader.read();
@@ -233,10 +235,13 @@ export default function ChatPage({ params }: ChatPageProps) {
               const event = JSON.parse(jsonStr);
               
               switch (event.type) {
-                case "content":
-                  setStreamingContent(prev => prev + event.content);
+                case "content": {
+                  const sep = contentRunId && event.run_id && event.run_id !== contentRunId ? "\n\n" : "";
+                  contentRunId = event.run_id || contentRunId;
+                  setStreamingContent(prev => prev + sep + event.content);
                   setStreamingStatus("");
                   break;
+                }
                 case "tool_start":
                   setStreamingStatus(`executing: ${event.tool}`);
                   break;
@@ -0,0 +1,12 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashAPIKey API Key 只存 SHA256；Key 本身是高熵随机串，不需要加盐
+func HashAPIKey(raw string) string {
+	hash := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(hash[:])
+}
@@ -18,7 +18,7 @@ func SeedDevOpsTeam(s store.Store) {
 			Type:        "system",
 			Description: "负责需求分析、任务拆解与分派",
 			ModelName:   "gpt-4o",
-			Temperature: 0.2, // 需要稳重
+			Temperature: temperature(0.2), // 需要稳重
 			Tags:        []string{"manager", "orchestrator"},
 			SystemPrompt: `你是一个资深的 DevOps 项目经理。你的职责不是写代码，而是理解用户的需求，并将其拆解为子任务，指派给最合适的专家。
 
@@ -41,8 +41,8 @@ func SeedDevOpsTeam(s store.Store) {
 			Name:        "Software Architect (架构师)",
 			Type:        "system",
 			Description: "负责高层设计与技术决策",
-			ModelName:   "gpt-4o", // 或 o1-preview
-			Temperature: 0.7,      // 需要一点创造力
+			ModelName:   "gpt-4o",         // 或 o1-preview
+			Temperature: temperature(0.7), // 需要一点创造力
 			Tags:        []string{"design", "structure"},
 			SystemPrompt: `你是 Nexus 平台的首席架构师。
 职责：
@@ -57,7 +57,7 @@ func SeedDevOpsTeam(s store.Store) {
 			Type:        "system",
 			Description: "负责高质量代码实现",
 			ModelName:   "claude-3-5-sonnet", // 写代码最强
-			Temperature: 0.1,                 // 极其严谨
+			Temperature: temperature(0.1),    // 极其严谨
 			Tags:        []string{"coding", "implementation"},
 			SystemPrompt: `你是一名拥有 10 年经验的全栈开发工程师，精通 Go 和 Python。
 职责：
@@ -74,7 +74,7 @@ func SeedDevOpsTeam(s store.Store) {
 			Type:        "system",
 			Description: "负责编写测试用例，保证覆盖率",
 			ModelName:   "gpt-4o",
-			Temperature: 0.1,
+			Temperature: temperature(0.1),
 			Tags:        []string{"testing", "coverage"},
 			SystemPrompt: `你是质量保证专家。
 职责：
@@ -89,7 +89,7 @@ func SeedDevOpsTeam(s store.Store) {
 			Type:        "system",
 			Description: "负责代码审查、安全检查",
 			ModelName:   "gpt-4o",
-			Temperature: 0.1,
+			Temperature: temperature(0.1),
 			Tags:        []string{"audit", "security"},
 			SystemPrompt: `你是以严苛著称的代码审查员。
 职责：
@@ -134,8 +134,9 @@ func SeedMCPServers(s store.Store) {
 			IsGlobal:      true, // 全局可用
 			Status:        "active",
 			TransportType: "stdio",
-			// 模拟真实的 uv 启动命令
+			// 由进程内的 Go 实现提供 (builtin)，command/args 是对应的上游 stdio Server，保留作参考
 			ConnectionConfig: map[string]interface{}{
+				"builtin": "git",
 				"command": "uv",
 				"args":    []string{"run", "mcp-server-git", "--repository", "."},
 			},
@@ -150,6 +151,7 @@ func SeedMCPServers(s store.Store) {
 			TransportType: "stdio",
 			// 模拟允许访问当前目录
 			ConnectionConfig: map[string]interface{}{
+				"builtin": "filesystem",
 				"command": "uv",
 				"args":    []string{"run", "mcp-server-filesystem", "--allowed-path", "."},
 			},
@@ -166,3 +168,7 @@ func SeedMCPServers(s store.Store) {
 		}
 	}
 }
+
+func temperature(v float64) *float64 {
+	return &v
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/runner"
 	"example.com/agent-server/internal/store"
 	"example.com/agent-server/pkg/response"
 	"github.com/cloudwego/hertz/pkg/app"
@@ -19,10 +20,12 @@ type AgentReq struct {
 	Description      string                 `json:"description"`
 	ModelName        string                 `json:"model_name"` // 默认 gpt-4o
 	SystemPrompt     string                 `json:"system_prompt" vd:"required"`
-	Temperature      float64                `json:"temperature" vd:">=0,<=2"`
+	Temperature      *float64               `json:"temperature"` // 不传则用 Provider 的默认温度；0 是合法取值
 	KnowledgeBaseIDs []string               `json:"knowledge_base_ids"`
 	Tags             []string               `json:"tags"`
 	ExtraConfig      map[string]interface{} `json:"extra_config"`
+	// Concurrency 该 Agent 同时执行的 Run 上限，0 不限制；更新时不传则保持不变
+	Concurrency *int `json:"concurrency"`
 	// Capabilities 定义该 Agent 能干什么，比如 ["code", "review"]
 	Capabilities []string `json:"capabilities"`
 }
@@ -80,6 +83,18 @@ func (h *Handler) CreateAgent(c context.Context, ctx *app.RequestContext) {
 		response.BadRequest(ctx, err.Error())
 		return
 	}
+	if _, err := runner.ParseToolPolicy(req.ExtraConfig); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+	if req.Concurrency != nil && *req.Concurrency < 0 {
+		response.BadRequest(ctx, "concurrency must be >= 0")
+		return
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2) {
+		response.BadRequest(ctx, "temperature must be between 0 and 2")
+		return
+	}
 
 	// 设置默认值
 	if req.ModelName == "" {
@@ -100,6 +115,9 @@ func (h *Handler) CreateAgent(c context.Context, ctx *app.RequestContext) {
 		Status:           "active",
 		Type:             "user", // 用户创建的标记为 user
 	}
+	if req.Concurrency != nil {
+		agent.Concurrency = *req.Concurrency
+	}
 
 	createdAgent := h.Store.CreateAgent(agent)
 
@@ -135,8 +153,8 @@ func (h *Handler) UpdateAgent(c context.Context, ctx *app.RequestContext) {
 		response.Error(ctx, http.StatusNotFound, 40400, "Agent not found")
 		return
 	}
-	// 只有拥有者或系统管理员(这里简化)能修改
-	if existing.Type != "system" && existing.OwnerUserID != userID {
+	// 只有拥有者或管理员能修改；系统 Agent 没有拥有者，其 ExtraConfig 决定工具策略 / 预算等，仅限管理员
+	if !middleware.IsAdmin(ctx) && (existing.Type == "system" || existing.OwnerUserID != userID) {
 		response.Error(ctx, http.StatusForbidden, 40300, "No permission")
 		return
 	}
@@ -147,6 +165,18 @@ func (h *Handler) UpdateAgent(c context.Context, ctx *app.RequestContext) {
 		response.BadRequest(ctx, err.Error())
 		return
 	}
+	if _, err := runner.ParseToolPolicy(req.ExtraConfig); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+	if req.Concurrency != nil && *req.Concurrency < 0 {
+		response.BadRequest(ctx, "concurrency must be >= 0")
+		return
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 2) {
+		response.BadRequest(ctx, "temperature must be between 0 and 2")
+		return
+	}
 
 	// 3. 执行更新 (使用闭包回调)
 	updated := h.Store.UpdateAgent(id, func(a *store.Agent) {
@@ -156,6 +186,12 @@ func (h *Handler) UpdateAgent(c context.Context, ctx *app.RequestContext) {
 		a.Temperature = req.Temperature
 		a.Tags = req.Tags
 		a.ModelName = req.ModelName
+		if req.Concurrency != nil {
+			a.Concurrency = *req.Concurrency
+		}
+		if req.ExtraConfig != nil {
+			a.ExtraConfig = req.ExtraConfig
+		}
 		// ... 其他字段
 	})
 
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+func TestUpdateAgentPermissions(t *testing.T) {
+	h := newTestHandler()
+	system := h.Store.CreateAgent(&store.Agent{Name: "system", SystemPrompt: "p", Type: "system", ExtraConfig: map[string]interface{}{"max_steps": float64(10)}})
+	theirs := h.Store.CreateAgent(&store.Agent{Name: "theirs", SystemPrompt: "p", OwnerUserID: "bob", Type: "user"})
+	mine := h.Store.CreateAgent(&store.Agent{Name: "mine", SystemPrompt: "p", OwnerUserID: "alice", Type: "user"})
+
+	escalate := map[string]interface{}{"name": "x", "system_prompt": "p", "extra_config": map[string]interface{}{"max_steps": 1000}}
+	cases := []struct {
+		name   string
+		caller testCaller
+		agent  *store.Agent
+		status int
+	}{
+		{"user edits system agent", alice, system, http.StatusForbidden},
+		{"user edits other user's agent", alice, theirs, http.StatusForbidden},
+		{"owner", alice, mine, http.StatusOK},
+		{"admin edits system agent", admin, system, http.StatusOK},
+		{"admin edits user agent", admin, theirs, http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			status, resp := call(t, h.UpdateAgent, c.caller, escalate, "id", c.agent.ID)
+			if status != c.status {
+				t.Fatalf("status = %d, want %d: %v", status, c.status, resp)
+			}
+			got := h.Store.GetAgent(c.agent.ID).ExtraConfig
+			if changed := got["max_steps"] == float64(1000); changed != (status == http.StatusOK) {
+				t.Fatalf("extra_config = %v (status %d)", got, status)
+			}
+		})
+	}
+}
+
+func TestUpdateAgentConcurrency(t *testing.T) {
+	h := newTestHandler()
+	mine := h.Store.CreateAgent(&store.Agent{Name: "mine", SystemPrompt: "p", OwnerUserID: "alice", Type: "user", Concurrency: 3})
+	system := h.Store.CreateAgent(&store.Agent{Name: "system", SystemPrompt: "p", Type: "system", Concurrency: 2})
+
+	steps := []struct {
+		name   string
+		caller testCaller
+		agent  *store.Agent
+		body   map[string]interface{}
+		status int
+		want   int
+	}{
+		// 不传 concurrency 时保持原值，不会被清成“不限制”
+		{"omitted keeps limit", alice, mine, map[string]interface{}{"name": "mine", "system_prompt": "p2"}, http.StatusOK, 3},
+		{"set", alice, mine, map[string]interface{}{"name": "mine", "system_prompt": "p", "concurrency": 5}, http.StatusOK, 5},
+		{"zero removes limit", alice, mine, map[string]interface{}{"name": "mine", "system_prompt": "p", "concurrency": 0}, http.StatusOK, 0},
+		{"negative", alice, mine, map[string]interface{}{"name": "mine", "system_prompt": "p", "concurrency": -1}, http.StatusBadRequest, 0},
+		{"user on system agent", alice, system, map[string]interface{}{"name": "system", "system_prompt": "p", "concurrency": 0}, http.StatusForbidden, 2},
+		{"admin on system agent", admin, system, map[string]interface{}{"name": "system", "system_prompt": "p", "concurrency": 4}, http.StatusOK, 4},
+	}
+	for _, s := range steps {
+		status, resp := call(t, h.UpdateAgent, s.caller, s.body, "id", s.agent.ID)
+		if status != s.status {
+			t.Fatalf("%s: status = %d, want %d: %v", s.name, status, s.status, resp)
+		}
+		if got := h.Store.GetAgent(s.agent.ID).Concurrency; got != s.want {
+			t.Fatalf("%s: concurrency = %d, want %d", s.name, got, s.want)
+		}
+	}
+}
+
+func TestCreateAgentConcurrency(t *testing.T) {
+	h := newTestHandler()
+	status, resp := call(t, h.CreateAgent, alice, map[string]interface{}{"name": "a", "system_prompt": "p", "concurrency": 2})
+	if status != http.StatusCreated {
+		t.Fatalf("status = %d: %v", status, resp)
+	}
+	id := resp["data"].(map[string]interface{})["id"].(string)
+	if got := h.Store.GetAgent(id); got.Concurrency != 2 || got.OwnerUserID != "alice" {
+		t.Fatalf("agent = %+v", got)
+	}
+	if status, _ := call(t, h.CreateAgent, alice, map[string]interface{}{"name": "b", "system_prompt": "p", "concurrency": -1}); status != http.StatusBadRequest {
+		t.Fatalf("negative concurrency status = %d", status)
+	}
+}
@@ -3,12 +3,12 @@ package handler
 import (
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/http"
 	"time"
 
+	"example.com/agent-server/internal/auth"
 	"example.com/agent-server/internal/middleware"
 	"example.com/agent-server/internal/store"
 	"example.com/agent-server/pkg/response"
@@ -98,8 +98,7 @@ func (h *Handler) CreateAPIKey(c context.Context, ctx *app.RequestContext) {
 
 	// 2. 计算 Hash (存库用)
 	// API Key 通常使用 SHA256 即可，速度快且足够安全（因为 Key 本身也是随机的，熵很高）
-	hash := sha256.Sum256([]byte(rawKey))
-	keyHash := hex.EncodeToString(hash[:])
+	keyHash := auth.HashAPIKey(rawKey)
 
 	// 3. 提取前缀 (展示用)
 	// sk-nx-abcdef... -> sk-nx-ab...
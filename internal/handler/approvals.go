@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+	"example.com/agent-server/pkg/response"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// ==========================================
+// DTOs
+// ==========================================
+
+// EditToolCallReq 修改参数后批准，arguments 需符合工具的 InputSchema
+type EditToolCallReq struct {
+	Arguments map[string]interface{} `json:"arguments" vd:"required"`
+}
+
+// RejectToolCallReq 拒绝理由会作为工具结果交给模型
+type RejectToolCallReq struct {
+	Reason string `json:"reason"`
+}
+
+// ==========================================
+// Handlers
+// ==========================================
+
+// ListApprovals 当前用户的审批，默认只列 pending；?status=all 列出全部
+func (h *Handler) ListApprovals(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+
+	status := ctx.DefaultQuery("status", "pending")
+	if status == "all" {
+		status = ""
+	}
+	response.Success(ctx, h.Store.ListToolApprovalsByUser(userID, status))
+}
+
+// GetApproval 审批详情
+func (h *Handler) GetApproval(c context.Context, ctx *app.RequestContext) {
+	a, ok := h.ownedApproval(ctx)
+	if !ok {
+		return
+	}
+	response.Success(ctx, a)
+}
+
+// ApproveToolCall 按模型给出的参数批准
+func (h *Handler) ApproveToolCall(c context.Context, ctx *app.RequestContext) {
+	a, ok := h.ownedApproval(ctx)
+	if !ok {
+		return
+	}
+	h.decide(ctx, a, "approved", nil, "")
+}
+
+// EditToolCall 修改参数后批准
+func (h *Handler) EditToolCall(c context.Context, ctx *app.RequestContext) {
+	a, ok := h.ownedApproval(ctx)
+	if !ok {
+		return
+	}
+
+	var req EditToolCallReq
+	if err := ctx.BindAndValidate(&req); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+
+	// 修改后的参数同样按 Schema 校验（并补齐默认值）
+	bt, err := mcp.LoadToolSet(h.Store, a.AgentID).Resolve(a.ToolName)
+	if err != nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "Tool is no longer bound to the agent")
+		return
+	}
+	raw, _ := json.Marshal(req.Arguments)
+	args, err := mcp.ValidateArguments(a.ToolName, bt.Tool.InputSchema, string(raw))
+	if err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+	h.decide(ctx, a, "edited", args, "")
+}
+
+// RejectToolCall 拒绝，工具不执行
+func (h *Handler) RejectToolCall(c context.Context, ctx *app.RequestContext) {
+	a, ok := h.ownedApproval(ctx)
+	if !ok {
+		return
+	}
+
+	var req RejectToolCallReq
+	if err := ctx.BindAndValidate(&req); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+	h.decide(ctx, a, "rejected", nil, req.Reason)
+}
+
+// ==========================================
+// Helper Functions
+// ==========================================
+
+// ownedApproval 取路径中的审批并校验归属；失败时已写好响应
+func (h *Handler) ownedApproval(ctx *app.RequestContext) (*store.ToolApproval, bool) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return nil, false
+	}
+	a := h.Store.GetToolApproval(ctx.Param("id"))
+	if a == nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "Approval not found")
+		return nil, false
+	}
+	if a.UserID != userID {
+		response.Error(ctx, http.StatusForbidden, 40300, "You do not own this approval")
+		return nil, false
+	}
+	return a, true
+}
+
+// decide 记录决定并恢复 Run
+// ?stream=true 时以 SSE 推送恢复后的事件（格式同 /chat/stream），否则在后台恢复，立即返回审批记录，事件可通过 /runs/:id/events 跟随
+func (h *Handler) decide(ctx *app.RequestContext, a *store.ToolApproval, status string, edited map[string]interface{}, reason string) {
+	if !h.Store.DecideToolApproval(a.ID, status, edited, reason) {
+		response.Error(ctx, http.StatusConflict, 40900, "Approval has already been decided")
+		return
+	}
+
+	// 和新的对话一样经过队列，受 worker / 用户 / Agent 并发限制，同一会话依次执行
+	rootID, err := h.Engine.Queue.Resume(a.ID)
+	if err != nil {
+		response.ServerError(ctx, err)
+		return
+	}
+
+	if ctx.Query("stream") == "true" {
+		if eventChan, stop, ok := h.Engine.Queue.Subscribe(rootID); ok {
+			streamRunEvents(ctx, eventChan, stop)
+			return
+		}
+	}
+	// 后台恢复的事件按用户发起的 Run 推送给 GET /api/runs/:id/events 的订阅者
+	response.Success(ctx, h.Store.GetToolApproval(a.ID))
+}
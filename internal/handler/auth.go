@@ -108,7 +108,7 @@ func (h *Handler) Login(c context.Context, ctx *app.RequestContext) {
 	}
 
 	// 3. 生成 Tokens
-	accessToken, err := h.generateAccessToken(user.ID)
+	accessToken, err := h.generateAccessToken(user)
 	if err != nil {
 		response.ServerError(ctx, err)
 		return
@@ -153,8 +153,13 @@ func (h *Handler) Refresh(c context.Context, ctx *app.RequestContext) {
 		return
 	}
 
-	// 2. 签发新的 Access Token
-	newAccessToken, err := h.generateAccessToken(rt.UserID)
+	// 2. 签发新的 Access Token（重新查一次用户，角色变更可以及时生效）
+	user := h.Store.FindUserByID(rt.UserID)
+	if user == nil {
+		response.Unauthorized(ctx, "User not found")
+		return
+	}
+	newAccessToken, err := h.generateAccessToken(user)
 	if err != nil {
 		response.ServerError(ctx, err)
 		return
@@ -212,11 +217,14 @@ func (h *Handler) Me(c context.Context, ctx *app.RequestContext) {
 // ==========================================
 
 // generateAccessToken 生成 JWT
-func (h *Handler) generateAccessToken(userID string) (string, error) {
+// roles 一并写入，中间件据此判断管理员权限
+func (h *Handler) generateAccessToken(user *store.User) (string, error) {
 	claims := jwt.MapClaims{
-		"sub": userID,
-		"exp": time.Now().Add(1 * time.Hour).Unix(), // 1小时过期
-		"iss": "nexus-agent",
+		"sub":   user.ID,
+		"email": user.Email,
+		"roles": []string(user.Roles),
+		"exp":   time.Now().Add(1 * time.Hour).Unix(), // 1小时过期
+		"iss":   "nexus-agent",
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
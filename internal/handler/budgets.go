@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/runner"
+	"example.com/agent-server/internal/store"
+	"example.com/agent-server/pkg/response"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// ==========================================
+// DTOs
+// ==========================================
+
+// SpendBudgetReq 设置预算，单位美元；0 表示该窗口不限制
+type SpendBudgetReq struct {
+	DailyUSD   float64 `json:"daily_usd"`
+	MonthlyUSD float64 `json:"monthly_usd"`
+}
+
+// SpendBudgetResp 预算及当前窗口内的花费
+type SpendBudgetResp struct {
+	*store.SpendBudget
+	Spent runner.BudgetSpend `json:"spent"`
+}
+
+// ==========================================
+// Handlers
+// ==========================================
+
+// ListBudgets 当前用户能看到的预算：自己的用户预算、自己的 API Key 和 Agent（含 system Agent）的预算；管理员看到全部
+func (h *Handler) ListBudgets(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+
+	res := []SpendBudgetResp{}
+	for _, b := range h.Store.ListSpendBudgets() {
+		if visible, _, _ := h.budgetAccess(ctx, userID, b.Scope, b.ScopeID); !visible {
+			continue
+		}
+		res = append(res, SpendBudgetResp{SpendBudget: b, Spent: h.Engine.SpendOf(b.Scope, b.ScopeID)})
+	}
+	response.Success(ctx, res)
+}
+
+// SetBudget 设置（覆盖）某个用户 / API Key / Agent 的预算
+// 用户预算只有管理员能设置；API Key 和自定义 Agent 的预算由其所有者设置；system Agent 只有管理员能设置
+func (h *Handler) SetBudget(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+	scope, scopeID := ctx.Param("scope"), ctx.Param("id")
+	if !h.checkBudgetEditable(ctx, userID, scope, scopeID) {
+		return
+	}
+
+	var req SpendBudgetReq
+	if err := ctx.BindAndValidate(&req); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+	if req.DailyUSD < 0 || req.MonthlyUSD < 0 {
+		response.BadRequest(ctx, "daily_usd and monthly_usd must be >= 0")
+		return
+	}
+
+	b := h.Store.UpsertSpendBudget(&store.SpendBudget{
+		Scope:      scope,
+		ScopeID:    scopeID,
+		DailyUSD:   req.DailyUSD,
+		MonthlyUSD: req.MonthlyUSD,
+		UpdatedBy:  userID,
+	})
+	response.Success(ctx, SpendBudgetResp{SpendBudget: b, Spent: h.Engine.SpendOf(scope, scopeID)})
+}
+
+// DeleteBudget 删除预算，之后不再限制
+func (h *Handler) DeleteBudget(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+	scope, scopeID := ctx.Param("scope"), ctx.Param("id")
+	if !h.checkBudgetEditable(ctx, userID, scope, scopeID) {
+		return
+	}
+	if !h.Store.DeleteSpendBudget(scope, scopeID) {
+		response.Error(ctx, http.StatusNotFound, 40400, "Budget not found")
+		return
+	}
+	response.Success(ctx, nil)
+}
+
+// ==========================================
+// Helper Functions
+// ==========================================
+
+// budgetAccess 当前用户对某个预算范围的权限；found 为 false 表示范围不存在
+func (h *Handler) budgetAccess(ctx *app.RequestContext, userID, scope, scopeID string) (visible, editable, found bool) {
+	admin := middleware.IsAdmin(ctx)
+	switch scope {
+	case runner.BudgetScopeUser:
+		if h.Store.FindUserByID(scopeID) == nil {
+			return false, false, false
+		}
+		return admin || scopeID == userID, admin, true
+	case runner.BudgetScopeAPIKey:
+		k := h.Store.GetAPIKey(scopeID)
+		if k == nil {
+			return false, false, false
+		}
+		return admin || k.UserID == userID, admin || k.UserID == userID, true
+	case runner.BudgetScopeAgent:
+		a := h.Store.GetAgent(scopeID)
+		if a == nil {
+			return false, false, false
+		}
+		owner := a.Type != "system" && a.OwnerUserID == userID
+		return admin || owner || a.Type == "system", admin || owner, true
+	}
+	return false, false, false
+}
+
+// checkBudgetEditable 校验范围并检查修改权限，不通过时已写入响应
+func (h *Handler) checkBudgetEditable(ctx *app.RequestContext, userID, scope, scopeID string) bool {
+	if scope != runner.BudgetScopeUser && scope != runner.BudgetScopeAPIKey && scope != runner.BudgetScopeAgent {
+		response.BadRequest(ctx, "scope must be one of user, api_key, agent")
+		return false
+	}
+	visible, editable, found := h.budgetAccess(ctx, userID, scope, scopeID)
+	if !found || !visible {
+		response.Error(ctx, http.StatusNotFound, 40400, "Budget target not found")
+		return false
+	}
+	if !editable {
+		response.Error(ctx, http.StatusForbidden, 40300, "You are not allowed to change this budget")
+		return false
+	}
+	return true
+}
@@ -33,6 +33,14 @@ type ChatMessageResp struct {
 	CreatedAt  string                 `json:"created_at"`
 }
 
+// RunAcceptedResp 消息已保存、Run 已入队；结果通过 GET /api/runs/:id 轮询或 GET /api/runs/:id/events 跟随
+type RunAcceptedResp struct {
+	RunID   string           `json:"run_id"`
+	TraceID string           `json:"trace_id"`
+	Status  string           `json:"status"`
+	Message *ChatMessageResp `json:"message"` // 刚保存的用户消息
+}
+
 // ==========================================
 // Helpers
 // ==========================================
@@ -56,6 +64,38 @@ func createTextContent(text string) map[string]interface{} {
 	}
 }
 
+// streamRunEvents 把 Run 的事件以 SSE 推给前端，直到 channel 关闭
+// 客户端断开时调用 stop（取消订阅）；stop 为 nil 时读完剩余事件，避免引擎阻塞在 channel 上
+func streamRunEvents(ctx *app.RequestContext, eventChan <-chan runner.RunStreamEvent, stop func()) {
+	// 设置 SSE 响应头
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Response.Header.Set("X-Accel-Buffering", "no") // 禁用 nginx 缓冲
+
+	// 创建 SSE Stream
+	stream := sse.NewStream(ctx)
+
+	// 流式推送给前端
+	for event := range eventChan {
+		data, _ := json.Marshal(event)
+		err := stream.Publish(&sse.Event{
+			Event: event.Type,
+			Data:  data,
+		})
+		if err != nil {
+			// 客户端断开连接：Run 照常跑完
+			if stop != nil {
+				stop()
+				return
+			}
+			go func() {
+				for range eventChan {
+				}
+			}()
+			return
+		}
+	}
+}
+
 // ==========================================
 // Handlers
 // ==========================================
@@ -121,47 +161,30 @@ func (h *Handler) SendChatMessage(c context.Context, ctx *app.RequestContext) {
 	h.Store.CreateChatMessage(userMsg)
 
 	// =============================================================
-	// Step 2: 创建运行任务 (Run) - 状态为 Running
+	// Step 2: 创建运行任务 (Run) - 入队，由 worker 执行
 	// =============================================================
-	run := &store.Run{
+	run, err := h.Engine.Queue.Enqueue(&store.Run{
 		SessionID:    sessionID,
 		UserID:       userID,
 		AgentID:      session.AgentID,
 		TraceID:      uuid.New().String(), // 生成新的 Trace
-		Status:       "running",
 		InputPayload: map[string]interface{}{"content": req.Content},
-	}
-	// 关联一下 UserMsg (如果表里有 RunID 字段的话)
-	// userMsg.RunID = run.ID
-	h.Store.CreateRun(run)
-
-	// =============================================================
-	// Step 3: 执行 Agent 逻辑 (Mock Engine)
-	// =============================================================
-	// 注意：真实场景中，这里应该是异步的，或者 SSE 流式返回
-	// 这里我们模拟一个同步阻塞的过程，让前端一次性拿到结果
-
-	finalResponse, err := h.Engine.ExecuteRun(run.ID)
+	})
 	if err != nil {
-		h.Store.FinishRun(run.ID, nil, "failed")
 		response.ServerError(ctx, err)
 		return
 	}
 
 	// =============================================================
-	// Step 4: 结束 Run
+	// Step 3: 立即返回 Run ID
 	// =============================================================
-	h.Store.FinishRun(run.ID, map[string]interface{}{"response": finalResponse}, "succeeded")
-
-	// 返回最终结果 (通常前端发一条消息，期望立刻看到 Agent 的回复)
-	// 如果是流式，这里就不返回 JSON，而是 Hijack 连接推流
-	// 这里我们返回【最新的一条】Assistant 消息
-
-	// 重新查一下最新的消息
-	allMsgs := h.Store.ListChatMessagesBySession(sessionID)
-	lastMsg := allMsgs[len(allMsgs)-1]
-
-	response.Success(ctx, toMessageResp(lastMsg))
+	// Agent 的回复、工具调用和审批请求通过轮询 Run 或订阅 /runs/:id/events 获取
+	response.Accepted(ctx, &RunAcceptedResp{
+		RunID:   run.ID,
+		TraceID: run.TraceID,
+		Status:  run.Status,
+		Message: toMessageResp(userMsg),
+	})
 }
 
 // SendChatMessageStream 流式发送消息 (SSE)
@@ -196,40 +219,24 @@ func (h *Handler) SendChatMessageStream(c context.Context, ctx *app.RequestConte
 	}
 	h.Store.CreateChatMessage(userMsg)
 
-	// 创建 Run
-	run := &store.Run{
+	// 创建 Run 并入队，跟随它的事件直到结束；客户端断开不影响 Run 执行
+	run, err := h.Engine.Queue.Enqueue(&store.Run{
 		SessionID:    sessionID,
 		UserID:       userID,
 		AgentID:      session.AgentID,
 		TraceID:      uuid.New().String(),
-		Status:       "running",
 		InputPayload: map[string]interface{}{"content": req.Content},
+	})
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return
 	}
-	h.Store.CreateRun(run)
-
-	// 设置 SSE 响应头
-	ctx.SetStatusCode(http.StatusOK)
-	ctx.Response.Header.Set("X-Accel-Buffering", "no") // 禁用 nginx 缓冲
-
-	// 创建 SSE Stream
-	stream := sse.NewStream(ctx)
-
-	// 创建事件通道
-	eventChan := make(chan runner.RunStreamEvent, 10)
-
-	// 异步执行 Agent
-	go h.Engine.ExecuteRunStream(run.ID, eventChan)
-
-	// 流式推送给前端
-	for event := range eventChan {
-		data, _ := json.Marshal(event)
-		err := stream.Publish(&sse.Event{
-			Event: event.Type,
-			Data:  data,
-		})
-		if err != nil {
-			// 客户端断开连接
-			return
-		}
+	eventChan, stop, ok := h.Engine.Queue.Subscribe(run.ID)
+	if !ok {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ctx.WriteString("run is not queued")
+		return
 	}
+	streamRunEvents(ctx, eventChan, stop)
 }
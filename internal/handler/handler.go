@@ -15,10 +15,14 @@ type Handler struct {
 
 // 工厂函数：初始化 Handler
 func New(s store.Store, secret string, svc *service.Service) *Handler {
+	engine := runner.NewEngine(s, svc.LLM)
+	engine.Executor = svc.MCP.Executor // 与 MCPService 共享 MCP 连接池
+	engine.Workspaces = svc.Workspaces
+
 	return &Handler{
 		Store:     s,
 		JWTSecret: []byte(secret),
-		Engine:    runner.NewEngine(s, svc.LLM),
+		Engine:    engine,
 		Svc:       svc,
 	}
 }
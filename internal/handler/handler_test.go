@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/store"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/route/param"
+)
+
+// testCaller 模拟通过鉴权中间件的调用方
+type testCaller struct {
+	userID string
+	admin  bool
+}
+
+var (
+	alice = testCaller{userID: "alice"}
+	bob   = testCaller{userID: "bob"}
+	admin = testCaller{userID: "root", admin: true}
+)
+
+func newTestHandler() *Handler {
+	return &Handler{Store: store.NewMemoryStore()}
+}
+
+// call 以 caller 身份调用 handler，body 编码为 JSON，params 是路由参数 (key, value, ...)
+func call(t *testing.T, fn app.HandlerFunc, caller testCaller, body interface{}, params ...string) (int, map[string]interface{}) {
+	t.Helper()
+	ctx := app.NewContext(0)
+	ctx.Request.SetMethod("POST")
+	ctx.Request.SetRequestURI("/")
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx.Request.Header.SetContentTypeBytes([]byte("application/json"))
+		ctx.Request.Header.SetContentLength(len(b))
+		ctx.Request.SetBody(b)
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		ctx.Params = append(ctx.Params, param.Param{Key: params[i], Value: params[i+1]})
+	}
+	ctx.Set(middleware.CtxKeyUserID, caller.userID)
+	roles := []string{"user"}
+	if caller.admin {
+		roles = append(roles, "admin")
+	}
+	ctx.Set(middleware.CtxKeyRoles, roles)
+
+	fn(context.Background(), ctx)
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(ctx.Response.Body(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", ctx.Response.Body(), err)
+	}
+	return ctx.Response.StatusCode(), resp
+}
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/integration"
+	"example.com/agent-server/internal/store"
+	"example.com/agent-server/pkg/response"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// ==========================================
+// DTOs
+// ==========================================
+
+type CreateIntegrationReq struct {
+	Provider     string            `json:"provider" vd:"required"` // github / docker_hub / linear ...
+	Credentials  map[string]string `json:"credentials"`            // e.g. {"token": "ghp_xxx"}，加密后存库
+	DisplayLabel string            `json:"display_label"`          // 不填时自动生成掩码
+}
+
+// UpdateIntegrationReq 轮换凭证或修改展示名，credentials 为空时只改展示名
+type UpdateIntegrationReq struct {
+	Credentials  map[string]string `json:"credentials"`
+	DisplayLabel string            `json:"display_label"`
+}
+
+// IntegrationResp 不包含凭证（明文和密文都不返回）
+type IntegrationResp struct {
+	ID           string `json:"id"`
+	Provider     string `json:"provider"`
+	DisplayLabel string `json:"display_label"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+var providerPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ==========================================
+// Handlers
+// ==========================================
+
+// ListIntegrations 列出当前用户绑定的平台
+func (h *Handler) ListIntegrations(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+
+	list := h.Store.ListIntegrationsByUser(userID)
+	res := make([]IntegrationResp, 0, len(list))
+	for _, in := range list {
+		res = append(res, toIntegrationResp(in))
+	}
+	response.Success(ctx, res)
+}
+
+// CreateIntegration 绑定一个平台的凭证（每个平台只能绑定一次，更换凭证用 PUT）
+func (h *Handler) CreateIntegration(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+
+	var req CreateIntegrationReq
+	if err := ctx.BindAndValidate(&req); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+	req.Provider = strings.ToLower(strings.TrimSpace(req.Provider))
+	if !providerPattern.MatchString(req.Provider) {
+		response.BadRequest(ctx, "invalid provider")
+		return
+	}
+	if !validCredentials(req.Credentials) {
+		response.BadRequest(ctx, "credentials must be a non-empty object of string values")
+		return
+	}
+	if !h.Svc.Integrations.Enabled() {
+		response.Error(ctx, http.StatusInternalServerError, 50000, integration.ErrNoMasterKey.Error())
+		return
+	}
+	if h.Store.GetIntegrationByProvider(userID, req.Provider) != nil {
+		response.Error(ctx, http.StatusConflict, 40900, "integration already exists: "+req.Provider)
+		return
+	}
+
+	sealed, err := h.Svc.Integrations.Seal(userID, req.Provider, req.Credentials)
+	if err != nil {
+		response.ServerError(ctx, err)
+		return
+	}
+	label := req.DisplayLabel
+	if label == "" {
+		label = integration.DisplayLabel(req.Credentials)
+	}
+
+	in := h.Store.CreateIntegration(&store.UserIntegration{
+		UserID:               userID,
+		Provider:             req.Provider,
+		EncryptedCredentials: sealed,
+		DisplayLabel:         label,
+	})
+	response.Created(ctx, toIntegrationResp(in))
+}
+
+// UpdateIntegration 轮换凭证 / 修改展示名
+func (h *Handler) UpdateIntegration(c context.Context, ctx *app.RequestContext) {
+	in, ok := h.ownedIntegration(ctx)
+	if !ok {
+		return
+	}
+
+	var req UpdateIntegrationReq
+	if err := ctx.BindAndValidate(&req); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+
+	var sealed string
+	if req.Credentials != nil {
+		if !validCredentials(req.Credentials) {
+			response.BadRequest(ctx, "credentials must be a non-empty object of string values")
+			return
+		}
+		var err error
+		if sealed, err = h.Svc.Integrations.Seal(in.UserID, in.Provider, req.Credentials); err != nil {
+			response.Error(ctx, http.StatusInternalServerError, 50000, err.Error())
+			return
+		}
+		if req.DisplayLabel == "" {
+			req.DisplayLabel = integration.DisplayLabel(req.Credentials)
+		}
+	}
+
+	h.Store.UpdateIntegration(in.ID, func(u *store.UserIntegration) {
+		if sealed != "" {
+			u.EncryptedCredentials = sealed
+		}
+		if req.DisplayLabel != "" {
+			u.DisplayLabel = req.DisplayLabel
+		}
+	})
+	response.Success(ctx, toIntegrationResp(h.Store.GetIntegration(in.ID)))
+}
+
+// DeleteIntegration 解绑
+func (h *Handler) DeleteIntegration(c context.Context, ctx *app.RequestContext) {
+	in, ok := h.ownedIntegration(ctx)
+	if !ok {
+		return
+	}
+	if h.Store.DeleteIntegration(in.ID) {
+		response.Success(ctx, map[string]string{"message": "Integration deleted"})
+	} else {
+		response.Error(ctx, http.StatusInternalServerError, 50000, "Failed to delete integration")
+	}
+}
+
+// ==========================================
+// Helper Functions
+// ==========================================
+
+// ownedIntegration 取路径中的 Integration 并校验归属；失败时已写好响应
+func (h *Handler) ownedIntegration(ctx *app.RequestContext) (*store.UserIntegration, bool) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return nil, false
+	}
+	in := h.Store.GetIntegration(ctx.Param("id"))
+	if in == nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "Integration not found")
+		return nil, false
+	}
+	if in.UserID != userID {
+		response.Error(ctx, http.StatusForbidden, 40300, "You do not own this integration")
+		return nil, false
+	}
+	return in, true
+}
+
+func validCredentials(creds map[string]string) bool {
+	if len(creds) == 0 {
+		return false
+	}
+	for k, v := range creds {
+		if k == "" || v == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func toIntegrationResp(in *store.UserIntegration) IntegrationResp {
+	return IntegrationResp{
+		ID:           in.ID,
+		Provider:     in.Provider,
+		DisplayLabel: in.DisplayLabel,
+		CreatedAt:    in.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:    in.UpdatedAt.Format(time.RFC3339),
+	}
+}
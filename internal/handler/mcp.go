@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/mcp"
 	"example.com/agent-server/internal/store"
 	"example.com/agent-server/pkg/response"
 	"github.com/cloudwego/hertz/pkg/app"
@@ -19,9 +20,9 @@ import (
 // RegisterMCPServerReq 注册请求
 type RegisterMCPServerReq struct {
 	Name          string                 `json:"name" vd:"required"`
-	TransportType string                 `json:"transport_type" vd:"in(stdio,sse)"` // 只允许 stdio 或 sse
-	AgentID       string                 `json:"agent_id"`                          // 可选，如果绑定特定 Agent
-	Config        map[string]interface{} `json:"connection_config" vd:"required"`   // {"command": "...", "args": [...]}
+	TransportType string                 `json:"transport_type" vd:"in(stdio,sse,http)"` // stdio / sse / http (Streamable HTTP)
+	AgentID       string                 `json:"agent_id"`                               // 可选，如果绑定特定 Agent
+	Config        map[string]interface{} `json:"connection_config" vd:"required"`        // 远程: {"url": "...", "headers": {...}}; stdio: {"command": "...", "args": [...], "env": {...}, "cwd": "..."}
 }
 
 // MCPServerResp 响应
@@ -41,6 +42,34 @@ type MCPToolResp struct {
 	// Schema 已经是 map，直接透传即可
 }
 
+// MCPResourceResp 资源响应
+type MCPResourceResp struct {
+	*store.MCPResource
+	CreatedAt string `json:"created_at"`
+}
+
+// MCPPromptResp 提示模板响应
+type MCPPromptResp struct {
+	*store.MCPPrompt
+	CreatedAt string `json:"created_at"`
+}
+
+// GetMCPPromptReq 渲染提示模板的参数
+type GetMCPPromptReq struct {
+	Arguments map[string]string `json:"arguments"`
+}
+
+// MCPServerHealthResp 健康状态 + 最近的探测记录（新的在前）
+type MCPServerHealthResp struct {
+	ServerID      string            `json:"server_id"`
+	Name          string            `json:"name"`
+	Status        string            `json:"status"`
+	LatencyMs     int               `json:"latency_ms"`
+	LastError     string            `json:"last_error"`
+	LastCheckedAt string            `json:"last_checked_at,omitempty"`
+	History       []mcp.ProbeResult `json:"history"`
+}
+
 // ==========================================
 // Handlers
 // ==========================================
@@ -84,7 +113,7 @@ func (h *Handler) ListMCPServers(c context.Context, ctx *app.RequestContext) {
 // RegisterMCPServer 注册新的 Server
 func (h *Handler) RegisterMCPServer(c context.Context, ctx *app.RequestContext) {
 	// 权限检查...
-	_, ok := middleware.GetUserID(ctx)
+	userID, ok := middleware.GetUserID(ctx)
 	if !ok {
 		response.Unauthorized(ctx, "Unauthorized")
 		return
@@ -95,9 +124,10 @@ func (h *Handler) RegisterMCPServer(c context.Context, ctx *app.RequestContext)
 		response.BadRequest(ctx, err.Error())
 		return
 	}
-	//用户不得创建本地的mcpserver,只能采用远程连接的方式
-	if req.TransportType == "stdio" {
-		response.Error(ctx, http.StatusForbidden, 40300, "Security Alert: Users can only register SSE (Remote) servers, not local stdio processes.")
+	admin := middleware.IsAdmin(ctx)
+	// 普通用户不得创建本地的 mcpserver，只能采用远程连接的方式；stdio 会在服务器上拉起进程，仅限管理员
+	if req.TransportType == "stdio" && !admin {
+		response.Error(ctx, http.StatusForbidden, 40300, "Security Alert: Only admins can register local stdio servers; users can only register remote (SSE / HTTP) servers.")
 		return
 	}
 
@@ -111,6 +141,35 @@ func (h *Handler) RegisterMCPServer(c context.Context, ctx *app.RequestContext)
 		IsGlobal:         req.AgentID == "", // 没绑 Agent 就是全局的
 	}
 
+	// 全局 Server 对所有 Agent 可见；引用集成平台的 Server 会被注入每个调用用户的凭证，
+	// 普通用户注册的地址可以借此收集别人的 Token，两者都仅限管理员
+	if server.IsGlobal && !admin {
+		response.Error(ctx, http.StatusForbidden, 40300, "Only admins can register global servers; set agent_id to bind the server to one of your agents")
+		return
+	}
+	if mcp.IntegrationProvider(server) != "" && !admin {
+		response.Error(ctx, http.StatusForbidden, 40300, "Only admins can register servers that use integration credentials")
+		return
+	}
+	// 只能绑定到自己的 Agent；系统 Agent 由管理员维护
+	if server.AgentID != "" {
+		agent := h.Store.GetAgent(server.AgentID)
+		if agent == nil {
+			response.Error(ctx, http.StatusNotFound, 40400, "Agent not found")
+			return
+		}
+		if !admin && (agent.Type == "system" || agent.OwnerUserID != userID) {
+			response.Error(ctx, http.StatusForbidden, 40300, "You do not own this agent")
+			return
+		}
+	}
+
+	// 提前校验连接配置 (缺 url 等)，避免注册一个永远连不上的 Server
+	if _, err := mcp.NewTransport(server); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+
 	createdServer := h.Store.CreateMCPServer(server)
 
 	response.Created(ctx, map[string]interface{}{
@@ -145,16 +204,13 @@ func (h *Handler) ListMCPTools(c context.Context, ctx *app.RequestContext) {
 }
 
 // SyncMCPTools [核心] 同步工具
-// 真实场景：连接 MCP 进程 -> 发送 list_tools -> 更新 DB
-// 当前场景：模拟数据，方便前端开发
-// ... 前面的 List 和 Register 保持不变 ...
-
-// SyncMCPTools [核心] 同步工具
+// 连接 MCP Server -> tools/list -> 按 (server_id, name) upsert，并删除已下线的工具
 func (h *Handler) SyncMCPTools(c context.Context, ctx *app.RequestContext) {
 	serverID := ctx.Param("id")
 
-	// 调用 Service 层逻辑
-	count, err := h.Svc.MCP.SyncTools(c, serverID)
+	// 调用 Service 层逻辑；引用了集成平台的 Server 用当前用户的凭证连接
+	c = withMCPUser(c, ctx)
+	result, err := h.Svc.MCP.SyncTools(c, serverID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			response.Error(ctx, http.StatusNotFound, 40400, err.Error())
@@ -164,8 +220,155 @@ func (h *Handler) SyncMCPTools(c context.Context, ctx *app.RequestContext) {
 		return
 	}
 
+	serverName := ""
+	if s := h.Store.GetMCPServer(serverID); s != nil {
+		serverName = s.Name
+	}
+
+	// 资源和提示模板一并同步；Server 未声明对应能力时为空，失败不影响工具同步的结果
+	resp := map[string]interface{}{
+		"message":     "Sync successful",
+		"server_name": serverName,
+		"sync_count":  result.Total,
+		"added":       result.Added,
+		"updated":     result.Updated,
+		"removed":     result.Removed,
+		"unchanged":   result.Unchanged,
+	}
+	if r, err := h.Svc.MCP.SyncResources(c, serverID); err != nil {
+		resp["resources"] = map[string]interface{}{"error": err.Error()}
+	} else {
+		resp["resources"] = r
+	}
+	if r, err := h.Svc.MCP.SyncPrompts(c, serverID); err != nil {
+		resp["prompts"] = map[string]interface{}{"error": err.Error()}
+	} else {
+		resp["prompts"] = r
+	}
+	response.Success(ctx, resp)
+}
+
+// GetMCPServerHealth 查看 Server 的健康状态和探测历史
+// Query Param: ?refresh=true 立即探测一次再返回
+func (h *Handler) GetMCPServerHealth(c context.Context, ctx *app.RequestContext) {
+	serverID := ctx.Param("id")
+	server := h.Store.GetMCPServer(serverID)
+	if server == nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "server not found: "+serverID)
+		return
+	}
+
+	if ctx.Query("refresh") == "true" {
+		h.Svc.MCP.Health.Probe(c, server)
+		server = h.Store.GetMCPServer(serverID)
+	}
+
+	res := &MCPServerHealthResp{
+		ServerID:  server.ID,
+		Name:      server.Name,
+		Status:    server.Status,
+		LatencyMs: server.LatencyMs,
+		LastError: server.LastError,
+		History:   h.Svc.MCP.Health.History(server.ID),
+	}
+	if server.LastCheckedAt != nil {
+		res.LastCheckedAt = server.LastCheckedAt.Format(time.RFC3339)
+	}
+	response.Success(ctx, res)
+}
+
+// ListMCPResources 查看某个 Server 下已同步的资源
+func (h *Handler) ListMCPResources(c context.Context, ctx *app.RequestContext) {
+	serverID := ctx.Param("id")
+	if h.Store.GetMCPServer(serverID) == nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "server not found: "+serverID)
+		return
+	}
+
+	resources := h.Store.ListMCPResourcesByServer(serverID)
+	res := make([]*MCPResourceResp, 0, len(resources))
+	for _, r := range resources {
+		res = append(res, &MCPResourceResp{
+			MCPResource: r,
+			CreatedAt:   r.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	response.Success(ctx, res)
+}
+
+// ReadMCPResource 实时读取资源内容
+// Query Param: ?uri=xxx
+func (h *Handler) ReadMCPResource(c context.Context, ctx *app.RequestContext) {
+	serverID := ctx.Param("id")
+	server := h.Store.GetMCPServer(serverID)
+	if server == nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "server not found: "+serverID)
+		return
+	}
+	uri := ctx.Query("uri")
+	if uri == "" {
+		response.BadRequest(ctx, "uri is required")
+		return
+	}
+
+	result, err := h.Svc.MCP.Executor.ReadResource(withMCPUser(c, ctx), server, uri)
+	if err != nil {
+		response.Error(ctx, http.StatusInternalServerError, 50000, err.Error())
+		return
+	}
+	response.Success(ctx, result)
+}
+
+// ListMCPPrompts 查看某个 Server 下已同步的提示模板
+func (h *Handler) ListMCPPrompts(c context.Context, ctx *app.RequestContext) {
+	serverID := ctx.Param("id")
+	if h.Store.GetMCPServer(serverID) == nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "server not found: "+serverID)
+		return
+	}
+
+	prompts := h.Store.ListMCPPromptsByServer(serverID)
+	res := make([]*MCPPromptResp, 0, len(prompts))
+	for _, p := range prompts {
+		res = append(res, &MCPPromptResp{
+			MCPPrompt: p,
+			CreatedAt: p.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	response.Success(ctx, res)
+}
+
+// GetMCPPrompt 按参数实时渲染提示模板，便于预览作为 Agent 系统提示片段的效果
+func (h *Handler) GetMCPPrompt(c context.Context, ctx *app.RequestContext) {
+	serverID := ctx.Param("id")
+	server := h.Store.GetMCPServer(serverID)
+	if server == nil {
+		response.Error(ctx, http.StatusNotFound, 40400, "server not found: "+serverID)
+		return
+	}
+
+	var req GetMCPPromptReq
+	if err := ctx.BindAndValidate(&req); err != nil {
+		response.BadRequest(ctx, err.Error())
+		return
+	}
+
+	result, err := h.Svc.MCP.Executor.GetPrompt(withMCPUser(c, ctx), server, ctx.Param("name"), req.Arguments)
+	if err != nil {
+		response.Error(ctx, http.StatusInternalServerError, 50000, err.Error())
+		return
+	}
 	response.Success(ctx, map[string]interface{}{
-		"message":    "Sync successful",
-		"sync_count": count,
+		"description": result.Description,
+		"messages":    result.Messages,
+		"text":        result.Text(),
 	})
 }
+
+// withMCPUser 把当前登录用户带进 MCP 调用，用于注入其绑定的集成凭证
+func withMCPUser(c context.Context, ctx *app.RequestContext) context.Context {
+	if userID, ok := middleware.GetUserID(ctx); ok {
+		return mcp.WithUser(c, userID)
+	}
+	return c
+}
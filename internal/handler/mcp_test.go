@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+func TestRegisterMCPServerPermissions(t *testing.T) {
+	h := newTestHandler()
+	mine := h.Store.CreateAgent(&store.Agent{Name: "mine", OwnerUserID: "alice", Type: "user"})
+	theirs := h.Store.CreateAgent(&store.Agent{Name: "theirs", OwnerUserID: "bob", Type: "user"})
+	system := h.Store.CreateAgent(&store.Agent{Name: "system", Type: "system"})
+
+	remote := map[string]interface{}{"url": "https://mcp.example.com/mcp"}
+	withIntegration := map[string]interface{}{"url": "https://mcp.example.com/mcp", "integration": map[string]interface{}{"provider": "github"}}
+	cases := []struct {
+		name   string
+		caller testCaller
+		req    RegisterMCPServerReq
+		status int
+	}{
+		{"own agent", alice, RegisterMCPServerReq{Name: "s", TransportType: "http", AgentID: mine.ID, Config: remote}, http.StatusCreated},
+		{"global", alice, RegisterMCPServerReq{Name: "s", TransportType: "http", Config: remote}, http.StatusForbidden},
+		{"other user's agent", alice, RegisterMCPServerReq{Name: "s", TransportType: "http", AgentID: theirs.ID, Config: remote}, http.StatusForbidden},
+		{"system agent", alice, RegisterMCPServerReq{Name: "s", TransportType: "http", AgentID: system.ID, Config: remote}, http.StatusForbidden},
+		{"unknown agent", alice, RegisterMCPServerReq{Name: "s", TransportType: "http", AgentID: "nope", Config: remote}, http.StatusNotFound},
+		{"integration on own agent", alice, RegisterMCPServerReq{Name: "s", TransportType: "http", AgentID: mine.ID, Config: withIntegration}, http.StatusForbidden},
+		{"stdio", alice, RegisterMCPServerReq{Name: "s", TransportType: "stdio", AgentID: mine.ID, Config: map[string]interface{}{"command": "x"}}, http.StatusForbidden},
+		{"admin global integration", admin, RegisterMCPServerReq{Name: "s", TransportType: "http", Config: withIntegration}, http.StatusCreated},
+		{"admin other user's agent", admin, RegisterMCPServerReq{Name: "s", TransportType: "http", AgentID: theirs.ID, Config: remote}, http.StatusCreated},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			before := len(h.Store.ListAllMCPServers())
+			status, resp := call(t, h.RegisterMCPServer, c.caller, c.req)
+			if status != c.status {
+				t.Fatalf("status = %d, want %d: %v", status, c.status, resp)
+			}
+			created := len(h.Store.ListAllMCPServers()) - before
+			if (status == http.StatusCreated) != (created == 1) {
+				t.Fatalf("created %d servers with status %d", created, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/service/runner"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/protocol/http1/resp"
+	"github.com/hertz-contrib/sse"
+)
+
+// mcpHeartbeat 调用超过这个时间仍未结束时改用 SSE 响应，并按这个间隔写心跳
+// hertz 不会在客户端断开时取消请求 ctx，只能靠写失败发现断开
+var mcpHeartbeat = 15 * time.Second
+
+// ==========================================
+// Handlers
+// ==========================================
+
+// ServeMCP 平台作为 MCP Server (Streamable HTTP)：每个可访问的 Agent 是一个工具
+// 使用 API Key 鉴权（middleware.APIKeyAuth），工具调用在 Key 所属用户名下创建 Run
+func (h *Handler) ServeMCP(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return
+	}
+
+	srv := &mcp.Server{
+		Info:         mcp.Implementation{Name: "nexus-agent", Version: "0.1.0"},
+		Instructions: "Each tool is a Nexus agent. Pass a natural-language task; the call returns the agent's final answer. _meta.run_id identifies the run and its trace in Nexus.",
+		Tools:        &runner.AgentTools{Engine: h.Engine, APIKeyID: middleware.GetAPIKeyID(ctx)},
+	}
+	// 取消 callCtx 即取消其中的 Agent Run（见 runner.AgentTools.CallTool）
+	callCtx, cancel := context.WithCancel(mcp.WithUser(c, userID))
+	defer cancel()
+	body := ctx.Request.Body()
+	done := make(chan []byte, 1)
+	go func() { done <- srv.HandleHTTP(callCtx, body) }()
+
+	timer := time.NewTimer(mcpHeartbeat)
+	defer timer.Stop()
+	var out []byte
+	select {
+	case out = <-done:
+	case <-timer.C:
+		if acceptsEventStream(ctx) {
+			streamMCPResponse(ctx, done, cancel)
+			return
+		}
+		// 客户端只接受 JSON：无法探测断开，等调用结束（AgentTools 自带超时）
+		out = <-done
+	}
+	if out == nil {
+		ctx.SetStatusCode(http.StatusAccepted)
+		return
+	}
+	ctx.Data(http.StatusOK, "application/json", out)
+}
+
+// streamMCPResponse 以 SSE 返回还在进行中的调用：定期写心跳，结束后推送响应
+// 心跳是 SSE 注释行，客户端会忽略；写失败说明客户端已断开，取消调用
+func streamMCPResponse(ctx *app.RequestContext, done <-chan []byte, cancel context.CancelFunc) {
+	ctx.SetStatusCode(http.StatusOK)
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	w := resp.NewChunkedBodyWriter(&ctx.Response, ctx.GetWriter())
+	stream := sse.NewStreamWithWriter(ctx, w)
+	heartbeat := func() error {
+		if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	ticker := time.NewTicker(mcpHeartbeat)
+	defer ticker.Stop()
+	err := heartbeat()
+	for err == nil {
+		select {
+		case out := <-done:
+			if out != nil {
+				_ = stream.Publish(&sse.Event{Event: "message", Data: out})
+			}
+			return
+		case <-ticker.C:
+			err = heartbeat()
+		}
+	}
+	cancel()
+	<-done
+}
+
+// acceptsEventStream 请求的 Accept 是否包含 text/event-stream（Streamable HTTP 客户端都会带）
+func acceptsEventStream(ctx *app.RequestContext) bool {
+	return strings.Contains(string(ctx.Request.Header.Peek("Accept")), "text/event-stream")
+}
+
+// MCPStreamNotSupported 无状态实现不提供服务端主动推送的 GET 流，也没有会话可删除
+func (h *Handler) MCPStreamNotSupported(c context.Context, ctx *app.RequestContext) {
+	ctx.Response.Header.Set("Allow", "POST")
+	ctx.SetStatusCode(http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/runner"
+	"example.com/agent-server/internal/service/workspace"
+	"example.com/agent-server/internal/store"
+	"github.com/cloudwego/hertz/pkg/app"
+	"github.com/cloudwego/hertz/pkg/app/server"
+)
+
+// slowProvider 等 delay 后回复 "done"；ctx 先结束则返回错误
+type slowProvider struct{ delay time.Duration }
+
+func (p slowProvider) ChatCompletion(ctx context.Context, req *llm.ChatRequest) (*llm.ChatResponse, error) {
+	select {
+	case <-time.After(p.delay):
+		return &llm.ChatResponse{Content: "done"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p slowProvider) ChatStream(ctx context.Context, req *llm.ChatRequest) (<-chan llm.StreamEvent, error) {
+	ch := make(chan llm.StreamEvent, 1)
+	go func() {
+		defer close(ch)
+		select {
+		case <-time.After(p.delay):
+			ch <- llm.StreamEvent{Type: "done", Content: "done"}
+		case <-ctx.Done():
+			ch <- llm.StreamEvent{Type: "error", Error: ctx.Err().Error()}
+		}
+	}()
+	return ch, nil
+}
+
+// serveMCP 在真实的 hertz 服务上挂 ServeMCP（跳过 API Key 鉴权，固定为 alice），返回地址
+func serveMCP(t *testing.T, provider llm.Provider) (string, *runner.AgentEngine) {
+	t.Helper()
+	old := mcpHeartbeat
+	mcpHeartbeat = 20 * time.Millisecond
+	t.Cleanup(func() { mcpHeartbeat = old })
+
+	s := store.NewMemoryStore()
+	s.CreateAgent(&store.Agent{Name: "Coder", SystemPrompt: "You are coder.", ModelName: "test-model", Type: "system", Status: "active"})
+	engine := runner.NewEngine(s, llm.Fixed(provider))
+	engine.Workspaces = workspace.NewManager(t.TempDir())
+	engine.Queue.Start()
+	t.Cleanup(engine.Queue.Stop)
+	h := &Handler{Store: s, Engine: engine}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	srv := server.New(server.WithHostPorts(addr))
+	srv.POST("/mcp", func(c context.Context, ctx *app.RequestContext) {
+		ctx.Set(middleware.CtxKeyUserID, "alice")
+		ctx.Next(c)
+	}, h.ServeMCP)
+	go srv.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	})
+	for i := 0; ; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return addr, engine
+}
+
+// postMCP 在原始连接上发一个 JSON-RPC 请求，返回连接和读到响应头之后的 reader
+func postMCP(t *testing.T, addr, accept, body string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "POST /mcp HTTP/1.1\r\nHost: %s\r\nContent-Type: application/json\r\nAccept: %s\r\nContent-Length: %d\r\n\r\n%s", addr, accept, len(body), body)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, resp
+}
+
+const callCoder = `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"coder","arguments":{"task":"hi"}}}`
+
+func TestServeMCPQuickCallRespondsWithJSON(t *testing.T) {
+	addr, _ := serveMCP(t, slowProvider{})
+	_, _, resp := postMCP(t, addr, "application/json, text/event-stream", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("response = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestServeMCPSlowCallStreamsHeartbeats(t *testing.T) {
+	addr, _ := serveMCP(t, slowProvider{delay: 100 * time.Millisecond})
+	_, _, resp := postMCP(t, addr, "application/json, text/event-stream", callCoder)
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("content type = %s", resp.Header.Get("Content-Type"))
+	}
+	var heartbeats int
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == ": ping" {
+			heartbeats++
+		}
+		if strings.HasPrefix(line, "data:") {
+			if !strings.Contains(line, `"text":"done"`) || !strings.Contains(line, `"id":1`) {
+				t.Fatalf("message = %s", line)
+			}
+			if heartbeats == 0 {
+				t.Fatal("no heartbeat before the result")
+			}
+			return
+		}
+	}
+	t.Fatalf("stream ended without a result: %v", scanner.Err())
+}
+
+func TestServeMCPDisconnectCancelsRun(t *testing.T) {
+	addr, engine := serveMCP(t, slowProvider{delay: time.Hour})
+	conn, r, resp := postMCP(t, addr, "application/json, text/event-stream", callCoder)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	// 收到第一个心跳后断开
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == ": ping\n" {
+			break
+		}
+	}
+	conn.Close()
+
+	var runID string
+	for i := 0; runID == ""; i++ {
+		if runs := engine.Store.ListRunsByUser("alice"); len(runs) == 1 {
+			runID = runs[0].ID
+		} else if i == 500 {
+			t.Fatal("run not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := engine.Queue.Wait(runID)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != runner.ErrRunCancelled.Error() || engine.Store.GetRun(runID).Status != "cancelled" {
+			t.Fatalf("run ended with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run still running after the client disconnected")
+	}
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -137,6 +138,9 @@ func (h *Handler) DeleteChatSession(c context.Context, ctx *app.RequestContext)
 
 	// 执行删除
 	if h.Store.DeleteChatSession(id) {
+		if err := h.Svc.Workspaces.Remove(id); err != nil {
+			log.Printf("[Workspace] remove workspace of session %s failed: %v", id, err)
+		}
 		response.Success(ctx, map[string]string{"message": "Deleted"})
 	} else {
 		response.ServerError(ctx, fmt.Errorf("failed to delete session"))
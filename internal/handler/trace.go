@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/runner"
+	"example.com/agent-server/internal/store"
 	"example.com/agent-server/pkg/response"
 	"github.com/cloudwego/hertz/pkg/app"
 )
@@ -41,6 +43,37 @@ func (h *Handler) GetRunDetail(c context.Context, ctx *app.RequestContext) {
 	response.Success(ctx, run)
 }
 
+// FollowRunEvents 以 SSE 跟随 Run 的事件（格式同 /chat/stream），先补发已经推送过的
+// 已经结束的 Run 只推送一个 done / error 事件；不在队列里执行的 Run（e.g. handoff 子 Run）请跟随发起它的顶层 Run
+func (h *Handler) FollowRunEvents(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+
+	runID := ctx.Param("id")
+	run := h.Store.GetRun(runID)
+	if run == nil || run.UserID != userID {
+		response.Error(ctx, http.StatusNotFound, 40400, "Run not found")
+		return
+	}
+
+	if eventChan, stop, ok := h.Engine.Queue.Subscribe(runID); ok {
+		streamRunEvents(ctx, eventChan, stop)
+		return
+	}
+	end, ok := runEndEvent(run)
+	if !ok {
+		response.Error(ctx, http.StatusConflict, 40900, fmt.Sprintf("Run is %s and has no live events, poll GET /api/runs/%s instead", run.Status, run.ID))
+		return
+	}
+	eventChan := make(chan runner.RunStreamEvent, 1)
+	eventChan <- end
+	close(eventChan)
+	streamRunEvents(ctx, eventChan, nil)
+}
+
 // RunStepResp Trace 响应结构（支持树形结构）
 type RunStepResp struct {
 	ID            string                 `json:"id"`
@@ -185,6 +218,22 @@ func calculateLatency(started, finished time.Time) int {
 	return int(finished.Sub(started).Milliseconds())
 }
 
+// runEndEvent 已结束的 Run 对应的结束事件
+func runEndEvent(run *store.Run) (runner.RunStreamEvent, bool) {
+	ev := runner.RunStreamEvent{RunID: run.ID, AgentID: run.AgentID}
+	switch run.Status {
+	case "succeeded":
+		ev.Type = "done"
+		ev.Content, _ = run.OutputPayload["response"].(string)
+	case "failed", "cancelled", "budget_exceeded":
+		ev.Type = "error"
+		ev.Content, _ = run.OutputPayload["error"].(string)
+	default:
+		return ev, false
+	}
+	return ev, true
+}
+
 // CancelRun 异步取消任务
 func (h *Handler) CancelRun(c context.Context, ctx *app.RequestContext) {
 	userID, ok := middleware.GetUserID(ctx)
@@ -200,9 +249,9 @@ func (h *Handler) CancelRun(c context.Context, ctx *app.RequestContext) {
 		return
 	}
 
-	// 只有 running 状态的任务才需要取消
+	// 只有 queued / running / awaiting_approval 状态的任务才需要取消
 	// 但是为了幂等性，即使完成了也可以调 CancelRun，只是没效果
-	if run.Status != "running" {
+	if run.Status != "queued" && run.Status != "running" && run.Status != "awaiting_approval" {
 		response.Success(ctx, map[string]string{"message": "Run is not running"})
 		return
 	}
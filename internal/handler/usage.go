@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/store"
+	"example.com/agent-server/pkg/response"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// ==========================================
+// DTOs
+// ==========================================
+
+// UsageResp Token 用量统计
+type UsageResp struct {
+	From    string               `json:"from"` // 含
+	To      string               `json:"to"`   // 含
+	GroupBy []string             `json:"group_by"`
+	Buckets []*store.UsageBucket `json:"buckets"`
+	Total   store.UsageBucket    `json:"total"`
+}
+
+const (
+	usageDateLayout  = "2006-01-02"
+	usageDefaultDays = 30
+	usageMaxDays     = 366
+)
+
+// ==========================================
+// Handlers
+// ==========================================
+
+// GetUsage 按天 / Agent / 用户统计 Token 用量
+// 查询参数：group_by=day,agent,user（任意组合）、from / to（UTC 日期，含两端，默认最近 30 天）、agent_id、api_key_id
+// 普通用户只能看自己的；管理员可以用 user_id 指定用户，或 user_id=all 查看全部
+func (h *Handler) GetUsage(c context.Context, ctx *app.RequestContext) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return
+	}
+	admin := middleware.IsAdmin(ctx)
+
+	q := store.UsageQuery{UserID: userID, AgentID: ctx.Query("agent_id"), APIKeyID: ctx.Query("api_key_id")}
+	if target := ctx.Query("user_id"); target != "" && target != userID {
+		if !admin {
+			response.Error(ctx, http.StatusForbidden, 40300, "Only admins can view other users' usage")
+			return
+		}
+		q.UserID = target
+		if target == "all" {
+			q.UserID = ""
+		}
+	}
+
+	groupBy, msg := parseGroupBy(ctx.Query("group_by"))
+	if msg != "" {
+		response.BadRequest(ctx, msg)
+		return
+	}
+	for _, g := range groupBy {
+		if g == "user" && !admin {
+			response.Error(ctx, http.StatusForbidden, 40300, "Only admins can group usage by user")
+			return
+		}
+	}
+	q.GroupBy = groupBy
+
+	from, to, msg := parseUsageRange(ctx.Query("from"), ctx.Query("to"))
+	if msg != "" {
+		response.BadRequest(ctx, msg)
+		return
+	}
+	q.From, q.To = from, to.AddDate(0, 0, 1)
+
+	buckets := h.Store.AggregateUsage(q)
+	resp := UsageResp{
+		From:    from.Format(usageDateLayout),
+		To:      to.Format(usageDateLayout),
+		GroupBy: groupBy,
+		Buckets: buckets,
+	}
+	for _, b := range buckets {
+		resp.Total.Runs += b.Runs
+		resp.Total.LLMCalls += b.LLMCalls
+		resp.Total.PromptTokens += b.PromptTokens
+		resp.Total.CompletionTokens += b.CompletionTokens
+		resp.Total.TotalTokens += b.TotalTokens
+		resp.Total.CostUSD += b.CostUSD
+	}
+	response.Success(ctx, resp)
+}
+
+// ==========================================
+// Helper Functions
+// ==========================================
+
+// parseGroupBy 逗号分隔的分组维度，去重并保持顺序
+func parseGroupBy(raw string) ([]string, string) {
+	res := []string{}
+	seen := map[string]bool{}
+	for _, g := range strings.Split(raw, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" || seen[g] {
+			continue
+		}
+		if g != "day" && g != "agent" && g != "user" {
+			return nil, "group_by must be a comma-separated list of day, agent, user"
+		}
+		seen[g] = true
+		res = append(res, g)
+	}
+	return res, ""
+}
+
+// parseUsageRange 解析日期范围（UTC，含两端）
+func parseUsageRange(fromStr, toStr string) (time.Time, time.Time, string) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if toStr != "" {
+		t, err := time.Parse(usageDateLayout, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, "to must be a date like 2006-01-02"
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -(usageDefaultDays - 1))
+	if fromStr != "" {
+		t, err := time.Parse(usageDateLayout, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, "from must be a date like 2006-01-02"
+		}
+		from = t
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, "from must not be after to"
+	}
+	if to.Sub(from) >= usageMaxDays*24*time.Hour {
+		return time.Time{}, time.Time{}, "date range must not exceed 366 days"
+	}
+	return from, to, ""
+}
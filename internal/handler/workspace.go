@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"example.com/agent-server/internal/middleware"
+	"example.com/agent-server/internal/service/workspace"
+	"example.com/agent-server/internal/store"
+	"example.com/agent-server/pkg/response"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// ==========================================
+// DTOs
+// ==========================================
+
+// InitWorkspaceReq 从服务器本地目录初始化（仅管理员）；上传归档时用 multipart 的 file 字段
+type InitWorkspaceReq struct {
+	SourcePath string `json:"source_path" vd:"required"`
+}
+
+// ==========================================
+// Handlers
+// ==========================================
+
+// GetWorkspace 工作区概况
+func (h *Handler) GetWorkspace(c context.Context, ctx *app.RequestContext) {
+	s, ok := h.ownedSession(ctx)
+	if !ok {
+		return
+	}
+	info, err := h.Svc.Workspaces.Info(s.ID)
+	if err != nil {
+		response.ServerError(ctx, err)
+		return
+	}
+	response.Success(ctx, info)
+}
+
+// InitWorkspace 清空工作区并导入内容
+// multipart/form-data: file=<.tar / .tar.gz / .tgz / .zip>
+// application/json: {"source_path": "/srv/repos/demo"}（仅管理员）
+func (h *Handler) InitWorkspace(c context.Context, ctx *app.RequestContext) {
+	s, ok := h.ownedSession(ctx)
+	if !ok {
+		return
+	}
+
+	var err error
+	if strings.HasPrefix(string(ctx.ContentType()), "multipart/form-data") {
+		fileHeader, ferr := ctx.FormFile("file")
+		if ferr != nil {
+			response.BadRequest(ctx, "file is required: "+ferr.Error())
+			return
+		}
+		file, ferr := fileHeader.Open()
+		if ferr != nil {
+			response.ServerError(ctx, ferr)
+			return
+		}
+		defer file.Close()
+		err = h.Svc.Workspaces.InitFromArchive(s.ID, fileHeader.Filename, file)
+	} else {
+		var req InitWorkspaceReq
+		if berr := ctx.BindAndValidate(&req); berr != nil {
+			response.BadRequest(ctx, berr.Error())
+			return
+		}
+		// 读取服务器本地目录，普通用户只能上传归档
+		if !middleware.IsAdmin(ctx) {
+			response.Error(ctx, http.StatusForbidden, 40300, "Only admins can initialize a workspace from a server path; upload an archive instead.")
+			return
+		}
+		err = h.Svc.Workspaces.InitFromDir(s.ID, req.SourcePath)
+	}
+	if err != nil {
+		if errors.Is(err, workspace.ErrOutsideWorkspace) || errors.Is(err, workspace.ErrTooLarge) {
+			response.BadRequest(ctx, err.Error())
+		} else {
+			response.Error(ctx, http.StatusInternalServerError, 50000, err.Error())
+		}
+		return
+	}
+
+	info, _ := h.Svc.Workspaces.Info(s.ID)
+	response.Success(ctx, info)
+}
+
+// ResetWorkspace 清空工作区
+func (h *Handler) ResetWorkspace(c context.Context, ctx *app.RequestContext) {
+	s, ok := h.ownedSession(ctx)
+	if !ok {
+		return
+	}
+	if err := h.Svc.Workspaces.Reset(s.ID); err != nil {
+		response.ServerError(ctx, err)
+		return
+	}
+	response.Success(ctx, map[string]string{"message": "Workspace reset"})
+}
+
+// DownloadWorkspace 打包下载工作区 (tar.gz)
+func (h *Handler) DownloadWorkspace(c context.Context, ctx *app.RequestContext) {
+	s, ok := h.ownedSession(ctx)
+	if !ok {
+		return
+	}
+	if _, err := h.Svc.Workspaces.Ensure(s.ID); err != nil {
+		response.ServerError(ctx, err)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(h.Svc.Workspaces.Export(s.ID, pw))
+	}()
+	ctx.SetContentType("application/gzip")
+	ctx.Response.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="workspace-%s.tar.gz"`, s.ID))
+	ctx.SetBodyStream(pr, -1)
+}
+
+// ==========================================
+// Helper Functions
+// ==========================================
+
+// ownedSession 取路径中的会话并校验归属；失败时已写好响应
+func (h *Handler) ownedSession(ctx *app.RequestContext) (*store.ChatSession, bool) {
+	userID, ok := middleware.GetUserID(ctx)
+	if !ok {
+		response.Unauthorized(ctx, "Unauthorized")
+		return nil, false
+	}
+	s := h.Store.GetChatSession(ctx.Param("id"))
+	if s == nil || s.UserID != userID {
+		response.Error(ctx, http.StatusNotFound, 40400, "Session not found")
+		return nil, false
+	}
+	return s, true
+}
@@ -31,6 +31,11 @@ func RegisterRoutes(h *server.Hertz, hdl *handler.Handler, secret string) {
 	h.POST("/api/auth/login", hdl.Login)
 	h.POST("/api/auth/refresh", hdl.Refresh)
 
+	// MCP Server 端点：把 Agent 作为工具提供给 IDE 等 MCP 客户端，使用 API Key 鉴权
+	h.POST("/mcp", middleware.APIKeyAuth(hdl.Store), hdl.ServeMCP)
+	h.GET("/mcp", hdl.MCPStreamNotSupported)
+	h.DELETE("/mcp", hdl.MCPStreamNotSupported)
+
 	// ===========================
 	// 2. 受保护接口 (Protected)
 	// ===========================
@@ -48,6 +53,11 @@ func RegisterRoutes(h *server.Hertz, hdl *handler.Handler, secret string) {
 	g.POST("/api-keys", hdl.CreateAPIKey)
 	g.DELETE("/api-keys/:id", hdl.RevokeAPIKey)
 
+	g.GET("/integrations", hdl.ListIntegrations)
+	g.POST("/integrations", hdl.CreateIntegration)
+	g.PUT("/integrations/:id", hdl.UpdateIntegration)
+	g.DELETE("/integrations/:id", hdl.DeleteIntegration)
+
 	// --- Agent Management ---
 	g.GET("/agents", hdl.ListAgents)
 	g.POST("/agents", hdl.CreateAgent)
@@ -60,6 +70,11 @@ func RegisterRoutes(h *server.Hertz, hdl *handler.Handler, secret string) {
 	g.POST("/mcp/servers", hdl.RegisterMCPServer)
 	g.POST("/mcp/servers/:id/sync", hdl.SyncMCPTools)
 	g.GET("/mcp/servers/:id/tools", hdl.ListMCPTools)
+	g.GET("/mcp/servers/:id/health", hdl.GetMCPServerHealth)
+	g.GET("/mcp/servers/:id/resources", hdl.ListMCPResources)
+	g.GET("/mcp/servers/:id/resources/read", hdl.ReadMCPResource)
+	g.GET("/mcp/servers/:id/prompts", hdl.ListMCPPrompts)
+	g.POST("/mcp/servers/:id/prompts/:name", hdl.GetMCPPrompt)
 
 	// --- Knowledge Base ---
 	g.GET("/knowledge", hdl.ListKnowledgeBases)
@@ -76,9 +91,29 @@ func RegisterRoutes(h *server.Hertz, hdl *handler.Handler, secret string) {
 	g.POST("/sessions/:id/chat", hdl.SendChatMessage)
 	g.POST("/sessions/:id/chat/stream", hdl.SendChatMessageStream) // 流式聊天
 
+	// 会话工作区（内置 git / filesystem 工具的根目录）
+	g.GET("/sessions/:id/workspace", hdl.GetWorkspace)
+	g.POST("/sessions/:id/workspace/init", hdl.InitWorkspace)
+	g.POST("/sessions/:id/workspace/reset", hdl.ResetWorkspace)
+	g.GET("/sessions/:id/workspace/download", hdl.DownloadWorkspace)
+
 	// --- Observability ---
 	g.GET("/runs", hdl.ListRuns)
 	g.GET("/runs/:id", hdl.GetRunDetail)
 	g.GET("/runs/:id/trace", hdl.GetRunTrace)
+	g.GET("/runs/:id/events", hdl.FollowRunEvents)
 	g.POST("/runs/:id/cancel", hdl.CancelRun)
+	g.GET("/usage", hdl.GetUsage) // Token 用量统计
+
+	// 花费预算（用户 / API Key / Agent）
+	g.GET("/budgets", hdl.ListBudgets)
+	g.PUT("/budgets/:scope/:id", hdl.SetBudget)
+	g.DELETE("/budgets/:scope/:id", hdl.DeleteBudget)
+
+	// 工具调用审批（Human-in-the-loop）
+	g.GET("/approvals", hdl.ListApprovals)
+	g.GET("/approvals/:id", hdl.GetApproval)
+	g.POST("/approvals/:id/approve", hdl.ApproveToolCall)
+	g.POST("/approvals/:id/edit", hdl.EditToolCall)
+	g.POST("/approvals/:id/reject", hdl.RejectToolCall)
 }
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/agent-server/internal/auth"
+	"example.com/agent-server/internal/store"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+// CtxKeyAPIKeyID 通过 API Key 鉴权时，请求使用的 Key
+const CtxKeyAPIKeyID = "apiKeyID"
+
+// APIKeyAuth API Key 鉴权中间件，供 CLI / IDE 等非浏览器客户端使用
+// 接受 Authorization: Bearer sk-nx-... 或 X-API-Key: sk-nx-...，通过后与 JWT 一样注入 UserID
+func APIKeyAuth(s store.Store) app.HandlerFunc {
+	return func(c context.Context, ctx *app.RequestContext) {
+		raw := string(ctx.Request.Header.Get("X-API-Key"))
+		if raw == "" {
+			parts := strings.SplitN(string(ctx.Request.Header.Get("Authorization")), " ", 2)
+			if len(parts) == 2 && parts[0] == "Bearer" {
+				raw = parts[1]
+			}
+		}
+		if raw == "" {
+			ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="nexus"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, map[string]string{
+				"error": "Missing API key",
+			})
+			return
+		}
+
+		key := s.FindAPIKeyByHash(auth.HashAPIKey(raw))
+		if key == nil || (!key.ExpiresAt.IsZero() && key.ExpiresAt.Before(time.Now())) {
+			ctx.Response.Header.Set("WWW-Authenticate", `Bearer realm="nexus", error="invalid_token"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, map[string]string{
+				"error": "Invalid or expired API key",
+			})
+			return
+		}
+		s.TouchAPIKey(key.ID)
+
+		ctx.Set(CtxKeyUserID, key.UserID)
+		ctx.Set(CtxKeyAPIKeyID, key.ID)
+		ctx.Next(c)
+	}
+}
+
+// GetAPIKeyID 请求使用的 API Key；JWT 鉴权的请求返回空串
+func GetAPIKeyID(ctx *app.RequestContext) string {
+	val, _ := ctx.Get(CtxKeyAPIKeyID)
+	id, _ := val.(string)
+	return id
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/auth"
+	"example.com/agent-server/internal/store"
+	"github.com/cloudwego/hertz/pkg/app"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	s := store.NewMemoryStore()
+	valid := s.CreateAPIKey(&store.APIKey{UserID: "alice", Name: "cli", KeyHash: auth.HashAPIKey("sk-nx-valid")})
+	s.CreateAPIKey(&store.APIKey{UserID: "alice", Name: "old", KeyHash: auth.HashAPIKey("sk-nx-expired"), ExpiresAt: time.Now().Add(-time.Hour)})
+	s.CreateAPIKey(&store.APIKey{UserID: "bob", Name: "later", KeyHash: auth.HashAPIKey("sk-nx-future"), ExpiresAt: time.Now().Add(time.Hour)})
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		user    string
+		auth    string // WWW-Authenticate
+	}{
+		{"bearer", map[string]string{"Authorization": "Bearer sk-nx-valid"}, http.StatusOK, "alice", ""},
+		{"x-api-key", map[string]string{"X-API-Key": "sk-nx-valid"}, http.StatusOK, "alice", ""},
+		{"not yet expired", map[string]string{"X-API-Key": "sk-nx-future"}, http.StatusOK, "bob", ""},
+		{"missing", nil, http.StatusUnauthorized, "", `Bearer realm="nexus"`},
+		{"not bearer", map[string]string{"Authorization": "Basic sk-nx-valid"}, http.StatusUnauthorized, "", `Bearer realm="nexus"`},
+		{"unknown", map[string]string{"Authorization": "Bearer sk-nx-unknown"}, http.StatusUnauthorized, "", `error="invalid_token"`},
+		{"expired", map[string]string{"X-API-Key": "sk-nx-expired"}, http.StatusUnauthorized, "", `error="invalid_token"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := app.NewContext(0)
+			for k, v := range c.headers {
+				ctx.Request.Header.Set(k, v)
+			}
+			var reached bool
+			ctx.SetHandlers(app.HandlersChain{APIKeyAuth(s), func(c context.Context, ctx *app.RequestContext) {
+				reached = true
+				ctx.SetStatusCode(http.StatusOK)
+			}})
+			ctx.Next(context.Background())
+
+			if got := ctx.Response.StatusCode(); got != c.status {
+				t.Fatalf("status = %d, want %d", got, c.status)
+			}
+			if reached != (c.status == http.StatusOK) {
+				t.Fatalf("handler reached = %v", reached)
+			}
+			userID, _ := GetUserID(ctx)
+			if userID != c.user {
+				t.Fatalf("user = %q", userID)
+			}
+			if got := string(ctx.Response.Header.Peek("WWW-Authenticate")); !strings.Contains(got, c.auth) || (c.auth == "") != (got == "") {
+				t.Fatalf("WWW-Authenticate = %q", got)
+			}
+		})
+	}
+
+	// 只有鉴权成功的请求才更新 last_used_at，并记录使用的 Key
+	ctx := app.NewContext(0)
+	ctx.Request.Header.Set("X-API-Key", "sk-nx-valid")
+	ctx.SetHandlers(app.HandlersChain{APIKeyAuth(s)})
+	ctx.Next(context.Background())
+	if GetAPIKeyID(ctx) != valid.ID || s.GetAPIKey(valid.ID).LastUsedAt.IsZero() {
+		t.Fatalf("key id = %q, last used = %v", GetAPIKeyID(ctx), s.GetAPIKey(valid.ID).LastUsedAt)
+	}
+}
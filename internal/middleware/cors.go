@@ -23,6 +23,8 @@ func Cors() app.HandlerFunc {
 			"Accept",
 			"Authorization",
 			"X-Request-ID",
+			"X-API-Key",            // MCP Server 端点
+			"Mcp-Protocol-Version", // 浏览器里的 MCP 客户端（如 Inspector）会带上
 		},
 
 		// 暴露给前端的 Header (比如前端需要读取 X-Request-ID)
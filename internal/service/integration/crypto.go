@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 密文格式：v1:<base64(nonce || AES-256-GCM 密文 || tag)>
+// 版本前缀为以后更换算法 / 轮换主密钥留余地
+const cipherVersion = "v1:"
+
+var ErrDecrypt = errors.New("integration credentials cannot be decrypted")
+
+// Cipher 用服务端主密钥做认证加密 (AES-256-GCM)
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher masterKey 必须是 32 字节
+func NewCipher(masterKey []byte) (*Cipher, error) {
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(masterKey))
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// ParseMasterKey 解析环境变量中的主密钥，支持 64 位 hex 或 base64 编码的 32 字节
+func ParseMasterKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	return nil, fmt.Errorf("master key must be 32 bytes encoded as hex or base64")
+}
+
+// Seal 加密；aad 绑定到密文上（用户 + 平台），密文被挪到别的记录上无法解密
+func (c *Cipher) Seal(plaintext, aad []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := c.aead.Seal(nonce, nonce, plaintext, aad)
+	return cipherVersion + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Open 解密；密文被篡改、aad 不匹配或主密钥不对都返回 ErrDecrypt
+func (c *Cipher) Open(sealed string, aad []byte) ([]byte, error) {
+	if !strings.HasPrefix(sealed, cipherVersion) {
+		return nil, ErrDecrypt
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, cipherVersion))
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ct := raw[:c.aead.NonceSize()], raw[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ct, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
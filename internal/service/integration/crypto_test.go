@@ -0,0 +1,84 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testCipher(t *testing.T, fill byte) *Cipher {
+	t.Helper()
+	c, err := NewCipher(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c := testCipher(t, 1)
+	aad := []byte("alice/github")
+	sealed, err := c.Seal([]byte(`{"token":"ghp_alice"}`), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, cipherVersion) || strings.Contains(sealed, "ghp_alice") {
+		t.Fatalf("sealed = %q", sealed)
+	}
+	// 每次随机 nonce，同一明文的密文不同
+	if again, _ := c.Seal([]byte(`{"token":"ghp_alice"}`), aad); again == sealed {
+		t.Fatal("nonce reused")
+	}
+	plain, err := c.Open(sealed, aad)
+	if err != nil || string(plain) != `{"token":"ghp_alice"}` {
+		t.Fatalf("open = %q, %v", plain, err)
+	}
+}
+
+func TestCipherOpenRejects(t *testing.T) {
+	c := testCipher(t, 1)
+	aad := []byte("alice/github")
+	sealed, err := c.Seal([]byte("secret"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, cipherVersion))
+	flip := func(i int) string {
+		b := append([]byte(nil), raw...)
+		b[i] ^= 0x01
+		return cipherVersion + base64.StdEncoding.EncodeToString(b)
+	}
+
+	cases := []struct {
+		name   string
+		cipher *Cipher
+		sealed string
+		aad    string
+	}{
+		{"tampered ciphertext", c, flip(len(raw) / 2), "alice/github"},
+		{"tampered tag", c, flip(len(raw) - 1), "alice/github"},
+		{"tampered nonce", c, flip(0), "alice/github"},
+		{"wrong aad", c, sealed, "bob/github"},
+		{"missing aad", c, sealed, ""},
+		{"wrong key", testCipher(t, 2), sealed, "alice/github"},
+		{"unknown version", c, "v2:" + strings.TrimPrefix(sealed, cipherVersion), "alice/github"},
+		{"truncated", c, cipherVersion + base64.StdEncoding.EncodeToString(raw[:4]), "alice/github"},
+		{"not base64", c, cipherVersion + "!!!", "alice/github"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			plain, err := tc.cipher.Open(tc.sealed, []byte(tc.aad))
+			if !errors.Is(err, ErrDecrypt) || plain != nil {
+				t.Fatalf("open = %q, %v", plain, err)
+			}
+		})
+	}
+}
+
+func TestNewCipherKeyLength(t *testing.T) {
+	if _, err := NewCipher(make([]byte, 16)); err == nil {
+		t.Fatal("16-byte key accepted")
+	}
+}
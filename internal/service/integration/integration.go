@@ -0,0 +1,87 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"example.com/agent-server/internal/store"
+)
+
+var (
+	ErrNoMasterKey = errors.New("integration master key not configured (INTEGRATION_MASTER_KEY)")
+	ErrNotBound    = errors.New("integration not bound")
+)
+
+// Service 管理用户绑定的外部平台凭证 (GitHub Token 等)
+// 凭证以 JSON 对象 {"token": "..."} 的形式整体加密后存库，明文只在注入 MCP 连接时短暂出现在内存里
+type Service struct {
+	Store  store.Store
+	cipher *Cipher // 未配置主密钥时为 nil，所有加解密操作返回 ErrNoMasterKey
+}
+
+func NewService(s store.Store, c *Cipher) *Service {
+	return &Service{Store: s, cipher: c}
+}
+
+// Enabled 是否配置了主密钥
+func (s *Service) Enabled() bool {
+	return s.cipher != nil
+}
+
+// Seal 加密一组凭证，密文绑定到 (userID, provider)
+func (s *Service) Seal(userID, provider string, creds map[string]string) (string, error) {
+	if s.cipher == nil {
+		return "", ErrNoMasterKey
+	}
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return "", err
+	}
+	return s.cipher.Seal(b, aad(userID, provider))
+}
+
+// Credentials 解密用户在某个平台上的凭证，实现 mcp.CredentialSource
+func (s *Service) Credentials(ctx context.Context, userID, provider string) (map[string]string, error) {
+	if s.cipher == nil {
+		return nil, ErrNoMasterKey
+	}
+	in := s.Store.GetIntegrationByProvider(userID, provider)
+	if in == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotBound, provider)
+	}
+	plain, err := s.cipher.Open(in.EncryptedCredentials, aad(in.UserID, in.Provider))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", provider, err)
+	}
+	var creds map[string]string
+	if err := json.Unmarshal(plain, &creds); err != nil {
+		return nil, fmt.Errorf("%s: %w", provider, ErrDecrypt)
+	}
+	return creds, nil
+}
+
+func aad(userID, provider string) []byte {
+	return []byte(userID + "/" + provider)
+}
+
+// DisplayLabel 生成前端展示用的掩码，e.g. "gh...890"；只露出首尾少量字符
+func DisplayLabel(creds map[string]string) string {
+	keys := make([]string, 0, len(creds))
+	for k := range creds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := creds[k]
+		if len(v) >= 12 {
+			return v[:2] + "..." + v[len(v)-3:]
+		}
+		if v != "" {
+			return "***"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,351 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Messages 风格 API (Anthropic /v1/messages) 适配器
+const (
+	defaultAnthropicBaseURL = "https://api.anthropic.com/v1"
+	anthropicVersion        = "2023-06-01"
+	defaultMaxTokens        = 4096
+)
+
+type AnthropicClient struct {
+	config     LLMConfig
+	httpClient *http.Client
+}
+
+// NewAnthropicClient BaseURL 形如 https://api.anthropic.com/v1，请求发往 {BaseURL}/messages
+func NewAnthropicClient(cfg LLMConfig) *AnthropicClient {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultAnthropicBaseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return &AnthropicClient{config: cfg, httpClient: &http.Client{Timeout: 10 * time.Minute}}
+}
+
+// ==========================================
+// 请求 / 响应结构
+// ==========================================
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float32            `json:"temperature"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"` // user / assistant
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type string `json:"type"` // text / tool_use / tool_result
+
+	Text string `json:"text,omitempty"`
+
+	// tool_use
+	ID    string      `json:"id,omitempty"`
+	Name  string      `json:"name,omitempty"`
+	Input interface{} `json:"input,omitempty"` // 用 interface{}：空对象 {} 也必须带上
+
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+// ChatCompletion 同步调用
+func (c *AnthropicClient) ChatCompletion(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	resp, err := c.do(ctx, c.buildRequest(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("anthropic decode response: %w", err)
+	}
+
+	var textBuf strings.Builder
+	var calls []ToolCallInfo
+	for _, b := range out.Content {
+		switch b.Type {
+		case "text":
+			textBuf.WriteString(b.Text)
+		case "tool_use":
+			calls = append(calls, ToolCallInfo{ID: b.ID, Name: b.Name, Arguments: argumentsString(b.Input)})
+		}
+	}
+
+	text, handoff, raw := parseContentAndHandoff(textBuf.String())
+	return &ChatResponse{
+		Content:    text,
+		ToolCalls:  toOpenAIToolCalls(calls),
+		Handoff:    handoff,
+		RawPayload: raw,
+		Usage: map[string]int{
+			"prompt_tokens":     out.Usage.InputTokens,
+			"completion_tokens": out.Usage.OutputTokens,
+			"total_tokens":      out.Usage.InputTokens + out.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// ChatStream 流式调用，解析 SSE 事件：
+// content_block_start / content_block_delta (text_delta, input_json_delta) / message_delta / message_stop / error
+func (c *AnthropicClient) ChatStream(ctx context.Context, req *ChatRequest) (<-chan StreamEvent, error) {
+	resp, err := c.do(ctx, c.buildRequest(req, true))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent, 10)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		var contentBuffer strings.Builder
+		blocks := make(map[int]*toolCallAccumulator) // index -> tool_use 块
+		var order []int
+		var usage anthropicUsage // message_start 给 input，message_delta 给累计 output
+
+		finish := func() {
+			usageMap := map[string]int{
+				"prompt_tokens":     usage.InputTokens,
+				"completion_tokens": usage.OutputTokens,
+				"total_tokens":      usage.InputTokens + usage.OutputTokens,
+			}
+			if len(order) > 0 {
+				calls := make([]ToolCallInfo, 0, len(order))
+				for _, idx := range order {
+					acc := blocks[idx]
+					args := acc.Args.String()
+					if strings.TrimSpace(args) == "" {
+						args = "{}"
+					}
+					calls = append(calls, ToolCallInfo{ID: acc.ID, Name: acc.Name, Arguments: args})
+				}
+				ch <- StreamEvent{Type: "tool_call", Content: contentBuffer.String(), ToolCalls: calls, Usage: usageMap}
+				return
+			}
+			text, handoff, _ := parseContentAndHandoff(contentBuffer.String())
+			if handoff != nil && handoff.TargetAgentID != "" {
+				ch <- StreamEvent{Type: "handoff", Content: text, Handoff: handoff, Usage: usageMap}
+			} else {
+				ch <- StreamEvent{Type: "done", Content: text, Usage: usageMap}
+			}
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data:") {
+				// event: 行与 data 里的 type 冗余，直接看 data
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			var ev struct {
+				Type         string          `json:"type"`
+				Index        int             `json:"index"`
+				ContentBlock *anthropicBlock `json:"content_block"`
+				Delta        struct {
+					Type        string `json:"type"`
+					Text        string `json:"text"`
+					PartialJSON string `json:"partial_json"`
+				} `json:"delta"`
+				Error *struct {
+					Type    string `json:"type"`
+					Message string `json:"message"`
+				} `json:"error"`
+				Message *struct {
+					Usage anthropicUsage `json:"usage"`
+				} `json:"message"`
+				Usage *anthropicUsage `json:"usage"`
+			}
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				continue
+			}
+
+			switch ev.Type {
+			case "message_start":
+				if ev.Message != nil {
+					usage.InputTokens = ev.Message.Usage.InputTokens
+				}
+			case "message_delta":
+				if ev.Usage != nil {
+					usage.OutputTokens = ev.Usage.OutputTokens
+				}
+			case "content_block_start":
+				if ev.ContentBlock != nil && ev.ContentBlock.Type == "tool_use" {
+					blocks[ev.Index] = &toolCallAccumulator{ID: ev.ContentBlock.ID, Name: ev.ContentBlock.Name}
+					order = append(order, ev.Index)
+				}
+			case "content_block_delta":
+				switch ev.Delta.Type {
+				case "text_delta":
+					if ev.Delta.Text != "" {
+						contentBuffer.WriteString(ev.Delta.Text)
+						ch <- StreamEvent{Type: "content", Content: ev.Delta.Text}
+					}
+				case "input_json_delta":
+					if acc, ok := blocks[ev.Index]; ok {
+						acc.Args.WriteString(ev.Delta.PartialJSON)
+					}
+				}
+			case "message_stop":
+				finish()
+				return
+			case "error":
+				msg := "anthropic stream error"
+				if ev.Error != nil {
+					msg = ev.Error.Type + ": " + ev.Error.Message
+				}
+				ch <- StreamEvent{Type: "error", Error: msg}
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			ch <- StreamEvent{Type: "error", Error: err.Error()}
+			return
+		}
+		// 连接正常关闭但没收到 message_stop，按已收到的内容收尾
+		finish()
+	}()
+	return ch, nil
+}
+
+func (c *AnthropicClient) do(ctx context.Context, body *anthropicRequest) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.BaseURL+"/messages", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", c.config.ApiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+	if body.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic request: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, httpStatusError("anthropic", resp)
+	}
+	return resp, nil
+}
+
+func (c *AnthropicClient) buildRequest(req *ChatRequest, stream bool) *anthropicRequest {
+	model := req.Model
+	if model == "" {
+		model = c.config.ModelName
+	}
+	temperature := c.config.Temperature
+	if req.Temperature != nil {
+		temperature = *req.Temperature
+	}
+	maxTokens := c.config.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultMaxTokens
+	}
+
+	out := &anthropicRequest{
+		Model:       model,
+		System:      systemPrompt(req),
+		Messages:    c.buildMessages(req),
+		MaxTokens:   maxTokens,
+		Temperature: temperature,
+		Stream:      stream,
+	}
+	for _, t := range req.Tools {
+		out.Tools = append(out.Tools, anthropicTool{Name: t.Name, Description: t.Description, InputSchema: toolSchema(t)})
+	}
+	return out
+}
+
+// buildMessages 转成 user / assistant 交替的消息
+// tool 结果作为 user 消息里的 tool_result 块；相邻同角色消息合并
+func (c *AnthropicClient) buildMessages(req *ChatRequest) []anthropicMessage {
+	var msgs []anthropicMessage
+	push := func(role string, blocks ...anthropicBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(msgs); n > 0 && msgs[n-1].Role == role {
+			msgs[n-1].Content = append(msgs[n-1].Content, blocks...)
+			return
+		}
+		msgs = append(msgs, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	for _, m := range req.History {
+		text := extractContent(m.Content)
+		switch m.Role {
+		case "assistant":
+			var blocks []anthropicBlock
+			if calls := historyToolCalls(m); len(calls) > 0 {
+				if t, ok := m.Content["text"].(string); ok && t != "" {
+					blocks = append(blocks, anthropicBlock{Type: "text", Text: t})
+				}
+				for _, tc := range calls {
+					blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Name, Input: argumentsObject(tc.Arguments)})
+				}
+			} else if text != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+			}
+			push("assistant", blocks...)
+		case "tool":
+			push("user", anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: text})
+		case "system":
+			// 历史里的 system 消息（e.g. 摘要）没有对应角色，作为 user 文本带上
+			if text != "" {
+				push("user", anthropicBlock{Type: "text", Text: text})
+			}
+		default:
+			if text != "" {
+				push("user", anthropicBlock{Type: "text", Text: text})
+			}
+		}
+	}
+	if req.UserPrompt != "" {
+		push("user", anthropicBlock{Type: "text", Text: req.UserPrompt})
+	}
+	return msgs
+}
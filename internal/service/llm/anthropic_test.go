@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAnthropic 按固定脚本应答 /messages：stream=true 时逐条写 SSE 事件，否则写 JSON 响应
+func fakeAnthropic(t *testing.T, status int, body string, events ...string) (*AnthropicClient, *anthropicRequest) {
+	t.Helper()
+	var got anthropicRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "sk-test" || r.Header.Get("anthropic-version") != anthropicVersion {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, body, status)
+			return
+		}
+		if !got.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, body)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, ev := range events {
+			var head struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal([]byte(ev), &head)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", head.Type, ev)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return NewAnthropicClient(LLMConfig{ApiKey: "sk-test", BaseURL: srv.URL + "/v1/", ModelName: "claude-test"}), &got
+}
+
+func TestAnthropicChatStream(t *testing.T) {
+	client, got := fakeAnthropic(t, http.StatusOK, "",
+		`{"type":"message_start","message":{"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"fs__read_file","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"path\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":" \"a.txt\"}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"git__status","input":{}}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":30}}`,
+		`{"type":"message_stop"}`,
+	)
+
+	events := collect(t, client, &ChatRequest{UserPrompt: "read a.txt"})
+	if got.Model != "claude-test" || got.MaxTokens != defaultMaxTokens || !got.Stream {
+		t.Fatalf("request = %+v", got)
+	}
+	if len(events) != 3 || events[0].Content != "Let me " || events[1].Content != "check." {
+		t.Fatalf("events = %+v", events)
+	}
+	last := events[2]
+	if last.Type != "tool_call" || last.Content != "Let me check." {
+		t.Fatalf("final event = %+v", last)
+	}
+	// 分片的 input_json 拼成完整参数，没有参数的调用补 {}
+	wantCalls := []ToolCallInfo{
+		{ID: "toolu_1", Name: "fs__read_file", Arguments: `{"path": "a.txt"}`},
+		{ID: "toolu_2", Name: "git__status", Arguments: "{}"},
+	}
+	if !reflect.DeepEqual(last.ToolCalls, wantCalls) {
+		t.Fatalf("tool calls = %+v", last.ToolCalls)
+	}
+	wantUsage := map[string]int{"prompt_tokens": 12, "completion_tokens": 30, "total_tokens": 42}
+	if !reflect.DeepEqual(last.Usage, wantUsage) {
+		t.Fatalf("usage = %v", last.Usage)
+	}
+}
+
+func TestAnthropicChatStreamError(t *testing.T) {
+	client, _ := fakeAnthropic(t, http.StatusOK, "",
+		`{"type":"message_start","message":{"usage":{"input_tokens":5}}}`,
+		`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	)
+	events := collect(t, client, &ChatRequest{UserPrompt: "hi"})
+	if len(events) != 1 || events[0].Type != "error" || events[0].Error != "overloaded_error: Overloaded" {
+		t.Fatalf("events = %+v", events)
+	}
+}
+
+func TestAnthropicChatCompletion(t *testing.T) {
+	client, _ := fakeAnthropic(t, http.StatusOK, `{
+		"content": [
+			{"type": "text", "text": "Reading it."},
+			{"type": "tool_use", "id": "toolu_1", "name": "fs__read_file", "input": {"path": "a.txt"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 20, "output_tokens": 8}
+	}`)
+	resp, err := client.ChatCompletion(context.Background(), &ChatRequest{UserPrompt: "read a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "Reading it." || len(resp.ToolCalls) != 1 {
+		t.Fatalf("response = %+v", resp)
+	}
+	if tc := resp.ToolCalls[0]; tc.ID != "toolu_1" || tc.Function.Name != "fs__read_file" || tc.Function.Arguments != `{"path":"a.txt"}` {
+		t.Fatalf("tool call = %+v", tc)
+	}
+	if resp.Usage["prompt_tokens"] != 20 || resp.Usage["completion_tokens"] != 8 || resp.Usage["total_tokens"] != 28 {
+		t.Fatalf("usage = %v", resp.Usage)
+	}
+}
+
+func TestAnthropicErrorStatus(t *testing.T) {
+	body := `{"type":"error","error":{"type":"rate_limit_error","message":"Number of requests has exceeded your rate limit"}}`
+	client, _ := fakeAnthropic(t, http.StatusTooManyRequests, body)
+
+	_, err := client.ChatCompletion(context.Background(), &ChatRequest{UserPrompt: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "status 429") || !strings.Contains(err.Error(), "rate_limit_error") {
+		t.Fatalf("completion error = %v", err)
+	}
+	// 流式调用在建立流之前就返回错误，不会开 channel
+	ch, err := client.ChatStream(context.Background(), &ChatRequest{UserPrompt: "hi"})
+	if ch != nil || err == nil || !strings.Contains(err.Error(), "anthropic api error: status 429") {
+		t.Fatalf("stream error = %v", err)
+	}
+}
+
+// collect 发起流式调用并读完所有事件
+func collect(t *testing.T, p Provider, req *ChatRequest) []StreamEvent {
+	t.Helper()
+	ch, err := p.ChatStream(context.Background(), req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	var events []StreamEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatalf("stream not closed, got %+v", events)
+		}
+	}
+}
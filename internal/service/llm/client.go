@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 
 	"example.com/agent-server/internal/store" // 引入你的 store 包
@@ -17,13 +18,25 @@ type LLMConfig struct {
 	BaseURL     string
 	ModelName   string
 	Temperature float32
+	MaxTokens   int // 仅 Messages 风格 API 必填，<=0 用默认值
 }
 
-// 定义一个接口，方便 Runner 做 Mock 测试
+// Provider 大模型调用的统一接口，Runner 只依赖它
+// 实现：Client (OpenAI 兼容) / AnthropicClient (Messages 风格) / OllamaClient (Ollama 风格)
 type Provider interface {
+	// ChatCompletion 同步调用
 	ChatCompletion(ctx context.Context, req *ChatRequest) (*ChatResponse, error)
+	// ChatStream 流式调用：依次推送 content 增量，最后以 tool_call / handoff / done / error 之一结束
+	ChatStream(ctx context.Context, req *ChatRequest) (<-chan StreamEvent, error)
 }
 
+// 编译期检查各适配器实现了 Provider
+var (
+	_ Provider = (*Client)(nil)
+	_ Provider = (*AnthropicClient)(nil)
+	_ Provider = (*OllamaClient)(nil)
+)
+
 type Client struct {
 	client *openai.Client
 	config LLMConfig
@@ -53,6 +66,10 @@ type ChatRequest struct {
 
 	// 是否强制提示 LLM 返回携带 handoff 字段的 JSON
 	ForceHandoff bool
+
+	// 本次请求使用的模型和温度（按 Agent 选择）；为空则用 Client 的默认配置
+	Model       string
+	Temperature *float32
 }
 
 // ChatResponse 统一响应结果
@@ -94,7 +111,8 @@ type HandoffDecision struct {
 
 // StreamEvent 定义流式返回的事件类型
 type StreamEvent struct {
-	Type    string `json:"type"` // "content", "tool_call", "handoff", "error", "done"
+	Type string `json:"type"` // "content", "tool_call", "handoff", "error", "done"
+	// content 事件为增量文本；结束事件 (tool_call / handoff / done) 为本轮完整文本（已去掉 handoff JSON 包装）
 	Content string `json:"content,omitempty"`
 
 	// 完整的工具调用信息（内部拼接完成后才发送）
@@ -105,6 +123,9 @@ type StreamEvent struct {
 
 	// 错误信息
 	Error string `json:"error,omitempty"`
+
+	// Usage 仅在结束事件 (tool_call / handoff / done) 上携带，Provider 不返回时为空
+	Usage map[string]int `json:"usage,omitempty"`
 }
 
 // ToolCallInfo 工具调用信息（简化版，不依赖 openai 包）
@@ -120,11 +141,12 @@ func (c *Client) ChatStream(ctx context.Context, req *ChatRequest) (<-chan Strea
 	tools := c.buildTools(req.Tools)
 
 	apiReq := openai.ChatCompletionRequest{
-		Model:       c.config.ModelName,
-		Messages:    messages,
-		Tools:       tools,
-		Temperature: c.config.Temperature,
-		Stream:      true,
+		Model:         c.model(req),
+		Messages:      messages,
+		Tools:         tools,
+		Temperature:   c.temperature(req),
+		Stream:        true,
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true}, // 最后一个 chunk 带上 usage
 	}
 
 	stream, err := c.client.CreateChatCompletionStream(ctx, apiReq)
@@ -141,6 +163,8 @@ func (c *Client) ChatStream(ctx context.Context, req *ChatRequest) (<-chan Strea
 		var contentBuffer strings.Builder
 		// toolCallsBuffer: map[index] -> {id, name, argsBuffer}
 		toolCallsBuffer := make(map[int]*toolCallAccumulator)
+		var finishReason openai.FinishReason
+		var usage map[string]int
 
 		for {
 			chunk, err := stream.Recv()
@@ -152,12 +176,22 @@ func (c *Client) ChatStream(ctx context.Context, req *ChatRequest) (<-chan Strea
 				return
 			}
 
+			// include_usage 时最后一个 chunk 的 choices 为空，只有 usage
+			if chunk.Usage != nil {
+				usage = map[string]int{
+					"prompt_tokens":     chunk.Usage.PromptTokens,
+					"completion_tokens": chunk.Usage.CompletionTokens,
+					"total_tokens":      chunk.Usage.TotalTokens,
+				}
+			}
 			if len(chunk.Choices) == 0 {
 				continue
 			}
 
 			delta := chunk.Choices[0].Delta
-			finishReason := chunk.Choices[0].FinishReason
+			if chunk.Choices[0].FinishReason != "" {
+				finishReason = chunk.Choices[0].FinishReason
+			}
 
 			// 1. 处理文本内容增量
 			if delta.Content != "" {
@@ -187,40 +221,32 @@ func (c *Client) ChatStream(ctx context.Context, req *ChatRequest) (<-chan Strea
 					acc.Args.WriteString(tc.Function.Arguments)
 				}
 			}
+		}
 
-			// 3. 检查结束原因
-			if finishReason == openai.FinishReasonToolCalls {
-				// 工具调用结束，发送完整的工具调用信息
-				var calls []ToolCallInfo
-				for i := 0; i < len(toolCallsBuffer); i++ {
-					if acc, ok := toolCallsBuffer[i]; ok {
-						calls = append(calls, ToolCallInfo{
-							ID:        acc.ID,
-							Name:      acc.Name,
-							Arguments: acc.Args.String(),
-						})
-					}
-				}
-				ch <- StreamEvent{Type: "tool_call", ToolCalls: calls}
-				return
-			}
-
-			if finishReason == openai.FinishReasonStop || finishReason == "stop" {
-				// 正常结束，解析 handoff
-				fullContent := contentBuffer.String()
-				_, handoff, _ := parseContentAndHandoff(fullContent)
-
-				if handoff != nil && handoff.TargetAgentID != "" {
-					ch <- StreamEvent{Type: "handoff", Handoff: handoff}
-				} else {
-					ch <- StreamEvent{Type: "done"}
+		// 3. 流结束：根据结束原因发送结束事件
+		// 部分兼容接口 finish_reason 不规范，有拼出来的工具调用就按工具调用处理
+		if finishReason == openai.FinishReasonToolCalls || len(toolCallsBuffer) > 0 {
+			var calls []ToolCallInfo
+			for i := 0; i < len(toolCallsBuffer); i++ {
+				if acc, ok := toolCallsBuffer[i]; ok {
+					calls = append(calls, ToolCallInfo{
+						ID:        acc.ID,
+						Name:      acc.Name,
+						Arguments: acc.Args.String(),
+					})
 				}
-				return
 			}
+			ch <- StreamEvent{Type: "tool_call", Content: contentBuffer.String(), ToolCalls: calls, Usage: usage}
+			return
 		}
 
-		// 流正常结束但没有明确的 finish_reason
-		ch <- StreamEvent{Type: "done"}
+		// 正常结束，解析 handoff
+		text, handoff, _ := parseContentAndHandoff(contentBuffer.String())
+		if handoff != nil && handoff.TargetAgentID != "" {
+			ch <- StreamEvent{Type: "handoff", Content: text, Handoff: handoff, Usage: usage}
+		} else {
+			ch <- StreamEvent{Type: "done", Content: text, Usage: usage}
+		}
 	}()
 
 	return ch, nil
@@ -243,10 +269,10 @@ func (c *Client) ChatCompletion(ctx context.Context, req *ChatRequest) (*ChatRes
 
 	// 3. 发起请求
 	apiReq := openai.ChatCompletionRequest{
-		Model:       c.config.ModelName,
+		Model:       c.model(req),
 		Messages:    messages,
 		Tools:       tools,
-		Temperature: c.config.Temperature,
+		Temperature: c.temperature(req),
 	}
 
 	resp, err := c.client.CreateChatCompletion(ctx, apiReq)
@@ -278,6 +304,28 @@ func (c *Client) ChatCompletion(ctx context.Context, req *ChatRequest) (*ChatRes
 // 私有辅助方法：负责脏活累活 (Type Conversion)
 // ==========================================
 
+// model 请求级别的模型优先，其次是 Client 的默认模型
+func (c *Client) model(req *ChatRequest) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return c.config.ModelName
+}
+
+// temperature 请求级别的温度优先，其次是 Client 的默认温度
+// go-openai 的 Temperature 带 omitempty，0 会被丢掉、服务端改用默认值 (通常是 1)，
+// 所以 0 以最小的非零 float32 发送，效果等同于 0
+func (c *Client) temperature(req *ChatRequest) float32 {
+	t := c.config.Temperature
+	if req.Temperature != nil {
+		t = *req.Temperature
+	}
+	if t == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return t
+}
+
 func (c *Client) buildMessages(req *ChatRequest) []openai.ChatCompletionMessage {
 	var msgs []openai.ChatCompletionMessage
 
@@ -307,10 +355,14 @@ func (c *Client) buildMessages(req *ChatRequest) []openai.ChatCompletionMessage
 		msg.Content = extractContent(m.Content)
 
 		// 处理 Tool Calls (Assistant 产生的)
-		// 如果你的 store 存了 ToolCalls 结构，需要在这里还原成 openai.ToolCall
-		// 这里假设 m.Content 里如果存了 json 格式的 tool_calls，需要特殊处理
-		// 这是一个复杂的点，取决于你 ChatMessage 如何存储 ToolCall。
-		// 简单起见，如果 Role 是 Tool，需要填 ToolCallID
+		// 引擎把 tool_calls 存在 Content 里，这里还原成 openai.ToolCall，否则后面的 tool 消息对不上
+		if m.Role == "assistant" {
+			if calls := historyToolCalls(m); len(calls) > 0 {
+				msg.Content, _ = m.Content["text"].(string)
+				msg.ToolCalls = toOpenAIToolCalls(calls)
+			}
+		}
+		// 如果 Role 是 Tool，需要填 ToolCallID
 		if m.Role == "tool" {
 			msg.ToolCallID = m.ToolCallID
 		}
@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeOpenAI 应答 /chat/completions，记录每次请求的原始 JSON body（即实际发到线上的内容）
+func fakeOpenAI(t *testing.T, temperature float32) (*Client, *[]map[string]interface{}) {
+	t.Helper()
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bodies = append(bodies, body)
+		if body["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return NewClient(LLMConfig{ApiKey: "sk-test", BaseURL: srv.URL, ModelName: "gpt-4o", Temperature: temperature}), &bodies
+}
+
+func TestClientTemperatureOnTheWire(t *testing.T) {
+	zero, half := float32(0), float32(0.5)
+	cases := []struct {
+		name       string
+		configured float32
+		request    *float32
+		want       float64
+	}{
+		// 0 不能被 omitempty 丢掉，否则服务端会用它自己的默认温度
+		{"agent zero", 0.7, &zero, math.SmallestNonzeroFloat32},
+		{"provider default zero", 0, nil, math.SmallestNonzeroFloat32},
+		{"agent value", 0.7, &half, 0.5},
+		{"provider default", 0.7, nil, float64(float32(0.7))},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, bodies := fakeOpenAI(t, c.configured)
+			req := &ChatRequest{UserPrompt: "hi", Temperature: c.request}
+			if _, err := client.ChatCompletion(context.Background(), req); err != nil {
+				t.Fatal(err)
+			}
+			collect(t, client, req)
+			if len(*bodies) != 2 {
+				t.Fatalf("got %d requests", len(*bodies))
+			}
+			for _, body := range *bodies {
+				got, ok := body["temperature"].(float64)
+				if !ok || float32(got) != float32(c.want) {
+					t.Fatalf("temperature on the wire = %v (stream=%v), want %v", body["temperature"], body["stream"], c.want)
+				}
+			}
+		})
+	}
+}
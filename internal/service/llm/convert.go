@@ -0,0 +1,110 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"example.com/agent-server/internal/store"
+	"github.com/sashabaranov/go-openai"
+)
+
+// 各 Provider 共用的转换逻辑
+
+// systemPrompt 合并 SystemPrompt 与 handoff 规范提示
+// 没有独立 system 角色的 API（Messages 风格）只能传一段 system 文本
+func systemPrompt(req *ChatRequest) string {
+	parts := make([]string, 0, 2)
+	if req.SystemPrompt != "" {
+		parts = append(parts, req.SystemPrompt)
+	}
+	if req.ForceHandoff {
+		parts = append(parts, buildHandoffInstruction(req.HandoffCandidates))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// historyToolCalls 从 assistant 消息中还原 tool_calls
+// 引擎写入的格式为 OpenAI 风格：[{"id":"...","type":"function","function":{"name":"...","arguments":"..."}}]
+// 内存存储里是 []map[string]interface{}，数据库读出来是 []interface{}，统一过一遍 JSON
+func historyToolCalls(m *store.ChatMessage) []ToolCallInfo {
+	raw, ok := m.Content["tool_calls"]
+	if !ok || raw == nil {
+		return nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var calls []struct {
+		ID       string `json:"id"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(b, &calls); err != nil {
+		return nil
+	}
+	res := make([]ToolCallInfo, 0, len(calls))
+	for _, c := range calls {
+		res = append(res, ToolCallInfo{ID: c.ID, Name: c.Function.Name, Arguments: c.Function.Arguments})
+	}
+	return res
+}
+
+// toOpenAIToolCalls ChatResponse.ToolCalls 沿用 openai.ToolCall，其它 Provider 的结果在这里转换
+func toOpenAIToolCalls(calls []ToolCallInfo) []openai.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	res := make([]openai.ToolCall, 0, len(calls))
+	for _, c := range calls {
+		res = append(res, openai.ToolCall{
+			ID:   c.ID,
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      c.Name,
+				Arguments: c.Arguments,
+			},
+		})
+	}
+	return res
+}
+
+// argumentsObject 把 JSON 字符串形式的参数转成对象（Messages / Ollama 风格要求传对象）
+func argumentsObject(args string) map[string]interface{} {
+	obj := map[string]interface{}{}
+	if strings.TrimSpace(args) != "" {
+		_ = json.Unmarshal([]byte(args), &obj)
+	}
+	return obj
+}
+
+// argumentsString 反过来：对象转 JSON 字符串，与 OpenAI 的 arguments 保持一致
+func argumentsString(v interface{}) string {
+	if v == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// toolSchema 工具没有声明 Schema 时给一个空对象 Schema，部分 API 不接受 null
+func toolSchema(t *store.MCPTool) map[string]interface{} {
+	if len(t.InputSchema) == 0 {
+		return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	return t.InputSchema
+}
+
+// httpStatusError 读取错误响应体（截断）组装错误
+func httpStatusError(provider string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s api error: status %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(body)))
+}
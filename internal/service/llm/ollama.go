@@ -0,0 +1,267 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Ollama 风格本地 API (/api/chat) 适配器
+const defaultOllamaBaseURL = "http://localhost:11434"
+
+type OllamaClient struct {
+	config     LLMConfig
+	httpClient *http.Client
+}
+
+// NewOllamaClient BaseURL 形如 http://localhost:11434，请求发往 {BaseURL}/api/chat
+func NewOllamaClient(cfg LLMConfig) *OllamaClient {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultOllamaBaseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return &OllamaClient{config: cfg, httpClient: &http.Client{Timeout: 10 * time.Minute}}
+}
+
+// ==========================================
+// 请求 / 响应结构
+// ==========================================
+
+type ollamaRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Tools    []ollamaTool           `json:"tools,omitempty"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"` // system / user / assistant / tool
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // role=tool 时标明是哪个工具的结果
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string                 `json:"name"`
+		Description string                 `json:"description,omitempty"`
+		Parameters  map[string]interface{} `json:"parameters"`
+	} `json:"function"`
+}
+
+// ollamaChunk 非流式响应与流式的每一行结构相同
+type ollamaChunk struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ChatCompletion 同步调用
+func (c *OllamaClient) ChatCompletion(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	resp, err := c.do(ctx, c.buildRequest(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out ollamaChunk
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("ollama decode response: %w", err)
+	}
+	if out.Error != "" {
+		return nil, fmt.Errorf("ollama api error: %s", out.Error)
+	}
+
+	text, handoff, raw := parseContentAndHandoff(out.Message.Content)
+	return &ChatResponse{
+		Content:    text,
+		ToolCalls:  toOpenAIToolCalls(ollamaToolCalls(out.Message.ToolCalls, 0)),
+		Handoff:    handoff,
+		RawPayload: raw,
+		Usage: map[string]int{
+			"prompt_tokens":     out.PromptEvalCount,
+			"completion_tokens": out.EvalCount,
+			"total_tokens":      out.PromptEvalCount + out.EvalCount,
+		},
+	}, nil
+}
+
+// ChatStream 流式调用，响应为 NDJSON，每行一个 chunk，最后一行 done=true
+// 工具调用不分片，整块出现在某个 chunk 的 message.tool_calls 里
+func (c *OllamaClient) ChatStream(ctx context.Context, req *ChatRequest) (<-chan StreamEvent, error) {
+	resp, err := c.do(ctx, c.buildRequest(req, true))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent, 10)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		var contentBuffer strings.Builder
+		var calls []ToolCallInfo
+		var usage map[string]int
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var chunk ollamaChunk
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				ch <- StreamEvent{Type: "error", Error: fmt.Sprintf("ollama decode chunk: %v", err)}
+				return
+			}
+			if chunk.Error != "" {
+				ch <- StreamEvent{Type: "error", Error: chunk.Error}
+				return
+			}
+			if chunk.Message.Content != "" {
+				contentBuffer.WriteString(chunk.Message.Content)
+				ch <- StreamEvent{Type: "content", Content: chunk.Message.Content}
+			}
+			calls = append(calls, ollamaToolCalls(chunk.Message.ToolCalls, len(calls))...)
+			if chunk.Done {
+				usage = map[string]int{
+					"prompt_tokens":     chunk.PromptEvalCount,
+					"completion_tokens": chunk.EvalCount,
+					"total_tokens":      chunk.PromptEvalCount + chunk.EvalCount,
+				}
+				break
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			ch <- StreamEvent{Type: "error", Error: err.Error()}
+			return
+		}
+
+		if len(calls) > 0 {
+			ch <- StreamEvent{Type: "tool_call", Content: contentBuffer.String(), ToolCalls: calls, Usage: usage}
+			return
+		}
+		text, handoff, _ := parseContentAndHandoff(contentBuffer.String())
+		if handoff != nil && handoff.TargetAgentID != "" {
+			ch <- StreamEvent{Type: "handoff", Content: text, Handoff: handoff, Usage: usage}
+		} else {
+			ch <- StreamEvent{Type: "done", Content: text, Usage: usage}
+		}
+	}()
+	return ch, nil
+}
+
+func (c *OllamaClient) do(ctx context.Context, body *ollamaRequest) (*http.Response, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.BaseURL+"/api/chat", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.config.ApiKey != "" {
+		// 本地 Ollama 不需要鉴权；挂在反向代理后面时可能需要
+		httpReq.Header.Set("Authorization", "Bearer "+c.config.ApiKey)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("ollama request: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, httpStatusError("ollama", resp)
+	}
+	return resp, nil
+}
+
+func (c *OllamaClient) buildRequest(req *ChatRequest, stream bool) *ollamaRequest {
+	model := req.Model
+	if model == "" {
+		model = c.config.ModelName
+	}
+	temperature := c.config.Temperature
+	if req.Temperature != nil {
+		temperature = *req.Temperature
+	}
+
+	out := &ollamaRequest{
+		Model:    model,
+		Messages: c.buildMessages(req),
+		Stream:   stream,
+		Options:  map[string]interface{}{"temperature": temperature},
+	}
+	for _, t := range req.Tools {
+		tool := ollamaTool{Type: "function"}
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		tool.Function.Parameters = toolSchema(t)
+		out.Tools = append(out.Tools, tool)
+	}
+	return out
+}
+
+func (c *OllamaClient) buildMessages(req *ChatRequest) []ollamaMessage {
+	var msgs []ollamaMessage
+	if sys := systemPrompt(req); sys != "" {
+		msgs = append(msgs, ollamaMessage{Role: "system", Content: sys})
+	}
+
+	// Ollama 的工具结果不带 call id，只能带工具名；先记下 id -> 工具名
+	toolNames := make(map[string]string)
+	for _, m := range req.History {
+		msg := ollamaMessage{Role: m.Role, Content: extractContent(m.Content)}
+		switch m.Role {
+		case "assistant":
+			if calls := historyToolCalls(m); len(calls) > 0 {
+				msg.Content, _ = m.Content["text"].(string)
+				for _, tc := range calls {
+					var oc ollamaToolCall
+					oc.Function.Name = tc.Name
+					oc.Function.Arguments = argumentsObject(tc.Arguments)
+					msg.ToolCalls = append(msg.ToolCalls, oc)
+					toolNames[tc.ID] = tc.Name
+				}
+			}
+		case "tool":
+			msg.ToolName = toolNames[m.ToolCallID]
+		}
+		msgs = append(msgs, msg)
+	}
+	if req.UserPrompt != "" {
+		msgs = append(msgs, ollamaMessage{Role: "user", Content: req.UserPrompt})
+	}
+	return msgs
+}
+
+// ollamaToolCalls Ollama 不返回 call id，按序号补一个，保证 tool 消息能对上
+func ollamaToolCalls(calls []ollamaToolCall, offset int) []ToolCallInfo {
+	res := make([]ToolCallInfo, 0, len(calls))
+	for i, tc := range calls {
+		res = append(res, ToolCallInfo{
+			ID:        fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), offset+i),
+			Name:      tc.Function.Name,
+			Arguments: argumentsString(tc.Function.Arguments),
+		})
+	}
+	return res
+}
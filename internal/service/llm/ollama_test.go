@@ -0,0 +1,120 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeOllama 按固定脚本应答 /api/chat：stream=true 时逐行写 NDJSON，否则写最后一行作为完整响应
+func fakeOllama(t *testing.T, status int, lines ...string) (*OllamaClient, *ollamaRequest) {
+	t.Helper()
+	var got ollamaRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, strings.Join(lines, "\n"), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if !got.Stream {
+			fmt.Fprint(w, lines[len(lines)-1])
+			return
+		}
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return NewOllamaClient(LLMConfig{BaseURL: srv.URL, ModelName: "qwen2.5", Temperature: 0.3}), &got
+}
+
+func TestOllamaChatStream(t *testing.T) {
+	client, got := fakeOllama(t, http.StatusOK,
+		`{"message":{"role":"assistant","content":"Let me "},"done":false}`,
+		`{"message":{"role":"assistant","content":"check."},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"fs__read_file","arguments":{"path":"a.txt"}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"git__status","arguments":{}}}]},"done":false}`,
+		`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":15,"eval_count":9}`,
+	)
+
+	temperature := float32(0)
+	events := collect(t, client, &ChatRequest{UserPrompt: "read a.txt", Temperature: &temperature})
+	if got.Model != "qwen2.5" || !got.Stream || got.Options["temperature"] != float64(0) {
+		t.Fatalf("request = %+v", got)
+	}
+	if len(events) != 3 || events[0].Content != "Let me " || events[1].Content != "check." {
+		t.Fatalf("events = %+v", events)
+	}
+	last := events[2]
+	if last.Type != "tool_call" || last.Content != "Let me check." || len(last.ToolCalls) != 2 {
+		t.Fatalf("final event = %+v", last)
+	}
+	// Ollama 不给 call id，按序号补上且互不相同
+	a, b := last.ToolCalls[0], last.ToolCalls[1]
+	if a.Name != "fs__read_file" || a.Arguments != `{"path":"a.txt"}` || b.Name != "git__status" || b.Arguments != "{}" {
+		t.Fatalf("tool calls = %+v", last.ToolCalls)
+	}
+	if !strings.HasPrefix(a.ID, "call_") || a.ID == b.ID {
+		t.Fatalf("tool call ids = %q, %q", a.ID, b.ID)
+	}
+	wantUsage := map[string]int{"prompt_tokens": 15, "completion_tokens": 9, "total_tokens": 24}
+	if !reflect.DeepEqual(last.Usage, wantUsage) {
+		t.Fatalf("usage = %v", last.Usage)
+	}
+}
+
+func TestOllamaChatStreamErrorChunk(t *testing.T) {
+	client, _ := fakeOllama(t, http.StatusOK,
+		`{"message":{"role":"assistant","content":"partial"},"done":false}`,
+		`{"error":"model runner has unexpectedly stopped"}`,
+	)
+	events := collect(t, client, &ChatRequest{UserPrompt: "hi"})
+	if len(events) != 2 || events[1].Type != "error" || events[1].Error != "model runner has unexpectedly stopped" {
+		t.Fatalf("events = %+v", events)
+	}
+}
+
+func TestOllamaChatCompletion(t *testing.T) {
+	client, got := fakeOllama(t, http.StatusOK,
+		`{"message":{"role":"assistant","content":"All good."},"done":true,"prompt_eval_count":11,"eval_count":3}`,
+	)
+	resp, err := client.ChatCompletion(context.Background(), &ChatRequest{UserPrompt: "status?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Stream || got.Options["temperature"] != 0.3 {
+		t.Fatalf("request = %+v", got)
+	}
+	if resp.Content != "All good." || len(resp.ToolCalls) != 0 {
+		t.Fatalf("response = %+v", resp)
+	}
+	if resp.Usage["prompt_tokens"] != 11 || resp.Usage["completion_tokens"] != 3 || resp.Usage["total_tokens"] != 14 {
+		t.Fatalf("usage = %v", resp.Usage)
+	}
+}
+
+func TestOllamaErrorStatus(t *testing.T) {
+	client, _ := fakeOllama(t, http.StatusNotFound, `{"error":"model \"qwen2.5\" not found, try pulling it first"}`)
+
+	_, err := client.ChatCompletion(context.Background(), &ChatRequest{UserPrompt: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "status 404") || !strings.Contains(err.Error(), "try pulling it first") {
+		t.Fatalf("completion error = %v", err)
+	}
+	ch, err := client.ChatStream(context.Background(), &ChatRequest{UserPrompt: "hi"})
+	if ch != nil || err == nil || !strings.Contains(err.Error(), "ollama api error: status 404") {
+		t.Fatalf("stream error = %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"example.com/agent-server/internal/store"
+)
+
+// Agent.ExtraConfig 中指定 Provider 的键，e.g. {"provider": "siliconflow"}
+const ExtraConfigProvider = "provider"
+
+// Provider 的 API 风格 (ProviderConfig.Type)
+const (
+	ProviderTypeOpenAI    = "openai"    // OpenAI 兼容 /chat/completions（默认）
+	ProviderTypeAnthropic = "anthropic" // Messages 风格 /v1/messages
+	ProviderTypeOllama    = "ollama"    // Ollama 风格 /api/chat
+)
+
+// ProviderConfig 一个具名的模型端点
+type ProviderConfig struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"` // openai / anthropic / ollama，空为 openai
+	BaseURL       string   `json:"base_url"`
+	ApiKey        string   `json:"api_key"`
+	Models        []string `json:"models"`         // 该端点支持的模型；为空表示不限制
+	DefaultModel  string   `json:"default_model"`  // Agent 未指定或指定了不支持的模型时使用
+	Temperature   float32  `json:"temperature"`    // Agent 未指定温度时使用
+	MaxTokens     int      `json:"max_tokens"`     // 仅 anthropic 使用
+	ContextWindow int      `json:"context_window"` // 模型上下文窗口 (Token)，Runner 据此裁剪历史；0 用 Runner 的默认值
+}
+
+// NewProvider 按 Type 创建对应的适配器
+func NewProvider(cfg ProviderConfig) (Provider, error) {
+	llmCfg := LLMConfig{
+		ApiKey:      cfg.ApiKey,
+		BaseURL:     cfg.BaseURL,
+		ModelName:   cfg.DefaultModel,
+		Temperature: cfg.Temperature,
+		MaxTokens:   cfg.MaxTokens,
+	}
+	switch cfg.Type {
+	case "", ProviderTypeOpenAI:
+		return NewClient(llmCfg), nil
+	case ProviderTypeAnthropic:
+		return NewAnthropicClient(llmCfg), nil
+	case ProviderTypeOllama:
+		return NewOllamaClient(llmCfg), nil
+	default:
+		return nil, fmt.Errorf("provider %s: unsupported type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// ParseProviderConfigs 解析 JSON 数组形式的 Provider 配置 (LLM_PROVIDERS)
+func ParseProviderConfigs(raw string) ([]ProviderConfig, error) {
+	var cfgs []ProviderConfig
+	if err := json.Unmarshal([]byte(raw), &cfgs); err != nil {
+		return nil, fmt.Errorf("parse provider configs: %w", err)
+	}
+	for i, c := range cfgs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("provider #%d: name is required", i)
+		}
+		switch c.Type {
+		case "", ProviderTypeOpenAI, ProviderTypeAnthropic, ProviderTypeOllama:
+		default:
+			return nil, fmt.Errorf("provider %s: unsupported type %q", c.Name, c.Type)
+		}
+	}
+	return cfgs, nil
+}
+
+// Selection 为某个 Agent 选定的 Provider / 模型 / 温度
+type Selection struct {
+	Provider      string
+	Model         string
+	Temperature   float32
+	ContextWindow int // 0 表示未配置
+}
+
+// ProviderSource 按 Agent 选择 Provider，Runner 只依赖它
+// *Registry 按配置路由；Fixed 包装单个 Provider（e.g. 测试用的 ScriptedProvider）
+type ProviderSource interface {
+	Resolve(agent *store.Agent) (Provider, Selection, error)
+}
+
+// Fixed 所有 Agent 都用同一个 Provider，模型和温度仍取自 Agent
+func Fixed(p Provider) ProviderSource {
+	return fixedSource{p}
+}
+
+type fixedSource struct{ p Provider }
+
+func (f fixedSource) Resolve(agent *store.Agent) (Provider, Selection, error) {
+	sel := Selection{Provider: "fixed"}
+	if agent != nil {
+		sel.Model = agent.ModelName
+		if agent.Temperature != nil {
+			sel.Temperature = float32(*agent.Temperature)
+		}
+	}
+	return f.p, sel, nil
+}
+
+// Registry Provider 注册表
+// 每次 Run 根据 Agent 的 ExtraConfig / ModelName / Temperature 选择端点和参数
+type Registry struct {
+	mu          sync.RWMutex
+	providers   map[string]*registeredProvider
+	defaultName string
+}
+
+type registeredProvider struct {
+	cfg      ProviderConfig
+	provider Provider
+	models   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]*registeredProvider)}
+}
+
+// Register 按配置创建并注册（或覆盖）一个 Provider；第一个注册的作为默认
+func (r *Registry) Register(cfg ProviderConfig) error {
+	p, err := NewProvider(cfg)
+	if err != nil {
+		return err
+	}
+	r.Add(cfg, p)
+	return nil
+}
+
+// Add 注册一个现成的 Provider 实现，cfg 只用到 Name / Models / DefaultModel / Temperature / ContextWindow
+func (r *Registry) Add(cfg ProviderConfig, p Provider) {
+	rp := &registeredProvider{cfg: cfg, provider: p, models: make(map[string]bool, len(cfg.Models))}
+	for _, m := range cfg.Models {
+		rp.models[m] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[cfg.Name] = rp
+	if r.defaultName == "" {
+		r.defaultName = cfg.Name
+	}
+}
+
+// SetDefault 指定默认 Provider
+func (r *Registry) SetDefault(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.providers[name]; !ok {
+		return fmt.Errorf("provider not found: %s", name)
+	}
+	r.defaultName = name
+	return nil
+}
+
+// Names 已注册的 Provider 名称
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.providers))
+	for n := range r.providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve 为 Agent 选择 Provider 和调用参数
+// Provider：ExtraConfig["provider"] > 声明支持该模型的 Provider > 默认 Provider
+// 模型：Agent.ModelName，Provider 不支持时回退到 Provider 的默认模型
+// 温度：Agent.Temperature，未设置 (nil) 时用 Provider 的默认温度，0 也是有效设置
+func (r *Registry) Resolve(agent *store.Agent) (Provider, Selection, error) {
+	if r == nil {
+		return nil, Selection{}, fmt.Errorf("no llm provider configured")
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p := r.pick(agent)
+	if p == nil {
+		return nil, Selection{}, fmt.Errorf("no llm provider configured")
+	}
+
+	sel := Selection{Provider: p.cfg.Name, Model: p.cfg.DefaultModel, Temperature: p.cfg.Temperature, ContextWindow: p.cfg.ContextWindow}
+	if agent != nil {
+		if agent.ModelName != "" && p.supports(agent.ModelName) {
+			sel.Model = agent.ModelName
+		} else if agent.ModelName != "" {
+			log.Printf("[LLM Registry] model %q not served by provider %s, falling back to %q", agent.ModelName, p.cfg.Name, sel.Model)
+		}
+		if agent.Temperature != nil {
+			sel.Temperature = float32(*agent.Temperature)
+		}
+	}
+	return p.provider, sel, nil
+}
+
+func (r *Registry) pick(agent *store.Agent) *registeredProvider {
+	if agent != nil {
+		if name, _ := agent.ExtraConfig[ExtraConfigProvider].(string); name != "" {
+			if p, ok := r.providers[name]; ok {
+				return p
+			}
+			log.Printf("[LLM Registry] agent %s asks for unknown provider %q, using default", agent.Name, name)
+		} else if agent.ModelName != "" {
+			// 没指定 Provider 时，找显式声明了该模型的端点（按名字排序，结果稳定）
+			names := make([]string, 0, len(r.providers))
+			for n := range r.providers {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				if r.providers[n].models[agent.ModelName] {
+					return r.providers[n]
+				}
+			}
+		}
+	}
+	return r.providers[r.defaultName]
+}
+
+// supports Models 为空表示端点不做限制
+func (p *registeredProvider) supports(model string) bool {
+	return len(p.models) == 0 || p.models[model]
+}
@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+func TestRegistryResolveTemperature(t *testing.T) {
+	r := NewRegistry()
+	r.Add(ProviderConfig{Name: "p", DefaultModel: "m", Temperature: 0.7}, NewScriptedProvider())
+
+	zero, half := 0.0, 0.5
+	cases := []struct {
+		name        string
+		temperature *float64
+		want        float32
+	}{
+		{"unset uses provider default", nil, 0.7},
+		{"zero is kept", &zero, 0},
+		{"explicit value", &half, 0.5},
+	}
+	for _, c := range cases {
+		_, sel, err := r.Resolve(&store.Agent{Temperature: c.temperature})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if sel.Temperature != c.want {
+			t.Errorf("%s: temperature = %v, want %v", c.name, sel.Temperature, c.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ScriptedTurn 一轮预设的模型输出
+// Content / ToolCalls / Handoff 与 ChatResponse 对应；Error 非空时本轮返回错误
+type ScriptedTurn struct {
+	Content   string           `json:"content,omitempty"`
+	ToolCalls []ToolCallInfo   `json:"tool_calls,omitempty"`
+	Handoff   *HandoffDecision `json:"handoff,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Usage     map[string]int   `json:"usage,omitempty"`
+
+	// Request 录制时附带的请求摘要，回放时不参与匹配，只方便阅读 fixture
+	Request *RecordedRequest `json:"request,omitempty"`
+}
+
+// RecordedRequest 请求摘要
+type RecordedRequest struct {
+	Model       string   `json:"model,omitempty"`
+	System      string   `json:"system,omitempty"`
+	LastMessage string   `json:"last_message,omitempty"`
+	Tools       []string `json:"tools,omitempty"`
+}
+
+// Fixture 录制 / 回放文件的格式
+type Fixture struct {
+	Turns []ScriptedTurn `json:"turns"`
+}
+
+// ScriptedProvider 按顺序返回预设输出的 Provider，用于离线测试引擎
+// 每次 ChatCompletion / ChatStream 消费一轮；轮次耗尽后返回错误
+type ScriptedProvider struct {
+	mu    sync.Mutex
+	turns []ScriptedTurn
+	next  int
+
+	// Requests 收到的全部请求，供测试断言（工具列表、历史消息等）
+	Requests []*ChatRequest
+}
+
+func NewScriptedProvider(turns ...ScriptedTurn) *ScriptedProvider {
+	return &ScriptedProvider{turns: turns}
+}
+
+// LoadFixture 从录制文件创建 ScriptedProvider
+func LoadFixture(path string) (*ScriptedProvider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse fixture %s: %w", path, err)
+	}
+	return NewScriptedProvider(f.Turns...), nil
+}
+
+// Enqueue 追加轮次
+func (p *ScriptedProvider) Enqueue(turns ...ScriptedTurn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.turns = append(p.turns, turns...)
+}
+
+// Remaining 还没被消费的轮次数
+func (p *ScriptedProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.turns) - p.next
+}
+
+func (p *ScriptedProvider) pop(req *ChatRequest) (ScriptedTurn, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Requests = append(p.Requests, req)
+	if p.next >= len(p.turns) {
+		return ScriptedTurn{}, p.next, fmt.Errorf("scripted provider: no turn left (consumed %d)", len(p.turns))
+	}
+	t := p.turns[p.next]
+	p.next++
+	return t, p.next - 1, nil
+}
+
+// ChatCompletion 返回下一轮预设输出
+func (p *ScriptedProvider) ChatCompletion(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t, idx, err := p.pop(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.Error != "" {
+		return nil, fmt.Errorf("%s", t.Error)
+	}
+	return &ChatResponse{
+		Content:   t.Content,
+		ToolCalls: toOpenAIToolCalls(scriptedCallIDs(t.ToolCalls, idx)),
+		Handoff:   t.Handoff,
+		Usage:     t.Usage,
+	}, nil
+}
+
+// ChatStream 把下一轮预设输出拆成若干 content 增量后，以 tool_call / handoff / done / error 结束
+func (p *ScriptedProvider) ChatStream(ctx context.Context, req *ChatRequest) (<-chan StreamEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t, idx, err := p.pop(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan StreamEvent, 10)
+	go func() {
+		defer close(ch)
+		if t.Error != "" {
+			ch <- StreamEvent{Type: "error", Error: t.Error}
+			return
+		}
+		for _, chunk := range splitChunks(t.Content) {
+			select {
+			case ch <- StreamEvent{Type: "content", Content: chunk}:
+			case <-ctx.Done():
+				ch <- StreamEvent{Type: "error", Error: ctx.Err().Error()}
+				return
+			}
+		}
+		switch {
+		case len(t.ToolCalls) > 0:
+			ch <- StreamEvent{Type: "tool_call", Content: t.Content, ToolCalls: scriptedCallIDs(t.ToolCalls, idx), Usage: t.Usage}
+		case t.Handoff != nil && t.Handoff.TargetAgentID != "":
+			ch <- StreamEvent{Type: "handoff", Content: t.Content, Handoff: t.Handoff, Usage: t.Usage}
+		default:
+			ch <- StreamEvent{Type: "done", Content: t.Content, Usage: t.Usage}
+		}
+	}()
+	return ch, nil
+}
+
+// scriptedCallIDs 没写 id 的工具调用补一个稳定的 id
+func scriptedCallIDs(calls []ToolCallInfo, turn int) []ToolCallInfo {
+	if len(calls) == 0 {
+		return nil
+	}
+	res := make([]ToolCallInfo, len(calls))
+	for i, c := range calls {
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("call_%d_%d", turn, i)
+		}
+		if c.Arguments == "" {
+			c.Arguments = "{}"
+		}
+		res[i] = c
+	}
+	return res
+}
+
+// splitChunks 按单词切分，模拟流式增量（拼起来与原文完全一致）
+func splitChunks(s string) []string {
+	var chunks []string
+	for len(s) > 0 {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			chunks = append(chunks, s)
+			break
+		}
+		chunks = append(chunks, s[:i+1])
+		s = s[i+1:]
+	}
+	return chunks
+}
+
+// ==========================================
+// 录制
+// ==========================================
+
+// RecordingProvider 透传给真实 Provider，同时把每轮输出写入 fixture 文件，之后可用 LoadFixture 回放
+type RecordingProvider struct {
+	Inner Provider
+	Path  string
+
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+func NewRecordingProvider(inner Provider, path string) *RecordingProvider {
+	return &RecordingProvider{Inner: inner, Path: path}
+}
+
+func (r *RecordingProvider) ChatCompletion(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	resp, err := r.Inner.ChatCompletion(ctx, req)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.record(req, ScriptedTurn{Error: err.Error()})
+		}
+		return nil, err
+	}
+	turn := ScriptedTurn{Content: resp.Content, Handoff: resp.Handoff, Usage: resp.Usage}
+	for _, tc := range resp.ToolCalls {
+		turn.ToolCalls = append(turn.ToolCalls, ToolCallInfo{ID: tc.ID, Name: tc.Function.Name, Arguments: tc.Function.Arguments})
+	}
+	r.record(req, turn)
+	return resp, nil
+}
+
+func (r *RecordingProvider) ChatStream(ctx context.Context, req *ChatRequest) (<-chan StreamEvent, error) {
+	in, err := r.Inner.ChatStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan StreamEvent, 10)
+	go func() {
+		defer close(out)
+		var turn ScriptedTurn
+		for ev := range in {
+			switch ev.Type {
+			case "tool_call":
+				turn.Content, turn.ToolCalls, turn.Usage = ev.Content, ev.ToolCalls, ev.Usage
+			case "handoff":
+				turn.Content, turn.Handoff, turn.Usage = ev.Content, ev.Handoff, ev.Usage
+			case "done":
+				turn.Content, turn.Usage = ev.Content, ev.Usage
+			case "error":
+				turn.Error = ev.Error
+			}
+			out <- ev
+		}
+		r.record(req, turn)
+	}()
+	return out, nil
+}
+
+func (r *RecordingProvider) record(req *ChatRequest, turn ScriptedTurn) {
+	turn.Request = summarizeRequest(req)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fixture.Turns = append(r.fixture.Turns, turn)
+	b, err := json.MarshalIndent(r.fixture, "", "  ")
+	if err == nil {
+		err = os.WriteFile(r.Path, b, 0o644)
+	}
+	if err != nil {
+		fmt.Printf("[LLM Recorder] write fixture %s failed: %v\n", r.Path, err)
+	}
+}
+
+func summarizeRequest(req *ChatRequest) *RecordedRequest {
+	s := &RecordedRequest{Model: req.Model, System: req.SystemPrompt}
+	if n := len(req.History); n > 0 {
+		s.LastMessage = extractContent(req.History[n-1].Content)
+	}
+	if req.UserPrompt != "" {
+		s.LastMessage = req.UserPrompt
+	}
+	for _, t := range req.Tools {
+		s.Tools = append(s.Tools, t.Name)
+	}
+	return s
+}
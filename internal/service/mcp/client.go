@@ -0,0 +1,342 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClientClosed 连接已断开（服务端关闭流 / 进程退出 / 主动 Close）
+var ErrClientClosed = errors.New("mcp client closed")
+
+// Client MCP 客户端
+// 负责 JSON-RPC 请求/响应的配对，具体收发交给 Transport
+type Client struct {
+	transport Transport
+
+	nextID  int64
+	mu      sync.Mutex
+	pending map[string]chan *Message
+	closed  bool
+	done    chan struct{}
+
+	info *InitializeResult
+
+	// secrets 连接注入的凭证值，工具输出里出现时要抹掉
+	secrets []string
+
+	// OnNotification 服务端推送的通知 (e.g. notifications/tools/list_changed)
+	OnNotification func(method string, params json.RawMessage)
+}
+
+func NewClient(t Transport) *Client {
+	return &Client{
+		transport: t,
+		pending:   make(map[string]chan *Message),
+		done:      make(chan struct{}),
+	}
+}
+
+// Connect 建立连接并完成 initialize 握手
+func (c *Client) Connect(ctx context.Context) (*InitializeResult, error) {
+	if err := c.transport.Start(ctx); err != nil {
+		return nil, fmt.Errorf("mcp transport start: %w", err)
+	}
+	go c.readLoop()
+
+	params := map[string]interface{}{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      Implementation{Name: clientName, Version: clientVersion},
+	}
+	var res InitializeResult
+	if err := c.call(ctx, "initialize", params, &res); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("mcp initialize: %w", err)
+	}
+	if err := c.Notify(ctx, "notifications/initialized", nil); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("mcp initialized notification: %w", err)
+	}
+
+	c.mu.Lock()
+	c.info = &res
+	c.mu.Unlock()
+	return &res, nil
+}
+
+// ServerInfo 握手时拿到的服务端信息
+func (c *Client) ServerInfo() *InitializeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.info
+}
+
+// Alive 连接是否仍可用
+func (c *Client) Alive() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.closed
+}
+
+// Done 连接断开时关闭
+func (c *Client) Done() <-chan struct{} { return c.done }
+
+// ListTools 拉取全部工具（自动翻页）
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page listToolsResult
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, err
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// ListResources 拉取全部资源（自动翻页）
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	var resources []Resource
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page listResourcesResult
+		if err := c.call(ctx, "resources/list", params, &page); err != nil {
+			return nil, err
+		}
+		resources = append(resources, page.Resources...)
+		if page.NextCursor == "" {
+			return resources, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// ReadResource 读取资源内容
+func (c *Client) ReadResource(ctx context.Context, uri string) (*ReadResourceResult, error) {
+	var res ReadResourceResult
+	if err := c.call(ctx, "resources/read", map[string]interface{}{"uri": uri}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ListPrompts 拉取全部提示模板（自动翻页）
+func (c *Client) ListPrompts(ctx context.Context) ([]Prompt, error) {
+	var prompts []Prompt
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page listPromptsResult
+		if err := c.call(ctx, "prompts/list", params, &page); err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, page.Prompts...)
+		if page.NextCursor == "" {
+			return prompts, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// GetPrompt 按参数渲染提示模板
+func (c *Client) GetPrompt(ctx context.Context, name string, args map[string]string) (*GetPromptResult, error) {
+	if args == nil {
+		args = map[string]string{}
+	}
+	var res GetPromptResult
+	if err := c.call(ctx, "prompts/get", map[string]interface{}{"name": name, "arguments": args}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// HasCapability 服务端在握手时是否声明了某项能力 (tools / resources / prompts ...)
+func (c *Client) HasCapability(name string) bool {
+	info := c.ServerInfo()
+	if info == nil {
+		return false
+	}
+	_, ok := info.Capabilities[name]
+	return ok
+}
+
+// CallTool 调用工具
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]interface{}) (*CallToolResult, error) {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	var res CallToolResult
+	if err := c.call(ctx, "tools/call", map[string]interface{}{"name": name, "arguments": args}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Ping 探活
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, "ping", nil, nil)
+}
+
+// Notify 发送通知（无需响应）
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	msg := &Message{JSONRPC: jsonrpcVersion, Method: method}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = b
+	}
+	return c.transport.Send(ctx, msg)
+}
+
+// Close 关闭连接，所有等待中的请求立即失败
+func (c *Client) Close() error {
+	c.shutdown()
+	return c.transport.Close()
+}
+
+// call 发送请求并等待对应 id 的响应
+func (c *Client) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	id := strconv.FormatInt(atomic.AddInt64(&c.nextID, 1), 10)
+	msg := &Message{JSONRPC: jsonrpcVersion, ID: json.RawMessage(id), Method: method}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = b
+	}
+
+	// 先登记再发送：Streamable HTTP 可能在 Send 返回前就把响应投递过来
+	ch := make(chan *Message, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.transport.Send(ctx, msg); err != nil {
+		return err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return ErrClientClosed
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if out == nil || len(resp.Result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Result, out); err != nil {
+			return fmt.Errorf("decode %s result: %w", method, err)
+		}
+		return nil
+	case <-ctx.Done():
+		// 告诉服务端放弃这个请求
+		_ = c.Notify(context.Background(), "notifications/cancelled", map[string]interface{}{
+			"requestId": json.RawMessage(id),
+			"reason":    ctx.Err().Error(),
+		})
+		return ctx.Err()
+	}
+}
+
+// readLoop 持续消费 Transport 收到的消息
+func (c *Client) readLoop() {
+	incoming := c.transport.Incoming()
+	for {
+		select {
+		case msg, ok := <-incoming:
+			if !ok {
+				c.shutdown()
+				return
+			}
+			c.dispatch(msg)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) dispatch(msg *Message) {
+	switch {
+	case msg.IsResponse():
+		// 持锁投递，避免与 shutdown 关闭 channel 竞争；ch 有 1 个缓冲，不会阻塞
+		c.mu.Lock()
+		if ch := c.pending[string(msg.ID)]; ch != nil {
+			select {
+			case ch <- msg:
+			default:
+			}
+		}
+		c.mu.Unlock()
+
+	case msg.IsNotification():
+		if c.OnNotification != nil {
+			c.OnNotification(msg.Method, msg.Params)
+		}
+
+	case msg.IsRequest():
+		// 服务端 -> 客户端的请求：目前只支持 ping，其它一律 method not found
+		go c.reply(msg)
+	}
+}
+
+func (c *Client) reply(req *Message) {
+	resp := &Message{JSONRPC: jsonrpcVersion, ID: req.ID}
+	if req.Method == "ping" {
+		resp.Result = json.RawMessage("{}")
+	} else {
+		resp.Error = &RPCError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	if err := c.transport.Send(context.Background(), resp); err != nil {
+		log.Printf("[MCP Client] reply %s failed: %v", req.Method, err)
+	}
+}
+
+// shutdown 标记关闭并唤醒所有等待者
+func (c *Client) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
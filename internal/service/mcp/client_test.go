@@ -0,0 +1,148 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connect 通过指定传输连上 fakeServer
+func connect(t *testing.T, f *fakeServer, transport string, onNote func(method string, params json.RawMessage)) *Client {
+	t.Helper()
+	var tr Transport
+	if transport == TransportSSE {
+		tr = newSSETransport(f.url(transport), nil)
+	} else {
+		tr = newStreamableHTTPTransport(f.url(transport), nil)
+	}
+	c := NewClient(tr)
+	c.OnNotification = onNote
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Connect(ctx); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testCtx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+var transports = []string{TransportSSE, TransportHTTP}
+
+func TestClientHandshake(t *testing.T) {
+	versions := map[string]string{TransportSSE: "2024-11-05", TransportHTTP: "2025-03-26"}
+	for _, transport := range transports {
+		t.Run(transport, func(t *testing.T) {
+			f := newFakeServer(t)
+			c := connect(t, f, transport, nil)
+
+			info := c.ServerInfo()
+			if info == nil || info.ServerInfo.Name != "fake" || info.ProtocolVersion != versions[transport] {
+				t.Fatalf("unexpected server info: %+v", info)
+			}
+			if !c.HasCapability("tools") || c.HasCapability("prompts") {
+				t.Fatalf("capabilities not parsed: %+v", info.Capabilities)
+			}
+			got := f.received()
+			if len(got) != 2 || got[0] != "initialize" || got[1] != "notifications/initialized" {
+				t.Fatalf("handshake messages = %v", got)
+			}
+			if err := c.Ping(testCtx(t)); err != nil {
+				t.Fatalf("ping: %v", err)
+			}
+			if transport == TransportHTTP {
+				// initialize 之后的请求带上服务端下发的会话 ID
+				f.mu.Lock()
+				sessions := append([]string(nil), f.sessions...)
+				f.mu.Unlock()
+				if sessions[0] != "" || sessions[len(sessions)-1] != "fake-session" {
+					t.Fatalf("Mcp-Session-Id not propagated: %v", sessions)
+				}
+			}
+		})
+	}
+}
+
+func TestClientListToolsPagination(t *testing.T) {
+	for _, transport := range transports {
+		t.Run(transport, func(t *testing.T) {
+			f := newFakeServer(t, textTool("a"), textTool("b"), textTool("c"), textTool("d"), textTool("e"))
+			c := connect(t, f, transport, nil)
+
+			tools, err := c.ListTools(testCtx(t))
+			if err != nil {
+				t.Fatalf("list tools: %v", err)
+			}
+			var names []string
+			for _, tool := range tools {
+				names = append(names, tool.Name)
+			}
+			if len(names) != 5 || names[0] != "a" || names[4] != "e" {
+				t.Fatalf("tools = %v", names)
+			}
+			if n := f.count("tools/list"); n != 3 {
+				t.Fatalf("tools/list called %d times, want 3 pages", n)
+			}
+		})
+	}
+}
+
+func TestClientCallTool(t *testing.T) {
+	for _, transport := range transports {
+		t.Run(transport, func(t *testing.T) {
+			f := newFakeServer(t, textTool("echo"))
+			var mu sync.Mutex
+			var notes []string
+			c := connect(t, f, transport, func(method string, params json.RawMessage) {
+				mu.Lock()
+				notes = append(notes, method)
+				mu.Unlock()
+			})
+
+			// 响应之前先到一条通知，不影响等待中的请求
+			res, err := c.CallTool(testCtx(t), "echo", map[string]interface{}{"text": "hello"})
+			if err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			if res.IsError || res.Text() != "hello" {
+				t.Fatalf("result = %+v", res)
+			}
+			mu.Lock()
+			gotNotes := append([]string(nil), notes...)
+			mu.Unlock()
+			if len(gotNotes) != 1 || gotNotes[0] != "notifications/message" {
+				t.Fatalf("notifications = %v", gotNotes)
+			}
+
+			// JSON-RPC 错误原样返回给调用方，连接仍然可用
+			_, err = c.CallTool(testCtx(t), "missing", nil)
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) || rpcErr.Code != codeInvalidParams {
+				t.Fatalf("error = %v, want rpc error %d", err, codeInvalidParams)
+			}
+			if !c.Alive() {
+				t.Fatal("client closed after rpc error")
+			}
+			if _, err := c.CallTool(testCtx(t), "echo", map[string]interface{}{"text": "again"}); err != nil {
+				t.Fatalf("call after error: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientCloseFailsPending(t *testing.T) {
+	f := newFakeServer(t)
+	c := connect(t, f, TransportSSE, nil)
+	c.Close()
+	if err := c.Ping(testCtx(t)); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("ping after close = %v, want ErrClientClosed", err)
+	}
+}
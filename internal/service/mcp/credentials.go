@@ -0,0 +1,158 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"example.com/agent-server/internal/store"
+)
+
+// Server 可以引用一个集成平台，调用时把“当前用户”在该平台上绑定的凭证注入连接：
+//
+//	{"url": "https://mcp.example.com/mcp", "integration": {"provider": "github", "headers": {"Authorization": "Bearer {{token}}"}}}
+//	{"command": "github-mcp", "integration": {"provider": "github", "env": {"GITHUB_TOKEN": "{{token}}"}}}
+//
+// {{field}} 替换为凭证里的同名字段；不写 headers / env 时，
+// http / sse 默认注入 Authorization: Bearer {{token}}，stdio 默认注入 <PROVIDER>_TOKEN={{token}}
+// 注入只发生在连接池内部的副本上，不写回存储，也不会出现在接口响应里
+
+// CredentialSource 按用户 + 平台取解密后的凭证
+type CredentialSource interface {
+	Credentials(ctx context.Context, userID, provider string) (map[string]string, error)
+}
+
+type userCtxKey struct{}
+
+// WithUser 标记这次调用代表哪个用户，用于选择注入的凭证
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userCtxKey{}, userID)
+}
+
+// UserFromContext 取 WithUser 写入的用户 ID
+func UserFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(userCtxKey{}).(string)
+	return id
+}
+
+type integrationRef struct {
+	Provider string
+	Headers  map[string]string
+	Env      map[string]string
+}
+
+// IntegrationProvider Server 引用的集成平台；空串表示不需要用户凭证
+func IntegrationProvider(server *store.MCPServer) string {
+	if ref := integrationOf(server); ref != nil {
+		return ref.Provider
+	}
+	return ""
+}
+
+func integrationOf(server *store.MCPServer) *integrationRef {
+	raw, ok := server.ConnectionConfig["integration"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	ref := &integrationRef{
+		Provider: configString(raw, "provider"),
+		Headers:  configHeaders(raw),
+		Env:      map[string]string{},
+	}
+	if ref.Provider == "" {
+		return nil
+	}
+	if env, ok := raw["env"].(map[string]interface{}); ok {
+		for k, v := range env {
+			if s, ok := v.(string); ok {
+				ref.Env[k] = s
+			}
+		}
+	}
+	if len(ref.Headers) == 0 && len(ref.Env) == 0 {
+		if server.TransportType == TransportStdio {
+			ref.Env[envName(ref.Provider)+"_TOKEN"] = "{{token}}"
+		} else {
+			ref.Headers["Authorization"] = "Bearer {{token}}"
+		}
+	}
+	return ref
+}
+
+var nonEnvChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+func envName(provider string) string {
+	return nonEnvChars.ReplaceAllString(strings.ToUpper(provider), "_")
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// withCredentials 返回注入了凭证的 Server 副本
+func withCredentials(server *store.MCPServer, ref *integrationRef, creds map[string]string) (*store.MCPServer, error) {
+	var missing string
+	render := func(tpl string) string {
+		return placeholder.ReplaceAllStringFunc(tpl, func(m string) string {
+			field := placeholder.FindStringSubmatch(m)[1]
+			v, ok := creds[field]
+			if !ok && missing == "" {
+				missing = field
+			}
+			return v
+		})
+	}
+
+	cfg := make(map[string]interface{}, len(server.ConnectionConfig))
+	for k, v := range server.ConnectionConfig {
+		cfg[k] = v
+	}
+	delete(cfg, "integration")
+	if len(ref.Headers) > 0 {
+		headers := map[string]interface{}{}
+		for k, v := range configHeaders(server.ConnectionConfig) {
+			headers[k] = v
+		}
+		for k, tpl := range ref.Headers {
+			headers[k] = render(tpl)
+		}
+		cfg["headers"] = headers
+	}
+	if len(ref.Env) > 0 {
+		env := map[string]interface{}{}
+		if old, ok := server.ConnectionConfig["env"].(map[string]interface{}); ok {
+			for k, v := range old {
+				env[k] = v
+			}
+		}
+		for k, tpl := range ref.Env {
+			env[k] = render(tpl)
+		}
+		cfg["env"] = env
+	}
+	if missing != "" {
+		return nil, fmt.Errorf("%s credentials have no field %q", ref.Provider, missing)
+	}
+
+	copied := *server
+	copied.ConnectionConfig = cfg
+	return &copied, nil
+}
+
+// secretValues 需要从工具输出中抹掉的凭证值（太短的不处理，避免误伤正常文本）
+func secretValues(creds map[string]string) []string {
+	var res []string
+	for _, v := range creds {
+		if len(v) >= 6 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// redact 把文本里出现的凭证替换掉，防止 Server 回显的 Token 进入对话历史和 Trace
+func redact(text string, secrets []string) string {
+	for _, s := range secrets {
+		text = strings.ReplaceAll(text, s, "[REDACTED]")
+	}
+	return text
+}
@@ -0,0 +1,151 @@
+package mcp
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+// staticCredentials 按 用户/平台 返回固定凭证
+type staticCredentials map[string]map[string]string
+
+func (s staticCredentials) Credentials(_ context.Context, userID, provider string) (map[string]string, error) {
+	return s[userID+"/"+provider], nil
+}
+
+func TestWithCredentials(t *testing.T) {
+	creds := map[string]string{"token": "ghp_alice", "org": "acme"}
+	cases := []struct {
+		name      string
+		transport string
+		config    map[string]interface{}
+		headers   map[string]interface{}
+		env       map[string]interface{}
+		err       string
+	}{
+		{
+			name:      "http default bearer",
+			transport: TransportHTTP,
+			config:    map[string]interface{}{"url": "https://mcp.example.com/mcp", "integration": map[string]interface{}{"provider": "github"}},
+			headers:   map[string]interface{}{"Authorization": "Bearer ghp_alice"},
+		},
+		{
+			name:      "stdio default env",
+			transport: TransportStdio,
+			config:    map[string]interface{}{"command": "github-mcp", "integration": map[string]interface{}{"provider": "git-hub"}},
+			env:       map[string]interface{}{"GIT_HUB_TOKEN": "ghp_alice"},
+		},
+		{
+			name:      "custom headers keep static ones",
+			transport: TransportSSE,
+			config: map[string]interface{}{
+				"url":         "https://mcp.example.com/sse",
+				"headers":     map[string]interface{}{"X-Static": "1"},
+				"integration": map[string]interface{}{"provider": "github", "headers": map[string]interface{}{"X-Token": "{{ token }}", "X-Org": "org={{org}}"}},
+			},
+			headers: map[string]interface{}{"X-Static": "1", "X-Token": "ghp_alice", "X-Org": "org=acme"},
+		},
+		{
+			name:      "custom env merges",
+			transport: TransportStdio,
+			config: map[string]interface{}{
+				"command":     "github-mcp",
+				"env":         map[string]interface{}{"DEBUG": "1"},
+				"integration": map[string]interface{}{"provider": "github", "env": map[string]interface{}{"GH_TOKEN": "{{token}}"}},
+			},
+			env: map[string]interface{}{"DEBUG": "1", "GH_TOKEN": "ghp_alice"},
+		},
+		{
+			name:      "missing field",
+			transport: TransportHTTP,
+			config:    map[string]interface{}{"url": "https://mcp.example.com/mcp", "integration": map[string]interface{}{"provider": "github", "headers": map[string]interface{}{"X-Secret": "{{secret}}"}}},
+			err:       `github credentials have no field "secret"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := &store.MCPServer{ID: "s1", Name: "gh", TransportType: c.transport, ConnectionConfig: c.config}
+			ref := integrationOf(server)
+			if ref == nil {
+				t.Fatal("integration block not recognised")
+			}
+			got, err := withCredentials(server, ref, creds)
+			if c.err != "" {
+				if err == nil || err.Error() != c.err {
+					t.Fatalf("err = %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := got.ConnectionConfig["integration"]; ok {
+				t.Fatal("integration block left in the resolved config")
+			}
+			if c.headers != nil && !reflect.DeepEqual(got.ConnectionConfig["headers"], c.headers) {
+				t.Fatalf("headers = %v", got.ConnectionConfig["headers"])
+			}
+			if c.env != nil && !reflect.DeepEqual(got.ConnectionConfig["env"], c.env) {
+				t.Fatalf("env = %v", got.ConnectionConfig["env"])
+			}
+			// 注入只发生在副本上，存储里的配置不带凭证
+			if _, ok := server.ConnectionConfig["integration"]; !ok || strings.Contains(configVersion(server), "ghp_alice") {
+				t.Fatalf("stored config modified: %v", server.ConnectionConfig)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	secrets := secretValues(map[string]string{"token": "ghp_alice", "org": "acme"})
+	// 太短的值不抹，避免误伤正常文本
+	if !reflect.DeepEqual(secrets, []string{"ghp_alice"}) {
+		t.Fatalf("secrets = %v", secrets)
+	}
+	if got := redact("token ghp_alice for acme, again ghp_alice", secrets); got != "token [REDACTED] for acme, again [REDACTED]" {
+		t.Fatalf("redacted = %q", got)
+	}
+}
+
+func TestCallRemoteInjectsCallerCredentials(t *testing.T) {
+	f := newFakeServer(t, textTool("echo"))
+	e := NewExecutor(store.NewMemoryStore())
+	e.Clients.Credentials = staticCredentials{
+		"alice/github": {"token": "ghp_alice"},
+		"bob/github":   {"token": "ghp_bob_token"},
+	}
+	t.Cleanup(e.Clients.CloseAll)
+	server := &store.MCPServer{ID: "s1", Name: "gh", TransportType: TransportHTTP, ConnectionConfig: map[string]interface{}{
+		"url":         f.url(TransportHTTP),
+		"integration": map[string]interface{}{"provider": "github"},
+	}}
+
+	// 没有绑定用户的调用不注入任何凭证
+	if _, err := e.ExecuteTool(testCtx(t), server, "echo", `{"text":"hi"}`); err == nil || !strings.Contains(err.Error(), "no user is bound") {
+		t.Fatalf("anonymous call err = %v", err)
+	}
+
+	// 每个用户用自己的凭证单独建连；Server 回显的 Token 被抹掉
+	for _, user := range []string{"alice", "bob"} {
+		token := e.Clients.Credentials.(staticCredentials)[user+"/github"]["token"]
+		out, err := e.ExecuteTool(WithUser(testCtx(t), user), server, "echo", `{"text":"my token is `+token+`"}`)
+		if err != nil {
+			t.Fatalf("%s: %v", user, err)
+		}
+		if out != "my token is [REDACTED]" {
+			t.Fatalf("%s output = %q", user, out)
+		}
+	}
+	f.mu.Lock()
+	auth := map[string]bool{}
+	for _, a := range f.auth {
+		auth[a] = true
+	}
+	f.mu.Unlock()
+	if !reflect.DeepEqual(auth, map[string]bool{"Bearer ghp_alice": true, "Bearer ghp_bob_token": true}) {
+		t.Fatalf("authorization headers = %v", auth)
+	}
+}
@@ -3,32 +3,107 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"example.com/agent-server/internal/service/workspace"
 	"example.com/agent-server/internal/store"
 )
 
 // Executor 负责执行具体的工具逻辑
 type Executor struct {
-	Store store.Store
+	Store   store.Store
+	Clients *ClientPool // 远程 MCP Server 的连接池
 }
 
 func NewExecutor(s store.Store) *Executor {
-	return &Executor{Store: s}
+	return &Executor{Store: s, Clients: NewClientPool()}
+}
+
+// 工具调用超时，可按 Server / 工具覆盖：
+//
+//	{"timeout_seconds": 120, "tool_timeouts": {"search_files": 300}}
+const DefaultToolTimeout = 60 * time.Second
+
+// ToolTimeout 工具调用的超时时间：tool_timeouts > timeout_seconds > DefaultToolTimeout
+func ToolTimeout(server *store.MCPServer, toolName string) time.Duration {
+	if perTool, ok := server.ConnectionConfig["tool_timeouts"].(map[string]interface{}); ok {
+		if sec := configSeconds(perTool[toolName]); sec > 0 {
+			return sec
+		}
+	}
+	if sec := configSeconds(server.ConnectionConfig["timeout_seconds"]); sec > 0 {
+		return sec
+	}
+	return DefaultToolTimeout
+}
+
+// RequiresApproval 调用前是否需要用户审批：
+//
+//	{"require_approval": true}                          // 该 Server 的全部工具
+//	{"require_approval": ["git_commit", "write_file"]}  // 只有列出的工具
+func RequiresApproval(server *store.MCPServer, toolName string) bool {
+	switch v := server.ConnectionConfig["require_approval"].(type) {
+	case bool:
+		return v
+	case []interface{}:
+		for _, name := range v {
+			if name == toolName {
+				return true
+			}
+		}
+	case []string:
+		for _, name := range v {
+			if name == toolName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func configSeconds(v interface{}) time.Duration {
+	switch n := v.(type) {
+	case float64:
+		return time.Duration(n * float64(time.Second))
+	case int:
+		return time.Duration(n) * time.Second
+	case json.Number:
+		f, _ := n.Float64()
+		return time.Duration(f * float64(time.Second))
+	}
+	return 0
 }
 
 // ExecuteTool 执行工具
 // server: 目标 Server
 // toolName: 工具名 (e.g. "git_status")
 // argsJSON: LLM 生成的 JSON 参数字符串 (e.g. '{"path": "."}')
+// ctx 取消（CancelRun）或超时时，内置工具的子进程整组被杀掉，远程调用会通知 Server 放弃请求
 func (e *Executor) ExecuteTool(ctx context.Context, server *store.MCPServer, toolName string, argsJSON string) (string, error) {
 	log.Printf("[MCP Executor] Executing %s on server %s. Args: %s", toolName, server.Name, argsJSON)
 
+	timeout := ToolTimeout(server, toolName)
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out, err := e.execute(callCtx, server, toolName, argsJSON)
+	// 只有工具自己的超时才改写错误；Run 被取消 / Run 超时原样返回
+	if err != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("tool %s timed out after %s", toolName, timeout)
+	}
+	return out, err
+}
+
+func (e *Executor) execute(ctx context.Context, server *store.MCPServer, toolName string, argsJSON string) (string, error) {
 	// 1. 解析参数
 	var args map[string]interface{}
 	// 允许空参数的情况
@@ -40,26 +115,74 @@ func (e *Executor) ExecuteTool(ctx context.Context, server *store.MCPServer, too
 		args = make(map[string]interface{})
 	}
 
-	// 2. 路由分发 (Routing)
-	// 根据 Server 的名字或类型，分发到不同的处理函数
-	// 注意：在真实 MCP 中，这里应该是通过 stdio/sse 发送 JSON-RPC 请求
-	// 但在 MVP 中，我们直接调用内部实现的 Go 函数
-	serverName := strings.ToLower(server.Name)
-
-	switch {
-	case strings.Contains(serverName, "git"):
+	// 2. 平台内置 Server：分发到进程内实现的 Go 函数
+	switch BuiltinKind(server) {
+	case BuiltinGit:
 		return e.handleGit(ctx, toolName, args)
-
-	case strings.Contains(serverName, "filesystem") || strings.Contains(serverName, "fs"):
+	case BuiltinFilesystem:
 		return e.handleFilesystem(ctx, toolName, args)
+	}
+
+	// 3. 其它 Server (stdio 子进程 / sse / http)：走真正的 MCP JSON-RPC (tools/call)
+	return e.callRemote(ctx, server, toolName, args)
+}
 
-	// 未来扩展：
-	// case server.TransportType == "sse":
-	//     return e.callRemoteSSE(ctx, server, toolName, args)
+// 内置实现的种类，对应 connection_config.builtin
+const (
+	BuiltinGit        = "git"
+	BuiltinFilesystem = "filesystem"
+)
 
-	default:
-		return "", fmt.Errorf("server implementation not found for: %s", serverName)
+// BuiltinKind 返回 Server 使用的内置实现；空串表示这是一个真正的 MCP Server
+func BuiltinKind(server *store.MCPServer) string {
+	kind, _ := server.ConnectionConfig["builtin"].(string)
+	return strings.ToLower(kind)
+}
+
+// callRemote 通过连接池调用 MCP Server 的 tools/call
+func (e *Executor) callRemote(ctx context.Context, server *store.MCPServer, toolName string, args map[string]interface{}) (string, error) {
+	client, err := e.Clients.Get(ctx, server)
+	if err != nil {
+		return "", fmt.Errorf("connect mcp server %s: %w", server.Name, err)
+	}
+
+	res, err := client.CallTool(ctx, toolName, args)
+	if err != nil {
+		// 协议层错误 (RPCError) / 调用方取消 说明连接是好的；连接已断开 (ErrClientClosed) 交给连接池自己处理（stdio 会自动重启）；
+		// 其它错误连接可能已坏，丢掉重连
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) && !errors.Is(err, ErrClientClosed) && ctx.Err() == nil {
+			e.Clients.Invalidate(server.ID)
+		}
+		return "", fmt.Errorf("mcp tools/call %s: %w", toolName, err)
+	}
+
+	text := redact(res.Text(), client.secrets)
+	if res.IsError {
+		// 工具自己报的错，原样交给 LLM 自我修正
+		return "", fmt.Errorf("%s", text)
+	}
+	return text, nil
+}
+
+// =============================================================================
+// Workspace: 内置工具只在会话的工作区目录里活动
+// =============================================================================
+
+type workspaceCtxKey struct{}
+
+// WithWorkspace 绑定本次调用的工作区根目录（见 workspace.Manager）
+func WithWorkspace(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, workspaceCtxKey{}, dir)
+}
+
+// workspaceFrom 没有绑定工作区时拒绝执行，不会退回到服务进程的工作目录
+func workspaceFrom(ctx context.Context) (string, error) {
+	dir, _ := ctx.Value(workspaceCtxKey{}).(string)
+	if dir == "" {
+		return "", fmt.Errorf("no workspace bound to this call")
 	}
+	return dir, nil
 }
 
 // =============================================================================
@@ -67,16 +190,25 @@ func (e *Executor) ExecuteTool(ctx context.Context, server *store.MCPServer, too
 // =============================================================================
 
 func (e *Executor) handleGit(ctx context.Context, tool string, args map[string]interface{}) (string, error) {
+	dir, err := workspaceFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	switch tool {
 	case "git_status":
-		return runCommand("git", "status")
+		return runGit(ctx, dir, "status")
 
 	case "git_diff":
 		target, _ := args["target"].(string)
 		if target == "" {
 			target = "HEAD"
 		}
-		return runCommand("git", "diff", target)
+		// 以 - 开头会被当成选项（e.g. --output=...），拒绝
+		if strings.HasPrefix(target, "-") {
+			return "", fmt.Errorf("invalid target: %s", target)
+		}
+		return runGit(ctx, dir, "diff", target, "--")
 
 	case "git_commit":
 		msg, ok := args["message"].(string)
@@ -86,12 +218,14 @@ func (e *Executor) handleGit(ctx context.Context, tool string, args map[string]i
 		// 简单的 commit，实际可能需要处理 add_all
 		addAll, _ := args["add_all"].(bool)
 		if addAll {
-			runCommand("git", "add", ".")
+			if out, err := runGit(ctx, dir, "add", "."); err != nil {
+				return out, err
+			}
 		}
-		return runCommand("git", "commit", "-m", msg)
+		return runGit(ctx, dir, "commit", "-m", msg)
 
 	case "git_log":
-		return runCommand("git", "log", "-n", "10", "--oneline")
+		return runGit(ctx, dir, "log", "-n", "10", "--oneline")
 
 	default:
 		return "", fmt.Errorf("unknown git tool: %s", tool)
@@ -103,8 +237,11 @@ func (e *Executor) handleGit(ctx context.Context, tool string, args map[string]i
 // =============================================================================
 
 func (e *Executor) handleFilesystem(ctx context.Context, tool string, args map[string]interface{}) (string, error) {
-	// 获取当前工作目录，作为根目录
-	cwd, _ := os.Getwd()
+	// 会话工作区作为根目录，路径不能跳出（含符号链接）
+	root, err := workspaceFrom(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	switch tool {
 	case "list_directory":
@@ -113,10 +250,13 @@ func (e *Executor) handleFilesystem(ctx context.Context, tool string, args map[s
 			path = "."
 		}
 
-		targetPath := filepath.Join(cwd, path)
+		targetPath, err := workspace.Resolve(root, path)
+		if err != nil {
+			return "", err
+		}
 		entries, err := os.ReadDir(targetPath)
 		if err != nil {
-			return "", fmt.Errorf("ls error: %v", err)
+			return "", fmt.Errorf("ls error: %v", relErr(root, err))
 		}
 
 		var names []string
@@ -135,12 +275,11 @@ func (e *Executor) handleFilesystem(ctx context.Context, tool string, args map[s
 			return "", fmt.Errorf("missing path")
 		}
 
-		targetPath := filepath.Join(cwd, path)
-		content, err := os.ReadFile(targetPath)
+		targetPath, err := workspace.Resolve(root, path)
 		if err != nil {
-			return "", fmt.Errorf("read error: %v", err)
+			return "", err
 		}
-		return string(content), nil
+		return readFileLimited(root, targetPath)
 
 	case "write_file":
 		path, ok := args["path"].(string)
@@ -149,37 +288,113 @@ func (e *Executor) handleFilesystem(ctx context.Context, tool string, args map[s
 			return "", fmt.Errorf("missing path or content")
 		}
 
-		targetPath := filepath.Join(cwd, path)
+		targetPath, err := workspace.ResolveWritable(root, path)
+		if err != nil {
+			return "", err
+		}
 		// 0644 权限写入
 		if err := os.WriteFile(targetPath, []byte(content), 0644); err != nil {
-			return "", fmt.Errorf("write error: %v", err)
+			return "", fmt.Errorf("write error: %v", relErr(root, err))
 		}
 		return fmt.Sprintf("Successfully wrote to %s", path), nil
 
 	case "search_files":
-		// 简单的 Grep 实现
+		// 简单的 Grep 实现（-r 不跟随符号链接）
 		pattern, ok := args["pattern"].(string)
 		if !ok {
 			return "", fmt.Errorf("missing pattern")
 		}
-		return runCommand("grep", "-r", pattern, ".")
+		return runCommand(ctx, root, "grep", "-r", "-e", pattern, "--", ".")
 
 	default:
 		return "", fmt.Errorf("unknown fs tool: %s", tool)
 	}
 }
 
+// relErr 错误信息里的绝对路径换成工作区内的相对路径，不向 LLM 暴露服务器目录结构
+func relErr(root string, err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		if rel, e := filepath.Rel(root, pathErr.Path); e == nil {
+			pathErr.Path = rel
+		} else if real, e := filepath.EvalSymlinks(root); e == nil {
+			if rel, e := filepath.Rel(real, pathErr.Path); e == nil {
+				pathErr.Path = rel
+			}
+		}
+	}
+	return err
+}
+
 // =============================================================================
 // Helper: Command Runner
 // =============================================================================
 
-func runCommand(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	// 在生产环境中，这里应该设置 cmd.Dir 到用户的 Workspace 目录
-	// cmd.Dir = "/tmp/workspace/user_123"
+// 内置工具一次最多读入 / 收集这么多字节，超出部分丢弃；
+// 交给 LLM 前还会再按 Agent 的 max_tool_output_chars 截断
+const maxBuiltinOutput = 8 << 20
+
+// readFileLimited 读文件，最多 maxBuiltinOutput 字节
+func readFileLimited(root, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("read error: %v", relErr(root, err))
+	}
+	defer f.Close()
+	content, err := io.ReadAll(io.LimitReader(f, maxBuiltinOutput+1))
+	if err != nil {
+		return "", fmt.Errorf("read error: %v", relErr(root, err))
+	}
+	if len(content) > maxBuiltinOutput {
+		return string(content[:maxBuiltinOutput]) + fmt.Sprintf("\n[file truncated at %d bytes]", maxBuiltinOutput), nil
+	}
+	return string(content), nil
+}
+
+// runGit 在工作区内执行 git
+// 工作区内容来自用户上传，不读取系统 / 全局配置，并关掉会执行外部命令的 hooks 和 fsmonitor；
+// GIT_CEILING_DIRECTORIES 防止工作区不是仓库时向上找到服务器自己的仓库
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	base := []string{"-c", "core.hooksPath=/dev/null", "-c", "core.fsmonitor=false"}
+	cmd := commandContext(ctx, "git", append(base, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CEILING_DIRECTORIES="+filepath.Dir(dir),
+		"GIT_TERMINAL_PROMPT=0",
+		// 没有全局配置时 commit 需要身份
+		"GIT_AUTHOR_NAME=Nexus Agent", "GIT_AUTHOR_EMAIL=agent@nexus.local",
+		"GIT_COMMITTER_NAME=Nexus Agent", "GIT_COMMITTER_EMAIL=agent@nexus.local",
+	)
+	return combinedOutput(ctx, cmd)
+}
 
-	output, err := cmd.CombinedOutput()
-	result := string(output)
+func runCommand(ctx context.Context, dir, name string, args ...string) (string, error) {
+	cmd := commandContext(ctx, name, args...)
+	cmd.Dir = dir
+	return combinedOutput(ctx, cmd)
+}
+
+// commandContext ctx 结束时杀掉整个进程组（git 会再起子进程，只杀父进程时子进程仍占着输出管道）
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	// 被杀后仍有孙进程占着管道时，最多再等这么久就不管输出直接返回
+	cmd.WaitDelay = 2 * time.Second
+	return cmd
+}
+
+func combinedOutput(ctx context.Context, cmd *exec.Cmd) (string, error) {
+	output := &cappedBuffer{limit: maxBuiltinOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		// 被取消 / 超时：进程已被杀掉，输出不完整，按错误返回
+		return "", fmt.Errorf("%s killed: %w", filepath.Base(cmd.Path), ctxErr)
+	}
+	result := output.String()
 
 	if err != nil {
 		// 注意：即使报错（比如 git status 报 fatal），也应该返回 output 给 LLM，
@@ -189,3 +404,31 @@ func runCommand(name string, args ...string) (string, error) {
 
 	return result, nil
 }
+
+// cappedBuffer 只保留前 limit 字节的输出，其余丢弃（仍然读走，不让子进程阻塞在写管道上）
+type cappedBuffer struct {
+	buf     []byte
+	limit   int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - len(b.buf); room > 0 {
+		if len(p) <= room {
+			b.buf = append(b.buf, p...)
+			return len(p), nil
+		}
+		b.buf = append(b.buf, p[:room]...)
+		b.dropped += len(p) - room
+		return len(p), nil
+	}
+	b.dropped += len(p)
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.dropped > 0 {
+		return string(b.buf) + fmt.Sprintf("\n[output truncated: %d more bytes discarded]", b.dropped)
+	}
+	return string(b.buf)
+}
@@ -0,0 +1,147 @@
+package mcp
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/agent-server/internal/service/workspace"
+	"example.com/agent-server/internal/store"
+)
+
+var (
+	gitServer = &store.MCPServer{Name: "git", ConnectionConfig: map[string]interface{}{"builtin": BuiltinGit}}
+	fsServer  = &store.MCPServer{Name: "fs", ConnectionConfig: map[string]interface{}{"builtin": BuiltinFilesystem}}
+)
+
+func requireGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunGitIgnoresRepositoryHooksAndConfig(t *testing.T) {
+	requireGit(t)
+	dir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "pwned")
+	if out, err := runGit(testCtx(t), dir, "init", "-q"); err != nil || strings.HasPrefix(out, "Command failed") {
+		t.Fatalf("git init: %s %v", out, err)
+	}
+	// 不可信的仓库自带会执行命令的 hooks / fsmonitor / hooksPath
+	evil := "#!/bin/sh\ntouch " + marker + "\n"
+	writeTestFile(t, filepath.Join(dir, ".git", "hooks", "pre-commit"), evil, 0o755)
+	writeTestFile(t, filepath.Join(dir, "evil-hooks", "pre-commit"), evil, 0o755)
+	writeTestFile(t, filepath.Join(dir, "fsmonitor.sh"), evil, 0o755)
+	cfg, _ := os.ReadFile(filepath.Join(dir, ".git", "config"))
+	cfg = append(cfg, []byte("[core]\n\tfsmonitor = "+filepath.Join(dir, "fsmonitor.sh")+"\n\thooksPath = "+filepath.Join(dir, "evil-hooks")+"\n")...)
+	writeTestFile(t, filepath.Join(dir, ".git", "config"), string(cfg), 0o644)
+
+	e := NewExecutor(store.NewMemoryStore())
+	ctx := WithWorkspace(testCtx(t), dir)
+	for _, step := range []struct{ tool, args string }{
+		{"git_status", `{}`},
+		{"git_commit", `{"message":"first","add_all":true}`},
+	} {
+		out, err := e.ExecuteTool(ctx, gitServer, step.tool, step.args)
+		if err != nil || strings.HasPrefix(out, "Command failed") {
+			t.Fatalf("%s: %s %v", step.tool, out, err)
+		}
+	}
+	if out, _ := e.ExecuteTool(ctx, gitServer, "git_log", `{}`); !strings.Contains(out, "first") {
+		t.Fatalf("git log = %q", out)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("repository hook was executed")
+	}
+}
+
+func TestRunGitStaysInWorkspace(t *testing.T) {
+	requireGit(t)
+	// 工作区本身不是仓库，外层目录是：不能向上找到外层仓库
+	outer := t.TempDir()
+	if out, err := runGit(testCtx(t), outer, "init", "-q"); err != nil || strings.HasPrefix(out, "Command failed") {
+		t.Fatalf("git init: %s %v", out, err)
+	}
+	dir := filepath.Join(outer, "s1")
+	writeTestFile(t, filepath.Join(dir, "a.txt"), "a", 0o644)
+
+	e := NewExecutor(store.NewMemoryStore())
+	out, err := e.ExecuteTool(WithWorkspace(testCtx(t), dir), gitServer, "git_status", `{}`)
+	if err != nil || !strings.Contains(out, "not a git repository") {
+		t.Fatalf("git status = %q, %v", out, err)
+	}
+	// 以 - 开头的 target 会被 git 当成选项
+	if _, err := e.ExecuteTool(WithWorkspace(testCtx(t), dir), gitServer, "git_diff", `{"target":"--output=/tmp/pwned"}`); err == nil {
+		t.Fatal("option-like diff target accepted")
+	}
+}
+
+func TestBuiltinToolsRequireWorkspace(t *testing.T) {
+	e := NewExecutor(store.NewMemoryStore())
+	for _, server := range []*store.MCPServer{gitServer, fsServer} {
+		tool := "git_status"
+		if server == fsServer {
+			tool = "list_directory"
+		}
+		if _, err := e.ExecuteTool(testCtx(t), server, tool, `{}`); err == nil || !strings.Contains(err.Error(), "no workspace") {
+			t.Fatalf("%s without workspace: %v", tool, err)
+		}
+	}
+}
+
+func TestFilesystemToolsConfined(t *testing.T) {
+	dir, _ := filepath.EvalSymlinks(t.TempDir())
+	outside := t.TempDir()
+	writeTestFile(t, filepath.Join(outside, "secret"), "top secret", 0o644)
+	writeTestFile(t, filepath.Join(dir, ".git", "config"), "[core]\n", 0o644)
+	if err := os.Symlink(outside, filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+	e := NewExecutor(store.NewMemoryStore())
+	ctx := WithWorkspace(testCtx(t), dir)
+
+	cases := []struct {
+		tool, args string
+		err        error // nil 表示应当成功
+	}{
+		{"read_file", `{"path":"out/secret"}`, workspace.ErrOutsideWorkspace},
+		{"list_directory", `{"path":"out"}`, workspace.ErrOutsideWorkspace},
+		{"write_file", `{"path":"out/new","content":"x"}`, workspace.ErrOutsideWorkspace},
+		{"write_file", `{"path":".git/hooks/pre-commit","content":"#!/bin/sh"}`, workspace.ErrProtectedPath},
+		{"write_file", `{"path":"../.git/config","content":"x"}`, workspace.ErrProtectedPath},
+		// ../ 被钉在工作区内：写到工作区根目录下
+		{"write_file", `{"path":"../../escaped.txt","content":"x"}`, nil},
+	}
+	for _, c := range cases {
+		out, err := e.ExecuteTool(ctx, fsServer, c.tool, c.args)
+		if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("%s %s = %q, %v; want %v", c.tool, c.args, out, err, c.err)
+		}
+		if strings.Contains(out, "top secret") {
+			t.Errorf("%s %s leaked %q", c.tool, c.args, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); err != nil {
+		t.Fatalf("clamped write: %v", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Fatalf("outside dir has %d entries", len(entries))
+	}
+	if cfg, _ := os.ReadFile(filepath.Join(dir, ".git", "config")); string(cfg) != "[core]\n" {
+		t.Fatalf(".git/config = %q", cfg)
+	}
+}
@@ -0,0 +1,344 @@
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeServer 测试用的本地 MCP Server，同时提供三种传输：
+//   - 旧版 HTTP+SSE (2024-11-05)：GET /sse 建立流并推送 endpoint，POST /messages?session=<id> 的响应走 SSE 流
+//   - Streamable HTTP (2025-03-26)：POST /mcp，单条响应用 application/json，带通知时用 text/event-stream
+//   - stdio：测试二进制以子进程方式运行 TestFakeStdioServer（见 stdioConfig），在 stdin/stdout 上按行收发
+//
+// tools/call 在返回结果前先推送一条 notifications/message，验证通知不会打断等待中的响应
+// 除 echo 外还有 pid（返回进程号，用来区分 stdio 进程是否换过）；stdio 下调用 crash 会让进程直接退出
+type fakeServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	tools     []Tool
+	resources []Resource // 为 nil 时握手不声明 resources 能力
+	prompts   []Prompt   // 为 nil 时握手不声明 prompts 能力
+	pageSize  int
+	methods   []string               // 收到的消息（含通知），按顺序
+	sessions  []string               // Streamable HTTP 请求带的 Mcp-Session-Id
+	auth      []string               // Streamable HTTP 请求带的 Authorization
+	failing   bool                   // 为 true 时 Streamable HTTP 请求一律返回 503，模拟 Server 故障
+	streams   map[string]chan []byte // SSE 会话 -> 待推送的消息
+	nextID    int
+}
+
+// stdioServerEnv 设置了这个环境变量的测试进程充当 stdio Server
+const stdioServerEnv = "MCP_FAKE_STDIO_SERVER"
+
+// TestFakeStdioServer 不是真正的测试：只在 stdioConfig 拉起的子进程里运行，stdin 关闭后退出
+func TestFakeStdioServer(t *testing.T) {
+	if os.Getenv(stdioServerEnv) != "1" {
+		return
+	}
+	f := &fakeServer{tools: []Tool{textTool("echo"), textTool("pid"), textTool("crash")}, pageSize: 2}
+	f.serveStdio(os.Stdin, os.Stdout)
+	os.Exit(0)
+}
+
+// stdioConfig 以 stdio 方式运行 fakeServer 的 connection_config
+func stdioConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"command": os.Args[0],
+		"args":    []interface{}{"-test.run=^TestFakeStdioServer$"},
+		"env":     map[string]interface{}{stdioServerEnv: "1"},
+	}
+}
+
+func (f *fakeServer) serveStdio(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		if msg.Method == "tools/call" {
+			var params struct {
+				Name string `json:"name"`
+			}
+			if _ = json.Unmarshal(msg.Params, &params); params.Name == "crash" {
+				os.Exit(3)
+			}
+		}
+		for _, m := range f.handle(&msg, ProtocolVersion) {
+			_, _ = out.Write(append(mustMarshal(m), '\n'))
+		}
+	}
+}
+
+func newFakeServer(t *testing.T, tools ...Tool) *fakeServer {
+	t.Helper()
+	f := &fakeServer{tools: tools, pageSize: 2, streams: map[string]chan []byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", f.serveSSE)
+	mux.HandleFunc("/messages", f.serveSSEPost)
+	mux.HandleFunc("/mcp", f.serveHTTP)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		// SSE 长连接不会自己结束，先断开再关闭
+		f.CloseClientConnections()
+		f.Close()
+	})
+	return f
+}
+
+// setTools 替换工具列表
+func (f *fakeServer) setTools(tools ...Tool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tools = tools
+}
+
+// setResources 替换资源列表
+func (f *fakeServer) setResources(resources ...Resource) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resources = resources
+}
+
+// setPrompts 替换提示模板列表
+func (f *fakeServer) setPrompts(prompts ...Prompt) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prompts = prompts
+}
+
+// setFailing 切换故障状态
+func (f *fakeServer) setFailing(failing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing = failing
+}
+
+// received 收到的方法名
+func (f *fakeServer) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.methods...)
+}
+
+func (f *fakeServer) count(method string) int {
+	n := 0
+	for _, m := range f.received() {
+		if m == method {
+			n++
+		}
+	}
+	return n
+}
+
+// handle 处理一条消息，返回要推送给客户端的消息（通知在前，响应在后）
+func (f *fakeServer) handle(msg *Message, version string) []*Message {
+	f.mu.Lock()
+	f.methods = append(f.methods, msg.Method)
+	tools, resources, prompts := f.tools, f.resources, f.prompts
+	pageSize := f.pageSize
+	f.mu.Unlock()
+	if !msg.IsRequest() {
+		return nil
+	}
+
+	var params map[string]interface{}
+	_ = json.Unmarshal(msg.Params, &params)
+	result := func(v interface{}) []*Message {
+		return []*Message{{JSONRPC: jsonrpcVersion, ID: msg.ID, Result: mustMarshal(v)}}
+	}
+	// page 按 cursor 取一页，返回这一页的范围和下一页的 cursor
+	page := func(total int) (int, int, string) {
+		start := 0
+		if c, _ := params["cursor"].(string); c != "" {
+			start, _ = strconv.Atoi(c)
+		}
+		end := start + pageSize
+		if end >= total {
+			return start, total, ""
+		}
+		return start, end, strconv.Itoa(end)
+	}
+	switch msg.Method {
+	case "initialize":
+		caps := map[string]interface{}{"tools": map[string]interface{}{"listChanged": true}}
+		if resources != nil {
+			caps["resources"] = map[string]interface{}{}
+		}
+		if prompts != nil {
+			caps["prompts"] = map[string]interface{}{}
+		}
+		return result(InitializeResult{
+			ProtocolVersion: version,
+			Capabilities:    caps,
+			ServerInfo:      Implementation{Name: "fake", Version: "1.0.0"},
+		})
+	case "ping":
+		return result(map[string]interface{}{})
+	case "tools/list":
+		start, end, next := page(len(tools))
+		return result(listToolsResult{Tools: tools[start:end], NextCursor: next})
+	case "resources/list":
+		start, end, next := page(len(resources))
+		return result(listResourcesResult{Resources: resources[start:end], NextCursor: next})
+	case "resources/read":
+		uri, _ := params["uri"].(string)
+		for _, r := range resources {
+			if r.URI == uri {
+				return result(ReadResourceResult{Contents: []ResourceContents{{URI: uri, MimeType: r.MimeType, Text: "contents of " + r.Name}}})
+			}
+		}
+		return []*Message{errorMessage(msg.ID, codeInvalidParams, "unknown resource: "+uri)}
+	case "prompts/list":
+		start, end, next := page(len(prompts))
+		return result(listPromptsResult{Prompts: prompts[start:end], NextCursor: next})
+	case "prompts/get":
+		// 渲染结果：模板名 + 按参数名排序的参数
+		name, _ := params["name"].(string)
+		args, _ := params["arguments"].(map[string]interface{})
+		keys := make([]string, 0, len(args))
+		for k := range args {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		text := name
+		for _, k := range keys {
+			text += fmt.Sprintf(" %s=%v", k, args[k])
+		}
+		return result(GetPromptResult{Messages: []PromptMessage{{Role: "user", Content: Content{Type: "text", Text: text}}}})
+	case "tools/call":
+		name, _ := params["name"].(string)
+		if name == "pid" {
+			return result(CallToolResult{Content: []Content{{Type: "text", Text: strconv.Itoa(os.Getpid())}}})
+		}
+		if name != "echo" {
+			return []*Message{errorMessage(msg.ID, codeInvalidParams, "unknown tool: "+name)}
+		}
+		args, _ := params["arguments"].(map[string]interface{})
+		note := &Message{JSONRPC: jsonrpcVersion, Method: "notifications/message", Params: mustMarshal(map[string]interface{}{"level": "info", "data": "echoing"})}
+		res := result(CallToolResult{Content: []Content{{Type: "text", Text: fmt.Sprint(args["text"])}}})
+		return append([]*Message{note}, res...)
+	}
+	return []*Message{errorMessage(msg.ID, codeMethodNotFound, "method not found: "+msg.Method)}
+}
+
+func (f *fakeServer) serveSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if r.Method != http.MethodGet || !ok {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.nextID++
+	id := strconv.Itoa(f.nextID)
+	ch := make(chan []byte, 16)
+	f.streams[id] = ch
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "event: endpoint\ndata: /messages?session=%s\n\n", id)
+	flusher.Flush()
+	for {
+		select {
+		case data := <-ch:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (f *fakeServer) serveSSEPost(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	ch := f.streams[r.URL.Query().Get("session")]
+	f.mu.Unlock()
+	msg, ok := readMessage(w, r)
+	if !ok {
+		return
+	}
+	if ch == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	for _, out := range f.handle(msg, "2024-11-05") {
+		ch <- mustMarshal(out)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (f *fakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	failing := f.failing
+	f.mu.Unlock()
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	msg, ok := readMessage(w, r)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	f.sessions = append(f.sessions, r.Header.Get("Mcp-Session-Id"))
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	f.mu.Unlock()
+
+	out := f.handle(msg, ProtocolVersion)
+	if msg.Method == "initialize" {
+		w.Header().Set("Mcp-Session-Id", "fake-session")
+	}
+	switch len(out) {
+	case 0:
+		w.WriteHeader(http.StatusAccepted)
+	case 1:
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(mustMarshal(out[0]))
+	default:
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, m := range out {
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", mustMarshal(m))
+		}
+	}
+}
+
+func readMessage(w http.ResponseWriter, r *http.Request) (*Message, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return &msg, true
+}
+
+// url 某种传输对应的地址
+func (f *fakeServer) url(transport string) string {
+	if transport == TransportSSE {
+		return f.URL + "/sse"
+	}
+	return f.URL + "/mcp"
+}
+
+func textTool(name string) Tool {
+	return Tool{Name: name, Description: name + " tool", InputSchema: map[string]interface{}{"type": "object"}}
+}
@@ -0,0 +1,167 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"example.com/agent-server/internal/store"
+)
+
+// MCPServer.Status 的取值
+const (
+	ServerStatusActive       = "active"       // 最近一次探测正常
+	ServerStatusDegraded     = "degraded"     // 探测偏慢，或刚开始失败（未达到断开阈值）
+	ServerStatusDisconnected = "disconnected" // 连续多次探测失败，工具不再提供给 LLM
+)
+
+// 健康检查的默认策略
+const (
+	DefaultProbeInterval = 30 * time.Second
+	probeTimeout         = 10 * time.Second
+	slowProbeThreshold   = 2 * time.Second // ping 超过这个时间记为 degraded
+	disconnectAfter      = 3               // 连续失败次数达到后记为 disconnected
+	probeHistorySize     = 50              // 每个 Server 保留的探测记录条数
+)
+
+// ProbeResult 一次探测的结果
+type ProbeResult struct {
+	At        time.Time `json:"at"`
+	Status    string    `json:"status"` // active / degraded / disconnected / idle（stdio 进程未运行）
+	LatencyMs int       `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ServerUsable Server 的工具是否可以提供给 LLM
+// 只排除确认断开的 Server；degraded 仍可用，只是偏慢或偶发失败
+func ServerUsable(server *store.MCPServer) bool {
+	return server != nil && server.Status != ServerStatusDisconnected
+}
+
+// HealthMonitor 后台定期探测所有已注册的 MCP Server，维护 MCPServer.Status / LatencyMs / LastError
+type HealthMonitor struct {
+	Store    store.Store
+	Pool     *ClientPool
+	Interval time.Duration
+
+	mu       sync.Mutex
+	history  map[string][]ProbeResult // serverID -> 最近的探测记录（旧 -> 新）
+	failures map[string]int           // serverID -> 连续失败次数
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+}
+
+func NewHealthMonitor(s store.Store, pool *ClientPool) *HealthMonitor {
+	return &HealthMonitor{
+		Store:    s,
+		Pool:     pool,
+		Interval: DefaultProbeInterval,
+		history:  make(map[string][]ProbeResult),
+		failures: make(map[string]int),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start 启动后台探测（重复调用无效）
+func (m *HealthMonitor) Start() {
+	m.startOnce.Do(func() {
+		log.Printf("[MCP Health] probing servers every %s", m.Interval)
+		go m.loop()
+	})
+}
+
+// Stop 停止后台探测
+func (m *HealthMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *HealthMonitor) loop() {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.ProbeAll(context.Background())
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// ProbeAll 并发探测全部 Server
+func (m *HealthMonitor) ProbeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, server := range m.Store.ListAllMCPServers() {
+		wg.Add(1)
+		go func(server *store.MCPServer) {
+			defer wg.Done()
+			m.Probe(ctx, server)
+		}(server)
+	}
+	wg.Wait()
+}
+
+// Probe 探测一个 Server，写回状态并记录历史
+func (m *HealthMonitor) Probe(ctx context.Context, server *store.MCPServer) ProbeResult {
+	res := ProbeResult{At: time.Now(), Status: ServerStatusActive}
+
+	// 内置 Server 在进程内实现，不需要探测
+	if BuiltinKind(server) == "" {
+		pctx, cancel := context.WithTimeout(ctx, probeTimeout)
+		latency, err := m.Pool.Probe(pctx, server)
+		cancel()
+		res.LatencyMs = int(latency.Milliseconds())
+		switch {
+		case errors.Is(err, ErrNotRunning):
+			res.Status = "idle"
+		case err != nil:
+			res.Error = err.Error()
+		case latency > slowProbeThreshold:
+			res.Status = ServerStatusDegraded
+		}
+	}
+
+	m.mu.Lock()
+	if res.Error != "" {
+		m.failures[server.ID]++
+		res.Status = ServerStatusDegraded
+		if m.failures[server.ID] >= disconnectAfter {
+			res.Status = ServerStatusDisconnected
+		}
+	} else {
+		m.failures[server.ID] = 0
+	}
+	h := append(m.history[server.ID], res)
+	if len(h) > probeHistorySize {
+		h = h[len(h)-probeHistorySize:]
+	}
+	m.history[server.ID] = h
+	m.mu.Unlock()
+
+	// 进程没在运行不算故障，Server 视为可用
+	status := res.Status
+	if status == "idle" {
+		status = ServerStatusActive
+	}
+	if status != server.Status {
+		log.Printf("[MCP Health] %s: %s -> %s %s", server.Name, server.Status, status, res.Error)
+	}
+	m.Store.UpdateMCPServerHealth(server.ID, status, res.LatencyMs, res.Error, res.At)
+	return res
+}
+
+// History 某个 Server 最近的探测记录（新的在前）
+func (m *HealthMonitor) History(serverID string) []ProbeResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.history[serverID]
+	res := make([]ProbeResult, len(h))
+	for i, r := range h {
+		res[len(h)-1-i] = r
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package mcp
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+func TestHealthMonitorStatusTransitions(t *testing.T) {
+	f := newFakeServer(t, textTool("echo"))
+	s := store.NewMemoryStore()
+	pool := NewClientPool()
+	t.Cleanup(pool.CloseAll)
+	m := NewHealthMonitor(s, pool)
+
+	agent := s.CreateAgent(&store.Agent{Name: "coder", Status: "active"})
+	server := s.CreateMCPServer(&store.MCPServer{AgentID: agent.ID, Name: "remote", TransportType: TransportHTTP, ConnectionConfig: map[string]interface{}{"url": f.url(TransportHTTP)}, Status: ServerStatusActive})
+	s.UpsertMCPTool(&store.MCPTool{ServerID: server.ID, Name: "echo", InputSchema: map[string]interface{}{"type": "object"}})
+
+	steps := []struct {
+		failing bool
+		status  string // 写回 MCPServer.Status 的状态
+		offered bool   // 工具是否还提供给 LLM
+	}{
+		{false, ServerStatusActive, true},
+		// 刚开始失败记为 degraded，工具仍然可用
+		{true, ServerStatusDegraded, true},
+		{true, ServerStatusDegraded, true},
+		// 连续失败 3 次记为 disconnected，工具不再提供
+		{true, ServerStatusDisconnected, false},
+		{true, ServerStatusDisconnected, false},
+		// 恢复后一次成功即回到 active，失败计数清零
+		{false, ServerStatusActive, true},
+		{true, ServerStatusDegraded, true},
+	}
+	var want []string
+	for i, step := range steps {
+		f.setFailing(step.failing)
+		m.ProbeAll(testCtx(t))
+
+		got := s.GetMCPServer(server.ID)
+		if got.Status != step.status || (got.LastError != "") != step.failing || got.LastCheckedAt == nil {
+			t.Fatalf("probe %d: status = %s, last error = %q", i, got.Status, got.LastError)
+		}
+		if offered := len(LoadToolSet(s, agent.ID).Tools()) == 1; offered != step.offered {
+			t.Fatalf("probe %d: tools offered = %v", i, offered)
+		}
+		want = append([]string{step.status}, want...)
+	}
+
+	// 探测历史新的在前
+	var history []string
+	for _, r := range m.History(server.ID) {
+		history = append(history, r.Status)
+	}
+	if !reflect.DeepEqual(history, want) {
+		t.Fatalf("history = %v, want %v", history, want)
+	}
+}
+
+func TestHealthMonitorIdleAndBuiltin(t *testing.T) {
+	s := store.NewMemoryStore()
+	pool := NewClientPool()
+	t.Cleanup(pool.CloseAll)
+	m := NewHealthMonitor(s, pool)
+	// 没有运行中进程的 stdio Server 不为探测拉起（命令不存在也不会发现），视为可用
+	stdio := s.CreateMCPServer(&store.MCPServer{Name: "local", TransportType: TransportStdio, ConnectionConfig: map[string]interface{}{"command": "/nonexistent/mcp-server"}})
+	builtin := s.CreateMCPServer(&store.MCPServer{Name: "fs", TransportType: TransportStdio, ConnectionConfig: map[string]interface{}{"builtin": BuiltinFilesystem}})
+
+	cases := []struct {
+		server *store.MCPServer
+		result string
+	}{
+		{stdio, "idle"},
+		{builtin, ServerStatusActive},
+	}
+	for _, c := range cases {
+		res := m.Probe(testCtx(t), c.server)
+		if res.Status != c.result || res.Error != "" {
+			t.Fatalf("%s probe = %+v", c.server.Name, res)
+		}
+		if got := s.GetMCPServer(c.server.ID); got.Status != ServerStatusActive || got.LastError != "" {
+			t.Fatalf("%s status = %s %q", c.server.Name, got.Status, got.LastError)
+		}
+	}
+}
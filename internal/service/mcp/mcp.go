@@ -2,58 +2,153 @@ package mcp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"time"
+	"log"
+	"reflect"
 
 	"example.com/agent-server/internal/store"
-	"github.com/google/uuid"
 )
 
 type MCPService struct {
 	Store    store.Store
 	Executor *Executor
+	Health   *HealthMonitor // 与 Executor 共享连接池，需调用 Health.Start() 启动
 }
 
 func NewMCPService(s store.Store) *MCPService {
-	return &MCPService{Store: s, Executor: NewExecutor(s)}
+	svc := &MCPService{Store: s, Executor: NewExecutor(s)}
+	svc.Health = NewHealthMonitor(s, svc.Executor.Clients)
+	// Server 主动告知工具 / 资源 / 提示模板列表变化时，后台自动重新同步
+	svc.Executor.Clients.OnNotification = func(serverID, method string, params json.RawMessage) {
+		var sync func(context.Context, string) (*SyncResult, error)
+		switch method {
+		case "notifications/tools/list_changed":
+			sync = svc.SyncTools
+		case "notifications/resources/list_changed":
+			sync = svc.SyncResources
+		case "notifications/prompts/list_changed":
+			sync = svc.SyncPrompts
+		default:
+			return
+		}
+		go func() {
+			if _, err := sync(context.Background(), serverID); err != nil {
+				log.Printf("[MCP Service] auto sync (%s) %s failed: %v", method, serverID, err)
+			}
+		}()
+	}
+	return svc
+}
+
+// SyncResult 一次同步的差异
+type SyncResult struct {
+	Total     int      `json:"total"`   // Server 当前声明的工具数
+	Added     []string `json:"added"`   // 新增的工具名
+	Updated   []string `json:"updated"` // 描述或 Schema 变化的工具名
+	Removed   []string `json:"removed"` // Server 不再提供、已从库中删除的工具名
+	Unchanged int      `json:"unchanged"`
 }
 
 // SyncTools 负责同步一个 Server 下的所有工具
-func (s *MCPService) SyncTools(ctx context.Context, serverID string) (int, error) {
+// 以 (server_id, name) 为键做 upsert，并删除 Server 不再声明的工具
+func (s *MCPService) SyncTools(ctx context.Context, serverID string) (*SyncResult, error) {
 	// 1. 查库
 	server := s.Store.GetMCPServer(serverID)
 	if server == nil {
-		return 0, fmt.Errorf("server not found: %s", serverID)
+		return nil, fmt.Errorf("server not found: %s", serverID)
 	}
 
 	// 2. 获取工具列表 (Discovery)
-	// 在 MVP 阶段，我们在这里调用 Mock 逻辑
-	// 在真实阶段，这里会调用 JSON-RPC Client 去连远程
 	tools, err := s.fetchToolsFromSource(ctx, server)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	// 3. 更新数据库 (全量覆盖或增量更新)
-	// 简单起见，我们直接 Create 新的 (注意：store层最好加个 Upsert 或去重)
-	count := 0
+	// 3. 与库中已有工具做 diff
+	existing := make(map[string]*store.MCPTool)
+	for _, t := range s.Store.ListMCPToolsByServer(serverID) {
+		existing[t.Name] = t
+	}
+
+	res := &SyncResult{Total: len(tools), Added: []string{}, Updated: []string{}, Removed: []string{}}
+	seen := make(map[string]bool, len(tools))
 	for _, t := range tools {
-		// 补全 ID 和 时间
-		t.ID = uuid.New().String()
-		t.CreatedAt = time.Now()
-		t.UpdatedAt = time.Now()
+		if seen[t.Name] {
+			// Server 返回了重名工具，只认第一个
+			continue
+		}
+		seen[t.Name] = true
 
-		// 存库
-		s.Store.CreateMCPTool(t)
-		count++
+		old, ok := existing[t.Name]
+		switch {
+		case !ok:
+			res.Added = append(res.Added, t.Name)
+		case old.Description != t.Description || old.SideEffects != t.SideEffects || !sameSchema(old.InputSchema, t.InputSchema):
+			res.Updated = append(res.Updated, t.Name)
+		default:
+			res.Unchanged++
+			continue
+		}
+		s.Store.UpsertMCPTool(t)
 	}
 
-	return count, nil
+	// 4. 删除 Server 已不再提供的工具
+	for name, t := range existing {
+		if !seen[name] {
+			s.Store.DeleteMCPTool(t.ID)
+			res.Removed = append(res.Removed, name)
+		}
+	}
+
+	log.Printf("[MCP Service] synced %s: +%d ~%d -%d", server.Name, len(res.Added), len(res.Updated), len(res.Removed))
+	return res, nil
 }
 
-// fetchToolsFromSource 抽象了工具来源（Mock vs Real）
+// fetchToolsFromSource 抽象了工具来源（真正的 MCP Server vs 内置实现）
 func (s *MCPService) fetchToolsFromSource(ctx context.Context, server *store.MCPServer) ([]*store.MCPTool, error) {
-	// 如果是 demo 阶段，走 Mock
-	// 实际代码中可以用一个 flag 或者 server.TransportType 来判断
-	return MockToolsForServer(server.ID, server.Name), nil
+	// 内置 Server 由进程内 Go 代码实现，工具列表也是静态的
+	if kind := BuiltinKind(server); kind != "" {
+		return MockToolsForServer(server.ID, kind), nil
+	}
+
+	client, err := s.Executor.Clients.Get(ctx, server)
+	if err != nil {
+		return nil, fmt.Errorf("connect mcp server %s: %w", server.Name, err)
+	}
+	remote, err := client.ListTools(ctx)
+	if err != nil {
+		s.Executor.Clients.Invalidate(server.ID)
+		return nil, fmt.Errorf("mcp tools/list: %w", err)
+	}
+	tools := make([]*store.MCPTool, 0, len(remote))
+	for _, t := range remote {
+		tools = append(tools, &store.MCPTool{
+			ServerID:    server.ID,
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.InputSchema,
+			SideEffects: t.SideEffects(),
+		})
+	}
+	return tools, nil
+}
+
+// sameSchema 比较两份 JSON Schema 是否一致
+// 统一过一遍 JSON 再比较，消除 map[string]string / map[string]interface{} 之类的类型差异
+func sameSchema(a, b map[string]interface{}) bool {
+	return reflect.DeepEqual(normalizeJSON(a), normalizeJSON(b))
+}
+
+func normalizeJSON(v map[string]interface{}) interface{} {
+	if len(v) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	_ = json.Unmarshal(b, &out)
+	return out
 }
@@ -0,0 +1,81 @@
+package mcp
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+func TestSyncToolsDiff(t *testing.T) {
+	f := newFakeServer(t, textTool("a"), textTool("b"), textTool("c"))
+	s := store.NewMemoryStore()
+	svc := NewMCPService(s)
+	t.Cleanup(svc.Executor.Clients.CloseAll)
+	server := s.CreateMCPServer(&store.MCPServer{
+		Name:             "fake",
+		TransportType:    TransportHTTP,
+		ConnectionConfig: map[string]interface{}{"url": f.url(TransportHTTP)},
+	})
+
+	res, err := svc.SyncTools(testCtx(t), server.ID)
+	if err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if res.Total != 3 || !reflect.DeepEqual(res.Added, []string{"a", "b", "c"}) || len(res.Updated) != 0 || len(res.Removed) != 0 {
+		t.Fatalf("first sync = %+v", res)
+	}
+	ids := map[string]string{}
+	for _, tool := range s.ListMCPToolsByServer(server.ID) {
+		ids[tool.Name] = tool.ID
+	}
+
+	// a 不变，b 描述变化，c 不再提供，新增 d 和只读的 e；重复声明的 d 只认第一个
+	b := textTool("b")
+	b.Description = "b tool, v2"
+	readOnly := textTool("e")
+	readOnly.Annotations = &ToolAnnotations{ReadOnlyHint: true}
+	f.setTools(textTool("a"), b, textTool("d"), readOnly, textTool("d"))
+
+	res, err = svc.SyncTools(testCtx(t), server.ID)
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if res.Total != 5 || res.Unchanged != 1 ||
+		!reflect.DeepEqual(res.Added, []string{"d", "e"}) ||
+		!reflect.DeepEqual(res.Updated, []string{"b"}) ||
+		!reflect.DeepEqual(res.Removed, []string{"c"}) {
+		t.Fatalf("second sync = %+v", res)
+	}
+
+	got := map[string]*store.MCPTool{}
+	var names []string
+	for _, tool := range s.ListMCPToolsByServer(server.ID) {
+		got[tool.Name] = tool
+		names = append(names, tool.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"a", "b", "d", "e"}) {
+		t.Fatalf("stored tools = %v", names)
+	}
+	// upsert 保留原来的 ID，绑定关系不受影响
+	if got["a"].ID != ids["a"] || got["b"].ID != ids["b"] {
+		t.Fatal("existing tools were recreated instead of updated")
+	}
+	if got["b"].Description != "b tool, v2" {
+		t.Fatalf("description not updated: %q", got["b"].Description)
+	}
+	if !got["d"].SideEffects || got["e"].SideEffects {
+		t.Fatal("side effects should follow readOnlyHint")
+	}
+
+	// 没有变化时不写库
+	res, err = svc.SyncTools(testCtx(t), server.ID)
+	if err != nil {
+		t.Fatalf("third sync: %v", err)
+	}
+	if res.Unchanged != 4 || len(res.Added)+len(res.Updated)+len(res.Removed) != 0 {
+		t.Fatalf("third sync = %+v", res)
+	}
+}
@@ -8,41 +8,72 @@ import (
 
 // MockToolsForServer 生成模拟数据
 // 这是一个纯函数，不依赖外部状态
+// InputSchema 与 Executor 中内置实现接受的参数保持一致，调用前按它校验
 func MockToolsForServer(serverID, serverName string) []*store.MCPTool {
 	tools := []*store.MCPTool{}
 	name := strings.ToLower(serverName)
+	// 会改动工作区的工具，同一轮里不与其它调用并行
+	sideEffects := map[string]bool{"git_commit": true, "write_file": true}
+	add := func(tool, desc string, schema map[string]interface{}) {
+		tools = append(tools, &store.MCPTool{ServerID: serverID, Name: tool, Description: desc, InputSchema: schema, SideEffects: sideEffects[tool]})
+	}
 
-	// 1. 模拟 Git Server
+	// 1. 模拟 Git Server（在会话工作区内执行）
 	if strings.Contains(name, "git") {
-		tools = append(tools, &store.MCPTool{
-			ServerID:    serverID,
-			Name:        "git_status",
-			Description: "显示工作目录状态。",
-			InputSchema: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"repo_path": map[string]string{"type": "string", "description": "仓库路径,默认当前目录"},
-				},
+		add("git_status", "显示工作区的 git 状态。", map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		})
+		add("git_diff", "显示工作区相对某个提交的差异。", map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"target": map[string]interface{}{"type": "string", "description": "比较的目标提交 / 分支", "default": "HEAD"},
+			},
+		})
+		add("git_commit", "提交工作区的改动。", map[string]interface{}{
+			"type":     "object",
+			"required": []string{"message"},
+			"properties": map[string]interface{}{
+				"message": map[string]interface{}{"type": "string", "description": "提交信息", "minLength": 1},
+				"add_all": map[string]interface{}{"type": "boolean", "description": "提交前是否 git add .", "default": false},
 			},
 		})
-		// ... 其他 git 工具 ...
+		add("git_log", "显示最近 10 条提交记录。", map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		})
 	}
 
-	// 2. 模拟 Filesystem Server
+	// 2. 模拟 Filesystem Server（路径相对会话工作区）
 	if strings.Contains(name, "filesystem") || strings.Contains(name, "fs") {
-		tools = append(tools, &store.MCPTool{
-			ServerID:    serverID,
-			Name:        "read_file",
-			Description: "读取文件内容。",
-			InputSchema: map[string]interface{}{
-				"type":     "object",
-				"required": []string{"path"},
-				"properties": map[string]interface{}{
-					"path": map[string]string{"type": "string", "description": "文件路径"},
-				},
+		add("list_directory", "列出目录内容。", map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path": map[string]interface{}{"type": "string", "description": "目录路径", "default": "."},
+			},
+		})
+		add("read_file", "读取文件内容。", map[string]interface{}{
+			"type":     "object",
+			"required": []string{"path"},
+			"properties": map[string]interface{}{
+				"path": map[string]string{"type": "string", "description": "文件路径"},
+			},
+		})
+		add("write_file", "写入文件（覆盖）。", map[string]interface{}{
+			"type":     "object",
+			"required": []string{"path", "content"},
+			"properties": map[string]interface{}{
+				"path":    map[string]string{"type": "string", "description": "文件路径"},
+				"content": map[string]string{"type": "string", "description": "文件内容"},
+			},
+		})
+		add("search_files", "在工作区内递归搜索文本。", map[string]interface{}{
+			"type":     "object",
+			"required": []string{"pattern"},
+			"properties": map[string]interface{}{
+				"pattern": map[string]string{"type": "string", "description": "grep 正则"},
 			},
 		})
-		// ... 其他 fs 工具 ...
 	}
 
 	return tools
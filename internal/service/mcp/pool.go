@@ -0,0 +1,390 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/agent-server/internal/store"
+)
+
+// 连接池的默认策略
+const (
+	defaultIdleTimeout = 5 * time.Minute  // 超过这个时间没人用的连接会被关闭（stdio 即结束子进程）
+	reapInterval       = 30 * time.Second // 空闲巡检周期
+	connectTimeout     = 30 * time.Second // 后台重启时的握手超时
+	maxRestarts        = 5                // stdio 进程连续崩溃的最大自动重启次数
+	stableAfter        = time.Minute      // 存活超过这个时间才算“稳定”，崩溃计数清零
+)
+
+// ErrNotRunning stdio Server 当前没有在运行的进程（尚未使用或已空闲回收），不代表故障
+var ErrNotRunning = errors.New("mcp server process not running")
+
+// ClientPool 按 Server 复用 MCP 连接
+// 第一次使用时建连 + 握手，连接断开或 Server 配置变更后自动重建；
+// stdio 子进程崩溃后按指数退避自动重启，长时间空闲则关闭
+// 引用了集成平台的 Server 按 (Server, 用户) 分别建连，各自带上该用户的凭证
+type ClientPool struct {
+	mu      sync.Mutex
+	entries map[string]*poolEntry
+
+	IdleTimeout time.Duration
+	reapOnce    sync.Once
+	stop        chan struct{}
+
+	// OnNotification 透传所有 Server 的通知 (serverID, method, params)
+	OnNotification func(serverID, method string, params json.RawMessage)
+
+	// Credentials 解密用户凭证；为 nil 时引用了集成平台的 Server 无法连接
+	Credentials CredentialSource
+}
+
+type poolEntry struct {
+	mu         sync.Mutex
+	client     *Client
+	server     *store.MCPServer // 最近一次使用的配置（已注入凭证），崩溃重启时复用
+	secrets    []string         // 注入的凭证值
+	version    string           // 传输方式 + 连接配置的指纹，配置变了就重连
+	startedAt  time.Time
+	lastUsed   time.Time
+	crashes    int   // 连续崩溃次数
+	lastErr    error // 最近一次意外断开 / 连接失败的原因，连上后清空
+	restarting bool  // 已安排了自动重启
+}
+
+// configVersion 连接相关配置的指纹
+// 不用 UpdatedAt：健康检查等只改状态字段的更新不应触发重连
+func configVersion(server *store.MCPServer) string {
+	b, _ := json.Marshal(struct {
+		Transport string                 `json:"t"`
+		Config    map[string]interface{} `json:"c"`
+	}{server.TransportType, server.ConnectionConfig})
+	return string(b)
+}
+
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		entries:     make(map[string]*poolEntry),
+		IdleTimeout: defaultIdleTimeout,
+		stop:        make(chan struct{}),
+	}
+}
+
+// resolve 确定连接池的键，并在需要时注入调用用户（见 WithUser）的凭证
+func (p *ClientPool) resolve(ctx context.Context, server *store.MCPServer) (string, *store.MCPServer, []string, error) {
+	ref := integrationOf(server)
+	if ref == nil {
+		return server.ID, server, nil, nil
+	}
+	userID := UserFromContext(ctx)
+	if userID == "" {
+		return "", nil, nil, fmt.Errorf("server %s uses %s credentials but no user is bound to this call", server.Name, ref.Provider)
+	}
+	if p.Credentials == nil {
+		return "", nil, nil, fmt.Errorf("server %s uses %s credentials but integrations are not configured", server.Name, ref.Provider)
+	}
+	creds, err := p.Credentials.Credentials(ctx, userID, ref.Provider)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	resolved, err := withCredentials(server, ref, creds)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return server.ID + "@" + userID, resolved, secretValues(creds), nil
+}
+
+// Get 获取（必要时建立）到 server 的连接
+func (p *ClientPool) Get(ctx context.Context, server *store.MCPServer) (*Client, error) {
+	p.reapOnce.Do(func() { go p.reapLoop() })
+
+	key, server, secrets, err := p.resolve(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	entry, ok := p.entries[key]
+	if !ok {
+		entry = &poolEntry{}
+		p.entries[key] = entry
+	}
+	p.mu.Unlock()
+
+	// 每个 Server 单独加锁，慢 Server 建连不会卡住其它 Server
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	entry.lastUsed = time.Now()
+	entry.server = server
+	entry.secrets = secrets
+	if entry.client != nil && entry.client.Alive() && entry.version == configVersion(server) {
+		return entry.client, nil
+	}
+	if entry.client != nil {
+		old := entry.client
+		entry.client = nil // 先摘掉，watch 看到后不会当成崩溃
+		old.Close()
+	}
+	return p.connectLocked(ctx, entry)
+}
+
+// connectLocked 建立连接并挂上崩溃监听，调用方需持有 entry.mu
+func (p *ClientPool) connectLocked(ctx context.Context, entry *poolEntry) (*Client, error) {
+	server := entry.server
+	transport, err := NewTransport(server)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(transport)
+	client.secrets = entry.secrets
+	serverID := server.ID
+	client.OnNotification = func(method string, params json.RawMessage) {
+		if p.OnNotification != nil {
+			p.OnNotification(serverID, method, params)
+		}
+	}
+	info, err := client.Connect(ctx)
+	if err != nil {
+		entry.lastErr = err
+		return nil, err
+	}
+	log.Printf("[MCP Pool] connected to %s (%s %s, protocol %s)",
+		server.Name, info.ServerInfo.Name, info.ServerInfo.Version, info.ProtocolVersion)
+
+	entry.client = client
+	entry.version = configVersion(server)
+	entry.startedAt = time.Now()
+	entry.lastErr = nil
+	go p.watch(entry, client)
+	return client, nil
+}
+
+// watch 连接意外断开时的处理
+// stdio 子进程崩溃：按指数退避在后台重启；远程连接：留到下次 Get 时再重连
+func (p *ClientPool) watch(entry *poolEntry, client *Client) {
+	select {
+	case <-client.Done():
+	case <-p.stop:
+		return
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.client != client {
+		// 主动关闭（Invalidate / 空闲回收 / 配置变更），不是崩溃
+		return
+	}
+	entry.client = nil
+	client.Close()
+	entry.lastErr = ErrClientClosed
+
+	if entry.server.TransportType != TransportStdio {
+		return
+	}
+	if time.Since(entry.startedAt) > stableAfter {
+		entry.crashes = 0
+	}
+	if time.Since(entry.lastUsed) > p.IdleTimeout {
+		// 本来就快被回收了，没必要重启
+		entry.lastErr = nil
+		return
+	}
+	p.scheduleRestartLocked(entry)
+}
+
+func (p *ClientPool) scheduleRestartLocked(entry *poolEntry) {
+	entry.crashes++
+	if entry.crashes > maxRestarts {
+		log.Printf("[MCP Pool] %s crashed %d times in a row, giving up until next use", entry.server.Name, entry.crashes-1)
+		entry.crashes = 0
+		return
+	}
+	delay := time.Second << uint(entry.crashes-1) // 1s, 2s, 4s ...
+	log.Printf("[MCP Pool] %s exited unexpectedly, restarting in %s (attempt %d/%d)", entry.server.Name, delay, entry.crashes, maxRestarts)
+	entry.restarting = true
+	time.AfterFunc(delay, func() { p.restart(entry) })
+}
+
+func (p *ClientPool) restart(entry *poolEntry) {
+	select {
+	case <-p.stop:
+		return
+	default:
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.restarting = false
+	if entry.client != nil {
+		// 期间已经有人 Get 过，连上了
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if _, err := p.connectLocked(ctx, entry); err != nil {
+		log.Printf("[MCP Pool] restart %s failed: %v", entry.server.Name, err)
+		p.scheduleRestartLocked(entry)
+	}
+}
+
+// Probe 探测 Server 是否可用，返回 ping 的往返延迟
+// 已有连接直接 ping；远程 Server 没有连接时建连后再 ping；
+// stdio 不为探测拉起空闲的进程：没在运行返回 ErrNotRunning，等待自动重启时返回崩溃原因；
+// 上次连接失败（或崩溃次数超限已放弃重启）的才尝试重新拉起，恢复后状态随之恢复
+// 探测不刷新 lastUsed，不影响空闲回收
+// 引用了集成平台的 Server 没有“系统身份”可用，只 ping 已有的用户连接，没有时返回 ErrNotRunning
+func (p *ClientPool) Probe(ctx context.Context, server *store.MCPServer) (time.Duration, error) {
+	if integrationOf(server) != nil {
+		return p.probeUserConnections(ctx, server)
+	}
+
+	p.mu.Lock()
+	entry, ok := p.entries[server.ID]
+	if !ok {
+		entry = &poolEntry{}
+		p.entries[server.ID] = entry
+	}
+	p.mu.Unlock()
+
+	entry.mu.Lock()
+	client := entry.client
+	if client == nil || !client.Alive() || entry.version != configVersion(server) {
+		if server.TransportType == TransportStdio && (entry.lastErr == nil || entry.restarting) {
+			err := entry.lastErr
+			entry.mu.Unlock()
+			if err == nil {
+				return 0, ErrNotRunning
+			}
+			return 0, err
+		}
+		if client != nil {
+			entry.client = nil
+			client.Close()
+		}
+		entry.server = server
+		var err error
+		client, err = p.connectLocked(ctx, entry)
+		if err != nil {
+			entry.mu.Unlock()
+			return 0, err
+		}
+	}
+	entry.mu.Unlock()
+
+	start := time.Now()
+	err := client.Ping(ctx)
+	return time.Since(start), err
+}
+
+func (p *ClientPool) probeUserConnections(ctx context.Context, server *store.MCPServer) (time.Duration, error) {
+	var client *Client
+	for _, entry := range p.serverEntries(server.ID) {
+		entry.mu.Lock()
+		if entry.client != nil && entry.client.Alive() {
+			client = entry.client
+		}
+		entry.mu.Unlock()
+		if client != nil {
+			break
+		}
+	}
+	if client == nil {
+		return 0, ErrNotRunning
+	}
+	start := time.Now()
+	err := client.Ping(ctx)
+	return time.Since(start), err
+}
+
+// serverEntries 某个 Server 的全部连接（含各用户的）
+func (p *ClientPool) serverEntries(serverID string) []*poolEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var res []*poolEntry
+	for key, entry := range p.entries {
+		if key == serverID || strings.HasPrefix(key, serverID+"@") {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// reapLoop 定期关闭空闲连接
+func (p *ClientPool) reapLoop() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.reapIdle()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *ClientPool) reapIdle() {
+	p.mu.Lock()
+	entries := make([]*poolEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	p.mu.Unlock()
+
+	for _, entry := range entries {
+		entry.mu.Lock()
+		if entry.client != nil && time.Since(entry.lastUsed) > p.IdleTimeout {
+			log.Printf("[MCP Pool] closing idle connection to %s", entry.server.Name)
+			client := entry.client
+			entry.client = nil
+			client.Close()
+		}
+		entry.mu.Unlock()
+	}
+}
+
+// Invalidate 丢弃某个 Server 的连接（含各用户的），下次 Get 时重连
+func (p *ClientPool) Invalidate(serverID string) {
+	p.mu.Lock()
+	var entries []*poolEntry
+	for key, entry := range p.entries {
+		if key == serverID || strings.HasPrefix(key, serverID+"@") {
+			entries = append(entries, entry)
+			delete(p.entries, key)
+		}
+	}
+	p.mu.Unlock()
+	for _, entry := range entries {
+		entry.mu.Lock()
+		if entry.client != nil {
+			client := entry.client
+			entry.client = nil
+			client.Close()
+		}
+		entry.mu.Unlock()
+	}
+}
+
+// CloseAll 关闭全部连接并停止后台任务（进程退出时调用）
+func (p *ClientPool) CloseAll() {
+	p.mu.Lock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	ids := make([]string, 0, len(p.entries))
+	for key := range p.entries {
+		ids = append(ids, strings.SplitN(key, "@", 2)[0])
+	}
+	p.mu.Unlock()
+	for _, id := range ids {
+		p.Invalidate(id)
+	}
+}
@@ -0,0 +1,120 @@
+//go:build unix
+
+package mcp
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/store"
+)
+
+func newStdioPool(t *testing.T) (*ClientPool, *store.MCPServer) {
+	t.Helper()
+	pool := NewClientPool()
+	t.Cleanup(pool.CloseAll)
+	return pool, &store.MCPServer{ID: "stdio-1", Name: "fake-stdio", TransportType: TransportStdio, ConnectionConfig: stdioConfig()}
+}
+
+// serverPid 当前 stdio 进程的进程号
+func serverPid(t *testing.T, c *Client) int {
+	t.Helper()
+	res, err := c.CallTool(testCtx(t), "pid", nil)
+	if err != nil {
+		t.Fatalf("call pid: %v", err)
+	}
+	pid, err := strconv.Atoi(res.Text())
+	if err != nil {
+		t.Fatalf("pid = %q", res.Text())
+	}
+	return pid
+}
+
+func processGone(pid int) bool {
+	return errors.Is(syscall.Kill(pid, 0), syscall.ESRCH)
+}
+
+// waitFor 每 50ms 检查一次，超时返回 false
+func waitFor(timeout time.Duration, ok func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if ok() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return ok()
+}
+
+func TestPoolRestartsCrashedStdioServer(t *testing.T) {
+	pool, server := newStdioPool(t)
+	client, err := pool.Get(testCtx(t), server)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	pid := serverPid(t, client)
+	if again, _ := pool.Get(testCtx(t), server); again != client {
+		t.Fatal("second Get should reuse the running process")
+	}
+
+	if _, err := client.CallTool(testCtx(t), "crash", nil); err == nil {
+		t.Fatal("call to a crashing server should fail")
+	}
+
+	// 等待重启期间探测报告崩溃原因，不为探测拉起进程
+	var probeErr error
+	if !waitFor(time.Second, func() bool { _, probeErr = pool.Probe(testCtx(t), server); return errors.Is(probeErr, ErrClientClosed) }) {
+		t.Fatalf("probe while restarting = %v", probeErr)
+	}
+	// 1 秒退避后在后台重启，不需要有人调用 Get
+	if !waitFor(5*time.Second, func() bool { _, err := pool.Probe(testCtx(t), server); return err == nil }) {
+		t.Fatal("crashed server was not restarted")
+	}
+
+	restarted, err := pool.Get(testCtx(t), server)
+	if err != nil || restarted == client || !restarted.Alive() {
+		t.Fatalf("get after restart = %v, %v", restarted, err)
+	}
+	if newPid := serverPid(t, restarted); newPid == pid || !processGone(pid) {
+		t.Fatalf("pid %d -> %d", pid, newPid)
+	}
+}
+
+func TestPoolReapsIdleStdioServer(t *testing.T) {
+	pool, server := newStdioPool(t)
+	pool.IdleTimeout = 50 * time.Millisecond
+	client, err := pool.Get(testCtx(t), server)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	pid := serverPid(t, client)
+
+	// 还没到空闲时间的不回收
+	pool.reapIdle()
+	if !client.Alive() {
+		t.Fatal("connection reaped before it was idle")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	pool.reapIdle()
+	if client.Alive() || !processGone(pid) {
+		t.Fatal("idle server process was not shut down")
+	}
+	// 回收不是崩溃：不会自动重启，探测也不拉起进程
+	time.Sleep(1200 * time.Millisecond)
+	if _, err := pool.Probe(testCtx(t), server); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("probe after reaping = %v", err)
+	}
+
+	// 下次使用时重新拉起
+	again, err := pool.Get(testCtx(t), server)
+	if err != nil || again == client {
+		t.Fatalf("get after reaping = %v, %v", again, err)
+	}
+	if newPid := serverPid(t, again); newPid == pid {
+		t.Fatal("expected a new process")
+	}
+}
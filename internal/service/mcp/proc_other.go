@@ -0,0 +1,8 @@
+//go:build !unix
+
+package mcp
+
+import "os/exec"
+
+// setProcessGroup 非 Unix 平台没有进程组，取消时只杀子进程本身（exec.CommandContext 的默认行为）
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,220 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MCP 协议版本 & 客户端标识
+const (
+	ProtocolVersion = "2025-03-26"
+	jsonrpcVersion  = "2.0"
+
+	clientName    = "nexus-agent"
+	clientVersion = "0.1.0"
+)
+
+// 传输类型，与 mcp_servers.transport_type 保持一致
+const (
+	TransportStdio = "stdio"
+	TransportSSE   = "sse"  // 旧版 HTTP+SSE (2024-11-05)
+	TransportHTTP  = "http" // Streamable HTTP (2025-03-26)
+)
+
+// JSON-RPC 标准错误码
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Message JSON-RPC 2.0 消息
+// 请求 / 响应 / 通知共用一个结构，通过字段是否存在来区分
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// IsRequest 服务端发起的请求 (有 method 有 id)
+func (m *Message) IsRequest() bool { return m.Method != "" && len(m.ID) > 0 }
+
+// IsNotification 通知 (有 method 无 id)
+func (m *Message) IsNotification() bool { return m.Method != "" && len(m.ID) == 0 }
+
+// IsResponse 对我们请求的响应 (无 method 有 id)
+func (m *Message) IsResponse() bool { return m.Method == "" && len(m.ID) > 0 }
+
+// RPCError JSON-RPC 错误对象
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("mcp rpc error %d: %s", e.Code, e.Message)
+}
+
+// Implementation 客户端/服务端身份信息
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// InitializeResult initialize 握手的返回
+type InitializeResult struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ServerInfo      Implementation         `json:"serverInfo"`
+	Instructions    string                 `json:"instructions,omitempty"`
+}
+
+// Tool tools/list 返回的工具定义
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+	Annotations *ToolAnnotations       `json:"annotations,omitempty"`
+}
+
+// ToolAnnotations 工具行为提示（MCP 2025-03-26），只作参考，不保证准确
+type ToolAnnotations struct {
+	Title           string `json:"title,omitempty"`
+	ReadOnlyHint    bool   `json:"readOnlyHint,omitempty"`
+	DestructiveHint *bool  `json:"destructiveHint,omitempty"`
+	IdempotentHint  bool   `json:"idempotentHint,omitempty"`
+	OpenWorldHint   *bool  `json:"openWorldHint,omitempty"`
+}
+
+// SideEffects 没有声明 readOnlyHint 的工具按规范默认可能修改环境
+func (t Tool) SideEffects() bool {
+	return t.Annotations == nil || !t.Annotations.ReadOnlyHint
+}
+
+type listToolsResult struct {
+	Tools      []Tool `json:"tools"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Resource resources/list 返回的资源
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type listResourcesResult struct {
+	Resources  []Resource `json:"resources"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// ResourceContents resources/read 返回的一段内容，text 与 blob (base64) 二选一
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// ReadResourceResult resources/read 的返回
+type ReadResourceResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+// Text 拼接文本内容，二进制内容只保留占位描述
+func (r *ReadResourceResult) Text() string {
+	parts := make([]string, 0, len(r.Contents))
+	for _, c := range r.Contents {
+		if c.Blob != "" && c.Text == "" {
+			parts = append(parts, fmt.Sprintf("[binary resource %s, mime=%s]", c.URI, c.MimeType))
+			continue
+		}
+		parts = append(parts, c.Text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// PromptArgument 提示模板的参数
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// Prompt prompts/list 返回的提示模板
+type Prompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+type listPromptsResult struct {
+	Prompts    []Prompt `json:"prompts"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+// PromptMessage prompts/get 渲染出的一条消息
+type PromptMessage struct {
+	Role    string  `json:"role"` // user / assistant
+	Content Content `json:"content"`
+}
+
+// GetPromptResult prompts/get 的返回
+type GetPromptResult struct {
+	Description string          `json:"description,omitempty"`
+	Messages    []PromptMessage `json:"messages"`
+}
+
+// Text 把渲染结果拼成一段文本（用作系统提示片段）
+func (r *GetPromptResult) Text() string {
+	parts := make([]string, 0, len(r.Messages))
+	for _, m := range r.Messages {
+		res := CallToolResult{Content: []Content{m.Content}}
+		parts = append(parts, res.Text())
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Content 工具返回的内容块 (text / image / resource ...)
+type Content struct {
+	Type     string                 `json:"type"`
+	Text     string                 `json:"text,omitempty"`
+	MimeType string                 `json:"mimeType,omitempty"`
+	Data     string                 `json:"data,omitempty"`
+	Resource map[string]interface{} `json:"resource,omitempty"`
+}
+
+// CallToolResult tools/call 的返回
+type CallToolResult struct {
+	Content []Content              `json:"content"`
+	IsError bool                   `json:"isError,omitempty"`
+	Meta    map[string]interface{} `json:"_meta,omitempty"`
+}
+
+// Text 把返回内容拼成一段给 LLM 看的文本
+// 非文本内容只保留一个占位描述，避免把 base64 塞进上下文
+func (r *CallToolResult) Text() string {
+	parts := make([]string, 0, len(r.Content))
+	for _, c := range r.Content {
+		switch c.Type {
+		case "text":
+			parts = append(parts, c.Text)
+		case "resource":
+			if text, ok := c.Resource["text"].(string); ok {
+				parts = append(parts, text)
+			} else {
+				parts = append(parts, fmt.Sprintf("[resource %v]", c.Resource["uri"]))
+			}
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content, mime=%s]", c.Type, c.MimeType))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
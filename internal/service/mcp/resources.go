@@ -0,0 +1,197 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"example.com/agent-server/internal/store"
+)
+
+// errNoCapability Server 握手时没有声明 resources / prompts 能力
+var errNoCapability = errors.New("capability not supported by server")
+
+// SyncResources 同步 Server 声明的资源，以 (server_id, uri) 为键 upsert，并删除已不再声明的
+// 内置 Server 和未声明 resources 能力的 Server 视为没有资源
+func (s *MCPService) SyncResources(ctx context.Context, serverID string) (*SyncResult, error) {
+	server := s.Store.GetMCPServer(serverID)
+	if server == nil {
+		return nil, fmt.Errorf("server not found: %s", serverID)
+	}
+
+	var remote []Resource
+	if BuiltinKind(server) == "" {
+		client, err := s.capableClient(ctx, server, "resources")
+		if err != nil && !errors.Is(err, errNoCapability) {
+			return nil, err
+		}
+		if client != nil {
+			if remote, err = client.ListResources(ctx); err != nil {
+				return nil, fmt.Errorf("mcp resources/list: %w", err)
+			}
+		}
+	}
+
+	existing := make(map[string]*store.MCPResource)
+	for _, r := range s.Store.ListMCPResourcesByServer(serverID) {
+		existing[r.URI] = r
+	}
+
+	res := &SyncResult{Total: len(remote), Added: []string{}, Updated: []string{}, Removed: []string{}}
+	seen := make(map[string]bool, len(remote))
+	for _, r := range remote {
+		if seen[r.URI] {
+			continue
+		}
+		seen[r.URI] = true
+
+		old, ok := existing[r.URI]
+		switch {
+		case !ok:
+			res.Added = append(res.Added, r.URI)
+		case old.Name != r.Name || old.Description != r.Description || old.MimeType != r.MimeType:
+			res.Updated = append(res.Updated, r.URI)
+		default:
+			res.Unchanged++
+			continue
+		}
+		s.Store.UpsertMCPResource(&store.MCPResource{
+			ServerID:    serverID,
+			URI:         r.URI,
+			Name:        r.Name,
+			Description: r.Description,
+			MimeType:    r.MimeType,
+		})
+	}
+	for uri, r := range existing {
+		if !seen[uri] {
+			s.Store.DeleteMCPResource(r.ID)
+			res.Removed = append(res.Removed, uri)
+		}
+	}
+
+	log.Printf("[MCP Service] synced resources of %s: +%d ~%d -%d", server.Name, len(res.Added), len(res.Updated), len(res.Removed))
+	return res, nil
+}
+
+// SyncPrompts 同步 Server 声明的提示模板，以 (server_id, name) 为键 upsert，并删除已不再声明的
+func (s *MCPService) SyncPrompts(ctx context.Context, serverID string) (*SyncResult, error) {
+	server := s.Store.GetMCPServer(serverID)
+	if server == nil {
+		return nil, fmt.Errorf("server not found: %s", serverID)
+	}
+
+	var remote []Prompt
+	if BuiltinKind(server) == "" {
+		client, err := s.capableClient(ctx, server, "prompts")
+		if err != nil && !errors.Is(err, errNoCapability) {
+			return nil, err
+		}
+		if client != nil {
+			if remote, err = client.ListPrompts(ctx); err != nil {
+				return nil, fmt.Errorf("mcp prompts/list: %w", err)
+			}
+		}
+	}
+
+	existing := make(map[string]*store.MCPPrompt)
+	for _, p := range s.Store.ListMCPPromptsByServer(serverID) {
+		existing[p.Name] = p
+	}
+
+	res := &SyncResult{Total: len(remote), Added: []string{}, Updated: []string{}, Removed: []string{}}
+	seen := make(map[string]bool, len(remote))
+	for _, p := range remote {
+		if seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+
+		args := promptArguments(p.Arguments)
+		old, ok := existing[p.Name]
+		switch {
+		case !ok:
+			res.Added = append(res.Added, p.Name)
+		case old.Description != p.Description || !sameSchema(old.Arguments, args):
+			res.Updated = append(res.Updated, p.Name)
+		default:
+			res.Unchanged++
+			continue
+		}
+		s.Store.UpsertMCPPrompt(&store.MCPPrompt{
+			ServerID:    serverID,
+			Name:        p.Name,
+			Description: p.Description,
+			Arguments:   args,
+		})
+	}
+	for name, p := range existing {
+		if !seen[name] {
+			s.Store.DeleteMCPPrompt(p.ID)
+			res.Removed = append(res.Removed, name)
+		}
+	}
+
+	log.Printf("[MCP Service] synced prompts of %s: +%d ~%d -%d", server.Name, len(res.Added), len(res.Updated), len(res.Removed))
+	return res, nil
+}
+
+// capableClient 取连接并确认 Server 声明了该能力；没声明返回 errNoCapability
+func (s *MCPService) capableClient(ctx context.Context, server *store.MCPServer, capability string) (*Client, error) {
+	client, err := s.Executor.Clients.Get(ctx, server)
+	if err != nil {
+		return nil, fmt.Errorf("connect mcp server %s: %w", server.Name, err)
+	}
+	if !client.HasCapability(capability) {
+		return nil, errNoCapability
+	}
+	return client, nil
+}
+
+// promptArguments 参数列表转成存储格式：参数名 -> {"description", "required"}
+func promptArguments(args []PromptArgument) map[string]interface{} {
+	res := make(map[string]interface{}, len(args))
+	for _, a := range args {
+		res[a.Name] = map[string]interface{}{"description": a.Description, "required": a.Required}
+	}
+	return res
+}
+
+// ReadResource 实时读取资源内容（resources/read）
+func (e *Executor) ReadResource(ctx context.Context, server *store.MCPServer, uri string) (*ReadResourceResult, error) {
+	if BuiltinKind(server) != "" {
+		return nil, fmt.Errorf("builtin server %s has no resources", server.Name)
+	}
+	client, err := e.Clients.Get(ctx, server)
+	if err != nil {
+		return nil, fmt.Errorf("connect mcp server %s: %w", server.Name, err)
+	}
+	res, err := client.ReadResource(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("mcp resources/read %s: %w", uri, err)
+	}
+	for i := range res.Contents {
+		res.Contents[i].Text = redact(res.Contents[i].Text, client.secrets)
+	}
+	return res, nil
+}
+
+// GetPrompt 实时渲染提示模板（prompts/get）
+func (e *Executor) GetPrompt(ctx context.Context, server *store.MCPServer, name string, args map[string]string) (*GetPromptResult, error) {
+	if BuiltinKind(server) != "" {
+		return nil, fmt.Errorf("builtin server %s has no prompts", server.Name)
+	}
+	client, err := e.Clients.Get(ctx, server)
+	if err != nil {
+		return nil, fmt.Errorf("connect mcp server %s: %w", server.Name, err)
+	}
+	res, err := client.GetPrompt(ctx, name, args)
+	if err != nil {
+		return nil, fmt.Errorf("mcp prompts/get %s: %w", name, err)
+	}
+	for i := range res.Messages {
+		res.Messages[i].Content.Text = redact(res.Messages[i].Content.Text, client.secrets)
+	}
+	return res, nil
+}
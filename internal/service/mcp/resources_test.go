@@ -0,0 +1,147 @@
+package mcp
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+func newSyncEnv(t *testing.T, f *fakeServer) (*MCPService, *store.MemoryStore, *store.MCPServer) {
+	t.Helper()
+	s := store.NewMemoryStore()
+	svc := NewMCPService(s)
+	t.Cleanup(svc.Executor.Clients.CloseAll)
+	server := s.CreateMCPServer(&store.MCPServer{Name: "fake", TransportType: TransportHTTP, ConnectionConfig: map[string]interface{}{"url": f.url(TransportHTTP)}})
+	return svc, s, server
+}
+
+func syncDiff(res *SyncResult) [3][]string {
+	return [3][]string{res.Added, res.Updated, res.Removed}
+}
+
+func TestSyncResources(t *testing.T) {
+	f := newFakeServer(t)
+	// 每页 2 条，三个资源需要翻页
+	f.setResources(
+		Resource{URI: "file:///a.md", Name: "a", MimeType: "text/markdown"},
+		Resource{URI: "file:///b.md", Name: "b"},
+		Resource{URI: "file:///c.md", Name: "c"},
+	)
+	svc, s, server := newSyncEnv(t, f)
+
+	res, err := svc.SyncResources(testCtx(t), server.ID)
+	if err != nil || res.Total != 3 || !reflect.DeepEqual(syncDiff(res), [3][]string{{"file:///a.md", "file:///b.md", "file:///c.md"}, {}, {}}) {
+		t.Fatalf("first sync = %+v, %v", res, err)
+	}
+	ids := map[string]string{}
+	for _, r := range s.ListMCPResourcesByServer(server.ID) {
+		ids[r.URI] = r.ID
+	}
+
+	// a 不变，b 描述变化，c 不再声明，新增 d；重复声明的 d 只认第一个
+	f.setResources(
+		Resource{URI: "file:///a.md", Name: "a", MimeType: "text/markdown"},
+		Resource{URI: "file:///b.md", Name: "b", Description: "the b file"},
+		Resource{URI: "file:///d.md", Name: "d"},
+		Resource{URI: "file:///d.md", Name: "d again"},
+	)
+	res, err = svc.SyncResources(testCtx(t), server.ID)
+	if err != nil || res.Unchanged != 1 || !reflect.DeepEqual(syncDiff(res), [3][]string{{"file:///d.md"}, {"file:///b.md"}, {"file:///c.md"}}) {
+		t.Fatalf("second sync = %+v, %v", res, err)
+	}
+	got := map[string]*store.MCPResource{}
+	for _, r := range s.ListMCPResourcesByServer(server.ID) {
+		got[r.URI] = r
+	}
+	if len(got) != 3 || got["file:///d.md"].Name != "d" || got["file:///b.md"].Description != "the b file" || got["file:///a.md"].MimeType != "text/markdown" {
+		t.Fatalf("stored resources = %v", got)
+	}
+	// upsert 保留原来的 ID，Agent 上的资源附件不受影响
+	if got["file:///a.md"].ID != ids["file:///a.md"] || got["file:///b.md"].ID != ids["file:///b.md"] {
+		t.Fatal("existing resources were recreated instead of updated")
+	}
+
+	// 内容不落库，按需实时读取
+	read, err := svc.Executor.ReadResource(testCtx(t), server, "file:///b.md")
+	if err != nil || read.Text() != "contents of b" {
+		t.Fatalf("read = %+v, %v", read, err)
+	}
+	if _, err := svc.Executor.ReadResource(testCtx(t), server, "file:///c.md"); err == nil {
+		t.Fatal("reading a resource the server no longer has should fail")
+	}
+}
+
+func TestSyncPrompts(t *testing.T) {
+	f := newFakeServer(t)
+	f.setPrompts(
+		Prompt{Name: "review", Description: "Review code", Arguments: []PromptArgument{{Name: "lang", Required: true}}},
+		Prompt{Name: "explain"},
+	)
+	svc, s, server := newSyncEnv(t, f)
+
+	res, err := svc.SyncPrompts(testCtx(t), server.ID)
+	if err != nil || !reflect.DeepEqual(syncDiff(res), [3][]string{{"review", "explain"}, {}, {}}) {
+		t.Fatalf("first sync = %+v, %v", res, err)
+	}
+	stored := s.ListMCPPromptsByServer(server.ID)
+	byName := map[string]*store.MCPPrompt{}
+	for _, p := range stored {
+		byName[p.Name] = p
+	}
+	if args := byName["review"].Arguments; !reflect.DeepEqual(args, store.JSONMap{"lang": map[string]interface{}{"description": "", "required": true}}) {
+		t.Fatalf("stored arguments = %v", args)
+	}
+
+	// 参数变化算更新，explain 不再声明
+	f.setPrompts(Prompt{Name: "review", Description: "Review code", Arguments: []PromptArgument{{Name: "lang", Required: true}, {Name: "focus"}}})
+	res, err = svc.SyncPrompts(testCtx(t), server.ID)
+	if err != nil || !reflect.DeepEqual(syncDiff(res), [3][]string{{}, {"review"}, {"explain"}}) {
+		t.Fatalf("second sync = %+v, %v", res, err)
+	}
+	var names []string
+	for _, p := range s.ListMCPPromptsByServer(server.ID) {
+		names = append(names, p.Name)
+		if p.ID != byName["review"].ID || len(p.Arguments) != 2 {
+			t.Fatalf("stored prompt = %+v", p)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"review"}) {
+		t.Fatalf("stored prompts = %v", names)
+	}
+
+	rendered, err := svc.Executor.GetPrompt(testCtx(t), server, "review", map[string]string{"lang": "go", "focus": "errors"})
+	if err != nil || rendered.Text() != "review focus=errors lang=go" {
+		t.Fatalf("get prompt = %+v, %v", rendered, err)
+	}
+}
+
+func TestSyncWithoutCapability(t *testing.T) {
+	// 没声明 resources / prompts 能力的 Server 视为没有，已存的记录被清掉
+	f := newFakeServer(t, textTool("echo"))
+	svc, s, server := newSyncEnv(t, f)
+	s.UpsertMCPResource(&store.MCPResource{ServerID: server.ID, URI: "file:///old.md", Name: "old"})
+	s.UpsertMCPPrompt(&store.MCPPrompt{ServerID: server.ID, Name: "old"})
+
+	if res, err := svc.SyncResources(testCtx(t), server.ID); err != nil || res.Total != 0 || !reflect.DeepEqual(res.Removed, []string{"file:///old.md"}) {
+		t.Fatalf("sync resources = %+v, %v", res, err)
+	}
+	if res, err := svc.SyncPrompts(testCtx(t), server.ID); err != nil || res.Total != 0 || !reflect.DeepEqual(res.Removed, []string{"old"}) {
+		t.Fatalf("sync prompts = %+v, %v", res, err)
+	}
+	if n := f.count("resources/list") + f.count("prompts/list"); n != 0 {
+		t.Fatalf("listed %d times without the capability", n)
+	}
+
+	// 内置 Server 同样没有资源和提示模板，也不能实时读取
+	builtin := s.CreateMCPServer(&store.MCPServer{Name: "fs", TransportType: TransportStdio, ConnectionConfig: map[string]interface{}{"builtin": BuiltinFilesystem}})
+	if res, err := svc.SyncResources(testCtx(t), builtin.ID); err != nil || res.Total != 0 {
+		t.Fatalf("sync builtin = %+v, %v", res, err)
+	}
+	if _, err := svc.Executor.ReadResource(testCtx(t), builtin, "file:///a.md"); err == nil {
+		t.Fatal("builtin server should have no resources")
+	}
+	if _, err := svc.Executor.GetPrompt(testCtx(t), builtin, "review", nil); err == nil {
+		t.Fatal("builtin server should have no prompts")
+	}
+}
@@ -0,0 +1,275 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 工具参数按 MCPTool.InputSchema (JSON Schema) 校验后再分发
+// 支持常用子集：type / enum / const / required / properties / additionalProperties / items /
+// minimum / maximum / exclusiveMinimum / exclusiveMaximum / minLength / maxLength / pattern / minItems / maxItems，
+// 缺省字段按 default 补齐；不认识的关键字忽略
+
+// SchemaIssue 一处不符合 Schema 的地方
+type SchemaIssue struct {
+	Path    string `json:"path"` // e.g. "options.depth"，根为空串
+	Message string `json:"message"`
+}
+
+// ValidationError 参数校验失败
+// 以结构化 JSON 作为工具结果返回给 LLM，便于它修正参数后重试
+type ValidationError struct {
+	Tool   string        `json:"tool"`
+	Issues []SchemaIssue `json:"issues"`
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Issues))
+	for _, is := range e.Issues {
+		if is.Path == "" {
+			parts = append(parts, is.Message)
+		} else {
+			parts = append(parts, is.Path+": "+is.Message)
+		}
+	}
+	return fmt.Sprintf("invalid arguments for %s: %s", e.Tool, strings.Join(parts, "; "))
+}
+
+// ToolResult 返回给 LLM 的工具结果
+func (e *ValidationError) ToolResult() string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"error":  "invalid_arguments",
+		"tool":   e.Tool,
+		"issues": e.Issues,
+		"hint":   "The call was not executed. Fix the arguments to match the tool's input schema and call it again.",
+	})
+	return string(b)
+}
+
+// ValidateArguments 解析 LLM 生成的参数并按 Schema 校验，返回补齐了默认值的参数
+// schema 为空时只要求参数是 JSON 对象
+func ValidateArguments(tool string, schema map[string]interface{}, argsJSON string) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	if s := strings.TrimSpace(argsJSON); s != "" {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, &ValidationError{Tool: tool, Issues: []SchemaIssue{{Message: "arguments are not valid JSON: " + err.Error()}}}
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, &ValidationError{Tool: tool, Issues: []SchemaIssue{{Message: "arguments must be a JSON object, got " + jsonType(v)}}}
+		}
+		args = obj
+	}
+
+	v := &validator{}
+	// 代码里写的 Schema 可能是 []string / map[string]string，统一过一遍 JSON
+	if s, ok := normalizeJSON(schema).(map[string]interface{}); ok {
+		v.check("", args, s)
+	}
+	if len(v.issues) > 0 {
+		return nil, &ValidationError{Tool: tool, Issues: v.issues}
+	}
+	return args, nil
+}
+
+type validator struct {
+	issues []SchemaIssue
+}
+
+func (v *validator) fail(path, format string, a ...interface{}) {
+	v.issues = append(v.issues, SchemaIssue{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) check(path string, value interface{}, schema map[string]interface{}) {
+	if types := schemaTypes(schema); len(types) > 0 && !matchesType(value, types) {
+		v.fail(path, "expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !inEnum(value, enum) {
+		v.fail(path, "must be one of %s", compactJSON(enum))
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(value, c) {
+		v.fail(path, "must be %s", compactJSON(c))
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.checkObject(path, val, schema)
+	case []interface{}:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(val)) < n {
+			v.fail(path, "must have at least %v items", n)
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(val)) > n {
+			v.fail(path, "must have at most %v items", n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				v.check(fmt.Sprintf("%s[%d]", path, i), item, items)
+			}
+		}
+	case string:
+		n := float64(len([]rune(val)))
+		if min, ok := schemaNumber(schema, "minLength"); ok && n < min {
+			v.fail(path, "must be at least %v characters", min)
+		}
+		if max, ok := schemaNumber(schema, "maxLength"); ok && n > max {
+			v.fail(path, "must be at most %v characters", max)
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(val) {
+				v.fail(path, "must match pattern %s", p)
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(schema, "minimum"); ok && val < min {
+			v.fail(path, "must be >= %v", min)
+		}
+		if max, ok := schemaNumber(schema, "maximum"); ok && val > max {
+			v.fail(path, "must be <= %v", max)
+		}
+		if min, ok := schemaNumber(schema, "exclusiveMinimum"); ok && val <= min {
+			v.fail(path, "must be > %v", min)
+		}
+		if max, ok := schemaNumber(schema, "exclusiveMaximum"); ok && val >= max {
+			v.fail(path, "must be < %v", max)
+		}
+	}
+}
+
+// checkObject 补默认值、检查必填和各属性；补默认值会直接修改 obj
+func (v *validator) checkObject(path string, obj map[string]interface{}, schema map[string]interface{}) {
+	props, _ := schema["properties"].(map[string]interface{})
+
+	for name, raw := range props {
+		ps, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, present := obj[name]; !present {
+			if def, ok := ps["default"]; ok {
+				obj[name] = def
+			}
+		}
+	}
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, present := obj[name]; name != "" && !present {
+				v.fail(joinPath(path, name), "is required")
+			}
+		}
+	}
+
+	// 固定顺序，错误信息稳定
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if ps, ok := props[k].(map[string]interface{}); ok {
+			v.check(joinPath(path, k), obj[k], ps)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				v.fail(joinPath(path, k), "is not an allowed property")
+			}
+		case map[string]interface{}:
+			v.check(joinPath(path, k), obj[k], extra)
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		res := make([]string, 0, len(t))
+		for _, x := range t {
+			if s, ok := x.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func matchesType(value interface{}, types []string) bool {
+	actual := jsonType(value)
+	for _, t := range types {
+		switch {
+		case t == actual:
+			return true
+		case t == "number" && actual == "integer":
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType JSON Schema 里的类型名；整数值的 number 记为 integer
+func jsonType(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) && !math.IsInf(val, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	switch n := schema[key].(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, e := range enum {
+		if jsonEqual(value, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
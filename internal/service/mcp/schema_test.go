@@ -0,0 +1,143 @@
+package mcp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateArguments(t *testing.T) {
+	// 代码里写的 Schema 混用 []string / map[string]string，和 MockToolsForServer 一样
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []string{"path"},
+		"properties": map[string]interface{}{
+			"path":  map[string]string{"type": "string"},
+			"mode":  map[string]interface{}{"type": "string", "enum": []string{"read", "write"}, "default": "read"},
+			"depth": map[string]interface{}{"type": "integer", "minimum": 0, "default": 1},
+			"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"options": map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": false,
+				"properties": map[string]interface{}{
+					"follow": map[string]interface{}{"type": "boolean", "default": false},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		args   string
+		want   map[string]interface{} // 校验通过时补齐默认值后的参数
+		issues []SchemaIssue          // 校验失败时的问题列表
+	}{
+		{
+			name: "defaults injected",
+			args: `{"path":"a.txt"}`,
+			want: map[string]interface{}{"path": "a.txt", "mode": "read", "depth": float64(1)},
+		},
+		{
+			name: "given values kept",
+			args: `{"path":"a.txt","mode":"write","depth":3,"tags":["x"],"options":{}}`,
+			want: map[string]interface{}{"path": "a.txt", "mode": "write", "depth": float64(3), "tags": []interface{}{"x"}, "options": map[string]interface{}{"follow": false}},
+		},
+		{
+			name:   "missing required",
+			args:   `{"mode":"read"}`,
+			issues: []SchemaIssue{{Path: "path", Message: "is required"}},
+		},
+		{
+			name:   "empty arguments still need required fields",
+			args:   "",
+			issues: []SchemaIssue{{Path: "path", Message: "is required"}},
+		},
+		{
+			name:   "wrong type",
+			args:   `{"path":42}`,
+			issues: []SchemaIssue{{Path: "path", Message: "expected string, got integer"}},
+		},
+		{
+			name:   "integer rejects fractions",
+			args:   `{"path":"a","depth":1.5}`,
+			issues: []SchemaIssue{{Path: "depth", Message: "expected integer, got number"}},
+		},
+		{
+			name:   "nested wrong type",
+			args:   `{"path":"a","tags":["x",1]}`,
+			issues: []SchemaIssue{{Path: "tags[1]", Message: "expected string, got integer"}},
+		},
+		{
+			name:   "enum",
+			args:   `{"path":"a","mode":"append"}`,
+			issues: []SchemaIssue{{Path: "mode", Message: `must be one of ["read","write"]`}},
+		},
+		{
+			name:   "minimum",
+			args:   `{"path":"a","depth":-1}`,
+			issues: []SchemaIssue{{Path: "depth", Message: "must be >= 0"}},
+		},
+		{
+			name:   "additional properties",
+			args:   `{"path":"a","options":{"recursive":true}}`,
+			issues: []SchemaIssue{{Path: "options.recursive", Message: "is not an allowed property"}},
+		},
+		{
+			name: "all issues reported in stable order",
+			args: `{"mode":"x","depth":"deep"}`,
+			issues: []SchemaIssue{
+				{Path: "path", Message: "is required"},
+				{Path: "depth", Message: "expected integer, got string"},
+				{Path: "mode", Message: `must be one of ["read","write"]`},
+			},
+		},
+		{
+			name:   "not an object",
+			args:   `["a.txt"]`,
+			issues: []SchemaIssue{{Message: "arguments must be a JSON object, got array"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := ValidateArguments("fs__read_file", schema, c.args)
+			if c.issues == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, c.want) {
+					t.Fatalf("args = %#v, want %#v", got, c.want)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("err = %v, want *ValidationError", err)
+			}
+			if ve.Tool != "fs__read_file" || !reflect.DeepEqual(ve.Issues, c.issues) {
+				t.Fatalf("issues = %+v, want %+v", ve.Issues, c.issues)
+			}
+		})
+	}
+}
+
+func TestValidateArgumentsInvalidJSON(t *testing.T) {
+	for _, args := range []string{`{"path":`, `{path: "a"}`, `not json`} {
+		_, err := ValidateArguments("fs__read_file", nil, args)
+		var ve *ValidationError
+		if !errors.As(err, &ve) || len(ve.Issues) != 1 || !strings.HasPrefix(ve.Issues[0].Message, "arguments are not valid JSON") {
+			t.Fatalf("ValidateArguments(%q) = %v", args, err)
+		}
+		// 返回给模型的结果说明调用没有执行
+		if res := ve.ToolResult(); !strings.Contains(res, `"error":"invalid_arguments"`) || !strings.Contains(res, "not executed") {
+			t.Fatalf("tool result = %s", res)
+		}
+	}
+}
+
+func TestValidateArgumentsWithoutSchema(t *testing.T) {
+	got, err := ValidateArguments("remote__anything", nil, `{"x":1}`)
+	if err != nil || got["x"] != float64(1) {
+		t.Fatalf("ValidateArguments = %v, %v", got, err)
+	}
+}
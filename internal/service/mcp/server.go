@@ -0,0 +1,156 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+)
+
+// 平台自身作为 MCP Server 对外提供工具（Streamable HTTP，2025-03-26）
+// 无状态：不下发 Mcp-Session-Id，每个 POST 独立处理，响应直接以 application/json 返回
+
+// supportedVersions 客户端请求其中之一时原样协商，否则返回 ProtocolVersion
+var supportedVersions = map[string]bool{
+	"2024-11-05": true,
+	"2025-03-26": true,
+	"2025-06-18": true,
+}
+
+// ToolProvider 对外暴露的工具集，ctx 带有调用方的用户 (UserFromContext)
+type ToolProvider interface {
+	ListTools(ctx context.Context) []Tool
+	// CallTool name 一定来自 ListTools，args 已按 InputSchema 校验；返回的 error 以 isError 结果交给客户端
+	CallTool(ctx context.Context, name string, args map[string]interface{}) (*CallToolResult, error)
+}
+
+// Server 处理一次 POST 的 JSON-RPC 消息
+type Server struct {
+	Info         Implementation
+	Instructions string
+	Tools        ToolProvider
+}
+
+// HandleHTTP 处理请求体（单条消息或批量数组），返回响应体
+// 只有通知 / 响应、不需要回复时返回 nil，调用方应回 202 Accepted
+func (s *Server) HandleHTTP(ctx context.Context, body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []Message
+		if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+			return mustMarshal(errorMessage(nil, codeParseError, "invalid JSON-RPC batch"))
+		}
+		var replies []*Message
+		for i := range batch {
+			if r := s.handle(ctx, &batch[i]); r != nil {
+				replies = append(replies, r)
+			}
+		}
+		if len(replies) == 0 {
+			return nil
+		}
+		return mustMarshal(replies)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(trimmed, &msg); err != nil {
+		return mustMarshal(errorMessage(nil, codeParseError, "invalid JSON: "+err.Error()))
+	}
+	if r := s.handle(ctx, &msg); r != nil {
+		return mustMarshal(r)
+	}
+	return nil
+}
+
+func (s *Server) handle(ctx context.Context, msg *Message) *Message {
+	if msg.IsNotification() || msg.IsResponse() {
+		// notifications/initialized、notifications/cancelled 等：无状态，忽略
+		return nil
+	}
+	if msg.JSONRPC != jsonrpcVersion || msg.Method == "" {
+		return errorMessage(msg.ID, codeInvalidRequest, "invalid JSON-RPC request")
+	}
+
+	var result interface{}
+	var rpcErr *RPCError
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(msg.Params, &params)
+		version := ProtocolVersion
+		if supportedVersions[params.ProtocolVersion] {
+			version = params.ProtocolVersion
+		}
+		result = InitializeResult{
+			ProtocolVersion: version,
+			Capabilities:    map[string]interface{}{"tools": map[string]interface{}{"listChanged": false}},
+			ServerInfo:      s.Info,
+			Instructions:    s.Instructions,
+		}
+	case "ping":
+		result = map[string]interface{}{}
+	case "tools/list":
+		tools := s.Tools.ListTools(ctx)
+		if tools == nil {
+			tools = []Tool{}
+		}
+		result = listToolsResult{Tools: tools}
+	case "tools/call":
+		result, rpcErr = s.callTool(ctx, msg.Params)
+	default:
+		rpcErr = &RPCError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+
+	if rpcErr != nil {
+		return &Message{JSONRPC: jsonrpcVersion, ID: msg.ID, Error: rpcErr}
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return errorMessage(msg.ID, codeInvalidRequest, err.Error())
+	}
+	return &Message{JSONRPC: jsonrpcVersion, ID: msg.ID, Result: raw}
+}
+
+func (s *Server) callTool(ctx context.Context, raw json.RawMessage) (interface{}, *RPCError) {
+	var params struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil || params.Name == "" {
+		return nil, &RPCError{Code: codeInvalidParams, Message: "tools/call requires a tool name"}
+	}
+	if params.Arguments == nil {
+		params.Arguments = map[string]interface{}{}
+	}
+
+	// 参数按工具声明的 Schema 校验，与调用外部 Server 前的校验一致
+	for _, t := range s.Tools.ListTools(ctx) {
+		if t.Name != params.Name {
+			continue
+		}
+		b, _ := json.Marshal(params.Arguments)
+		args, err := ValidateArguments(t.Name, t.InputSchema, string(b))
+		if err != nil {
+			return nil, &RPCError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		res, err := s.Tools.CallTool(ctx, t.Name, args)
+		if err != nil {
+			return &CallToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		return res, nil
+	}
+	return nil, &RPCError{Code: codeInvalidParams, Message: "unknown tool: " + params.Name}
+}
+
+func errorMessage(id json.RawMessage, code int, message string) *Message {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &Message{JSONRPC: jsonrpcVersion, ID: id, Error: &RPCError{Code: code, Message: message}}
+}
+
+func mustMarshal(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
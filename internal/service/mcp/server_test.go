@@ -0,0 +1,161 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// stubTools 按用户返回工具；echo 原样返回 text，fail 返回错误
+type stubTools struct {
+	calls []string
+}
+
+func (s *stubTools) ListTools(ctx context.Context) []Tool {
+	if UserFromContext(ctx) == "" {
+		return nil
+	}
+	echo := textTool("echo")
+	echo.InputSchema = map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+		"required":   []string{"text"},
+	}
+	return []Tool{echo, textTool("fail")}
+}
+
+func (s *stubTools) CallTool(ctx context.Context, name string, args map[string]interface{}) (*CallToolResult, error) {
+	s.calls = append(s.calls, UserFromContext(ctx)+":"+name)
+	if name == "fail" {
+		return nil, errors.New("tool broke")
+	}
+	return &CallToolResult{Content: []Content{{Type: "text", Text: args["text"].(string)}}}, nil
+}
+
+// reply 解析单条响应；result 解到 out
+func reply(t *testing.T, raw []byte, out interface{}) *RPCError {
+	t.Helper()
+	var msg Message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		t.Fatalf("response %s: %v", raw, err)
+	}
+	if msg.JSONRPC != jsonrpcVersion {
+		t.Fatalf("response %s", raw)
+	}
+	if msg.Error == nil && out != nil {
+		if err := json.Unmarshal(msg.Result, out); err != nil {
+			t.Fatalf("result %s: %v", msg.Result, err)
+		}
+	}
+	return msg.Error
+}
+
+func TestServerHandleHTTP(t *testing.T) {
+	tools := &stubTools{}
+	srv := &Server{Info: Implementation{Name: "nexus", Version: "1"}, Instructions: "hi", Tools: tools}
+	ctx := WithUser(context.Background(), "alice")
+
+	t.Run("initialize", func(t *testing.T) {
+		for requested, want := range map[string]string{"2024-11-05": "2024-11-05", "2025-06-18": "2025-06-18", "1999-01-01": ProtocolVersion, "": ProtocolVersion} {
+			var res InitializeResult
+			body := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"` + requested + `"}}`
+			if err := reply(t, srv.HandleHTTP(ctx, []byte(body)), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.ProtocolVersion != want || res.ServerInfo.Name != "nexus" || res.Instructions != "hi" || res.Capabilities["tools"] == nil {
+				t.Fatalf("initialize(%q) = %+v", requested, res)
+			}
+		}
+	})
+
+	t.Run("notifications get no reply", func(t *testing.T) {
+		if out := srv.HandleHTTP(ctx, []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)); out != nil {
+			t.Fatalf("reply = %s", out)
+		}
+	})
+
+	t.Run("tools/list", func(t *testing.T) {
+		var res listToolsResult
+		if err := reply(t, srv.HandleHTTP(ctx, []byte(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Tools) != 2 || res.Tools[0].Name != "echo" {
+			t.Fatalf("tools = %+v", res.Tools)
+		}
+		// 没有工具时是空数组而不是 null
+		raw := srv.HandleHTTP(context.Background(), []byte(`{"jsonrpc":"2.0","id":3,"method":"tools/list"}`))
+		var msg Message
+		_ = json.Unmarshal(raw, &msg)
+		if string(msg.Result) != `{"tools":[]}` {
+			t.Fatalf("anonymous tools/list = %s", msg.Result)
+		}
+	})
+
+	t.Run("tools/call", func(t *testing.T) {
+		cases := []struct {
+			name    string
+			params  string
+			code    int    // JSON-RPC 错误码，0 表示返回结果
+			text    string // 结果文本
+			isError bool
+		}{
+			{"ok", `{"name":"echo","arguments":{"text":"hello"}}`, 0, "hello", false},
+			{"tool error", `{"name":"fail"}`, 0, "tool broke", true},
+			{"unknown tool", `{"name":"nope","arguments":{}}`, codeInvalidParams, "", false},
+			{"schema violation", `{"name":"echo","arguments":{"text":1}}`, codeInvalidParams, "", false},
+			{"missing argument", `{"name":"echo"}`, codeInvalidParams, "", false},
+			{"missing name", `{"arguments":{}}`, codeInvalidParams, "", false},
+		}
+		for _, c := range cases {
+			var res CallToolResult
+			err := reply(t, srv.HandleHTTP(ctx, []byte(`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":`+c.params+`}`)), &res)
+			if c.code != 0 {
+				if err == nil || err.Code != c.code {
+					t.Errorf("%s: err = %v, want code %d", c.name, err, c.code)
+				}
+				continue
+			}
+			if err != nil || res.IsError != c.isError || len(res.Content) != 1 || res.Content[0].Text != c.text {
+				t.Errorf("%s: result = %+v, %v", c.name, res, err)
+			}
+		}
+		// 只有通过校验的调用才到达工具，并带着调用方的用户
+		if !reflect.DeepEqual(tools.calls, []string{"alice:echo", "alice:fail"}) {
+			t.Fatalf("calls = %v", tools.calls)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := map[string]int{
+			`not json`: codeParseError,
+			`[]`:       codeParseError,
+			`{"jsonrpc":"1.0","id":5,"method":"ping"}`:   codeInvalidRequest,
+			`{"id":5,"method":"ping"}`:                   codeInvalidRequest,
+			`{"jsonrpc":"2.0","id":5,"method":"nope"}`:   codeMethodNotFound,
+			`{"jsonrpc":"2.0","id":5,"method":"ping"} `:  0,
+			`  {"jsonrpc":"2.0","id":5,"method":"ping"}`: 0,
+		}
+		for body, code := range cases {
+			err := reply(t, srv.HandleHTTP(ctx, []byte(body)), nil)
+			if code == 0 && err != nil || code != 0 && (err == nil || err.Code != code) {
+				t.Errorf("%q: err = %v, want %d", body, err, code)
+			}
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		body := `[{"jsonrpc":"2.0","id":"a","method":"ping"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":"b","method":"tools/call","params":{"name":"echo","arguments":{"text":"x"}}}]`
+		var replies []Message
+		if err := json.Unmarshal(srv.HandleHTTP(ctx, []byte(body)), &replies); err != nil {
+			t.Fatal(err)
+		}
+		if len(replies) != 2 || string(replies[0].ID) != `"a"` || string(replies[1].ID) != `"b"` {
+			t.Fatalf("replies = %+v", replies)
+		}
+		if out := srv.HandleHTTP(ctx, []byte(`[{"jsonrpc":"2.0","method":"notifications/initialized"}]`)); out != nil {
+			t.Fatalf("notification-only batch reply = %s", out)
+		}
+	})
+}
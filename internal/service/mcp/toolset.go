@@ -0,0 +1,148 @@
+package mcp
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"example.com/agent-server/internal/store"
+)
+
+// 暴露给 LLM 的工具名格式：<server>__<tool>
+// OpenAI 等厂商要求函数名匹配 ^[a-zA-Z0-9_-]{1,64}$
+const (
+	toolNameSep    = "__"
+	maxToolNameLen = 64
+)
+
+// BoundTool 已解析到具体 Server 的工具
+type BoundTool struct {
+	QualifiedName string           // 暴露给 LLM 的带命名空间的名字
+	Tool          *store.MCPTool   // 原始工具定义（Name 为 Server 侧的真实工具名）
+	Server        *store.MCPServer // 所属 Server
+}
+
+// ToolSet 某个 Agent 可用的工具集合
+// 只包含 ListMCPToolsByAgent 返回的工具，解析时不会越界到其它 Server
+type ToolSet struct {
+	tools  []*BoundTool
+	byName map[string]*BoundTool
+	bare   map[string][]*BoundTool // 内置 Server 的原始工具名 -> 候选，见 Resolve
+}
+
+// LoadToolSet 加载 Agent 绑定的全部工具
+// 健康检查判定为断开的 Server 的工具不提供给 LLM，恢复后自动回来
+func LoadToolSet(s store.Store, agentID string) *ToolSet {
+	ts := &ToolSet{
+		byName: make(map[string]*BoundTool),
+		bare:   make(map[string][]*BoundTool),
+	}
+	tools := s.ListMCPToolsByAgent(agentID)
+	// 固定顺序：同名冲突时谁加后缀、暴露给 LLM 的工具顺序都保持稳定
+	sort.Slice(tools, func(i, j int) bool {
+		if tools[i].ServerID != tools[j].ServerID {
+			return tools[i].ServerID < tools[j].ServerID
+		}
+		return tools[i].Name < tools[j].Name
+	})
+
+	servers := make(map[string]*store.MCPServer)
+	for _, t := range tools {
+		server, ok := servers[t.ServerID]
+		if !ok {
+			server = s.GetMCPServer(t.ServerID)
+			servers[t.ServerID] = server
+		}
+		if !ServerUsable(server) {
+			continue
+		}
+		ts.add(t, server)
+	}
+	return ts
+}
+
+func (ts *ToolSet) add(t *store.MCPTool, server *store.MCPServer) {
+	name := QualifiedToolName(server.Name, t.Name)
+	if _, dup := ts.byName[name]; dup {
+		// 两个 Server 同名（或清洗后同名），再用 Server ID 区分
+		name = QualifiedToolName(server.Name+"_"+shortID(server.ID), t.Name)
+	}
+	bt := &BoundTool{QualifiedName: name, Tool: t, Server: server}
+	ts.tools = append(ts.tools, bt)
+	ts.byName[name] = bt
+	if BuiltinKind(server) != "" {
+		ts.bare[t.Name] = append(ts.bare[t.Name], bt)
+	}
+}
+
+// Tools 暴露给 LLM 的工具定义（Name 已替换为带命名空间的名字）
+func (ts *ToolSet) Tools() []*store.MCPTool {
+	res := make([]*store.MCPTool, 0, len(ts.tools))
+	for _, bt := range ts.tools {
+		cp := *bt.Tool
+		cp.Name = bt.QualifiedName
+		res = append(res, &cp)
+	}
+	return res
+}
+
+// Resolve 把 LLM 给出的工具名解析为具体工具，只接受带命名空间的名字
+// 例外：内置 Server（git / filesystem）的工具在引入命名空间之前按原始名调用（git_status、read_file ...），
+// 旧会话历史、待审批记录里还留着这些名字，所以内置工具的原始名在该 Agent 内唯一时仍然接受；
+// 远程 Server 的工具不做这种回退，否则一个名字能否解析要取决于 Agent 还绑定了哪些其它 Server
+func (ts *ToolSet) Resolve(name string) (*BoundTool, error) {
+	if bt, ok := ts.byName[name]; ok {
+		return bt, nil
+	}
+	switch candidates := ts.bare[name]; len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("tool %q is not available to this agent", name)
+	case 1:
+		return candidates[0], nil
+	default:
+		names := make([]string, 0, len(candidates))
+		for _, bt := range candidates {
+			names = append(names, bt.QualifiedName)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("tool %q is ambiguous, use one of: %s", name, strings.Join(names, ", "))
+	}
+}
+
+// QualifiedToolName 生成 <server>__<tool> 形式的工具名
+// 非法字符替换为 '_'，超长时截断并追加哈希，保证稳定且唯一
+func QualifiedToolName(serverName, toolName string) string {
+	name := sanitizeToolName(serverName) + toolNameSep + sanitizeToolName(toolName)
+	if len(name) <= maxToolNameLen {
+		return name
+	}
+	sum := sha1.Sum([]byte(name))
+	suffix := "_" + hex.EncodeToString(sum[:])[:8]
+	return name[:maxToolNameLen-len(suffix)] + suffix
+}
+
+func sanitizeToolName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func shortID(id string) string {
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) > 6 {
+		return id[:6]
+	}
+	return id
+}
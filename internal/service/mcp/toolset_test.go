@@ -0,0 +1,63 @@
+package mcp
+
+import (
+	"strings"
+	"testing"
+
+	"example.com/agent-server/internal/store"
+)
+
+func TestToolSetResolve(t *testing.T) {
+	s := store.NewMemoryStore()
+	addServer := func(name string, cfg map[string]interface{}, tools ...string) *store.MCPServer {
+		server := s.CreateMCPServer(&store.MCPServer{AgentID: "agent-1", Name: name, TransportType: TransportHTTP, ConnectionConfig: cfg})
+		for _, tool := range tools {
+			s.UpsertMCPTool(&store.MCPTool{ServerID: server.ID, Name: tool})
+		}
+		return server
+	}
+	addServer("fs", map[string]interface{}{"builtin": "filesystem"}, "read_file", "write_file")
+	addServer("github", map[string]interface{}{"url": "http://github.invalid/mcp"}, "search", "create_issue")
+	addServer("jira", map[string]interface{}{"url": "http://jira.invalid/mcp"}, "search")
+	ts := LoadToolSet(s, "agent-1")
+
+	cases := []struct {
+		name   string
+		want   string // 解析到的 Server 名；空表示应失败
+		errHas string
+	}{
+		{name: "fs__read_file", want: "fs"},
+		{name: "github__search", want: "github"},
+		{name: "jira__search", want: "jira"},
+		// 内置工具为兼容旧会话接受原始名
+		{name: "read_file", want: "fs"},
+		// 远程工具必须带命名空间，即使原始名在 Agent 内唯一
+		{name: "create_issue", errHas: "not available"},
+		{name: "search", errHas: "not available"},
+		{name: "slack__post", errHas: "not available"},
+	}
+	for _, c := range cases {
+		bt, err := ts.Resolve(c.name)
+		if c.want == "" {
+			if err == nil || !strings.Contains(err.Error(), c.errHas) {
+				t.Errorf("Resolve(%q) = %v, %v; want error containing %q", c.name, bt, err, c.errHas)
+			}
+			continue
+		}
+		if err != nil || bt.Server.Name != c.want {
+			t.Errorf("Resolve(%q) = %v, %v; want server %s", c.name, bt, err, c.want)
+		}
+	}
+}
+
+func TestToolSetBuiltinBareNameAmbiguous(t *testing.T) {
+	s := store.NewMemoryStore()
+	for _, name := range []string{"fs-a", "fs-b"} {
+		server := s.CreateMCPServer(&store.MCPServer{AgentID: "agent-1", Name: name, ConnectionConfig: map[string]interface{}{"builtin": "filesystem"}})
+		s.UpsertMCPTool(&store.MCPTool{ServerID: server.ID, Name: "read_file"})
+	}
+	_, err := LoadToolSet(s, "agent-1").Resolve("read_file")
+	if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Fatalf("err = %v, want ambiguous", err)
+	}
+}
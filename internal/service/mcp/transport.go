@@ -0,0 +1,244 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/agent-server/internal/store"
+)
+
+// Transport 负责把 JSON-RPC 消息送到 MCP Server 并把收到的消息投递出来
+// 收到的消息（响应/通知/服务端请求）统一从 Incoming() 读取，
+// 连接断开时 Incoming() 被关闭
+type Transport interface {
+	Start(ctx context.Context) error
+	Send(ctx context.Context, msg *Message) error
+	Incoming() <-chan *Message
+	Close() error
+}
+
+// NewTransport 根据 MCPServer.TransportType + ConnectionConfig 构造传输层
+//
+// 远程 Server 的 connection_config:
+//
+//	{"url": "https://mcp.example.com/mcp", "headers": {"Authorization": "Bearer xxx"}}
+//
+// 本地 stdio Server 的 connection_config:
+//
+//	{"command": "uvx", "args": ["mcp-server-git"], "env": {"K": "V"}, "cwd": "/srv/repo"}
+func NewTransport(server *store.MCPServer) (Transport, error) {
+	cfg := server.ConnectionConfig
+	switch server.TransportType {
+	case TransportStdio:
+		command := configString(cfg, "command")
+		if command == "" {
+			return nil, fmt.Errorf("connection_config.command is required for stdio transport")
+		}
+		return newStdioTransport(command, configStrings(cfg, "args"), configEnv(cfg), configString(cfg, "cwd")), nil
+
+	case TransportHTTP:
+		url := configString(cfg, "url")
+		if url == "" {
+			return nil, fmt.Errorf("connection_config.url is required for http transport")
+		}
+		return newStreamableHTTPTransport(url, configHeaders(cfg)), nil
+
+	case TransportSSE:
+		url := configString(cfg, "url")
+		if url == "" {
+			return nil, fmt.Errorf("connection_config.url is required for sse transport")
+		}
+		return newSSETransport(url, configHeaders(cfg)), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported transport type: %s", server.TransportType)
+	}
+}
+
+// inbox 收件箱：多个 goroutine 投递，连接断开时安全关闭
+// (Streamable HTTP 每次 Send 都可能投递消息，不能简单地由某一方 close channel)
+type inbox struct {
+	mu     sync.RWMutex
+	ch     chan *Message
+	done   chan struct{}
+	closed bool
+	once   sync.Once
+}
+
+func newInbox() *inbox {
+	return &inbox{ch: make(chan *Message, 16), done: make(chan struct{})}
+}
+
+// deliver 投递一条消息；已关闭则丢弃
+func (b *inbox) deliver(msg *Message) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	select {
+	case b.ch <- msg:
+	case <-b.done:
+	}
+}
+
+// close 先关 done 唤醒阻塞中的投递者，再关 ch
+func (b *inbox) close() {
+	b.once.Do(func() {
+		close(b.done)
+		b.mu.Lock()
+		b.closed = true
+		close(b.ch)
+		b.mu.Unlock()
+	})
+}
+
+// deliverJSON 解析单条或批量 JSON-RPC 消息并投递
+func (b *inbox) deliverJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil
+	}
+	if data[0] == '[' {
+		var batch []*Message
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return fmt.Errorf("decode mcp batch: %w", err)
+		}
+		for _, m := range batch {
+			b.deliver(m)
+		}
+		return nil
+	}
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("decode mcp message: %w", err)
+	}
+	b.deliver(&m)
+	return nil
+}
+
+// =============================================================================
+// Helpers
+// =============================================================================
+
+// defaultHTTPClient 不设置整体超时：SSE 长连接和流式响应靠 ctx 控制
+var defaultHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+	},
+}
+
+func configString(cfg map[string]interface{}, key string) string {
+	if v, ok := cfg[key].(string); ok {
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+// configStrings 兼容 JSON 解出来的 []interface{} 和代码里直接写的 []string
+func configStrings(cfg map[string]interface{}, key string) []string {
+	switch vs := cfg[key].(type) {
+	case []string:
+		return vs
+	case []interface{}:
+		res := make([]string, 0, len(vs))
+		for _, v := range vs {
+			res = append(res, fmt.Sprint(v))
+		}
+		return res
+	}
+	return nil
+}
+
+// configEnv 把 {"K": "V"} 转成 exec 需要的 K=V 形式
+func configEnv(cfg map[string]interface{}) []string {
+	env, ok := cfg["env"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	res := make([]string, 0, len(env))
+	for k, v := range env {
+		res = append(res, fmt.Sprintf("%s=%v", k, v))
+	}
+	return res
+}
+
+func configHeaders(cfg map[string]interface{}) map[string]string {
+	res := map[string]string{}
+	switch hs := cfg["headers"].(type) {
+	case map[string]interface{}:
+		for k, v := range hs {
+			if s, ok := v.(string); ok {
+				res[k] = s
+			}
+		}
+	case map[string]string:
+		for k, v := range hs {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// readSSE 解析 text/event-stream，每收到一个完整事件回调一次
+// fn 返回 false 时停止读取
+func readSSE(r io.Reader, fn func(event, data string) bool) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var event string
+	var data []string
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			if err == io.EOF {
+				// 流结束时还有没以空行收尾的事件，照样投递
+				if len(data) > 0 {
+					if event == "" {
+						event = "message"
+					}
+					fn(event, strings.Join(data, "\n"))
+				}
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			// 空行 = 事件结束
+			if len(data) > 0 {
+				if event == "" {
+					event = "message"
+				}
+				if !fn(event, strings.Join(data, "\n")) {
+					return nil
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// 注释 / 心跳
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// 读到半行就出错/EOF 的情况，交给下一轮 ReadString 处理
+	}
+}
+
+// httpStatusError 读一小段 body 拼成可读的错误
+func httpStatusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("mcp http status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,139 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamableHTTPTransport Streamable HTTP 传输 (MCP 2025-03-26)
+// 每条消息一个 POST；响应可能是 application/json，也可能是 text/event-stream
+type streamableHTTPTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	inbox   *inbox
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+func newStreamableHTTPTransport(url string, headers map[string]string) *streamableHTTPTransport {
+	return &streamableHTTPTransport{
+		url:     url,
+		headers: headers,
+		client:  defaultHTTPClient,
+		inbox:   newInbox(),
+	}
+}
+
+// Start 无需预先建连，会话在 initialize 响应里通过 Mcp-Session-Id 建立
+func (t *streamableHTTPTransport) Start(ctx context.Context) error { return nil }
+
+func (t *streamableHTTPTransport) Incoming() <-chan *Message { return t.inbox.ch }
+
+func (t *streamableHTTPTransport) Send(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	t.applyHeaders(req)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mcp http post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if sid := resp.Header.Get("Mcp-Session-Id"); sid != "" {
+		t.mu.Lock()
+		t.sessionID = sid
+		t.mu.Unlock()
+	}
+
+	if resp.StatusCode == http.StatusNotFound && t.session() != "" {
+		// 会话被服务端回收，连接作废，让上层重连
+		t.inbox.close()
+		return fmt.Errorf("mcp session expired")
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		// 通知 / 响应：服务端不回消息
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return httpStatusError(resp)
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var decodeErr error
+		err := readSSE(resp.Body, func(event, data string) bool {
+			if event != "message" {
+				return true
+			}
+			if err := t.inbox.deliverJSON([]byte(data)); err != nil {
+				decodeErr = err
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("mcp http stream: %w", err)
+		}
+		return decodeErr
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("mcp http read: %w", err)
+	}
+	return t.inbox.deliverJSON(data)
+}
+
+// Close 通知服务端结束会话 (best effort)
+func (t *streamableHTTPTransport) Close() error {
+	defer t.inbox.close()
+	sid := t.session()
+	if sid == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	t.applyHeaders(req)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *streamableHTTPTransport) session() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessionID
+}
+
+func (t *streamableHTTPTransport) applyHeaders(req *http.Request) {
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	if sid := t.session(); sid != "" {
+		req.Header.Set("Mcp-Session-Id", sid)
+	}
+}
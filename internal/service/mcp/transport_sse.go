@@ -0,0 +1,168 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// sseTransport 旧版 HTTP+SSE 传输 (MCP 2024-11-05)
+// GET 建立长连接，服务端先推送 endpoint 事件告知 POST 地址，
+// 之后所有响应/通知都通过这条 SSE 流以 message 事件下发
+type sseTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	inbox   *inbox
+
+	mu       sync.Mutex
+	endpoint string
+	cancel   context.CancelFunc
+}
+
+func newSSETransport(url string, headers map[string]string) *sseTransport {
+	return &sseTransport{
+		url:     url,
+		headers: headers,
+		client:  defaultHTTPClient,
+		inbox:   newInbox(),
+	}
+}
+
+func (t *sseTransport) Incoming() <-chan *Message { return t.inbox.ch }
+
+// Start 建立 SSE 长连接并等待 endpoint 事件
+// 注意：长连接的生命周期不跟随 ctx（ctx 只控制握手等待时间），由 Close 结束
+func (t *sseTransport) Start(ctx context.Context) error {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, t.url, nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("mcp sse connect: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		cancel()
+		return httpStatusError(resp)
+	}
+
+	t.mu.Lock()
+	t.cancel = cancel
+	t.mu.Unlock()
+
+	endpointCh := make(chan string, 1)
+	go func() {
+		defer resp.Body.Close()
+		defer t.inbox.close()
+		err := readSSE(resp.Body, func(event, data string) bool {
+			switch event {
+			case "endpoint":
+				select {
+				case endpointCh <- data:
+				default:
+				}
+			case "message":
+				if err := t.inbox.deliverJSON([]byte(data)); err != nil {
+					log.Printf("[MCP SSE] drop malformed message: %v", err)
+				}
+			}
+			return true
+		})
+		if err != nil && streamCtx.Err() == nil {
+			log.Printf("[MCP SSE] stream closed: %v", err)
+		}
+	}()
+
+	select {
+	case ep := <-endpointCh:
+		resolved, err := resolveEndpoint(t.url, ep)
+		if err != nil {
+			t.Close()
+			return err
+		}
+		t.mu.Lock()
+		t.endpoint = resolved
+		t.mu.Unlock()
+		return nil
+	case <-t.inbox.done:
+		t.Close()
+		return fmt.Errorf("mcp sse stream closed before endpoint event")
+	case <-ctx.Done():
+		t.Close()
+		return ctx.Err()
+	}
+}
+
+func (t *sseTransport) Send(ctx context.Context, msg *Message) error {
+	t.mu.Lock()
+	endpoint := t.endpoint
+	t.mu.Unlock()
+	if endpoint == "" {
+		return fmt.Errorf("mcp sse transport not started")
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mcp sse post: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return httpStatusError(resp)
+	}
+	// 响应走 SSE 流，这里的 body 没有意义
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (t *sseTransport) Close() error {
+	t.mu.Lock()
+	cancel := t.cancel
+	t.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	t.inbox.close()
+	return nil
+}
+
+// resolveEndpoint endpoint 事件可能是相对路径，需要基于 SSE 地址解析
+func resolveEndpoint(base, endpoint string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	e, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	return b.ResolveReference(e).String(), nil
+}
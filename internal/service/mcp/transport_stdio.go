@@ -0,0 +1,152 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stdioTransport 本地子进程传输
+// 启动 connection_config.command，按行 (newline-delimited JSON) 在 stdin/stdout 上收发 JSON-RPC，
+// stderr 只用来打日志
+type stdioTransport struct {
+	command string
+	args    []string
+	env     []string
+	dir     string
+	inbox   *inbox
+
+	mu     sync.Mutex // 保护 stdin 写入
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	exited chan struct{}
+}
+
+func newStdioTransport(command string, args, env []string, dir string) *stdioTransport {
+	return &stdioTransport{
+		command: command,
+		args:    args,
+		env:     env,
+		dir:     dir,
+		inbox:   newInbox(),
+		exited:  make(chan struct{}),
+	}
+}
+
+func (t *stdioTransport) Incoming() <-chan *Message { return t.inbox.ch }
+
+// Start 拉起子进程
+// 进程生命周期不跟随 ctx，由 Close 或进程自己退出结束
+func (t *stdioTransport) Start(ctx context.Context) error {
+	cmd := exec.Command(t.command, t.args...)
+	cmd.Env = append(os.Environ(), t.env...)
+	cmd.Dir = t.dir
+	cmd.Stderr = &stderrLogger{prefix: fmt.Sprintf("[MCP stdio %s]", t.command)}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("spawn %s: %w", t.command, err)
+	}
+	log.Printf("[MCP stdio] started %s (pid %d)", t.command, cmd.Process.Pid)
+
+	t.mu.Lock()
+	t.cmd = cmd
+	t.stdin = stdin
+	t.mu.Unlock()
+
+	go func() {
+		defer close(t.exited)
+		reader := bufio.NewReaderSize(stdout, 64*1024)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(strings.TrimSpace(string(line))) > 0 {
+				if derr := t.inbox.deliverJSON(line); derr != nil {
+					log.Printf("[MCP stdio %s] drop non-JSON output: %v", t.command, derr)
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		// stdout 关闭 = 进程退出（或即将退出）
+		t.inbox.close()
+		if err := cmd.Wait(); err != nil {
+			log.Printf("[MCP stdio] %s (pid %d) exited: %v", t.command, cmd.Process.Pid, err)
+		} else {
+			log.Printf("[MCP stdio] %s (pid %d) exited", t.command, cmd.Process.Pid)
+		}
+	}()
+	return nil
+}
+
+func (t *stdioTransport) Send(ctx context.Context, msg *Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stdin == nil {
+		return fmt.Errorf("mcp stdio transport not started")
+	}
+	if _, err := t.stdin.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("mcp stdio write: %w", err)
+	}
+	return nil
+}
+
+// Close 先关 stdin 让进程自行退出，超时再强杀
+func (t *stdioTransport) Close() error {
+	t.mu.Lock()
+	cmd, stdin := t.cmd, t.stdin
+	t.stdin = nil
+	t.mu.Unlock()
+
+	defer t.inbox.close()
+	if cmd == nil {
+		return nil
+	}
+	if stdin != nil {
+		stdin.Close()
+	}
+	select {
+	case <-t.exited:
+	case <-time.After(3 * time.Second):
+		_ = cmd.Process.Kill()
+		select {
+		case <-t.exited:
+		case <-time.After(time.Second):
+			// 孙进程可能还握着 stdout，不再等
+		}
+	}
+	return nil
+}
+
+// stderrLogger 把子进程 stderr 按行打到日志
+type stderrLogger struct {
+	prefix string
+}
+
+func (w *stderrLogger) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if strings.TrimSpace(line) != "" {
+			log.Printf("%s %s", w.prefix, line)
+		}
+	}
+	return len(p), nil
+}
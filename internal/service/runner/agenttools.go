@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+	"github.com/google/uuid"
+)
+
+// AgentTools 把调用方可访问的 Agent 作为 MCP 工具暴露（见 mcp.Server）
+// 每次调用新建一个会话和 Run，与网页端发起的一样排队执行，阻塞到 Agent 给出最终回复
+type AgentTools struct {
+	Engine   *AgentEngine
+	APIKeyID string // 发起调用的 API Key，记录到 Run.input_payload
+	// Timeout 等待 Run 结束的上限，超时取消 Run；<=0 用 DefaultAgentToolTimeout
+	Timeout time.Duration
+}
+
+// DefaultAgentToolTimeout 单次 tools/call 的默认超时
+const DefaultAgentToolTimeout = 30 * time.Minute
+
+var toolNameUnsafe = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// agentToolName Agent 名转成工具名：小写，只保留字母数字下划线
+func agentToolName(a *store.Agent) string {
+	name := strings.Trim(toolNameUnsafe.ReplaceAllString(strings.ToLower(a.Name), "_"), "_")
+	if len(name) > 48 {
+		name = strings.TrimRight(name[:48], "_")
+	}
+	if name == "" {
+		name = "agent"
+	}
+	return name
+}
+
+// accessibleAgents 调用方可用的 Agent（内置的 system Agent 和自己创建的），按工具名索引
+// 重名时后创建的加上 ID 前缀区分，保证同一批 Agent 得到的名字稳定
+func (t *AgentTools) accessibleAgents(userID string) ([]string, map[string]*store.Agent) {
+	var agents []*store.Agent
+	for _, a := range t.Engine.Store.ListAgents() {
+		if a != nil && (a.Type == "system" || a.OwnerUserID == userID) {
+			agents = append(agents, a)
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		if !agents[i].CreatedAt.Equal(agents[j].CreatedAt) {
+			return agents[i].CreatedAt.Before(agents[j].CreatedAt)
+		}
+		return agents[i].ID < agents[j].ID
+	})
+
+	names := make([]string, 0, len(agents))
+	byName := make(map[string]*store.Agent, len(agents))
+	for _, a := range agents {
+		name := agentToolName(a)
+		if _, dup := byName[name]; dup {
+			name = fmt.Sprintf("%s_%.8s", name, strings.ReplaceAll(a.ID, "-", ""))
+		}
+		names = append(names, name)
+		byName[name] = a
+	}
+	return names, byName
+}
+
+func (t *AgentTools) ListTools(ctx context.Context) []mcp.Tool {
+	names, byName := t.accessibleAgents(mcp.UserFromContext(ctx))
+	tools := make([]mcp.Tool, 0, len(names))
+	for _, name := range names {
+		a := byName[name]
+		desc := a.Description
+		if desc == "" {
+			desc = "Nexus agent " + a.Name
+		}
+		tools = append(tools, mcp.Tool{
+			Name:        name,
+			Description: desc + "\nDelegates the task to this agent and returns its final answer.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"task": map[string]interface{}{"type": "string", "minLength": 1, "description": "What the agent should do, in natural language"},
+				},
+				"required":             []string{"task"},
+				"additionalProperties": false,
+			},
+			Annotations: &mcp.ToolAnnotations{Title: a.Name},
+		})
+	}
+	return tools
+}
+
+func (t *AgentTools) CallTool(ctx context.Context, name string, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	userID := mcp.UserFromContext(ctx)
+	_, byName := t.accessibleAgents(userID)
+	agent := byName[name]
+	if agent == nil {
+		return nil, fmt.Errorf("unknown tool: %s", name)
+	}
+	task, _ := args["task"].(string)
+
+	s := t.Engine.Store
+	title := []rune(task)
+	if len(title) > 40 {
+		title = append(title[:40], '…')
+	}
+	session := s.CreateChatSession(&store.ChatSession{
+		UserID:  userID,
+		AgentID: agent.ID,
+		Title:   "MCP: " + string(title),
+	})
+	s.CreateChatMessage(&store.ChatMessage{
+		SessionID: session.ID,
+		Role:      "user",
+		Content:   map[string]interface{}{"type": "text", "text": task},
+	})
+	run, err := t.Engine.Queue.Enqueue(&store.Run{
+		SessionID: session.ID,
+		UserID:    userID,
+		AgentID:   agent.ID,
+		APIKeyID:  t.APIKeyID,
+		TraceID:   uuid.New().String(),
+		InputPayload: map[string]interface{}{
+			"content":    task,
+			"source":     "mcp",
+			"api_key_id": t.APIKeyID,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create run failed: %w", err)
+	}
+	fmt.Printf("[Agent] MCP call %s -> run %s\n", name, run.ID)
+
+	// 超时或调用方取消 ctx（见 handler.ServeMCP：客户端断开时写心跳失败）时取消 Run，排队中的直接出队
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = DefaultAgentToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = t.Engine.CancelRun(run.ID) })
+	defer stop()
+
+	final, err := t.Engine.Queue.Wait(run.ID)
+	meta := map[string]interface{}{"run_id": run.ID, "session_id": session.ID, "trace_id": run.TraceID}
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		text := fmt.Sprintf("Agent run timed out after %s and was cancelled (run %s).", timeout, run.ID)
+		return &mcp.CallToolResult{Content: []mcp.Content{{Type: "text", Text: text}}, IsError: true, Meta: meta}, nil
+	case errors.Is(err, ErrAwaitingApproval):
+		text := fmt.Sprintf("The agent paused: a tool call needs approval in Nexus (run %s). Approve or reject it via /api/approvals; the run continues in the background.", run.ID)
+		return &mcp.CallToolResult{Content: []mcp.Content{{Type: "text", Text: text}}, IsError: true, Meta: meta}, nil
+	case err != nil:
+		return &mcp.CallToolResult{Content: []mcp.Content{{Type: "text", Text: "Agent run failed: " + err.Error()}}, IsError: true, Meta: meta}, nil
+	}
+	return &mcp.CallToolResult{Content: []mcp.Content{{Type: "text", Text: final}}, Meta: meta}, nil
+}
@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+)
+
+// agentToolsEnv 模型一直不回复的 Agent，通过 AgentTools 调用
+func agentToolsEnv(t *testing.T) (*testEnv, *AgentTools, *blockingProvider) {
+	t.Helper()
+	env := newTestEnv(t, llm.NewScriptedProvider())
+	provider := &blockingProvider{started: make(chan struct{})}
+	env.e.Providers = llm.Fixed(provider)
+	env.store.CreateAgent(&store.Agent{Name: "Coder", SystemPrompt: "You are coder.", ModelName: "test-model", Type: "system", Status: "active"})
+	env.e.Queue.Start()
+	t.Cleanup(env.e.Queue.Stop)
+	return env, &AgentTools{Engine: env.e}, provider
+}
+
+func (env *testEnv) onlyRun(userID string) *store.Run {
+	env.t.Helper()
+	runs := env.store.ListRunsByUser(userID)
+	if len(runs) != 1 {
+		env.t.Fatalf("user %s has %d runs", userID, len(runs))
+	}
+	return runs[0]
+}
+
+func TestAgentToolsCallerCancelCancelsRun(t *testing.T) {
+	env, tools, provider := agentToolsEnv(t)
+	ctx, cancel := context.WithCancel(mcp.WithUser(context.Background(), "alice"))
+	go func() {
+		<-provider.started
+		cancel()
+	}()
+
+	res, err := tools.CallTool(ctx, "coder", map[string]interface{}{"task": "hi"})
+	if err != nil || !res.IsError || !strings.Contains(res.Content[0].Text, ErrRunCancelled.Error()) {
+		t.Fatalf("result = %+v, %v", res, err)
+	}
+	if run := env.onlyRun("alice"); run.Status != "cancelled" || res.Meta["run_id"] != run.ID {
+		t.Fatalf("run = %s, meta = %v", run.Status, res.Meta)
+	}
+}
+
+func TestAgentToolsTimeoutCancelsRun(t *testing.T) {
+	env, tools, _ := agentToolsEnv(t)
+	tools.Timeout = 50 * time.Millisecond
+
+	res, err := tools.CallTool(mcp.WithUser(context.Background(), "alice"), "coder", map[string]interface{}{"task": "hi"})
+	if err != nil || !res.IsError || !strings.Contains(res.Content[0].Text, "timed out after 50ms") {
+		t.Fatalf("result = %+v, %v", res, err)
+	}
+	if run := env.onlyRun("alice"); run.Status != "cancelled" {
+		t.Fatalf("run = %s", run.Status)
+	}
+}
@@ -0,0 +1,245 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+)
+
+// 需要审批的工具（见 mcp.RequiresApproval）被调用时，Run 暂停：
+// 保存待审批的调用，状态改为 awaiting_approval，推送 approval_required 事件后退出执行循环
+// 用户批准 / 修改参数 / 拒绝后，ResumeRunStream 从这个调用继续，拒绝的理由作为工具结果交给模型
+
+// ErrAwaitingApproval Run 暂停等待审批，不是失败
+var ErrAwaitingApproval = errors.New("run is awaiting approval")
+
+// gateToolCall 检查调用是否需要审批
+// 已有决定时返回该审批记录；还没有决定时创建待审批记录并返回 paused = true
+func (e *AgentEngine) gateToolCall(run *store.Run, toolset *mcp.ToolSet, tc llm.ToolCallInfo, tracker *budgetTracker, emit func(RunStreamEvent)) (*store.ToolApproval, bool) {
+	bt, ok := approvalRequired(toolset, tc.Name)
+	if !ok {
+		return nil, false
+	}
+	// 参数本身不合法的调用不用打扰用户，照常执行，由校验把错误交给模型
+	args, err := mcp.ValidateArguments(tc.Name, bt.Tool.InputSchema, tc.Arguments)
+	if err != nil {
+		return nil, false
+	}
+
+	if a := e.Store.FindToolApproval(run.ID, tc.ID); a != nil {
+		if a.Status != "pending" {
+			e.finishApprovalStep(a)
+			return a, false
+		}
+		e.pause(run, a, emit)
+		return a, true
+	}
+
+	step := e.createStep(run, "approval", tc.Name, map[string]interface{}{"tool_call_id": tc.ID, "arguments": args})
+	state := tracker.pauseState()
+	state["step_id"] = step.ID
+	a := e.Store.CreateToolApproval(&store.ToolApproval{
+		RunID:      run.ID,
+		SessionID:  run.SessionID,
+		UserID:     run.UserID,
+		AgentID:    run.AgentID,
+		ServerID:   bt.Server.ID,
+		ToolCallID: tc.ID,
+		ToolName:   tc.Name,
+		Arguments:  args,
+		Status:     "pending",
+		State:      state,
+	})
+	fmt.Printf("[Agent] Run %s paused: %s needs approval (%s)\n", run.ID, tc.Name, a.ID)
+	e.pause(run, a, emit)
+	return a, true
+}
+
+func (e *AgentEngine) pause(run *store.Run, a *store.ToolApproval, emit func(RunStreamEvent)) {
+	e.Store.UpdateRunStatus(run.ID, "awaiting_approval")
+	args, _ := json.Marshal(a.Arguments)
+	emit(RunStreamEvent{Type: "approval_required", Tool: a.ToolName, ToolCallID: a.ToolCallID, ApprovalID: a.ID, Content: string(args)})
+}
+
+// finishApprovalStep 结束暂停时创建的 approval 步骤，耗时即等待审批的时间
+func (e *AgentEngine) finishApprovalStep(a *store.ToolApproval) {
+	stepID, _ := a.State["step_id"].(string)
+	if step := e.Store.GetRunStep(stepID); step != nil && step.Status == "running" {
+		out := map[string]interface{}{"approval_id": a.ID, "decision": a.Status}
+		if a.Status == "edited" {
+			out["edited_arguments"] = a.EditedArguments
+		}
+		if a.Reason != "" {
+			out["reason"] = a.Reason
+		}
+		e.finishStep(step.ID, out, "completed", "")
+	}
+}
+
+// approvalRequired 解析不到的工具执行时会直接报错，不需要审批
+func approvalRequired(toolset *mcp.ToolSet, name string) (*mcp.BoundTool, bool) {
+	if name == ReadToolOutputTool {
+		return nil, false
+	}
+	bt, err := toolset.Resolve(name)
+	if err != nil {
+		return nil, false
+	}
+	return bt, mcp.RequiresApproval(bt.Server, bt.Tool.Name)
+}
+
+// rejectToolCall 用户拒绝：不执行，把拒绝告诉模型
+func (e *AgentEngine) rejectToolCall(run *store.Run, tc llm.ToolCallInfo, a *store.ToolApproval, emit func(RunStreamEvent)) string {
+	output := "The user rejected this tool call; it was not executed."
+	if a.Reason != "" {
+		output += " Reason: " + a.Reason
+	}
+	step := e.createStep(run, "tool_call", tc.Name, a.Arguments)
+	e.finishStep(step.ID, map[string]interface{}{"tool_call_id": tc.ID, "approval_id": a.ID, "approval": a.Status, "output": output}, "rejected", a.Reason)
+	emit(RunStreamEvent{Type: "tool_end", Tool: tc.Name, ToolCallID: tc.ID, Content: output})
+	return output
+}
+
+// unfinishedToolCalls Run 最后一轮里还没有写回结果的工具调用（按模型给出的顺序）
+// 暂停恢复时从这里继续；正常结束的轮次返回空
+func (e *AgentEngine) unfinishedToolCalls(runID string) []llm.ToolCallInfo {
+	msgs := e.Store.ListChatMessagesByRun(runID)
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].CreatedAt.Before(msgs[j].CreatedAt) })
+
+	var calls []llm.ToolCallInfo
+	answered := map[string]bool{}
+	for _, m := range msgs {
+		switch m.Role {
+		case "assistant":
+			if tcs := toolCallsOf(m); len(tcs) > 0 {
+				calls = tcs
+				answered = map[string]bool{}
+			}
+		case "tool":
+			answered[m.ToolCallID] = true
+		}
+	}
+
+	var res []llm.ToolCallInfo
+	for _, tc := range calls {
+		if !answered[tc.ID] {
+			res = append(res, tc)
+		}
+	}
+	return res
+}
+
+// toolCallsOf 还原 Assistant 消息里保存的 tool_calls（内存里是 []map，从数据库读出来是 []interface{}）
+func toolCallsOf(m *store.ChatMessage) []llm.ToolCallInfo {
+	raw, ok := m.Content["tool_calls"]
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var stored []struct {
+		ID       string `json:"id"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil
+	}
+	res := make([]llm.ToolCallInfo, 0, len(stored))
+	for _, s := range stored {
+		res = append(res, llm.ToolCallInfo{ID: s.ID, Name: s.Function.Name, Arguments: s.Function.Arguments})
+	}
+	return res
+}
+
+// ResumeRunStream 审批决定后继续执行暂停的 Run，事件推送规则同 ExecuteRunStream
+// 暂停的是 handoff 出来的子 Run 时，子 Run 结束后依次替等待它的父 Run 收尾
+// 正常由 RunQueue.Resume 在 worker 里调用以遵守并发限制，直接调用时不占队列名额
+func (e *AgentEngine) ResumeRunStream(approvalID string, outCh chan<- RunStreamEvent) {
+	defer close(outCh)
+	emit := func(ev RunStreamEvent) { outCh <- ev }
+
+	a := e.Store.GetToolApproval(approvalID)
+	if a == nil {
+		emit(RunStreamEvent{Type: "error", Content: "approval not found"})
+		return
+	}
+	run := e.Store.GetRun(a.RunID)
+	if run == nil {
+		emit(RunStreamEvent{Type: "error", Content: "run not found", RunID: a.RunID})
+		return
+	}
+	// 等待恢复期间可能已被取消
+	if run.Status != "awaiting_approval" {
+		msg := fmt.Sprintf("run is %s, not awaiting approval", run.Status)
+		if run.Status == "cancelled" {
+			msg = ErrRunCancelled.Error()
+		}
+		emit(RunStreamEvent{Type: "error", Content: msg, RunID: run.ID, AgentID: run.AgentID})
+		return
+	}
+
+	e.Store.UpdateRunStatus(run.ID, "running")
+	final, err := e.execute(e.rootCtx, run.ID, emit)
+
+	for !errors.Is(err, ErrAwaitingApproval) && run.ParentRunID != "" {
+		parent := e.Store.GetRun(run.ParentRunID)
+		if parent == nil || parent.Status != "awaiting_approval" {
+			break
+		}
+		stepID := ""
+		for _, s := range e.Store.ListRunStepsByRun(parent.ID) {
+			if s.StepType == "handoff" && s.Status == "running" {
+				stepID = s.ID
+			}
+		}
+		e.Store.UpdateRunStatus(parent.ID, "running")
+		final, err = e.completeHandoff(parent, stepID, run.AgentID, run, final, err)
+		run = parent
+	}
+	e.endStream(emit, run.ID, final, err)
+}
+
+// cancelAwaiting 取消等待审批的 Run：连同等待它的父 Run、它在等待（或已恢复执行）的子 Run 一起结束
+func (e *AgentEngine) cancelAwaiting(run *store.Run) {
+	root := run
+	for root.ParentRunID != "" {
+		parent := e.Store.GetRun(root.ParentRunID)
+		if parent == nil || parent.Status != "awaiting_approval" {
+			break
+		}
+		root = parent
+	}
+
+	var cancel func(r *store.Run)
+	cancel = func(r *store.Run) {
+		for _, a := range e.Store.ListToolApprovalsByRun(r.ID) {
+			if a.Status == "pending" && e.Store.DecideToolApproval(a.ID, "cancelled", nil, ErrRunCancelled.Error()) {
+				a.Status = "cancelled"
+				e.finishApprovalStep(a)
+			}
+		}
+		e.Store.FinishRun(r.ID, map[string]interface{}{"error": ErrRunCancelled.Error()}, "cancelled")
+		for _, child := range e.Store.ListRunsBySession(r.SessionID) {
+			if child.ParentRunID != r.ID {
+				continue
+			}
+			if child.Status == "awaiting_approval" {
+				cancel(child)
+			} else if val, ok := e.runningRuns.Load(child.ID); ok {
+				val.(context.CancelFunc)()
+			}
+		}
+	}
+	cancel(root)
+}
@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+)
+
+// Agent.ExtraConfig 中挂载的 MCP 资源 / 提示模板，e.g.
+// {"mcp_resources": [{"server_id": "...", "uri": "file:///docs/guide.md"}],
+//
+//	"mcp_prompts": [{"server_id": "...", "name": "code_review", "arguments": {"language": "go"}}]}
+//
+// 资源内容作为上下文、提示模板渲染结果作为系统提示片段，每次 Run 开始时读取一次
+const (
+	cfgMCPResources = "mcp_resources"
+	cfgMCPPrompts   = "mcp_prompts"
+
+	maxResourceChars = 20000 // 单个资源注入的最大字符数，超出截断
+)
+
+// buildAttachments 读取 Agent 挂载的资源和提示模板，拼成追加到系统提示后的文本
+// 只允许引用该 Agent 自己的或全局的 Server；单项失败只记日志并跳过，不影响 Run
+func (e *AgentEngine) buildAttachments(ctx context.Context, agent *store.Agent) string {
+	var prompts, resources []string
+
+	for _, item := range configList(agent.ExtraConfig, cfgMCPPrompts) {
+		serverID, _ := item["server_id"].(string)
+		name, _ := item["name"].(string)
+		server := e.attachableServer(agent, serverID)
+		if server == nil || name == "" {
+			fmt.Printf("[Agent] skip prompt %q: server %s not available\n", name, serverID)
+			continue
+		}
+		args := make(map[string]string)
+		if m, ok := item["arguments"].(map[string]interface{}); ok {
+			for k, v := range m {
+				args[k] = fmt.Sprint(v)
+			}
+		}
+		res, err := e.Executor.GetPrompt(ctx, server, name, args)
+		if err != nil {
+			fmt.Printf("[Agent] skip prompt %q: %v\n", name, err)
+			continue
+		}
+		if text := strings.TrimSpace(res.Text()); text != "" {
+			prompts = append(prompts, text)
+		}
+	}
+
+	for _, item := range configList(agent.ExtraConfig, cfgMCPResources) {
+		serverID, _ := item["server_id"].(string)
+		uri, _ := item["uri"].(string)
+		server := e.attachableServer(agent, serverID)
+		if server == nil || uri == "" {
+			fmt.Printf("[Agent] skip resource %q: server %s not available\n", uri, serverID)
+			continue
+		}
+		res, err := e.Executor.ReadResource(ctx, server, uri)
+		if err != nil {
+			fmt.Printf("[Agent] skip resource %q: %v\n", uri, err)
+			continue
+		}
+		text := res.Text()
+		if r := []rune(text); len(r) > maxResourceChars {
+			text = string(r[:maxResourceChars]) + "\n...(truncated)"
+		}
+		resources = append(resources, fmt.Sprintf("<resource uri=%q>\n%s\n</resource>", uri, text))
+	}
+
+	var sb strings.Builder
+	for _, p := range prompts {
+		sb.WriteString("\n\n" + p)
+	}
+	if len(resources) > 0 {
+		sb.WriteString("\n\n### Attached Resources\nThe following resources are provided as reference context:\n")
+		sb.WriteString(strings.Join(resources, "\n"))
+	}
+	return sb.String()
+}
+
+// attachableServer 该 Agent 能引用的 Server：自己的或平台全局的，且未断开
+func (e *AgentEngine) attachableServer(agent *store.Agent, serverID string) *store.MCPServer {
+	server := e.Store.GetMCPServer(serverID)
+	if server == nil || (server.AgentID != agent.ID && !server.IsGlobal) || !mcp.ServerUsable(server) {
+		return nil
+	}
+	return server
+}
+
+// configList 读取 ExtraConfig 中的对象数组，忽略格式不对的项
+func configList(cfg map[string]interface{}, key string) []map[string]interface{} {
+	var res []map[string]interface{}
+	switch v := cfg[key].(type) {
+	case []interface{}:
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				res = append(res, m)
+			}
+		}
+	case []map[string]interface{}:
+		res = v
+	}
+	return res
+}
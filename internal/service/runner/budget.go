@@ -0,0 +1,259 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// Agent.ExtraConfig 中的预算配置，e.g.
+// {"max_steps": 8, "max_tool_calls": 20, "max_duration_seconds": 300, "max_tokens": 50000, "on_limit": "summary"}
+// 未配置或 <=0 表示不限制（max_steps 除外，默认 5）
+const (
+	cfgMaxSteps    = "max_steps"
+	cfgMaxTools    = "max_tool_calls"
+	cfgMaxDuration = "max_duration_seconds"
+	cfgMaxTokens   = "max_tokens"
+	cfgOnLimit     = "on_limit"
+
+	defaultMaxSteps = 5
+)
+
+// 超出预算后的处理策略
+const (
+	OnLimitFail    = "fail"     // Run 失败（默认）
+	OnLimitSummary = "summary"  // 不带工具再调一次模型，基于已有进展给出尽力而为的回答
+	OnLimitAskUser = "ask_user" // 停下来告诉用户进展，询问是否继续
+)
+
+// 终止原因
+const (
+	LimitSteps     = "max_steps"
+	LimitToolCalls = "max_tool_calls"
+	LimitDuration  = "max_duration"
+	LimitTokens    = "max_tokens"
+)
+
+// Budget 单个 Agent 一次 Run 的资源上限
+type Budget struct {
+	MaxSteps     int
+	MaxToolCalls int
+	MaxDuration  time.Duration
+	MaxTokens    int
+	OnLimit      string
+}
+
+// BudgetForAgent 从 ExtraConfig 读取预算
+func BudgetForAgent(agent *store.Agent) Budget {
+	b := Budget{MaxSteps: defaultMaxSteps, OnLimit: OnLimitFail}
+	if agent == nil {
+		return b
+	}
+	cfg := agent.ExtraConfig
+	if v := configInt(cfg, cfgMaxSteps); v > 0 {
+		b.MaxSteps = v
+	}
+	b.MaxToolCalls = configInt(cfg, cfgMaxTools)
+	b.MaxDuration = time.Duration(configInt(cfg, cfgMaxDuration)) * time.Second
+	b.MaxTokens = configInt(cfg, cfgMaxTokens)
+	switch s, _ := cfg[cfgOnLimit].(string); s {
+	case OnLimitSummary, OnLimitAskUser:
+		b.OnLimit = s
+	}
+	return b
+}
+
+// configInt 兼容 JSON 数字 (float64)、Go 字面量 (int) 和字符串
+func configInt(cfg map[string]interface{}, key string) int {
+	switch v := cfg[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return int(n)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
+
+// budgetTracker 记录一次 Run 已消耗的资源
+type budgetTracker struct {
+	Budget
+	start     time.Time
+	steps     int
+	toolCalls int
+	tokens    int
+}
+
+func newBudgetTracker(b Budget) *budgetTracker {
+	return &budgetTracker{Budget: b, start: time.Now()}
+}
+
+// deadline 用于给本次 Run 的 ctx 加超时；不限时返回零值
+func (t *budgetTracker) deadline() (time.Time, bool) {
+	if t.MaxDuration <= 0 {
+		return time.Time{}, false
+	}
+	return t.start.Add(t.MaxDuration), true
+}
+
+// beforeStep 开始新一轮推理前检查，返回终止原因（空表示可以继续）
+func (t *budgetTracker) beforeStep() string {
+	switch {
+	case t.steps >= t.MaxSteps:
+		return LimitSteps
+	case t.MaxDuration > 0 && time.Since(t.start) >= t.MaxDuration:
+		return LimitDuration
+	case t.MaxTokens > 0 && t.tokens >= t.MaxTokens:
+		return LimitTokens
+	}
+	t.steps++
+	return ""
+}
+
+// beforeToolCall 执行工具前检查
+func (t *budgetTracker) beforeToolCall() string {
+	if t.MaxToolCalls > 0 && t.toolCalls >= t.MaxToolCalls {
+		return LimitToolCalls
+	}
+	if t.MaxDuration > 0 && time.Since(t.start) >= t.MaxDuration {
+		return LimitDuration
+	}
+	t.toolCalls++
+	return ""
+}
+
+// addUsage 累计模型返回的 token 用量
+func (t *budgetTracker) addUsage(usage map[string]int) {
+	if n, ok := usage["total_tokens"]; ok {
+		t.tokens += n
+		return
+	}
+	t.tokens += usage["prompt_tokens"] + usage["completion_tokens"]
+}
+
+// snapshot 写入 OutputPayload / Trace 的消耗与上限
+func (t *budgetTracker) snapshot(reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"reason":     reason,
+		"strategy":   t.OnLimit,
+		"steps":      t.steps,
+		"tool_calls": t.toolCalls,
+		"tokens":     t.tokens,
+		"elapsed_ms": time.Since(t.start).Milliseconds(),
+		"limits": map[string]interface{}{
+			cfgMaxSteps:    t.MaxSteps,
+			cfgMaxTools:    t.MaxToolCalls,
+			cfgMaxDuration: int(t.MaxDuration / time.Second),
+			cfgMaxTokens:   t.MaxTokens,
+		},
+	}
+}
+
+// pauseState Run 暂停（等待审批）时保存的消耗
+func (t *budgetTracker) pauseState() map[string]interface{} {
+	return map[string]interface{}{
+		"steps":      t.steps,
+		"tool_calls": t.toolCalls,
+		"tokens":     t.tokens,
+		"elapsed_ms": time.Since(t.start).Milliseconds(),
+	}
+}
+
+// restore 恢复时接着暂停前的消耗累计；等待审批的时间不计入时长
+func (t *budgetTracker) restore(state map[string]interface{}) {
+	t.steps = configInt(state, "steps")
+	t.toolCalls = configInt(state, "tool_calls")
+	t.tokens = configInt(state, "tokens")
+	t.start = time.Now().Add(-time.Duration(configInt(state, "elapsed_ms")) * time.Millisecond)
+}
+
+func limitDescription(reason string) string {
+	switch reason {
+	case LimitSteps:
+		return "the maximum number of reasoning steps"
+	case LimitToolCalls:
+		return "the maximum number of tool calls"
+	case LimitDuration:
+		return "the time limit"
+	case LimitTokens:
+		return "the token budget"
+	}
+	return "a resource limit"
+}
+
+// terminate 超出预算时按 Agent 的策略收尾：记录 Trace、写消息、结束 Run
+// 返回给用户的回复；策略为 fail 或收尾失败时返回 error
+func (e *AgentEngine) terminate(ctx context.Context, run *store.Run, agent *store.Agent, provider llm.Provider, sel llm.Selection, tracker *budgetTracker, reason string) (string, error) {
+	info := tracker.snapshot(reason)
+	step := e.createStep(run, "termination", reason, info)
+	fmt.Printf("[Agent] Run %s stopped: %s (strategy: %s)\n", run.ID, reason, tracker.OnLimit)
+
+	errMsg := fmt.Sprintf("run stopped: reached %s (%s)", limitDescription(reason), reason)
+	fail := func(msg string) (string, error) {
+		e.finishStep(step.ID, map[string]interface{}{"outcome": "failed"}, "failed", msg)
+		e.Store.FinishRun(run.ID, map[string]interface{}{"error": msg, "termination": info}, "failed")
+		return "", fmt.Errorf("%s", msg)
+	}
+
+	switch tracker.OnLimit {
+	case OnLimitSummary:
+		// 用户已经取消的 Run 不再花一次调用去总结
+		if errors.Is(ctx.Err(), context.Canceled) {
+			e.finishStep(step.ID, map[string]interface{}{"outcome": "cancelled"}, "failed", ErrRunCancelled.Error())
+			e.Store.FinishRun(run.ID, map[string]interface{}{"error": ErrRunCancelled.Error(), "termination": info}, "cancelled")
+			return "", ErrRunCancelled
+		}
+		// 超时的话原 ctx 已经到期，给收尾单独一点时间
+		sumCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 60*time.Second)
+		defer cancel()
+		temperature := sel.Temperature
+		// 不带工具：模型只能给出文字回答；历史里的调用都已有结果，不需要声明工具
+		req := &llm.ChatRequest{
+			SystemPrompt: agent.SystemPrompt + "\n\n" + fmt.Sprintf(
+				"You have reached %s for this task and can no longer call tools. "+
+					"Based only on the conversation so far, summarize what has been done and give the best answer you can. "+
+					"Clearly state anything that remains unfinished.", limitDescription(reason)),
+			Model:       sel.Model,
+			Temperature: &temperature,
+		}
+		// 收尾只裁剪历史，不再生成新的会话摘要
+		e.fitContext(sumCtx, run, agent, provider, sel, e.Store.ListChatMessagesBySession(run.SessionID), req, tracker, false)
+		resp, err := provider.ChatCompletion(sumCtx, req)
+		if err != nil {
+			return fail(errMsg + "; summary failed: " + err.Error())
+		}
+		tracker.addUsage(resp.Usage)
+		usage := e.recordUsage(run.ID, sel, resp.Usage)
+		// 没有给出文字（比如仍然只想调用工具）时按 fail 处理
+		if strings.TrimSpace(resp.Content) == "" {
+			return fail(errMsg + "; summary was empty")
+		}
+		e.saveMessage(run, "assistant", resp.Content, "", usage.CompletionTokens)
+		e.finishStep(step.ID, map[string]interface{}{"outcome": "summary", "response": resp.Content, "usage": usage.toMap()}, "completed", "")
+		e.Store.FinishRun(run.ID, map[string]interface{}{"response": resp.Content, "termination": info}, "succeeded")
+		return resp.Content, nil
+
+	case OnLimitAskUser:
+		text := fmt.Sprintf("I have reached %s for this request (%d steps, %d tool calls so far) and paused before finishing. "+
+			"Reply \"continue\" if you would like me to keep going.", limitDescription(reason), tracker.steps, tracker.toolCalls)
+		e.saveMessage(run, "assistant", text, "", 0)
+		e.finishStep(step.ID, map[string]interface{}{"outcome": "ask_user", "response": text}, "completed", "")
+		e.Store.FinishRun(run.ID, map[string]interface{}{"response": text, "termination": info}, "succeeded")
+		return text, nil
+	}
+	return fail(errMsg)
+}
@@ -0,0 +1,157 @@
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// readTurn 一轮只调用工具的模型输出，用完一步预算
+func readTurn(id string) llm.ScriptedTurn {
+	return llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: id, Name: "fs__read_file", Arguments: `{"path":"a.txt"}`}}, Usage: map[string]int{"prompt_tokens": 10, "completion_tokens": 2}}
+}
+
+// limitedRun Agent 每次 Run 只有一步，第一步调用工具后即超出预算
+func limitedRun(t *testing.T, onLimit string, turns ...llm.ScriptedTurn) (*testEnv, *store.Run, []RunStreamEvent) {
+	t.Helper()
+	env := newTestEnv(t, llm.NewScriptedProvider(append([]llm.ScriptedTurn{readTurn("c1")}, turns...)...))
+	agent := env.agent("coder", map[string]interface{}{"max_steps": 1, "on_limit": onLimit})
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "read a.txt")
+	env.writeFile(run, "a.txt", "A")
+	return env, run, env.stream(run.ID)
+}
+
+func (env *testEnv) terminationStep(runID string) *store.RunStep {
+	env.t.Helper()
+	for _, s := range env.store.ListRunStepsByRun(runID) {
+		if s.StepType == "termination" {
+			return s
+		}
+	}
+	env.t.Fatalf("run %s has no termination step", runID)
+	return nil
+}
+
+func lastEvent(events []RunStreamEvent) RunStreamEvent {
+	if len(events) == 0 {
+		return RunStreamEvent{}
+	}
+	return events[len(events)-1]
+}
+
+func TestBudgetOnLimitFail(t *testing.T) {
+	env, run, events := limitedRun(t, OnLimitFail)
+
+	wantErr := "run stopped: reached the maximum number of reasoning steps (max_steps)"
+	if ev := lastEvent(events); ev.Type != "error" || ev.Content != wantErr {
+		t.Fatalf("last event = %+v", ev)
+	}
+	got := env.store.GetRun(run.ID)
+	if got.Status != "failed" || got.OutputPayload["error"] != wantErr {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	info := got.OutputPayload["termination"].(map[string]interface{})
+	if info["reason"] != LimitSteps || info["strategy"] != OnLimitFail || info["steps"] != 1 || info["tool_calls"] != 1 {
+		t.Fatalf("termination = %v", info)
+	}
+	if step := env.terminationStep(run.ID); step.Status != "failed" || step.OutputPayload["outcome"] != "failed" {
+		t.Fatalf("termination step = %s %v", step.Status, step.OutputPayload)
+	}
+	// 不会再调用模型
+	if len(env.llm.Requests) != 1 {
+		t.Fatalf("model called %d times", len(env.llm.Requests))
+	}
+}
+
+func TestBudgetOnLimitSummary(t *testing.T) {
+	env, run, events := limitedRun(t, OnLimitSummary, llm.ScriptedTurn{Content: "a.txt contains A; nothing else was done.", Usage: map[string]int{"prompt_tokens": 30, "completion_tokens": 8}})
+
+	summary := "a.txt contains A; nothing else was done."
+	if ev := lastEvent(events); ev.Type != "done" || ev.Content != summary {
+		t.Fatalf("last event = %+v", ev)
+	}
+	got := env.store.GetRun(run.ID)
+	if got.Status != "succeeded" || got.OutputPayload["response"] != summary {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	step := env.terminationStep(run.ID)
+	if step.Status != "completed" || step.OutputPayload["outcome"] != "summary" || step.OutputPayload["response"] != summary {
+		t.Fatalf("termination step = %s %v", step.Status, step.OutputPayload)
+	}
+	if !reflect.DeepEqual(env.roles(run.SessionID), []string{"user", "assistant", "tool", "assistant"}) {
+		t.Fatalf("roles = %v", env.roles(run.SessionID))
+	}
+
+	// 收尾请求不带工具，提示里说明已经不能调用工具；历史里带着已完成的调用和结果
+	req := env.llm.Requests[1]
+	if len(req.Tools) != 0 {
+		t.Fatalf("summary request offered %d tools", len(req.Tools))
+	}
+	if !strings.Contains(req.SystemPrompt, "can no longer call tools") {
+		t.Fatalf("system prompt = %q", req.SystemPrompt)
+	}
+	if n := len(req.History); n != 3 || req.History[2].Role != "tool" {
+		t.Fatalf("summary history has %d messages", n)
+	}
+	// 收尾的用量计入 Run
+	if u := usageOf(got.UsageMetadata); u.PromptTokens != 40 || u.CompletionTokens != 10 {
+		t.Fatalf("usage = %v", got.UsageMetadata)
+	}
+}
+
+func TestBudgetOnLimitSummaryFallsBackToFail(t *testing.T) {
+	cases := []struct {
+		name string
+		turn llm.ScriptedTurn
+		err  string
+	}{
+		{"empty", llm.ScriptedTurn{Content: "  "}, "summary was empty"},
+		{"tool calls only", readTurn("c2"), "summary was empty"},
+		{"model error", llm.ScriptedTurn{Error: "overloaded"}, "summary failed: "},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env, run, events := limitedRun(t, OnLimitSummary, c.turn)
+			if ev := lastEvent(events); ev.Type != "error" || !strings.Contains(ev.Content, c.err) {
+				t.Fatalf("last event = %+v", ev)
+			}
+			got := env.store.GetRun(run.ID)
+			if got.Status != "failed" || !strings.HasPrefix(got.OutputPayload["error"].(string), "run stopped: reached the maximum number of reasoning steps") {
+				t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+			}
+			if step := env.terminationStep(run.ID); step.Status != "failed" {
+				t.Fatalf("termination step = %s %v", step.Status, step.OutputPayload)
+			}
+			// 没有写入空的 Assistant 回复，也没有执行第二次工具调用
+			if !reflect.DeepEqual(env.roles(run.SessionID), []string{"user", "assistant", "tool"}) {
+				t.Fatalf("roles = %v", env.roles(run.SessionID))
+			}
+		})
+	}
+}
+
+func TestBudgetOnLimitAskUser(t *testing.T) {
+	env, run, events := limitedRun(t, OnLimitAskUser)
+
+	ev := lastEvent(events)
+	if ev.Type != "done" || !strings.Contains(ev.Content, "(1 steps, 1 tool calls so far)") || !strings.Contains(ev.Content, `Reply "continue"`) {
+		t.Fatalf("last event = %+v", ev)
+	}
+	got := env.store.GetRun(run.ID)
+	if got.Status != "succeeded" || got.OutputPayload["response"] != ev.Content {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	if step := env.terminationStep(run.ID); step.Status != "completed" || step.OutputPayload["outcome"] != "ask_user" {
+		t.Fatalf("termination step = %s %v", step.Status, step.OutputPayload)
+	}
+	if len(env.llm.Requests) != 1 {
+		t.Fatalf("model called %d times", len(env.llm.Requests))
+	}
+	if roles := env.roles(run.SessionID); roles[len(roles)-1] != "assistant" {
+		t.Fatalf("roles = %v", roles)
+	}
+}
@@ -0,0 +1,473 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// 上下文窗口管理
+// 每次请求 LLM 前按模型的上下文窗口裁剪会话历史，超出时依次：
+//  1. 把之前 Run 的工具输出替换成一行说明（从最早的开始；原文仍在消息表里，截断过的可用 read_tool_output 读回）
+//  2. 仍然超出时，让模型把当前轮次之前的对话合并进 ChatSession.Summary（滚动摘要），之后只发送摘要之后的消息
+//  3. 还不够时，当前 Run 较早的工具输出也替换，最后丢弃当前轮次之前最早的消息
+//
+// 一条带 tool_calls 的 Assistant 消息和它的 tool 结果作为一个整体保留或丢弃，缺少结果的调用补一条说明，
+// 找不到对应调用的 tool 结果不发送，保证发给模型的 tool_call / tool 结果总是成对的
+//
+// 窗口大小：Agent.ExtraConfig["context_window"] > Provider 的 context_window > 默认 32768
+// Token 数按字符估算（ASCII 约 4 个字符一个 Token，其它字符一个一个 Token），不依赖具体模型的分词器
+const (
+	cfgContextWindow = "context_window"
+
+	defaultContextWindow = 32768
+
+	// 给模型回复预留的 Token：窗口的 1/4，最多 4096
+	maxReplyReserve = 4096
+	// 每条消息的格式开销
+	messageOverhead = 4
+	// 摘要的长度上限（词），按窗口的 1/16 在这个区间内取
+	minSummaryWords = 256
+	maxSummaryWords = 2048
+	// 摘要输入里单条消息的最大字符数，工具输出只保留开头
+	summaryToolChars    = 2000
+	summaryMessageChars = 8000
+
+	omittedToolOutput = "[Earlier tool output omitted to fit the context window (%d characters).%s]"
+	missingToolResult = "[No result was recorded for this tool call.]"
+)
+
+// ContextWindowFor Agent 使用的模型上下文窗口（Token）
+func ContextWindowFor(agent *store.Agent, sel llm.Selection) int {
+	if agent != nil {
+		if v := configInt(agent.ExtraConfig, cfgContextWindow); v > 0 {
+			return v
+		}
+	}
+	if sel.ContextWindow > 0 {
+		return sel.ContextWindow
+	}
+	return defaultContextWindow
+}
+
+// contextUnit 历史里不可拆分的一段：一条消息，或一条带 tool_calls 的 Assistant 消息及其 tool 结果
+type contextUnit struct {
+	msgs   []*store.ChatMessage
+	start  int  // 第一条消息在 recent 中的下标
+	user   bool // 用户消息，开始一个新的轮次
+	tools  bool // 带 tool_calls
+	runID  string
+	tokens int
+}
+
+func (u *contextUnit) recount() {
+	u.tokens = 0
+	for _, m := range u.msgs {
+		u.tokens += messageTokens(m)
+	}
+}
+
+// fitContext 把会话历史裁剪到模型的上下文窗口内，写入 req.History；有滚动摘要时追加到 req.SystemPrompt
+// req 的 SystemPrompt / Tools / HandoffCandidates 需已填好；summarize 为 false 时只裁剪，不请求模型生成摘要
+func (e *AgentEngine) fitContext(ctx context.Context, run *store.Run, agent *store.Agent, provider llm.Provider, sel llm.Selection, history []*store.ChatMessage, req *llm.ChatRequest, tracker *budgetTracker, summarize bool) {
+	session := e.Store.GetChatSession(run.SessionID)
+	var summary, upTo string
+	if session != nil {
+		summary, upTo = session.Summary, session.SummaryUpTo
+	}
+	window := ContextWindowFor(agent, sel)
+	budget := historyBudget(window, req, summary)
+
+	recent := messagesAfter(history, upTo)
+	units := groupUnits(recent)
+	total := unitsTokens(units)
+
+	// 1. 之前 Run 的工具输出
+	if total > budget {
+		total -= trimToolOutputs(units, budget, total, func(u *contextUnit) bool { return u.runID != run.ID })
+	}
+
+	// 2. 当前轮次之前的对话合并进滚动摘要
+	if total > budget && summarize {
+		if cut := summaryCut(units, budget/2); cut > 0 {
+			if s, ok := e.summarizeHistory(ctx, run, provider, sel, tracker, summary, recent, units, cut, window, total, budget); ok {
+				summary, units = s, units[cut:]
+				budget = historyBudget(window, req, summary)
+				total = unitsTokens(units)
+			}
+		}
+	}
+
+	// 3. 当前 Run 的工具输出（最后一组结果除外，模型这一轮正要用到），仍然不够就丢弃最早的消息
+	if total > budget {
+		last := lastToolUnit(units)
+		total -= trimToolOutputs(units, budget, total, func(u *contextUnit) bool { return u != last })
+	}
+	if total > budget {
+		drop, dropped := 0, 0
+		for last := lastUserUnit(units); drop < last && total > budget; drop++ {
+			total -= units[drop].tokens
+			dropped += len(units[drop].msgs)
+		}
+		if drop > 0 {
+			units = units[drop:]
+			fmt.Printf("[Agent] Run %s: dropped %d early message(s) to fit the context window (%d tokens)\n", run.ID, dropped, window)
+		}
+	}
+
+	req.History = flattenUnits(units)
+	if summary != "" {
+		req.SystemPrompt += "\n\n" + summaryPrompt(summary)
+	}
+}
+
+// historyBudget 窗口减去回复预留、系统提示、工具定义、handoff 候选和摘要之后留给历史消息的 Token
+func historyBudget(window int, req *llm.ChatRequest, summary string) int {
+	reserve := window / 4
+	if reserve > maxReplyReserve {
+		reserve = maxReplyReserve
+	}
+	fixed := estimateTokens(req.SystemPrompt) + messageOverhead
+	for _, t := range req.Tools {
+		b, _ := json.Marshal(t.InputSchema)
+		fixed += estimateTokens(t.Name) + estimateTokens(t.Description) + estimateTokens(string(b)) + messageOverhead
+	}
+	if len(req.HandoffCandidates) > 0 || req.ForceHandoff {
+		// handoff 说明本身约 200 Token
+		fixed += 200
+		for _, c := range req.HandoffCandidates {
+			fixed += estimateTokens(c.Name) + estimateTokens(c.Description) + messageOverhead
+		}
+	}
+	if summary != "" {
+		fixed += estimateTokens(summaryPrompt(summary))
+	}
+	return window - reserve - fixed
+}
+
+// messagesAfter 摘要覆盖的消息之后的历史；找不到摘要的位置（消息已删除）时返回全部
+func messagesAfter(history []*store.ChatMessage, upTo string) []*store.ChatMessage {
+	if upTo == "" {
+		return history
+	}
+	for i, m := range history {
+		if m.ID == upTo {
+			return history[i+1:]
+		}
+	}
+	return history
+}
+
+// groupUnits 把历史按 tool_call / tool 结果配对分段
+func groupUnits(recent []*store.ChatMessage) []*contextUnit {
+	var units []*contextUnit
+	var open *contextUnit
+	var calls []llm.ToolCallInfo
+	pending := map[string]bool{}
+	closeOpen := func() {
+		if open == nil {
+			return
+		}
+		for _, tc := range calls {
+			if pending[tc.ID] {
+				open.msgs = append(open.msgs, &store.ChatMessage{
+					SessionID:  open.msgs[0].SessionID,
+					RunID:      open.runID,
+					Role:       "tool",
+					Content:    map[string]interface{}{"type": "text", "text": missingToolResult},
+					ToolCallID: tc.ID,
+					CreatedAt:  open.msgs[0].CreatedAt,
+				})
+			}
+		}
+		open.recount()
+		open, calls, pending = nil, nil, map[string]bool{}
+	}
+
+	for i, m := range recent {
+		if m.Role == "tool" {
+			if open != nil && pending[m.ToolCallID] {
+				delete(pending, m.ToolCallID)
+				open.msgs = append(open.msgs, m)
+			}
+			// 找不到对应调用的结果（调用已被摘要覆盖或丢失）不发送
+			continue
+		}
+		closeOpen()
+		u := &contextUnit{msgs: []*store.ChatMessage{m}, start: i, user: m.Role == "user", runID: m.RunID}
+		units = append(units, u)
+		if m.Role == "assistant" {
+			if calls = toolCallsOf(m); len(calls) > 0 {
+				u.tools = true
+				open = u
+				for _, tc := range calls {
+					pending[tc.ID] = true
+				}
+				continue
+			}
+		}
+		u.recount()
+	}
+	closeOpen()
+	return units
+}
+
+// trimToolOutputs 从最早的开始把 eligible 段里的工具输出换成说明，直到不超过 budget，返回省下的 Token
+func trimToolOutputs(units []*contextUnit, budget, total int, eligible func(*contextUnit) bool) int {
+	saved := 0
+	for _, u := range units {
+		if total-saved <= budget {
+			break
+		}
+		if !u.tools || !eligible(u) {
+			continue
+		}
+		for i, m := range u.msgs {
+			if m.Role != "tool" || total-saved <= budget {
+				continue
+			}
+			text := messageText(m)
+			hint := ""
+			if id, _ := m.Content["output_id"].(string); id != "" {
+				hint = fmt.Sprintf(" The full output is stored as output_id=%q and can be read with %s.", id, ReadToolOutputTool)
+			}
+			placeholder := fmt.Sprintf(omittedToolOutput, len([]rune(text)), hint)
+			if estimateTokens(placeholder) >= estimateTokens(text) {
+				continue
+			}
+			before := messageTokens(m)
+			trimmed := *m
+			trimmed.Content = map[string]interface{}{"type": "text", "text": placeholder}
+			u.msgs[i] = &trimmed
+			saved += before - messageTokens(&trimmed)
+		}
+		u.recount()
+	}
+	return saved
+}
+
+// summaryCut 选择摘要的分界：最早的一个用户消息，使其后的历史不超过 target；都不满足时为当前轮次的开始
+// 返回 0 表示当前轮次之前没有可以摘要的内容
+func summaryCut(units []*contextUnit, target int) int {
+	last := lastUserUnit(units)
+	if last <= 0 {
+		return 0
+	}
+	remaining := unitsTokens(units)
+	for i := 0; i < last; i++ {
+		remaining -= units[i].tokens
+		if units[i+1].user && remaining <= target {
+			return i + 1
+		}
+	}
+	return last
+}
+
+// summarizeHistory 请求模型把 units[:cut] 合并进已有摘要，保存到会话并记录一个 context_summary 步骤
+// 原始消息取自 recent（不受工具输出裁剪影响）；输入超出窗口时分段依次合并
+func (e *AgentEngine) summarizeHistory(ctx context.Context, run *store.Run, provider llm.Provider, sel llm.Selection, tracker *budgetTracker, summary string, recent []*store.ChatMessage, units []*contextUnit, cut, window, total, budget int) (string, bool) {
+	covered := recent[:units[cut].start]
+	upTo := covered[len(covered)-1].ID
+	step := e.createStep(run, "context_summary", sel.Model, map[string]interface{}{
+		"provider":         sel.Provider,
+		"model":            sel.Model,
+		"context_window":   window,
+		"history_budget":   budget,
+		"estimated_tokens": total,
+		"messages":         len(covered),
+		"summary_up_to":    upTo,
+		"had_summary":      summary != "",
+	})
+
+	maxWords := window / 16
+	if maxWords < minSummaryWords {
+		maxWords = minSummaryWords
+	}
+	if maxWords > maxSummaryWords {
+		maxWords = maxSummaryWords
+	}
+	var usage TokenUsage
+	temperature := sel.Temperature
+	lines := transcriptLines(covered)
+	for start := 0; start < len(lines); {
+		// 每次请求里留给新消息的 Token：窗口减去指令、已有摘要和新摘要（按一个词两个 Token 估）
+		// 分段合并时摘要每轮都会变，所以每段重新计算
+		pieceBudget := summaryPieceBudget(window, maxWords, summary)
+		var piece strings.Builder
+		n := 0
+		end := start
+		for end < len(lines) && (end == start || n+estimateTokens(lines[end]) <= pieceBudget) {
+			piece.WriteString(lines[end])
+			n += estimateTokens(lines[end])
+			end++
+		}
+		prompt := "Existing summary:\n"
+		if summary == "" {
+			prompt += "(none)"
+		} else {
+			prompt += summary
+		}
+		prompt += "\n\nNew messages:\n" + piece.String()
+
+		resp, err := provider.ChatCompletion(ctx, &llm.ChatRequest{
+			SystemPrompt: summarizerPrompt(maxWords),
+			UserPrompt:   prompt,
+			Model:        sel.Model,
+			Temperature:  &temperature,
+		})
+		if err != nil {
+			e.finishStep(step.ID, map[string]interface{}{"usage": usage.toMap()}, "failed", err.Error())
+			fmt.Printf("[Agent] Run %s: context summary failed: %v\n", run.ID, err)
+			return "", false
+		}
+		tracker.addUsage(resp.Usage)
+		usage.add(e.recordUsage(run.ID, sel, resp.Usage))
+		if s := strings.TrimSpace(resp.Content); s != "" {
+			summary = s
+		}
+		start = end
+	}
+
+	e.Store.UpdateSessionSummary(run.SessionID, summary, upTo)
+	kept := 0
+	for _, u := range units[cut:] {
+		kept += len(u.msgs)
+	}
+	e.finishStep(step.ID, map[string]interface{}{
+		"summary":       summary,
+		"summary_chars": len([]rune(summary)),
+		"kept_messages": kept,
+		"usage":         usage.toMap(),
+	}, "completed", "")
+	fmt.Printf("[Agent] Run %s: summarized %d message(s) into the session summary\n", run.ID, len(covered))
+	return summary, true
+}
+
+// summaryPieceBudget 一次摘要请求里留给新消息的 Token
+func summaryPieceBudget(window, maxWords int, summary string) int {
+	return window - estimateTokens(summarizerPrompt(maxWords)) - estimateTokens(summary) - maxWords*2
+}
+
+// summarizerPrompt 生成摘要的系统提示
+func summarizerPrompt(maxWords int) string {
+	return fmt.Sprintf("You maintain a running summary of a conversation between a user and an AI assistant that can call tools. "+
+		"Merge the existing summary and the new messages into one updated summary. "+
+		"Keep the user's goals and requirements, decisions made, important facts, names, numbers, file paths, "+
+		"tool results that later work depends on, and anything still unfinished. Drop small talk and redundant detail. "+
+		"Write in the language of the conversation, in at most %d words. Output only the summary.", maxWords)
+}
+
+// summaryPrompt 注入系统提示的摘要
+func summaryPrompt(summary string) string {
+	return "Summary of the earlier conversation (those messages are no longer shown):\n" + summary
+}
+
+// transcriptLines 把消息渲染成摘要输入的纯文本，每条一行（段）
+func transcriptLines(msgs []*store.ChatMessage) []string {
+	lines := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		text := messageText(m)
+		switch m.Role {
+		case "tool":
+			lines = append(lines, fmt.Sprintf("[tool result %s]\n%s\n\n", m.ToolCallID, clipRunes(text, summaryToolChars)))
+		case "assistant":
+			var b strings.Builder
+			if text != "" {
+				b.WriteString("[assistant]\n" + clipRunes(text, summaryMessageChars) + "\n")
+			}
+			for _, tc := range toolCallsOf(m) {
+				fmt.Fprintf(&b, "[assistant calls tool %s, id %s] %s\n", tc.Name, tc.ID, clipRunes(tc.Arguments, summaryToolChars))
+			}
+			lines = append(lines, b.String()+"\n")
+		default:
+			lines = append(lines, fmt.Sprintf("[%s]\n%s\n\n", m.Role, clipRunes(text, summaryMessageChars)))
+		}
+	}
+	return lines
+}
+
+func clipRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + fmt.Sprintf(" …[%d more characters]", len(r)-n)
+}
+
+// messageText 消息的文本部分，取法同发送给模型时一致
+func messageText(m *store.ChatMessage) string {
+	if s, ok := m.Content["text"].(string); ok {
+		return s
+	}
+	if s, ok := m.Content["content"].(string); ok {
+		return s
+	}
+	if m.Role == "assistant" && len(toolCallsOf(m)) > 0 {
+		return ""
+	}
+	if len(m.Content) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(m.Content)
+	return string(b)
+}
+
+// messageTokens 估算一条消息占用的 Token（含 tool_calls）
+func messageTokens(m *store.ChatMessage) int {
+	n := messageOverhead + estimateTokens(messageText(m))
+	for _, tc := range toolCallsOf(m) {
+		n += messageOverhead + estimateTokens(tc.ID) + estimateTokens(tc.Name) + estimateTokens(tc.Arguments)
+	}
+	return n
+}
+
+// estimateTokens 粗略估算文本的 Token 数：ASCII 约 4 个字符一个，其它字符（中文等）一个一个
+func estimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+func unitsTokens(units []*contextUnit) int {
+	n := 0
+	for _, u := range units {
+		n += u.tokens
+	}
+	return n
+}
+
+func lastUserUnit(units []*contextUnit) int {
+	for i := len(units) - 1; i >= 0; i-- {
+		if units[i].user {
+			return i
+		}
+	}
+	return -1
+}
+
+func lastToolUnit(units []*contextUnit) *contextUnit {
+	for i := len(units) - 1; i >= 0; i-- {
+		if units[i].tools {
+			return units[i]
+		}
+	}
+	return nil
+}
+
+func flattenUnits(units []*contextUnit) []*store.ChatMessage {
+	var res []*store.ChatMessage
+	for _, u := range units {
+		res = append(res, u.msgs...)
+	}
+	return res
+}
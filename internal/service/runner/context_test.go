@@ -0,0 +1,309 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// msgs 按顺序编号的一段历史，ID 为 m0、m1……
+func msgs(list ...*store.ChatMessage) []*store.ChatMessage {
+	for i, m := range list {
+		m.ID = fmt.Sprintf("m%d", i)
+		m.SessionID = "session-1"
+	}
+	return list
+}
+
+func userMsg(text string) *store.ChatMessage {
+	return &store.ChatMessage{Role: "user", Content: map[string]interface{}{"type": "text", "text": text}}
+}
+
+func assistantMsg(runID, text string) *store.ChatMessage {
+	return &store.ChatMessage{RunID: runID, Role: "assistant", Content: map[string]interface{}{"type": "text", "text": text}}
+}
+
+// callMsg 带 tool_calls 的 Assistant 消息，存法同 runLoop
+func callMsg(runID string, ids ...string) *store.ChatMessage {
+	calls := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		calls = append(calls, map[string]interface{}{"id": id, "type": "function", "function": map[string]interface{}{"name": "fs__read_file", "arguments": "{}"}})
+	}
+	return &store.ChatMessage{RunID: runID, Role: "assistant", Content: map[string]interface{}{"text": "", "tool_calls": calls}}
+}
+
+func toolMsg(runID, callID, text string) *store.ChatMessage {
+	return &store.ChatMessage{RunID: runID, Role: "tool", ToolCallID: callID, Content: map[string]interface{}{"type": "text", "text": text}}
+}
+
+// tokens 估算为 n 个 Token 的 ASCII 文本
+func tokens(n int) string {
+	return strings.Repeat("x", n*4)
+}
+
+func TestGroupUnits(t *testing.T) {
+	history := msgs(
+		userMsg("q1"),
+		callMsg("run-1", "c1", "c2"),
+		toolMsg("run-1", "c2", "B"), // 结果和调用的顺序可以不同
+		toolMsg("run-1", "c1", "A"),
+		userMsg("q2"),
+		callMsg("run-2", "c3", "c4"),
+		toolMsg("run-2", "c3", "C"), // c4 没有结果
+		toolMsg("run-2", "c9", "orphan"),
+		assistantMsg("run-2", "done"),
+		toolMsg("run-2", "c4", "too late"), // 调用所在的段已经结束
+	)
+	units := groupUnits(history)
+
+	type unit struct {
+		start       int
+		user, tools bool
+		ids         []string
+	}
+	var got []unit
+	for _, u := range units {
+		g := unit{start: u.start, user: u.user, tools: u.tools}
+		for _, m := range u.msgs {
+			g.ids = append(g.ids, m.ID)
+		}
+		got = append(got, g)
+		if u.tokens == 0 {
+			t.Fatalf("unit at %d not counted", u.start)
+		}
+	}
+	want := []unit{
+		{start: 0, user: true, ids: []string{"m0"}},
+		{start: 1, tools: true, ids: []string{"m1", "m2", "m3"}},
+		{start: 4, user: true, ids: []string{"m4"}},
+		// 缺少结果的 c4 补一条说明（ID 为空），孤立的 c9 / 迟到的 c4 结果都不发送
+		{start: 5, tools: true, ids: []string{"m5", "m6", ""}},
+		{start: 8, ids: []string{"m8"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("units =\n%+v\nwant\n%+v", got, want)
+	}
+
+	filled := units[3].msgs[2]
+	if filled.Role != "tool" || filled.ToolCallID != "c4" || filled.RunID != "run-2" || messageText(filled) != missingToolResult {
+		t.Fatalf("synthesized result = %+v", filled)
+	}
+	// 每个 tool_call 恰好有一个结果
+	for _, u := range units {
+		if !u.tools {
+			continue
+		}
+		var results []string
+		for _, m := range u.msgs[1:] {
+			results = append(results, m.ToolCallID)
+		}
+		var calls []string
+		for _, tc := range toolCallsOf(u.msgs[0]) {
+			calls = append(calls, tc.ID)
+		}
+		if len(results) != len(calls) {
+			t.Fatalf("calls %v paired with results %v", calls, results)
+		}
+	}
+}
+
+func TestSummaryCut(t *testing.T) {
+	// u(10) a(10) u(10) a(10) u(10) a(5)，共 55
+	units := []*contextUnit{
+		{user: true, tokens: 10}, {tokens: 10},
+		{user: true, tokens: 10}, {tokens: 10},
+		{user: true, tokens: 10}, {tokens: 5},
+	}
+	cases := []struct {
+		target int
+		want   int
+	}{
+		{target: 40, want: 2}, // 从第二轮开始剩 35
+		{target: 35, want: 2},
+		{target: 34, want: 4}, // 只能留当前轮次
+		{target: 1, want: 4},  // 都不满足时也只摘要到当前轮次之前
+	}
+	for _, c := range cases {
+		if got := summaryCut(units, c.target); got != c.want {
+			t.Errorf("summaryCut(target=%d) = %d, want %d", c.target, got, c.want)
+		}
+	}
+
+	// 只有当前轮次时没有可以摘要的内容
+	if got := summaryCut(units[4:], 1); got != 0 {
+		t.Errorf("single turn cut = %d", got)
+	}
+	if got := summaryCut([]*contextUnit{{tokens: 3}}, 1); got != 0 {
+		t.Errorf("no user message cut = %d", got)
+	}
+}
+
+func TestSummaryPieceBudget(t *testing.T) {
+	base := summaryPieceBudget(4096, 256, "")
+	if want := 4096 - estimateTokens(summarizerPrompt(256)) - 512; base != want {
+		t.Fatalf("budget without summary = %d, want %d", base, want)
+	}
+	// 已有摘要也占输入
+	if got := summaryPieceBudget(4096, 256, tokens(100)); got != base-100 {
+		t.Fatalf("budget with a 100-token summary = %d, want %d", got, base-100)
+	}
+}
+
+// fitEnv 用指定上下文窗口裁剪历史，系统提示为 "sys"（占 5）
+type fitEnv struct {
+	*testEnv
+	agent   *store.Agent
+	session *store.ChatSession
+	run     *store.Run
+}
+
+func newFitEnv(t *testing.T, window int, turns ...llm.ScriptedTurn) *fitEnv {
+	env := newTestEnv(t, llm.NewScriptedProvider(turns...))
+	agent := env.agent("coder", map[string]interface{}{cfgContextWindow: window})
+	session := env.store.CreateChatSession(&store.ChatSession{UserID: "user-1", AgentID: agent.ID, Title: "test"})
+	run, err := env.store.CreateRun(&store.Run{SessionID: session.ID, UserID: "user-1", AgentID: agent.ID, Status: "running"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fitEnv{testEnv: env, agent: agent, session: session, run: run}
+}
+
+func (f *fitEnv) fit(history []*store.ChatMessage, summarize bool) *llm.ChatRequest {
+	for _, m := range history {
+		m.SessionID = f.session.ID
+	}
+	req := &llm.ChatRequest{SystemPrompt: "sys"}
+	sel := llm.Selection{Provider: "fixed", Model: "test-model"}
+	f.e.fitContext(context.Background(), f.run, f.agent, f.llm, sel, history, req, newBudgetTracker(BudgetForAgent(f.agent)), summarize)
+	return req
+}
+
+func historyIDs(req *llm.ChatRequest) []string {
+	var ids []string
+	for _, m := range req.History {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func isOmitted(m *store.ChatMessage) bool {
+	return strings.HasPrefix(messageText(m), "[Earlier tool output omitted")
+}
+
+func TestFitContextWithinWindow(t *testing.T) {
+	f := newFitEnv(t, 400)
+	history := msgs(userMsg("q1"), callMsg(f.run.ID, "c1"), toolMsg(f.run.ID, "c1", tokens(50)), assistantMsg(f.run.ID, "done"))
+	req := f.fit(history, true)
+	if !reflect.DeepEqual(historyIDs(req), []string{"m0", "m1", "m2", "m3"}) || isOmitted(req.History[2]) {
+		t.Fatalf("history = %v", historyIDs(req))
+	}
+	if req.SystemPrompt != "sys" || len(f.llm.Requests) != 0 {
+		t.Fatalf("system prompt = %q, %d summary calls", req.SystemPrompt, len(f.llm.Requests))
+	}
+}
+
+func TestFitContextTrimsEarlierRunsFirst(t *testing.T) {
+	// 窗口 400：回复预留 100，留给历史 295；共约 450，只替换上一个 Run 的工具输出就够了
+	f := newFitEnv(t, 400)
+	history := msgs(
+		userMsg("q1"),
+		callMsg("run-old", "c-old"),
+		toolMsg("run-old", "c-old", tokens(200)),
+		assistantMsg("run-old", "ok"),
+		userMsg("q2"),
+		callMsg(f.run.ID, "c-new"),
+		toolMsg(f.run.ID, "c-new", tokens(200)),
+	)
+	req := f.fit(history, true)
+	if len(req.History) != 7 || !isOmitted(req.History[2]) || isOmitted(req.History[6]) {
+		t.Fatalf("history = %v", req.History)
+	}
+	if !strings.Contains(messageText(req.History[2]), "800 characters") || req.History[2].ToolCallID != "c-old" {
+		t.Fatalf("placeholder = %+v", req.History[2])
+	}
+	// 原消息不受影响，也没有生成摘要
+	if isOmitted(history[2]) || len(f.llm.Requests) != 0 {
+		t.Fatalf("history mutated or summarized (%d calls)", len(f.llm.Requests))
+	}
+}
+
+func TestFitContextSummarizesEarlierTurns(t *testing.T) {
+	// 窗口 1200：留给历史 895；摘要上限 256 词，每次摘要请求留给新消息约 580
+	f := newFitEnv(t, 1200,
+		llm.ScriptedTurn{Content: "The user asked q1."},
+		llm.ScriptedTurn{Content: "The user asked q1 and got a long answer."},
+	)
+	history := msgs(
+		userMsg("q1"),
+		callMsg("run-old", "c-old"),
+		toolMsg("run-old", "c-old", tokens(600)),
+		assistantMsg("run-old", tokens(400)),
+		userMsg("q2"),
+		callMsg(f.run.ID, "c-new"),
+		toolMsg(f.run.ID, "c-new", tokens(500)),
+	)
+	req := f.fit(history, true)
+
+	// 替换旧工具输出后仍然超出，上一轮整体并入摘要，当前轮次原样保留
+	if !reflect.DeepEqual(historyIDs(req), []string{"m4", "m5", "m6"}) || isOmitted(req.History[2]) {
+		t.Fatalf("history = %v", historyIDs(req))
+	}
+	if !strings.HasSuffix(req.SystemPrompt, summaryPrompt("The user asked q1 and got a long answer.")) {
+		t.Fatalf("system prompt = %q", req.SystemPrompt)
+	}
+
+	// 一次放不下，分两段合并：第二段带着第一段的摘要，请求都不超出窗口
+	if len(f.llm.Requests) != 2 {
+		t.Fatalf("%d summary calls", len(f.llm.Requests))
+	}
+	first, second := f.llm.Requests[0].UserPrompt, f.llm.Requests[1].UserPrompt
+	// 摘要输入用原始消息（工具输出截到 summaryToolChars），不是裁剪后的占位说明
+	if !strings.Contains(first, "Existing summary:\n(none)") || !strings.Contains(first, "[user]\nq1") || !strings.Contains(first, tokens(500)) || strings.Contains(first, "omitted") {
+		t.Fatalf("first summary prompt = %q", first)
+	}
+	if !strings.Contains(second, "Existing summary:\nThe user asked q1.") || !strings.Contains(second, "[assistant]\n"+tokens(400)) || strings.Contains(second, "q2") {
+		t.Fatalf("second summary prompt = %q", second)
+	}
+	for i, r := range f.llm.Requests {
+		if n := estimateTokens(r.SystemPrompt) + estimateTokens(r.UserPrompt) + 256*2; n > 1200 {
+			t.Errorf("summary request %d needs %d tokens", i, n)
+		}
+	}
+
+	session := f.store.GetChatSession(f.session.ID)
+	if session.Summary != "The user asked q1 and got a long answer." || session.SummaryUpTo != "m3" {
+		t.Fatalf("session summary = %q up to %q", session.Summary, session.SummaryUpTo)
+	}
+	if types := f.stepTypes(f.run.ID); !reflect.DeepEqual(types, []string{"context_summary"}) {
+		t.Fatalf("steps = %v", types)
+	}
+}
+
+func TestFitContextTrimsCurrentRunThenDrops(t *testing.T) {
+	f := newFitEnv(t, 400)
+	history := msgs(
+		userMsg("q1"),
+		assistantMsg("run-old", tokens(150)),
+		userMsg("q2"),
+		callMsg(f.run.ID, "c1"),
+		toolMsg(f.run.ID, "c1", tokens(200)),
+		callMsg(f.run.ID, "c2"),
+		toolMsg(f.run.ID, "c2", tokens(200)),
+	)
+	// 不生成摘要时：先替换当前 Run 较早的工具输出，仍然不够再丢弃当前轮次之前的消息；最后一组结果始终保留
+	req := f.fit(history, false)
+	if !reflect.DeepEqual(historyIDs(req), []string{"m2", "m3", "m4", "m5", "m6"}) {
+		t.Fatalf("history = %v", historyIDs(req))
+	}
+	if !isOmitted(req.History[2]) || isOmitted(req.History[4]) {
+		t.Fatalf("c1 omitted = %v, c2 omitted = %v", isOmitted(req.History[2]), isOmitted(req.History[4]))
+	}
+	if len(f.llm.Requests) != 0 {
+		t.Fatalf("%d summary calls", len(f.llm.Requests))
+	}
+}
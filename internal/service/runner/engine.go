@@ -2,7 +2,7 @@ package runner
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -12,21 +12,29 @@ import (
 
 	"example.com/agent-server/internal/service/llm"
 	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/service/workspace"
 	"example.com/agent-server/internal/store"
 )
 
 // AgentEngine 负责编排一次 Run 的全过程
 type AgentEngine struct {
 	Store       store.Store
-	LLMClient   *llm.Client
-	runningRuns sync.Map        // map[string]context.CancelFunc
-	rootCtx     context.Context // 全局根上下文
+	Providers   llm.ProviderSource // 按 Agent 选择 LLM 端点 / 模型 / 温度
+	Executor    *mcp.Executor      // 工具执行器（持有 MCP 连接池，应与 MCPService 共享）
+	Workspaces  *workspace.Manager // 会话工作区，内置 git / filesystem 工具在其中执行
+	Queue       *RunQueue          // 对话发起的 Run 排队执行，需调用 Queue.Start 启动
+	Prices      PriceTable         // 模型价格，用于折算花费和预算；为空时花费都是 0
+	runningRuns sync.Map           // map[string]context.CancelFunc
+	rootCtx     context.Context    // 全局根上下文
 }
 
-func NewEngine(s store.Store, c *llm.Client) *AgentEngine {
-	e := &AgentEngine{Store: s, runningRuns: sync.Map{}, rootCtx: context.Background()}
-	if c != nil {
-		e.LLMClient = c
+// NewEngine providers 通常是 *llm.Registry；离线测试可传 llm.Fixed(llm.NewScriptedProvider(...))
+// 为 nil 时按 LLM_* 环境变量构造默认 Provider
+func NewEngine(s store.Store, providers llm.ProviderSource) *AgentEngine {
+	e := &AgentEngine{Store: s, Executor: mcp.NewExecutor(s), Workspaces: workspace.NewManager(workspace.DefaultRoot()), runningRuns: sync.Map{}, rootCtx: context.Background()}
+	e.Queue = NewRunQueue(e)
+	if providers != nil {
+		e.Providers = providers
 		return e
 	}
 	apiKey := os.Getenv("LLM_API_KEY")
@@ -48,21 +56,121 @@ func NewEngine(s store.Store, c *llm.Client) *AgentEngine {
 			temperature = float32(v)
 		}
 	}
-	cfg := llm.LLMConfig{ApiKey: apiKey, BaseURL: baseURL, ModelName: model, Temperature: temperature}
-	return &AgentEngine{Store: s, LLMClient: llm.NewClient(cfg)}
+	registry := llm.NewRegistry()
+	_ = registry.Register(llm.ProviderConfig{Name: "default", ApiKey: apiKey, BaseURL: baseURL, DefaultModel: model, Temperature: temperature})
+	e.Providers = registry
+	return e
 }
 
-// ExecuteRun 核心方法：执行 Agent 的思考循环
+// resolveLLM 为 Agent 选择 Provider，并把模型 / 温度写进请求模板
+func (e *AgentEngine) resolveLLM(agent *store.Agent) (llm.Provider, llm.Selection, error) {
+	client, sel, err := e.Providers.Resolve(agent)
+	if err != nil {
+		return nil, sel, err
+	}
+	fmt.Printf("[Agent] %s uses provider=%s model=%s temperature=%.2f\n", agent.Name, sel.Provider, sel.Model, sel.Temperature)
+	return client, sel, nil
+}
+
+// RunStreamEvent 一次 Run 对外推送的事件
+// 流式接口直接转发给前端；阻塞接口 (ExecuteRun) 消费同一串事件得到最终结果
+// handoff 后子 Run 的事件也经由父 Run 的 channel 推送，RunID / AgentID 标明事件来自哪个 Run
+type RunStreamEvent struct {
+	Type       string `json:"type"` // "content", "tool_start", "tool_end", "handoff", "approval_required", "error", "done"
+	Content    string `json:"content,omitempty"`
+	Tool       string `json:"tool,omitempty"`         // 工具名
+	ToolCallID string `json:"tool_call_id,omitempty"` // tool_start / tool_end 携带；同一轮的调用可能并发，用它配对
+	ApprovalID string `json:"approval_id,omitempty"`  // approval_required 携带，Content 为待审批的参数 (JSON)
+	RunID      string `json:"run_id,omitempty"`
+	AgentID    string `json:"agent_id,omitempty"`
+
+	// ParentRunID 仅 handoff 事件携带：此时 RunID / AgentID 是新建的子 Run 和目标 Agent
+	ParentRunID string `json:"parent_run_id,omitempty"`
+}
+
+// ExecuteRun 阻塞执行：消费 ExecuteRunStream 的事件，返回最终回复
 // 注意：runID 对应的任务将在 Engine 的 rootCtx 下运行，而非依赖调用者的 ctx
+// Run 暂停等待审批时返回 ErrAwaitingApproval
 func (e *AgentEngine) ExecuteRun(runID string) (string, error) {
-	// 1. 创建可取消的上下文，挂载在 rootCtx 下
-	ctx, cancel := context.WithCancel(e.rootCtx)
+	events := make(chan RunStreamEvent, 10)
+	go e.ExecuteRunStream(runID, events)
+	return collectRun(events)
+}
+
+// collectRun 读完一个 Run 的事件，取出最终回复；没有 done / error 就结束说明在等待审批
+func collectRun(events <-chan RunStreamEvent) (string, error) {
+	var final string
+	var runErr error
+	ended := false
+	for ev := range events {
+		switch ev.Type {
+		case "done":
+			final, ended = ev.Content, true
+		case "error":
+			runErr, ended = fmt.Errorf("%s", ev.Content), true
+		}
+	}
+	if !ended {
+		return "", ErrAwaitingApproval
+	}
+	return final, runErr
+}
+
+// ExecuteRunStream 执行 Agent 的思考循环，通过 channel 推送事件
+// 以且仅以一个 done（Content 为最终回复）或 error 事件结束，之后关闭 outCh；
+// 暂停等待审批时以 approval_required 事件结束，没有 done / error
+// 调用方必须读完 outCh，否则引擎会阻塞
+func (e *AgentEngine) ExecuteRunStream(runID string, outCh chan<- RunStreamEvent) {
+	defer close(outCh)
+
+	emit := func(ev RunStreamEvent) { outCh <- ev }
+	final, err := e.execute(e.rootCtx, runID, emit)
+	e.endStream(emit, runID, final, err)
+}
+
+// endStream 推送结束事件；暂停等待审批时 approval_required 已经推送过，不再推送
+func (e *AgentEngine) endStream(emit func(RunStreamEvent), runID, final string, err error) {
+	if errors.Is(err, ErrAwaitingApproval) {
+		return
+	}
+	end := RunStreamEvent{Type: "done", Content: final, RunID: runID}
+	if err != nil {
+		end = RunStreamEvent{Type: "error", Content: err.Error(), RunID: runID}
+	}
+	if run := e.Store.GetRun(runID); run != nil {
+		end.AgentID = run.AgentID
+	}
+	emit(end)
+}
+
+// execute 在 parent 下执行一个 Run（顶层 Run 的 parent 是 rootCtx，子 Run 是父 Run 的 ctx）
+// 注册到 runningRuns 以便单独取消；出错时兜底把还在 running 的 Run 标记为失败
+func (e *AgentEngine) execute(parent context.Context, runID string, emit func(RunStreamEvent)) (string, error) {
+	ctx, cancel := context.WithCancel(parent)
 	e.runningRuns.Store(runID, cancel)
 	defer func() {
 		cancel()
 		e.runningRuns.Delete(runID)
 	}()
 
+	final, err := e.runLoop(ctx, runID, emit)
+	if err != nil && !errors.Is(err, ErrAwaitingApproval) {
+		status := "failed"
+		// 被 CancelRun（或父 Run 的取消）打断时，不管停在推理还是工具调用，都按取消结束
+		if errors.Is(ctx.Err(), context.Canceled) {
+			status, err = "cancelled", ErrRunCancelled
+		}
+		// 循环内部已经结束的 Run（e.g. 超出预算）保留它写入的结果，这里只兜底还在 running 的
+		if r := e.Store.GetRun(runID); r != nil && r.Status == "running" {
+			e.Store.FinishRun(runID, map[string]interface{}{"error": err.Error()}, status)
+		}
+	}
+	return final, err
+}
+
+// runLoop 引擎核心：推理 -> 工具 / handoff -> 再推理，直到给出最终回复
+// 消息、Trace 步骤和 Run 状态都在这里写入；成功时 Run 已被结束，返回最终回复
+func (e *AgentEngine) runLoop(ctx context.Context, runID string, emit func(RunStreamEvent)) (string, error) {
 	run := e.Store.GetRun(runID)
 	if run == nil {
 		return "", fmt.Errorf("run not found")
@@ -71,381 +179,325 @@ func (e *AgentEngine) ExecuteRun(runID string) (string, error) {
 	if session == nil {
 		return "", fmt.Errorf("session not found")
 	}
+	// MCP 连接按会话所属用户注入其绑定的集成凭证；内置工具在会话的工作区内执行
+	ctx = mcp.WithUser(ctx, session.UserID)
+	if dir, err := e.Workspaces.Ensure(session.ID); err == nil {
+		ctx = mcp.WithWorkspace(ctx, dir)
+	} else {
+		fmt.Printf("[Agent] workspace for session %s unavailable: %v\n", session.ID, err)
+	}
 	agent := e.Store.GetAgent(run.AgentID)
 	if agent == nil {
 		return "", fmt.Errorf("agent not found")
 	}
 
-	// 限制最大思考步数，防止死循环烧钱
-	maxSteps := 5
-
-	for i := 0; i < maxSteps; i++ {
-		// 1. 准备上下文
-		history := e.Store.ListChatMessagesBySession(session.ID)
-		tools := e.Store.ListMCPToolsByAgent(agent.ID)
-
-		// 组装 handoff 目标（当前实现：列出除自身外的所有 Agent）
-		handoffCandidates := e.buildHandoffCandidates(agent.ID)
-
-		req := llm.ChatRequest{
-			SystemPrompt:      agent.SystemPrompt + "\n\n" + buildToolInstruction(tools),
-			History:           history,
-			Tools:             tools,
-			HandoffCandidates: handoffCandidates,
-			ForceHandoff:      true,
+	// 本 Run 产生的事件打上 run_id / agent_id；子 Run 的事件已由子 Run 自己打好，原样透传
+	parentEmit := emit
+	emit = func(ev RunStreamEvent) {
+		if ev.RunID == "" {
+			ev.RunID, ev.AgentID = run.ID, agent.ID
 		}
+		parentEmit(ev)
+	}
+
+	llmClient, sel, err := e.resolveLLM(agent)
+	if err != nil {
+		return "", err
+	}
 
-		fmt.Printf("======== DEBUG TOOLS ========\n")
-		for _, t := range tools {
-			fmt.Printf("- Tool: %s (ServerID: %s)\n", t.Name, t.ServerID)
+	// 按 Agent 配置的预算限制步数 / 工具调用 / 时长 / Token，防止死循环烧钱
+	tracker := newBudgetTracker(BudgetForAgent(agent))
+	// 从审批暂停或崩溃中恢复：接着之前的消耗累计，先执行上一轮剩下的工具调用
+	pending := e.unfinishedToolCalls(run.ID)
+	if state, _ := e.savedState(run.ID); state != nil {
+		tracker.restore(state)
+	}
+	if dl, ok := tracker.deadline(); ok {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, dl)
+		defer cancelDeadline()
+	}
+	// stop 超出预算时按策略收尾；收尾文本没有经过模型流式输出，补发一个 content 事件
+	stop := func(reason string) (string, error) {
+		text, err := e.terminate(ctx, run, agent, llmClient, sel, tracker, reason)
+		if err == nil {
+			emit(RunStreamEvent{Type: "content", Content: text})
 		}
-		fmt.Printf("=============================\n")
+		return text, err
+	}
 
-		fmt.Printf("[Agent] Step %d: Thinking...\n", i+1)
+	// Agent 挂载的 MCP 资源 / 提示模板，每次 Run 只读取一次
+	attachments := e.buildAttachments(ctx, agent)
+	outputLimit := ToolOutputLimit(agent)
+	parallel := ParallelToolLimit(agent)
+	// 工具权限策略：每个调用执行前评估，同一 Run 内累计 max_calls
+	policy := e.newPolicyEnforcer(run, agent)
 
-		// 2. LLM 推理
-		resp, err := e.LLMClient.ChatCompletion(ctx, &req)
+	if len(pending) > 0 {
+		fmt.Printf("[Agent] Run %s resumed with %d pending tool call(s)\n", run.ID, len(pending))
+		reason, err := e.runToolCalls(ctx, run, mcp.LoadToolSet(e.Store, agent.ID), pending, tracker, policy, parallel, outputLimit, emit)
 		if err != nil {
-			return "", fmt.Errorf("step %d error: %v", i+1, err)
+			return "", err
 		}
+		if reason != "" {
+			return stop(reason)
+		}
+	}
 
-		// 3.1 处理 handoff（target_agent_id 为空表示不切换）
-		if resp.Handoff != nil && resp.Handoff.TargetAgentID != "" {
-			fmt.Printf("[Agent] Step %d: Handoff -> Agent %s\n", i+1, resp.Handoff.TargetAgentID)
-
-			// 记录 Assistant 消息，包含 handoff 信息
-			e.Store.CreateChatMessage(&store.ChatMessage{
-				SessionID: session.ID,
-				RunID:     run.ID,
-				Role:      "assistant",
-				Content: map[string]interface{}{
-					"text":    resp.Content,
-					"handoff": resp.Handoff,
-				},
-				CreatedAt: time.Now(),
-			})
+	for i := 1; ; i++ {
+		if reason := tracker.beforeStep(); reason != "" {
+			return stop(reason)
+		}
+		// 用户 / API Key / Agent 的花费预算用完时直接结束，不再请求 LLM
+		if b := e.checkSpend(run); b != nil {
+			return e.stopForBudget(run, b)
+		}
 
-			// 创建 handoff Step
-			step := e.createStep(run, "handoff", "agent_handoff", map[string]interface{}{
-				"target_agent_id":   resp.Handoff.TargetAgentID,
-				"reason":            resp.Handoff.Reason,
-				"preferred_server":  resp.Handoff.PreferredServer,
-				"parent_agent_id":   agent.ID,
-				"parent_agent_name": agent.Name,
-			})
+		// 1. 准备上下文
+		history := e.Store.ListChatMessagesBySession(session.ID)
+		// 只暴露该 Agent 绑定的工具，名字带 Server 命名空间，避免跨 Server 重名
+		toolset := mcp.LoadToolSet(e.Store, agent.ID)
+		tools := policy.visible(toolset, toolset.Tools())
+		if hasStoredOutputs(history) {
+			tools = append(tools, readToolOutputDef(outputLimit))
+		}
 
-			// 触发子 Agent Run
-			childResp, childRun, err := e.executeHandoff(ctx, run, resp.Handoff)
-			status := "completed"
-			errMsg := ""
-			if err != nil {
-				status = "failed"
-				errMsg = err.Error()
-			}
+		req := llm.ChatRequest{
+			SystemPrompt:      agent.SystemPrompt + attachments + "\n\n" + buildToolInstruction(tools),
+			Tools:             tools,
+			HandoffCandidates: e.buildHandoffCandidates(agent.ID),
+			ForceHandoff:      true,
+			Model:             sel.Model,
+			Temperature:       &sel.Temperature,
+		}
+		// 按模型的上下文窗口裁剪历史，必要时把较早的对话总结进会话摘要
+		e.fitContext(ctx, run, agent, llmClient, sel, history, &req, tracker, true)
 
-			e.finishStep(step.ID, map[string]interface{}{
-				"child_run_id":   childRunIDOrEmpty(childRun),
-				"child_agent_id": resp.Handoff.TargetAgentID,
-				"response":       childResp,
-			}, status, errMsg)
+		fmt.Printf("[Agent] Step %d: Thinking...\n", i)
 
-			if err != nil {
-				e.Store.FinishRun(run.ID, map[string]interface{}{
-					"error": errMsg,
-				}, "failed")
-				return "", err
+		// 2. LLM 推理（content 增量直接转发），用量记入 Trace 和 Run
+		round, usage, err := e.llmRound(ctx, run, llmClient, sel, &req, i, emit)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return stop(LimitDuration)
 			}
-
-			// 父 Run 成功，输出子 Agent 结果
-			e.Store.FinishRun(run.ID, map[string]interface{}{
-				"child_run_id": childRun.ID,
-				"response":     childResp,
-			}, "succeeded")
-			return childResp, nil
+			return "", fmt.Errorf("step %d error: %v", i, err)
 		}
+		tracker.addUsage(round.Usage)
 
-		// 3. 判断是否调用工具
-		if len(resp.ToolCalls) > 0 {
-			fmt.Printf("[Agent] Step %d: Tool Call(s) detected: %d calls\n", i+1, len(resp.ToolCalls))
+		switch {
+		// 3.1 handoff（target_agent_id 为空表示不切换）
+		case round.Handoff != nil && round.Handoff.TargetAgentID != "":
+			fmt.Printf("[Agent] Step %d: Handoff -> Agent %s\n", i, round.Handoff.TargetAgentID)
+			return e.handoff(ctx, run, agent, round, usage, emit)
 
-			// A. 先把 Assistant 的决定存入历史 (Question 1 修正)
-			// 注意：这里 ToolCallID 留空，因为这是一条 Assistant 消息
-			// 我们把 tool_calls 完整结构存入 Content，以便下次发给 LLM
-			// (前提是你的 llm.Client.buildMessages 能正确处理 Content 里的 tool_calls 字段)
+		// 3.2 工具调用：执行后把结果喂回 LLM，进入下一轮
+		case len(round.ToolCalls) > 0:
+			fmt.Printf("[Agent] Step %d: Tool Call(s) detected: %d calls\n", i, len(round.ToolCalls))
 
-			// 为了简单，我们把 ToolCalls 转成 map 存进去
-			toolCallsMap := make([]map[string]interface{}, 0)
-			for _, tc := range resp.ToolCalls {
+			// 先把 Assistant 的决定存入历史，tool_calls 完整结构存进 Content，下次发给 LLM 时还原
+			toolCallsMap := make([]map[string]interface{}, 0, len(round.ToolCalls))
+			for _, tc := range round.ToolCalls {
 				toolCallsMap = append(toolCallsMap, map[string]interface{}{
 					"id":   tc.ID,
 					"type": "function",
 					"function": map[string]interface{}{
-						"name":      tc.Function.Name,
-						"arguments": tc.Function.Arguments,
+						"name":      tc.Name,
+						"arguments": tc.Arguments,
 					},
 				})
 			}
-
 			e.Store.CreateChatMessage(&store.ChatMessage{
-				SessionID: session.ID,
-				RunID:     run.ID,
-				Role:      "assistant",
-				Content:   map[string]interface{}{"text": resp.Content, "tool_calls": toolCallsMap}, //tool_calls[i].id为某个具体工具的id
-				CreatedAt: time.Now(),
-				// ToolCallID: "", // 这里不要填！
+				SessionID:  session.ID,
+				RunID:      run.ID,
+				Role:       "assistant",
+				Content:    map[string]interface{}{"text": round.Content, "tool_calls": toolCallsMap},
+				TokenCount: usage.CompletionTokens,
+				CreatedAt:  time.Now(),
 			})
 
-			// B. 挨个执行工具
-			for _, call := range resp.ToolCalls {
-				fmt.Printf("[Agent] Executing Tool: %s (ID: %s)\n", call.Function.Name, call.ID)
-
-				// 解析参数用于 Trace (Question 2 修正)
-				var args map[string]interface{}
-				_ = json.Unmarshal([]byte(call.Function.Arguments), &args) // 忽略错误，仅仅为了记录
-
-				// 记录 Trace: Tool Start
-				step := e.createStep(run, "tool_call", call.Function.Name, args)
-
-				// 执行工具逻辑
-				output, err := e.executeToolCall(ctx, call)
-				status := "completed"
-				errMsg := ""
-				if err != nil {
-					status = "failed"
-					errMsg = err.Error()
-					output = fmt.Sprintf("Tool Execution Error: %v", err)
-				}
-
-				// 记录 Trace: Tool End
-				e.finishStep(step.ID, map[string]interface{}{"output": output}, status, errMsg)
-
-				// 将工具结果存入对话历史 (User 不可见，LLM 可见)
-				e.saveToolOutput(run, call.ID, output)
+			// 互不依赖的调用并发执行，结果仍按模型给出的顺序写回
+			reason, err := e.runToolCalls(ctx, run, toolset, round.ToolCalls, tracker, policy, parallel, outputLimit, emit)
+			if err != nil {
+				return "", err
+			}
+			if reason != "" {
+				return stop(reason)
 			}
 
-			// 继续下一轮循环 (将工具结果发回给 LLM)
-			continue
-
-		} else {
-			// 4. 没有工具调用，说明是最终回复
-			fmt.Printf("[Agent] Step %d: Final Response: %s\n", i+1, resp.Content)
-			e.saveMessage(run, "assistant", resp.Content, "")
-
-			// 更新 Run 状态
-			e.Store.FinishRun(run.ID, map[string]interface{}{"response": resp.Content}, "succeeded")
-
-			return resp.Content, nil
+		// 4. 没有工具调用，说明是最终回复（只取本轮内容，之前轮次的文本已随 tool_calls 存入历史）
+		default:
+			fmt.Printf("[Agent] Step %d: Final Response: %s\n", i, round.Content)
+			e.saveMessage(run, "assistant", round.Content, "", usage.CompletionTokens)
+			e.Store.FinishRun(run.ID, map[string]interface{}{"response": round.Content}, "succeeded")
+			return round.Content, nil
 		}
 	}
-	return "", fmt.Errorf("max steps reached")
 }
 
-// RunStreamEvent 流式执行返回给 Handler 的事件
-type RunStreamEvent struct {
-	Type    string `json:"type"` // "content", "tool_start", "tool_end", "handoff", "error", "done"
-	Content string `json:"content,omitempty"`
-	Tool    string `json:"tool,omitempty"`     // 工具名
-	AgentID string `json:"agent_id,omitempty"` // handoff 目标
+// roundResult 一轮推理的结果
+type roundResult struct {
+	Content   string
+	ToolCalls []llm.ToolCallInfo
+	Handoff   *llm.HandoffDecision
+	Usage     map[string]int
 }
 
-// ExecuteRunStream 流式执行 Agent，通过 channel 推送事件
-func (e *AgentEngine) ExecuteRunStream(runID string, outCh chan<- RunStreamEvent) {
-	ctx, cancel := context.WithCancel(e.rootCtx)
-	e.runningRuns.Store(runID, cancel)
-	defer func() {
-		cancel()
-		e.runningRuns.Delete(runID)
-		close(outCh)
-	}()
-
-	run := e.Store.GetRun(runID)
-	if run == nil {
-		outCh <- RunStreamEvent{Type: "error", Content: "run not found"}
-		return
-	}
-	session := e.Store.GetChatSession(run.SessionID)
-	if session == nil {
-		outCh <- RunStreamEvent{Type: "error", Content: "session not found"}
-		return
+// llmRound 一次推理，记录 llm_call 步骤，把用量计入 Run，返回本次调用的用量
+func (e *AgentEngine) llmRound(ctx context.Context, run *store.Run, provider llm.Provider, sel llm.Selection, req *llm.ChatRequest, i int, emit func(RunStreamEvent)) (*roundResult, TokenUsage, error) {
+	step := e.createStep(run, "llm_call", sel.Model, map[string]interface{}{
+		"provider":         sel.Provider,
+		"model":            sel.Model,
+		"round":            i,
+		"history_messages": len(req.History),
+		"tools":            len(req.Tools),
+	})
+	round, err := streamRound(ctx, provider, req, emit)
+	if err != nil {
+		e.finishStep(step.ID, nil, "failed", err.Error())
+		return nil, TokenUsage{}, err
 	}
-	agent := e.Store.GetAgent(run.AgentID)
-	if agent == nil {
-		outCh <- RunStreamEvent{Type: "error", Content: "agent not found"}
-		return
+	usage := e.recordUsage(run.ID, sel, round.Usage)
+
+	outcome := "final"
+	switch {
+	case round.Handoff != nil && round.Handoff.TargetAgentID != "":
+		outcome = "handoff"
+	case len(round.ToolCalls) > 0:
+		outcome = "tool_call"
 	}
+	e.finishStep(step.ID, map[string]interface{}{"outcome": outcome, "usage": usage.toMap()}, "completed", "")
+	return round, usage, nil
+}
 
-	maxSteps := 5
-	var fullResponseBuffer strings.Builder
-
-	for i := 0; i < maxSteps; i++ {
-		history := e.Store.ListChatMessagesBySession(session.ID)
-		tools := e.Store.ListMCPToolsByAgent(agent.ID)
-		handoffCandidates := e.buildHandoffCandidates(agent.ID)
-
-		req := llm.ChatRequest{
-			SystemPrompt:      agent.SystemPrompt + "\n\n" + buildToolInstruction(tools),
-			History:           history,
-			Tools:             tools,
-			HandoffCandidates: handoffCandidates,
-			ForceHandoff:      true,
-		}
-
-		// 调用流式 LLM
-		llmCh, err := e.LLMClient.ChatStream(ctx, &req)
-		if err != nil {
-			outCh <- RunStreamEvent{Type: "error", Content: err.Error()}
-			e.Store.FinishRun(run.ID, map[string]interface{}{"error": err.Error()}, "failed")
-			return
-		}
-
-		// 本轮内容缓冲
-		var roundContent strings.Builder
-		var pendingToolCalls []llm.ToolCallInfo
-		var pendingHandoff *llm.HandoffDecision
-
-		// 监听 LLM 流式输出
-		for event := range llmCh {
-			switch event.Type {
-			case "content":
-				roundContent.WriteString(event.Content)
-				fullResponseBuffer.WriteString(event.Content)
-				outCh <- RunStreamEvent{Type: "content", Content: event.Content}
-
-			case "tool_call":
-				pendingToolCalls = event.ToolCalls
-
-			case "handoff":
-				pendingHandoff = event.Handoff
-
-			case "error":
-				outCh <- RunStreamEvent{Type: "error", Content: event.Error}
-				e.Store.FinishRun(run.ID, map[string]interface{}{"error": event.Error}, "failed")
-				return
+// streamRound 调用一次流式推理，转发 content 增量，返回结束事件携带的完整结果
+func streamRound(ctx context.Context, provider llm.Provider, req *llm.ChatRequest, emit func(RunStreamEvent)) (*roundResult, error) {
+	llmCh, err := provider.ChatStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
 
-			case "done":
-				// 正常结束，无工具调用
+	var deltas strings.Builder
+	var res *roundResult
+	for event := range llmCh {
+		switch event.Type {
+		case "content":
+			deltas.WriteString(event.Content)
+			emit(RunStreamEvent{Type: "content", Content: event.Content})
+		case "tool_call":
+			res = &roundResult{Content: event.Content, ToolCalls: event.ToolCalls, Usage: event.Usage}
+		case "handoff":
+			res = &roundResult{Content: event.Content, Handoff: event.Handoff, Usage: event.Usage}
+		case "done":
+			res = &roundResult{Content: event.Content, Usage: event.Usage}
+		case "error":
+			for range llmCh {
 			}
+			return nil, fmt.Errorf("%s", event.Error)
 		}
-
-		// 处理 handoff
-		if pendingHandoff != nil && pendingHandoff.TargetAgentID != "" {
-			// 存储 assistant 消息
-			e.Store.CreateChatMessage(&store.ChatMessage{
-				SessionID: session.ID,
-				RunID:     run.ID,
-				Role:      "assistant",
-				Content: map[string]interface{}{
-					"text":    roundContent.String(),
-					"handoff": pendingHandoff,
-				},
-				CreatedAt: time.Now(),
-			})
-
-			outCh <- RunStreamEvent{Type: "handoff", AgentID: pendingHandoff.TargetAgentID, Content: pendingHandoff.Reason}
-
-			// 创建子 Run（MVP 先不流式递归，直接同步执行）
-			childResp, childRun, err := e.executeHandoff(ctx, run, pendingHandoff)
-			if err != nil {
-				outCh <- RunStreamEvent{Type: "error", Content: err.Error()}
-				e.Store.FinishRun(run.ID, map[string]interface{}{"error": err.Error()}, "failed")
-				return
-			}
-
-			// 发送子 Agent 的结果
-			outCh <- RunStreamEvent{Type: "content", Content: childResp}
-			outCh <- RunStreamEvent{Type: "done"}
-
-			e.Store.FinishRun(run.ID, map[string]interface{}{
-				"child_run_id": childRun.ID,
-				"response":     childResp,
-			}, "succeeded")
-			return
+	}
+	if res == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
+		return nil, fmt.Errorf("llm stream ended without a result")
+	}
+	// 兼容没有在结束事件里带完整文本的 Provider
+	if res.Content == "" && res.Handoff == nil {
+		res.Content = deltas.String()
+	}
+	return res, nil
+}
 
-		// 处理工具调用
-		if len(pendingToolCalls) > 0 {
-			// 先存 assistant 消息（含 tool_calls）
-			toolCallsMap := make([]map[string]interface{}, 0)
-			for _, tc := range pendingToolCalls {
-				toolCallsMap = append(toolCallsMap, map[string]interface{}{
-					"id":   tc.ID,
-					"type": "function",
-					"function": map[string]interface{}{
-						"name":      tc.Name,
-						"arguments": tc.Arguments,
-					},
-				})
-			}
-			e.Store.CreateChatMessage(&store.ChatMessage{
-				SessionID: session.ID,
-				RunID:     run.ID,
-				Role:      "assistant",
-				Content:   map[string]interface{}{"text": roundContent.String(), "tool_calls": toolCallsMap},
-				CreatedAt: time.Now(),
-			})
-
-			// 执行每个工具
-			for _, tc := range pendingToolCalls {
-				outCh <- RunStreamEvent{Type: "tool_start", Tool: tc.Name}
-
-				// 解析参数
-				var args map[string]interface{}
-				_ = json.Unmarshal([]byte(tc.Arguments), &args)
-
-				step := e.createStep(run, "tool_call", tc.Name, args)
-
-				// 执行工具（同步）
-				output, err := e.executeToolCallByInfo(ctx, tc)
-				status := "completed"
-				errMsg := ""
-				if err != nil {
-					status = "failed"
-					errMsg = err.Error()
-					output = fmt.Sprintf("Tool Execution Error: %v", err)
-				}
-
-				e.finishStep(step.ID, map[string]interface{}{"output": output}, status, errMsg)
-				e.saveToolOutput(run, tc.ID, output)
+// handoff 记录切换决策，执行子 Agent，并以子 Agent 的回复结束当前 Run
+func (e *AgentEngine) handoff(ctx context.Context, run *store.Run, agent *store.Agent, round *roundResult, usage TokenUsage, emit func(RunStreamEvent)) (string, error) {
+	decision := round.Handoff
 
-				outCh <- RunStreamEvent{Type: "tool_end", Tool: tc.Name, Content: output}
-			}
+	// 记录 Assistant 消息，包含 handoff 信息
+	e.Store.CreateChatMessage(&store.ChatMessage{
+		SessionID: run.SessionID,
+		RunID:     run.ID,
+		Role:      "assistant",
+		Content: map[string]interface{}{
+			"text":    round.Content,
+			"handoff": decision,
+		},
+		TokenCount: usage.CompletionTokens,
+		CreatedAt:  time.Now(),
+	})
 
-			// 继续下一轮（把工具结果喂回 LLM）
-			continue
-		}
+	step := e.createStep(run, "handoff", "agent_handoff", map[string]interface{}{
+		"target_agent_id":   decision.TargetAgentID,
+		"reason":            decision.Reason,
+		"preferred_server":  decision.PreferredServer,
+		"parent_agent_id":   agent.ID,
+		"parent_agent_name": agent.Name,
+	})
 
-		// 无工具调用，最终回复
-		finalContent := fullResponseBuffer.String()
-		e.saveMessage(run, "assistant", finalContent, "")
-		e.Store.FinishRun(run.ID, map[string]interface{}{"response": finalContent}, "succeeded")
-		outCh <- RunStreamEvent{Type: "done"}
-		return
+	// 触发子 Agent Run，子 Run 的事件实时经由 emit 推送
+	childResp, childRun, err := e.executeHandoff(ctx, run, decision, emit)
+	if errors.Is(err, ErrAwaitingApproval) {
+		// 子 Run 暂停：父 Run 跟着等待，handoff 步骤留在 running，子 Run 恢复并结束后由 ResumeRunStream 收尾
+		e.Store.UpdateRunStatus(run.ID, "awaiting_approval")
+		return "", err
 	}
-
-	outCh <- RunStreamEvent{Type: "error", Content: "max steps reached"}
-	e.Store.FinishRun(run.ID, map[string]interface{}{"error": "max steps reached"}, "failed")
+	return e.completeHandoff(run, step.ID, decision.TargetAgentID, childRun, childResp, err)
 }
 
-// executeToolCallByInfo 通过 ToolCallInfo 执行工具
-func (e *AgentEngine) executeToolCallByInfo(ctx context.Context, tc llm.ToolCallInfo) (string, error) {
-	toolDef := e.Store.FindMCPToolByName(tc.Name)
-	if toolDef == nil {
-		return "", fmt.Errorf("tool definition not found: %s", tc.Name)
+// completeHandoff 结束 handoff 步骤，并以子 Run 的结果结束父 Run
+func (e *AgentEngine) completeHandoff(run *store.Run, stepID, childAgentID string, childRun *store.Run, childResp string, err error) (string, error) {
+	status := "completed"
+	errMsg := ""
+	if err != nil {
+		status = "failed"
+		errMsg = err.Error()
 	}
-
-	server := e.Store.GetMCPServer(toolDef.ServerID)
-	if server == nil {
-		return "", fmt.Errorf("server not found for tool: %s", tc.Name)
+	e.finishStep(stepID, map[string]interface{}{
+		"child_run_id":   childRunIDOrEmpty(childRun),
+		"child_agent_id": childAgentID,
+		"response":       childResp,
+	}, status, errMsg)
+	// 子 Run 的用量（含它的子 Run）计入父 Run 的 rollup
+	e.addChildUsage(run.ID, childRun)
+	if err != nil {
+		// 恢复路径上父 Run 不在 execute 里，失败状态在这里写入；子 Run 预算用完 / 被取消时父 Run 跟着是 budget_exceeded / cancelled
+		runStatus := "failed"
+		switch {
+		case errors.Is(err, ErrBudgetExceeded):
+			runStatus = "budget_exceeded"
+		case errors.Is(err, ErrRunCancelled):
+			runStatus = "cancelled"
+		}
+		e.Store.FinishRun(run.ID, map[string]interface{}{"error": err.Error()}, runStatus)
+		return "", err
 	}
 
-	executor := mcp.NewExecutor(e.Store)
-	return executor.ExecuteTool(ctx, server, tc.Name, tc.Arguments)
+	// 父 Run 成功，以子 Agent 的结果作为输出（内容已由子 Run 流式推送过）
+	e.Store.FinishRun(run.ID, map[string]interface{}{
+		"child_run_id": childRun.ID,
+		"response":     childResp,
+	}, "succeeded")
+	return childResp, nil
 }
 
+// ErrRunCancelled Run 被 CancelRun 取消，以 cancelled 状态结束
+var ErrRunCancelled = errors.New("run cancelled")
+
 // CancelRun 异步取消任务
 func (e *AgentEngine) CancelRun(runID string) error {
+	// 还在排队的 Run 直接结束，不会再被 worker 取走
+	if e.Queue.cancelQueued(runID) {
+		return nil
+	}
 	val, ok := e.runningRuns.Load(runID)
 	if !ok {
+		// 等待审批的 Run 不在执行，直接结束
+		if run := e.Store.GetRun(runID); run != nil && run.Status == "awaiting_approval" {
+			e.cancelAwaiting(run)
+			// 已经决定、还在等名额的恢复不再执行
+			e.Queue.cancelResume(e.RootRunID(runID))
+			return nil
+		}
 		// 任务可能已经结束，或者根本不存在
 		// 这种情况下也可以认为“取消成功”（幂等），或者返回特定错误
 		return fmt.Errorf("run not running or not found")
@@ -458,14 +510,26 @@ func (e *AgentEngine) CancelRun(runID string) error {
 	return nil
 }
 
-// 辅助函数：存消息
-func (e *AgentEngine) saveMessage(run *store.Run, role, content, toolCallID string) {
+// RootRunID handoff 链最上层的 Run，即用户发起的那个；事件按它推送给订阅者
+func (e *AgentEngine) RootRunID(runID string) string {
+	for {
+		run := e.Store.GetRun(runID)
+		if run == nil || run.ParentRunID == "" {
+			return runID
+		}
+		runID = run.ParentRunID
+	}
+}
+
+// 辅助函数：存消息，tokens 为生成这条消息的 completion tokens
+func (e *AgentEngine) saveMessage(run *store.Run, role, content, toolCallID string, tokens int) {
 	e.Store.CreateChatMessage(&store.ChatMessage{
 		SessionID:  run.SessionID,
 		RunID:      run.ID,
 		Role:       role,
 		Content:    map[string]interface{}{"type": "text", "text": content},
 		ToolCallID: toolCallID,
+		TokenCount: tokens,
 		CreatedAt:  time.Now(),
 	})
 }
@@ -515,8 +579,9 @@ func (e *AgentEngine) buildHandoffCandidates(currentAgentID string) []llm.Handof
 	return res
 }
 
-// executeHandoff 创建子 Run 并递归执行
-func (e *AgentEngine) executeHandoff(ctx context.Context, parentRun *store.Run, decision *llm.HandoffDecision) (string, *store.Run, error) {
+// executeHandoff 创建子 Run 并在父 Run 的 ctx 下递归执行（取消父 Run 会一并取消子 Run）
+// 先推送 handoff 事件告知子 Run 的 ID，之后子 Run 的事件都经由 emit 推送
+func (e *AgentEngine) executeHandoff(ctx context.Context, parentRun *store.Run, decision *llm.HandoffDecision, emit func(RunStreamEvent)) (string, *store.Run, error) {
 	if decision == nil || decision.TargetAgentID == "" {
 		return "", nil, fmt.Errorf("invalid handoff decision")
 	}
@@ -529,6 +594,7 @@ func (e *AgentEngine) executeHandoff(ctx context.Context, parentRun *store.Run,
 	childRun := &store.Run{
 		SessionID:     parentRun.SessionID,
 		UserID:        parentRun.UserID,
+		APIKeyID:      parentRun.APIKeyID,
 		AgentID:       decision.TargetAgentID,
 		ParentRunID:   parentRun.ID,
 		TraceID:       parentRun.TraceID,
@@ -542,10 +608,17 @@ func (e *AgentEngine) executeHandoff(ctx context.Context, parentRun *store.Run,
 		return "", nil, fmt.Errorf("create child run failed: %w", err)
 	}
 
+	emit(RunStreamEvent{
+		Type:        "handoff",
+		Content:     decision.Reason,
+		RunID:       created.ID,
+		AgentID:     decision.TargetAgentID,
+		ParentRunID: parentRun.ID,
+	})
+
 	// 递归执行子 Agent
-	resp, err := e.ExecuteRun(created.ID)
+	resp, err := e.execute(ctx, created.ID, emit)
 	if err != nil {
-		e.Store.FinishRun(created.ID, map[string]interface{}{"error": err.Error()}, "failed")
 		return "", created, err
 	}
 	return resp, created, nil
@@ -0,0 +1,353 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/service/workspace"
+	"example.com/agent-server/internal/store"
+)
+
+// testEnv MemoryStore + 按脚本回复的 Provider，工作区放在临时目录
+type testEnv struct {
+	t     *testing.T
+	store *store.MemoryStore
+	llm   *llm.ScriptedProvider
+	e     *AgentEngine
+}
+
+func newTestEnv(t *testing.T, provider *llm.ScriptedProvider) *testEnv {
+	t.Helper()
+	s := store.NewMemoryStore()
+	e := NewEngine(s, llm.Fixed(provider))
+	e.Workspaces = workspace.NewManager(t.TempDir())
+	return &testEnv{t: t, store: s, llm: provider, e: e}
+}
+
+func (env *testEnv) agent(name string, extra map[string]interface{}) *store.Agent {
+	return env.store.CreateAgent(&store.Agent{Name: name, SystemPrompt: "You are " + name + ".", ModelName: "test-model", ExtraConfig: extra, Status: "active"})
+}
+
+// bindFS 给 Agent 绑定一个内置 filesystem Server（命名空间 fs），工具在会话工作区内执行
+func (env *testEnv) bindFS(agent *store.Agent, config map[string]interface{}) *store.MCPServer {
+	return env.bindBuiltin(agent, "fs", mcp.BuiltinFilesystem, config)
+}
+
+func (env *testEnv) bindBuiltin(agent *store.Agent, name, kind string, config map[string]interface{}) *store.MCPServer {
+	cfg := map[string]interface{}{"builtin": kind}
+	for k, v := range config {
+		cfg[k] = v
+	}
+	server := env.store.CreateMCPServer(&store.MCPServer{AgentID: agent.ID, Name: name, TransportType: "stdio", ConnectionConfig: cfg})
+	for _, tool := range mcp.MockToolsForServer(server.ID, server.Name) {
+		env.store.UpsertMCPTool(tool)
+	}
+	return server
+}
+
+// startRun 新建会话、写入用户消息并创建 running 状态的 Run，和 worker 取走排队的 Run 之后一样
+func (env *testEnv) startRun(agent *store.Agent, prompt string) *store.Run {
+	env.t.Helper()
+	session := env.store.CreateChatSession(&store.ChatSession{UserID: "user-1", AgentID: agent.ID, Title: "test"})
+	env.store.CreateChatMessage(&store.ChatMessage{SessionID: session.ID, Role: "user", Content: map[string]interface{}{"type": "text", "text": prompt}, CreatedAt: time.Now()})
+	run, err := env.store.CreateRun(&store.Run{SessionID: session.ID, UserID: "user-1", AgentID: agent.ID, TraceID: "trace-1", Status: "running"})
+	if err != nil {
+		env.t.Fatalf("create run: %v", err)
+	}
+	return run
+}
+
+// writeFile 在 Run 所在会话的工作区里放一个文件
+func (env *testEnv) writeFile(run *store.Run, name, content string) {
+	env.t.Helper()
+	dir, err := env.e.Workspaces.Ensure(run.SessionID)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		env.t.Fatal(err)
+	}
+}
+
+// stream 执行 Run 并收集全部事件
+func (env *testEnv) stream(runID string) []RunStreamEvent {
+	env.t.Helper()
+	return env.drain(runID, func(ch chan<- RunStreamEvent) { env.e.ExecuteRunStream(runID, ch) })
+}
+
+// resume 审批决定后恢复 Run 并收集全部事件
+func (env *testEnv) resume(approvalID string) []RunStreamEvent {
+	env.t.Helper()
+	return env.drain(approvalID, func(ch chan<- RunStreamEvent) { env.e.ResumeRunStream(approvalID, ch) })
+}
+
+func (env *testEnv) drain(runID string, start func(chan<- RunStreamEvent)) []RunStreamEvent {
+	env.t.Helper()
+	ch := make(chan RunStreamEvent, 10)
+	go start(ch)
+	var events []RunStreamEvent
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		case <-timeout:
+			env.t.Fatalf("run %s did not finish, events so far: %+v", runID, events)
+		}
+	}
+}
+
+func (env *testEnv) stepTypes(runID string) []string {
+	var types []string
+	for _, step := range env.store.ListRunStepsByRun(runID) {
+		types = append(types, step.StepType)
+	}
+	return types
+}
+
+func (env *testEnv) roles(sessionID string) []string {
+	var roles []string
+	for _, m := range env.store.ListChatMessagesBySession(sessionID) {
+		roles = append(roles, m.Role)
+	}
+	return roles
+}
+
+func TestExecuteRunToolLoop(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider(
+		llm.ScriptedTurn{
+			ToolCalls: []llm.ToolCallInfo{{Name: "fs__read_file", Arguments: `{"path":"notes.txt"}`}},
+			Usage:     map[string]int{"prompt_tokens": 100, "completion_tokens": 10},
+		},
+		llm.ScriptedTurn{Content: "The notes say hello.", Usage: map[string]int{"prompt_tokens": 130, "completion_tokens": 6}},
+	))
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "What is in notes.txt?")
+	env.writeFile(run, "notes.txt", "hello from the workspace")
+
+	final, err := env.e.ExecuteRun(run.ID)
+	if err != nil || final != "The notes say hello." {
+		t.Fatalf("ExecuteRun = %q, %v", final, err)
+	}
+
+	got := env.store.GetRun(run.ID)
+	if got.Status != "succeeded" || got.OutputPayload["response"] != final {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	if u := usageOf(got.UsageMetadata); u.PromptTokens != 230 || u.CompletionTokens != 16 || u.LLMCalls != 2 {
+		t.Fatalf("usage = %+v", u)
+	}
+	if steps := env.stepTypes(run.ID); !reflect.DeepEqual(steps, []string{"llm_call", "tool_call", "llm_call"}) {
+		t.Fatalf("steps = %v", steps)
+	}
+
+	// 历史：用户消息 -> 带 tool_calls 的 assistant -> tool 结果 -> 最终回复
+	msgs := env.store.ListChatMessagesBySession(run.SessionID)
+	if roles := env.roles(run.SessionID); !reflect.DeepEqual(roles, []string{"user", "assistant", "tool", "assistant"}) {
+		t.Fatalf("roles = %v", roles)
+	}
+	calls := toolCallsOf(msgs[1])
+	if len(calls) != 1 || calls[0].Name != "fs__read_file" || msgs[2].ToolCallID != calls[0].ID {
+		t.Fatalf("tool call %+v not paired with result %q", calls, msgs[2].ToolCallID)
+	}
+	if text := messageText(msgs[2]); text != "hello from the workspace" {
+		t.Fatalf("tool result = %q", text)
+	}
+
+	// 第二轮请求带上了工具结果，工具以命名空间名暴露给模型
+	if n := len(env.llm.Requests); n != 2 {
+		t.Fatalf("llm called %d times", n)
+	}
+	second := env.llm.Requests[1]
+	if last := second.History[len(second.History)-1]; last.Role != "tool" || messageText(last) != "hello from the workspace" {
+		t.Fatalf("second request ends with %s %q", last.Role, messageText(last))
+	}
+	var names []string
+	for _, tool := range second.Tools {
+		names = append(names, tool.Name)
+	}
+	if !strings.Contains(strings.Join(names, ","), "fs__read_file") {
+		t.Fatalf("tools = %v", names)
+	}
+}
+
+func TestExecuteRunStreamEventOrder(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider(
+		llm.ScriptedTurn{Content: "Let me look.", ToolCalls: []llm.ToolCallInfo{{ID: "call-1", Name: "fs__read_file", Arguments: `{"path":"a.txt"}`}}},
+		llm.ScriptedTurn{Content: "Found it."},
+	))
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "read a.txt")
+	env.writeFile(run, "a.txt", "A")
+
+	events := env.stream(run.ID)
+	var got []string
+	for _, ev := range events {
+		if ev.RunID != run.ID || ev.AgentID != agent.ID {
+			t.Fatalf("event %+v not tagged with run %s / agent %s", ev, run.ID, agent.ID)
+		}
+		got = append(got, ev.Type+":"+ev.Tool+ev.Content)
+	}
+	want := []string{
+		"content:Let ", "content:me ", "content:look.",
+		"tool_start:fs__read_file", "tool_end:fs__read_fileA",
+		"content:Found ", "content:it.",
+		"done:Found it.",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events =\n%v\nwant\n%v", got, want)
+	}
+	if events[3].ToolCallID != "call-1" || events[4].ToolCallID != "call-1" {
+		t.Fatalf("tool events not paired: %+v %+v", events[3], events[4])
+	}
+}
+
+// blockingProvider 收到请求后一直等到 ctx 结束，模拟慢模型，用来测取消
+type blockingProvider struct{ started chan struct{} }
+
+func (p *blockingProvider) ChatCompletion(ctx context.Context, req *llm.ChatRequest) (*llm.ChatResponse, error) {
+	close(p.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (p *blockingProvider) ChatStream(ctx context.Context, req *llm.ChatRequest) (<-chan llm.StreamEvent, error) {
+	ch := make(chan llm.StreamEvent, 1)
+	go func() {
+		defer close(ch)
+		close(p.started)
+		<-ctx.Done()
+		ch <- llm.StreamEvent{Type: "error", Error: ctx.Err().Error()}
+	}()
+	return ch, nil
+}
+
+func TestCancelRunWhileRunning(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider())
+	provider := &blockingProvider{started: make(chan struct{})}
+	env.e.Providers = llm.Fixed(provider)
+	agent := env.agent("coder", nil)
+	run := env.startRun(agent, "hi")
+
+	go func() {
+		<-provider.started
+		if err := env.e.CancelRun(run.ID); err != nil {
+			t.Errorf("cancel: %v", err)
+		}
+	}()
+	events := env.stream(run.ID)
+
+	// 执行中被取消的 Run 以 cancelled 结束，而不是 failed
+	if len(events) != 1 || events[0].Type != "error" || events[0].Content != ErrRunCancelled.Error() {
+		t.Fatalf("events = %+v", events)
+	}
+	got := env.store.GetRun(run.ID)
+	if got.Status != "cancelled" || got.OutputPayload["error"] != ErrRunCancelled.Error() {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	if err := env.e.CancelRun(run.ID); err == nil {
+		t.Fatal("cancelling a finished run should fail")
+	}
+}
+
+func TestExecuteRunHandoff(t *testing.T) {
+	provider := llm.NewScriptedProvider()
+	env := newTestEnv(t, provider)
+	manager := env.agent("manager", nil)
+	coder := env.agent("coder", nil)
+	provider.Enqueue(
+		llm.ScriptedTurn{Handoff: &llm.HandoffDecision{TargetAgentID: coder.ID, Reason: "needs code"}, Usage: map[string]int{"prompt_tokens": 50, "completion_tokens": 5}},
+		llm.ScriptedTurn{Content: "Here is the code.", Usage: map[string]int{"prompt_tokens": 70, "completion_tokens": 20}},
+	)
+	run := env.startRun(manager, "write a function")
+
+	events := env.stream(run.ID)
+	if len(events) != 6 {
+		t.Fatalf("events = %+v", events)
+	}
+	// handoff 事件标明新建的子 Run，之后子 Run 的事件经父 Run 的流转发，最后由父 Run 结束
+	handoff := events[0]
+	child := env.store.GetRun(handoff.RunID)
+	if handoff.Type != "handoff" || handoff.ParentRunID != run.ID || handoff.AgentID != coder.ID || handoff.Content != "needs code" || child == nil {
+		t.Fatalf("handoff event = %+v", handoff)
+	}
+	for _, ev := range events[1:5] {
+		if ev.Type != "content" || ev.RunID != child.ID || ev.AgentID != coder.ID {
+			t.Fatalf("child event = %+v", ev)
+		}
+	}
+	if end := events[5]; end.Type != "done" || end.RunID != run.ID || end.AgentID != manager.ID || end.Content != "Here is the code." {
+		t.Fatalf("end event = %+v", end)
+	}
+
+	if child.ParentRunID != run.ID || child.Status != "succeeded" || child.TraceID != run.TraceID {
+		t.Fatalf("child run = %+v", child)
+	}
+	parent := env.store.GetRun(run.ID)
+	if parent.Status != "succeeded" || parent.OutputPayload["child_run_id"] != child.ID || parent.OutputPayload["response"] != "Here is the code." {
+		t.Fatalf("parent run = %s %v", parent.Status, parent.OutputPayload)
+	}
+	if steps := env.stepTypes(run.ID); !reflect.DeepEqual(steps, []string{"llm_call", "handoff"}) {
+		t.Fatalf("parent steps = %v", steps)
+	}
+	// 子 Run 的用量计入父 Run 的 rollup
+	rollup, _ := parent.UsageMetadata["rollup"].(map[string]interface{})
+	if u := usageOf(rollup); u.PromptTokens != 120 || u.CompletionTokens != 25 || u.LLMCalls != 2 {
+		t.Fatalf("rollup = %+v", u)
+	}
+	// 子 Agent 看到的候选里没有它自己
+	for _, c := range env.llm.Requests[1].HandoffCandidates {
+		if c.AgentID == coder.ID {
+			t.Fatal("child agent offered itself as handoff target")
+		}
+	}
+}
+
+func TestExecuteRunFixtureReplay(t *testing.T) {
+	provider, err := llm.LoadFixture(filepath.Join("testdata", "list_and_read.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := newTestEnv(t, provider)
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "Summarize the README in this workspace.")
+	env.writeFile(run, "README.md", "# demo\nA tiny demo project.\n")
+
+	final, err := env.e.ExecuteRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final != "The workspace holds a tiny demo project described in README.md." {
+		t.Fatalf("final = %q", final)
+	}
+	if provider.Remaining() != 0 {
+		t.Fatalf("%d recorded turns not replayed", provider.Remaining())
+	}
+	msgs := env.store.ListChatMessagesBySession(run.SessionID)
+	if roles := env.roles(run.SessionID); !reflect.DeepEqual(roles, []string{"user", "assistant", "tool", "assistant", "tool", "assistant"}) {
+		t.Fatalf("roles = %v", roles)
+	}
+	// 录制里的 call id 原样回放，工具真实执行
+	if msgs[2].ToolCallID != "call_list" || !strings.Contains(messageText(msgs[2]), "README.md") {
+		t.Fatalf("list result = %s %q", msgs[2].ToolCallID, messageText(msgs[2]))
+	}
+	if msgs[4].ToolCallID != "call_read" || !strings.Contains(messageText(msgs[4]), "tiny demo project") {
+		t.Fatalf("read result = %s %q", msgs[4].ToolCallID, messageText(msgs[4]))
+	}
+	if u := usageOf(env.store.GetRun(run.ID).UsageMetadata); u.TotalTokens != 1010 || u.LLMCalls != 3 {
+		t.Fatalf("usage = %+v", u)
+	}
+}
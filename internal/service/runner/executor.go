@@ -2,41 +2,46 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os/exec"
 	"time"
 
+	"example.com/agent-server/internal/service/llm"
 	"example.com/agent-server/internal/service/mcp"
 	"example.com/agent-server/internal/store"
-	"github.com/sashabaranov/go-openai"
 )
 
 // executeToolCall 分发并执行工具
-func (e *AgentEngine) executeToolCall(ctx context.Context, call openai.ToolCall) (string, error) {
-
-	// 为了代码跑通，我们临时在这里 new 一个 executor
-	// 最佳实践是在 NewEngine 里初始化它
-	executor := mcp.NewExecutor(e.Store)
-
-	// 1. 获取工具名和参数
-	// 现在 call 是具体类型，编译器能识别 Function 字段了
-	toolName := call.Function.Name
-	argsJSON := call.Function.Arguments
+// limit 为工具输出上限，read_tool_output 每次最多返回这么多字符
+func (e *AgentEngine) executeToolCall(ctx context.Context, run *store.Run, toolset *mcp.ToolSet, tc llm.ToolCallInfo, limit int) (string, error) {
+	if tc.Name == ReadToolOutputTool {
+		args, err := validateReadToolOutput(tc.Arguments, limit)
+		if err != nil {
+			return "", err
+		}
+		return e.readToolOutput(run, args, limit)
+	}
 
-	// 2. 反查 Tool 对应的 Server
-	// 我们需要先找到 tool 属于哪个 server
-	toolDef := e.Store.FindMCPToolByName(toolName)
-	if toolDef == nil {
-		return "", fmt.Errorf("tool definition not found: %s", toolName)
+	// 在当前 Agent 的工具集合内解析出 Tool 和对应的 Server
+	// 不在集合内（未绑定给该 Agent）的工具直接拒绝
+	bound, err := toolset.Resolve(tc.Name)
+	if err != nil {
+		return "", err
 	}
 
-	server := e.Store.GetMCPServer(toolDef.ServerID)
-	if server == nil {
-		return "", fmt.Errorf("server not found for tool: %s", toolName)
+	// 按工具的 InputSchema 校验参数并补齐默认值；不通过时不分发，返回 *mcp.ValidationError
+	args, err := mcp.ValidateArguments(tc.Name, bound.Tool.InputSchema, tc.Arguments)
+	if err != nil {
+		return "", err
+	}
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return "", err
 	}
 
-	// 3. 执行
-	return executor.ExecuteTool(ctx, server, toolName, argsJSON)
+	// 发给 Server 的是它自己的原始工具名
+	return e.Executor.ExecuteTool(ctx, bound.Server, bound.Tool.Name, string(argsJSON))
 }
 
 // runCmd 辅助函数：在服务器本地执行 Shell 命令
@@ -51,12 +56,17 @@ func runCmd(name string, args ...string) (string, error) {
 }
 
 // saveToolOutput 辅助：保存工具执行结果
-func (e *AgentEngine) saveToolOutput(run *store.Run, toolCallID, output string) {
+// outputID 非空表示 output 是截断后的文本，全文存在对应的 ToolOutput 里
+func (e *AgentEngine) saveToolOutput(run *store.Run, toolCallID, output, outputID string) {
+	content := map[string]interface{}{"type": "text", "text": output}
+	if outputID != "" {
+		content["output_id"] = outputID
+	}
 	e.Store.CreateChatMessage(&store.ChatMessage{
 		SessionID:  run.SessionID,
 		RunID:      run.ID,
 		Role:       "tool", // 角色必须是 tool
-		Content:    map[string]interface{}{"type": "text", "text": output},
+		Content:    content,
 		ToolCallID: toolCallID, // 必须填！跟 Assistant 的 tool_calls[i].id 对应
 		CreatedAt:  time.Now(),
 		IsHidden:   true, // 前端通常不展示大段的工具日志
@@ -0,0 +1,116 @@
+//go:build unix
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/service/llm"
+)
+
+// fakeGrep 把 PATH 里的 grep 换成给定的脚本，fs__search_files 会执行它；返回脚本所在的目录
+// 脚本的参数是 -r -e <pattern> -- .，$3 即 pattern
+func fakeGrep(t *testing.T, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "grep"), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// hangingGrep 换上一个不会结束的 grep：它先留下 started 标记，
+// 再起一个子进程，0.5 秒后留下 survived 标记。只杀脚本本身时子进程还活着，会留下 survived
+func hangingGrep(t *testing.T) (started, survived string) {
+	t.Helper()
+	dir := fakeGrep(t, "touch \"$(dirname \"$0\")/started\"\n(sleep 0.5; touch \"$(dirname \"$0\")/survived\") &\nwait\n")
+	return filepath.Join(dir, "started"), filepath.Join(dir, "survived")
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// assertGroupKilled 工具很快返回（不用等 WaitDelay），子进程也没能留下标记
+func assertGroupKilled(t *testing.T, elapsed time.Duration, survived string) {
+	t.Helper()
+	if elapsed > 1500*time.Millisecond {
+		t.Fatalf("tool took %s to return after being killed", elapsed)
+	}
+	time.Sleep(time.Second)
+	if exists(survived) {
+		t.Fatal("child process of the tool survived")
+	}
+}
+
+var searchCall = llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c1", Name: "fs__search_files", Arguments: `{"pattern":"TODO"}`}}}
+
+func TestToolTimeoutKillsProcessGroup(t *testing.T) {
+	_, survived := hangingGrep(t)
+	env := newTestEnv(t, llm.NewScriptedProvider(searchCall, llm.ScriptedTurn{Content: "The search timed out."}))
+	agent := env.agent("coder", nil)
+	// 工具级超时优先于 Server 级
+	env.bindFS(agent, map[string]interface{}{"timeout_seconds": 30, "tool_timeouts": map[string]interface{}{"search_files": 0.2}})
+	run := env.startRun(agent, "find TODOs")
+
+	start := time.Now()
+	events := env.stream(run.ID)
+	assertGroupKilled(t, time.Since(start), survived)
+
+	// 超时只让这次调用失败，结果交给模型，Run 继续
+	var end RunStreamEvent
+	for _, ev := range events {
+		if ev.Type == "tool_end" {
+			end = ev
+		}
+	}
+	if end.Content != "Tool Execution Error: tool search_files timed out after 200ms" {
+		t.Fatalf("tool_end = %+v", end)
+	}
+	if got := env.store.GetRun(run.ID); got.Status != "succeeded" {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	for _, s := range env.store.ListRunStepsByRun(run.ID) {
+		if s.StepType == "tool_call" && (s.Status != "failed" || !strings.Contains(s.ErrorMessage, "timed out")) {
+			t.Fatalf("tool step = %s %q", s.Status, s.ErrorMessage)
+		}
+	}
+}
+
+func TestCancelRunKillsToolProcessGroup(t *testing.T) {
+	started, survived := hangingGrep(t)
+	env := newTestEnv(t, llm.NewScriptedProvider(searchCall))
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "find TODOs")
+
+	cancelled := make(chan time.Time, 1)
+	go func() {
+		for !exists(started) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancelled <- time.Now()
+		if err := env.e.CancelRun(run.ID); err != nil {
+			t.Errorf("cancel: %v", err)
+		}
+	}()
+	events := env.stream(run.ID)
+	assertGroupKilled(t, time.Since(<-cancelled), survived)
+
+	if last := events[len(events)-1]; last.Type != "error" || last.Content != ErrRunCancelled.Error() {
+		t.Fatalf("last event = %+v", last)
+	}
+	if got := env.store.GetRun(run.ID); got.Status != "cancelled" {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	// 只请求过一次模型：取消后不再把工具错误交给模型
+	if len(env.llm.Requests) != 1 {
+		t.Fatalf("model called %d times", len(env.llm.Requests))
+	}
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"fmt"
+
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+)
+
+// 工具输出长度上限，Agent.ExtraConfig 可覆盖：{"max_tool_output_chars": 8000}
+// 超出部分不进入对话历史，全文存为 ToolOutput，模型通过 read_tool_output 按需分段读取
+const (
+	cfgMaxToolOutput = "max_tool_output_chars"
+
+	defaultMaxToolOutput = 20000
+
+	// ReadToolOutputTool 引擎内置的工具，不属于任何 MCP Server
+	ReadToolOutputTool = "read_tool_output"
+)
+
+// ToolOutputLimit 单次工具输出进入对话历史的最大字符数
+func ToolOutputLimit(agent *store.Agent) int {
+	if agent != nil {
+		if v := configInt(agent.ExtraConfig, cfgMaxToolOutput); v > 0 {
+			return v
+		}
+	}
+	return defaultMaxToolOutput
+}
+
+// truncation 一次截断的记录，写入 Trace
+type truncation struct {
+	OutputID     string
+	OriginalSize int
+}
+
+// capToolOutput 超出上限时保存全文，返回截断后的文本和截断记录（未截断时为 nil）
+func (e *AgentEngine) capToolOutput(run *store.Run, toolCallID, toolName, output string, limit int) (string, *truncation) {
+	runes := []rune(output)
+	if len(runes) <= limit {
+		return output, nil
+	}
+	saved := e.Store.CreateToolOutput(&store.ToolOutput{
+		SessionID:  run.SessionID,
+		RunID:      run.ID,
+		ToolCallID: toolCallID,
+		ToolName:   toolName,
+		Content:    output,
+		Size:       len(runes),
+	})
+	notice := fmt.Sprintf("\n\n[Output truncated: showing the first %d of %d characters. The full output is stored as output_id=%q; call %s with {\"output_id\": %q, \"offset\": %d} to read more.]",
+		limit, len(runes), saved.ID, ReadToolOutputTool, saved.ID, limit)
+	return string(runes[:limit]) + notice, &truncation{OutputID: saved.ID, OriginalSize: len(runes)}
+}
+
+// hasStoredOutputs 会话历史里有被截断的工具输出时，才把 read_tool_output 提供给模型
+func hasStoredOutputs(history []*store.ChatMessage) bool {
+	for _, m := range history {
+		if id, _ := m.Content["output_id"].(string); m.Role == "tool" && id != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func readToolOutputDef(limit int) *store.MCPTool {
+	return &store.MCPTool{
+		Name:        ReadToolOutputTool,
+		Description: "Read part of a tool output that was truncated earlier in this conversation, using the output_id from the truncation notice.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"output_id": map[string]interface{}{"type": "string", "description": "output_id from the truncation notice"},
+				"offset":    map[string]interface{}{"type": "integer", "minimum": 0, "default": 0, "description": "Character offset to start reading from"},
+				"limit":     map[string]interface{}{"type": "integer", "minimum": 1, "maximum": limit, "default": limit, "description": "Maximum number of characters to return"},
+			},
+			"required":             []string{"output_id"},
+			"additionalProperties": false,
+		},
+	}
+}
+
+// readToolOutput 读取同一会话内保存的工具输出全文的一段
+func (e *AgentEngine) readToolOutput(run *store.Run, args map[string]interface{}, limit int) (string, error) {
+	id, _ := args["output_id"].(string)
+	out := e.Store.GetToolOutput(id)
+	if out == nil || out.SessionID != run.SessionID {
+		return "", fmt.Errorf("tool output %q not found", id)
+	}
+
+	offset := configInt(args, "offset")
+	if n := configInt(args, "limit"); n > 0 && n < limit {
+		limit = n
+	}
+	runes := []rune(out.Content)
+	if offset >= len(runes) {
+		return "", fmt.Errorf("offset %d is beyond the end of the output (%d characters)", offset, len(runes))
+	}
+	end := offset + limit
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	footer := fmt.Sprintf("\n\n[Characters %d-%d of %d.", offset, end, len(runes))
+	if end < len(runes) {
+		footer += fmt.Sprintf(" Call %s with offset=%d to continue.]", ReadToolOutputTool, end)
+	} else {
+		footer += " End of output.]"
+	}
+	return string(runes[offset:end]) + footer, nil
+}
+
+// validateReadToolOutput 和 MCP 工具走同一套参数校验
+func validateReadToolOutput(argsJSON string, limit int) (map[string]interface{}, error) {
+	return mcp.ValidateArguments(ReadToolOutputTool, readToolOutputDef(limit).InputSchema, argsJSON)
+}
@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// followUp 在同一会话里再发一条用户消息并创建 running 状态的 Run
+func (env *testEnv) followUp(prev *store.Run, prompt string) *store.Run {
+	env.t.Helper()
+	env.store.CreateChatMessage(&store.ChatMessage{SessionID: prev.SessionID, Role: "user", Content: map[string]interface{}{"type": "text", "text": prompt}, CreatedAt: time.Now()})
+	run, err := env.store.CreateRun(&store.Run{SessionID: prev.SessionID, UserID: prev.UserID, AgentID: prev.AgentID, TraceID: "trace-2", Status: "running"})
+	if err != nil {
+		env.t.Fatalf("create run: %v", err)
+	}
+	return run
+}
+
+// toolMessages 会话里的工具结果消息，按写入顺序
+func (env *testEnv) toolMessages(sessionID string) []*store.ChatMessage {
+	var msgs []*store.ChatMessage
+	for _, m := range env.store.ListChatMessagesBySession(sessionID) {
+		if m.Role == "tool" {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+func offersTool(req *llm.ChatRequest, name string) bool {
+	for _, tool := range req.Tools {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestToolOutputTruncatedAndReadBack(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c1", Name: "fs__read_file", Arguments: `{"path":"big.txt"}`}}},
+		llm.ScriptedTurn{Content: "The file is long."},
+	))
+	agent := env.agent("coder", map[string]interface{}{"max_tool_output_chars": 100})
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "read big.txt")
+	// 按字符（rune）而不是字节计数
+	full := strings.Repeat("abcdefghi行", 25)
+	runes := []rune(full)
+	env.writeFile(run, "big.txt", full)
+
+	if final, err := env.e.ExecuteRun(run.ID); err != nil || final != "The file is long." {
+		t.Fatalf("ExecuteRun = %q, %v", final, err)
+	}
+
+	// 对话里只有前 100 个字符和截断说明，全文另存
+	msgs := env.toolMessages(run.SessionID)
+	outputID, _ := msgs[0].Content["output_id"].(string)
+	text := messageText(msgs[0])
+	if outputID == "" || !strings.HasPrefix(text, string(runes[:100])+"\n\n[Output truncated: showing the first 100 of 250 characters.") || !strings.Contains(text, `"output_id": "`+outputID+`", "offset": 100`) {
+		t.Fatalf("tool message = %q (output_id %q)", text, outputID)
+	}
+	saved := env.store.GetToolOutput(outputID)
+	if saved == nil || saved.Content != full || saved.Size != 250 || saved.SessionID != run.SessionID || saved.ToolCallID != "c1" || saved.ToolName != "fs__read_file" {
+		t.Fatalf("stored output = %+v", saved)
+	}
+	// Trace 记录原始大小
+	var step *store.RunStep
+	for _, s := range env.store.ListRunStepsByRun(run.ID) {
+		if s.StepType == "tool_call" {
+			step = s
+		}
+	}
+	if step.OutputPayload["truncated"] != true || step.OutputPayload["original_size"] != 250 || step.OutputPayload["output_id"] != outputID || step.OutputPayload["output"] != text {
+		t.Fatalf("tool step = %v", step.OutputPayload)
+	}
+	// 有了被截断的输出之后才提供 read_tool_output
+	if offersTool(env.llm.Requests[0], ReadToolOutputTool) || !offersTool(env.llm.Requests[1], ReadToolOutputTool) {
+		t.Fatal("read_tool_output should only be offered once an output was truncated")
+	}
+
+	// 下一个 Run 按 output_id 分段读回其余部分
+	env.llm.Enqueue(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c2", Name: ReadToolOutputTool, Arguments: `{"output_id":"` + outputID + `","offset":100}`}}},
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c3", Name: ReadToolOutputTool, Arguments: `{"output_id":"` + outputID + `","offset":200,"limit":80}`}}},
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c4", Name: ReadToolOutputTool, Arguments: `{"output_id":"` + outputID + `","offset":250}`}}},
+		llm.ScriptedTurn{Content: "Read it all."},
+	)
+	next := env.followUp(run, "show me the rest")
+	if final, err := env.e.ExecuteRun(next.ID); err != nil || final != "Read it all." {
+		t.Fatalf("ExecuteRun = %q, %v", final, err)
+	}
+	if !offersTool(env.llm.Requests[2], ReadToolOutputTool) {
+		t.Fatal("read_tool_output should be offered while the history has a truncated output")
+	}
+	msgs = env.toolMessages(run.SessionID)
+	want := []string{
+		// 默认每次最多读上限个字符
+		string(runes[100:200]) + "\n\n[Characters 100-200 of 250. Call read_tool_output with offset=200 to continue.]",
+		// 剩余不足 limit 时读到末尾
+		string(runes[200:250]) + "\n\n[Characters 200-250 of 250. End of output.]",
+		"Tool Execution Error: offset 250 is beyond the end of the output (250 characters)",
+	}
+	for i, w := range want {
+		m := msgs[i+1]
+		// 读回的内容不再截断
+		if got := messageText(m); got != w || m.Content["output_id"] != nil {
+			t.Fatalf("read %d = %q (output_id %v), want %q", i, got, m.Content["output_id"], w)
+		}
+	}
+}
+
+func TestReadToolOutputStaysInSession(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c1", Name: "fs__read_file", Arguments: `{"path":"big.txt"}`}}},
+		llm.ScriptedTurn{Content: "Long."},
+	))
+	agent := env.agent("coder", map[string]interface{}{"max_tool_output_chars": 10})
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "read big.txt")
+	env.writeFile(run, "big.txt", strings.Repeat("x", 50))
+	if _, err := env.e.ExecuteRun(run.ID); err != nil {
+		t.Fatal(err)
+	}
+	outputID, _ := env.toolMessages(run.SessionID)[0].Content["output_id"].(string)
+
+	// 另一个会话拿到 output_id 也读不到
+	env.llm.Enqueue(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c2", Name: ReadToolOutputTool, Arguments: `{"output_id":"` + outputID + `"}`}}},
+		llm.ScriptedTurn{Content: "Not found."},
+	)
+	other := env.startRun(agent, "read it")
+	if _, err := env.e.ExecuteRun(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	msgs := env.toolMessages(other.SessionID)
+	if len(msgs) != 1 || messageText(msgs[0]) != `Tool Execution Error: tool output "`+outputID+`" not found` {
+		t.Fatalf("tool results = %v", msgs)
+	}
+}
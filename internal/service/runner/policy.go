@@ -0,0 +1,324 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+)
+
+// 工具权限策略，配置在 Agent.ExtraConfig.tool_policy：
+//
+//	{"tool_policy": {
+//	    "allow": ["read_file", "git_*"],        // 非空时只允许这些工具
+//	    "deny":  ["git_commit"],                // 优先于 allow
+//	    "rules": {
+//	        "read_file":  {"args": {"path": {"prefixes": ["docs/"]}}},
+//	        "git_diff":   {"args": {"target": {"one_of": ["HEAD", "main"]}}},
+//	        "write_file": {"max_calls": 3}
+//	    }
+//	}}
+//
+// 工具名可以写原名或带 Server 命名空间的名字，支持 * ? 通配
+// 每个调用在执行（和审批）前评估，决定写入 Trace；拒绝的调用不执行，原因作为工具结果交给模型
+const cfgToolPolicy = "tool_policy"
+
+// ToolPolicy Agent 的工具权限策略
+type ToolPolicy struct {
+	Allow []string            `json:"allow,omitempty"`
+	Deny  []string            `json:"deny,omitempty"`
+	Rules map[string]ToolRule `json:"rules,omitempty"`
+}
+
+// ToolRule 对某个（某类）工具的限制
+type ToolRule struct {
+	Args     map[string]ArgConstraint `json:"args,omitempty"`
+	MaxCalls int                      `json:"max_calls,omitempty"` // 每个 Run 最多调用次数，0 不限制
+}
+
+// ArgConstraint 对单个参数的限制，同时配置时需全部满足；参数缺省时视为不满足
+type ArgConstraint struct {
+	Prefixes []string      `json:"prefixes,omitempty"` // 路径前缀，按清理后的相对路径比较，带 ../ 跳出的一律不满足
+	OneOf    []interface{} `json:"one_of,omitempty"`
+	Pattern  string        `json:"pattern,omitempty"` // 正则，需匹配整个值
+
+	re *regexp.Regexp
+}
+
+// ParseToolPolicy 从 ExtraConfig 读取策略，没有配置时返回 nil
+// 创建 / 更新 Agent 时用它校验配置
+func ParseToolPolicy(cfg map[string]interface{}) (*ToolPolicy, error) {
+	raw, ok := cfg[cfgToolPolicy]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", cfgToolPolicy, err)
+	}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+	var p ToolPolicy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", cfgToolPolicy, err)
+	}
+
+	for _, pattern := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s: bad tool pattern %q", cfgToolPolicy, pattern)
+		}
+	}
+	for name, rule := range p.Rules {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s: bad tool pattern %q", cfgToolPolicy, name)
+		}
+		if rule.MaxCalls < 0 {
+			return nil, fmt.Errorf("invalid %s: rules.%s.max_calls must be >= 0", cfgToolPolicy, name)
+		}
+		for arg, c := range rule.Args {
+			if c.Pattern == "" {
+				continue
+			}
+			re, err := regexp.Compile("^(?:" + c.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: rules.%s.args.%s.pattern: %v", cfgToolPolicy, name, arg, err)
+			}
+			c.re = re
+			rule.Args[arg] = c
+		}
+	}
+	return &p, nil
+}
+
+// policyDecision 一次评估的结果
+type policyDecision struct {
+	Allowed bool
+	Rule    string // 起作用的配置项，如 "deny[git_commit]"、"rules[read_file].args.path"
+	Reason  string
+
+	evaluated bool   // Agent 配置了策略，决定需要写入 Trace
+	counter   string // 计入 max_calls 的规则名，空表示不计数
+}
+
+// trace 写入 Trace 步骤的 output_payload.policy
+func (d policyDecision) trace() map[string]interface{} {
+	out := map[string]interface{}{"decision": "allow"}
+	if !d.Allowed {
+		out["decision"] = "deny"
+	}
+	if d.Rule != "" {
+		out["rule"] = d.Rule
+	}
+	if d.Reason != "" {
+		out["reason"] = d.Reason
+	}
+	if d.counter != "" {
+		out["counter"] = d.counter
+	}
+	return out
+}
+
+// policyEnforcer 一个 Run 内的策略评估，记录各规则已用的调用次数
+// 只在 runToolCalls 的主 goroutine 里使用，不需要加锁
+type policyEnforcer struct {
+	policy *ToolPolicy
+	calls  map[string]int
+}
+
+// newPolicyEnforcer 调用次数从 Run 已有的 Trace 统计，暂停恢复后接着累计
+func (e *AgentEngine) newPolicyEnforcer(run *store.Run, agent *store.Agent) *policyEnforcer {
+	p, err := ParseToolPolicy(agent.ExtraConfig)
+	if err != nil {
+		// 校验在保存 Agent 时已经做过，这里只可能是直接改库写坏了；宁可全部拒绝，不能当作没有策略
+		fmt.Printf("[Agent] %s: %v, denying all tool calls\n", agent.Name, err)
+		p = &ToolPolicy{Deny: []string{"*"}}
+	}
+	pe := &policyEnforcer{policy: p, calls: map[string]int{}}
+	if p == nil {
+		return pe
+	}
+	for _, s := range e.Store.ListRunStepsByRun(run.ID) {
+		if c, _ := s.OutputPayload["policy"].(map[string]interface{}); c != nil && s.StepType == "tool_call" && c["decision"] == "allow" {
+			if counter, _ := c["counter"].(string); counter != "" {
+				pe.calls[counter]++
+			}
+		}
+	}
+	return pe
+}
+
+// check 评估一个调用，不改变计数；参数先按 Schema 补齐默认值再比较
+func (pe *policyEnforcer) check(toolset *mcp.ToolSet, tc llm.ToolCallInfo) policyDecision {
+	p := pe.policy
+	if p == nil || tc.Name == ReadToolOutputTool {
+		return policyDecision{Allowed: true}
+	}
+	// 按解析到的工具匹配：命名空间名和原名都参与，内置工具以原名调用时 deny 里写的命名空间名同样生效
+	names := []string{tc.Name}
+	var args map[string]interface{}
+	if bt, err := toolset.Resolve(tc.Name); err == nil {
+		names = []string{bt.QualifiedName, bt.Tool.Name}
+		args, _ = mcp.ValidateArguments(tc.Name, bt.Tool.InputSchema, tc.Arguments)
+	}
+
+	if pattern, ok := matchAny(p.Deny, names); ok {
+		return policyDecision{Rule: "deny[" + pattern + "]", Reason: "tool is denied for this agent", evaluated: true}
+	}
+	d := policyDecision{Allowed: true, evaluated: true}
+	if len(p.Allow) > 0 {
+		pattern, ok := matchAny(p.Allow, names)
+		if !ok {
+			return policyDecision{Rule: "allow", Reason: "tool is not in this agent's allowlist", evaluated: true}
+		}
+		d.Rule = "allow[" + pattern + "]"
+	}
+
+	for _, key := range sortedRuleKeys(p.Rules) {
+		if _, ok := matchAny([]string{key}, names); !ok {
+			continue
+		}
+		rule := p.Rules[key]
+		// 参数不合法的调用不会执行（由 Schema 校验返回错误），不用再比较约束
+		if args != nil {
+			for _, arg := range sortedArgKeys(rule.Args) {
+				if reason := rule.Args[arg].violation(args[arg]); reason != "" {
+					return policyDecision{Rule: fmt.Sprintf("rules[%s].args.%s", key, arg), Reason: fmt.Sprintf("argument %q %s", arg, reason), evaluated: true}
+				}
+			}
+		}
+		if rule.MaxCalls > 0 {
+			if pe.calls[key] >= rule.MaxCalls {
+				return policyDecision{Rule: fmt.Sprintf("rules[%s].max_calls", key), Reason: fmt.Sprintf("at most %d call(s) per run; the limit is used up", rule.MaxCalls), evaluated: true}
+			}
+			d.counter = key
+		}
+		d.Rule = "rules[" + key + "]"
+	}
+	return d
+}
+
+// record 允许的调用确定执行时计数
+func (pe *policyEnforcer) record(d policyDecision) {
+	if d.Allowed && d.counter != "" {
+		pe.calls[d.counter]++
+	}
+}
+
+// visible 去掉被 allow / deny 整体禁止的工具，不提供给模型；参数约束和次数上限在调用时才能判断
+func (pe *policyEnforcer) visible(toolset *mcp.ToolSet, tools []*store.MCPTool) []*store.MCPTool {
+	if pe.policy == nil {
+		return tools
+	}
+	res := make([]*store.MCPTool, 0, len(tools))
+	for _, t := range tools {
+		d := pe.check(toolset, llm.ToolCallInfo{Name: t.Name})
+		if d.Allowed || strings.HasPrefix(d.Rule, "rules[") {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// violation 不满足约束时返回原因
+func (c ArgConstraint) violation(v interface{}) string {
+	if v == nil {
+		return "is required by the policy"
+	}
+	if len(c.OneOf) > 0 && !oneOf(v, c.OneOf) {
+		b, _ := json.Marshal(c.OneOf)
+		return fmt.Sprintf("must be one of %s", b)
+	}
+	s, isString := v.(string)
+	if len(c.Prefixes) > 0 {
+		if !isString || !underPrefixes(s, c.Prefixes) {
+			return fmt.Sprintf("must be a path under %s", strings.Join(c.Prefixes, ", "))
+		}
+	}
+	if c.re != nil {
+		if !isString || !c.re.MatchString(s) {
+			return fmt.Sprintf("must match %s", c.Pattern)
+		}
+	}
+	return ""
+}
+
+// underPrefixes 路径按工作区内的相对路径清理后再比较前缀，docs/../secret 不算在 docs/ 下，
+// 清理后仍以 ../ 开头（跳出工作区）的路径不在任何前缀下
+func underPrefixes(p string, prefixes []string) bool {
+	clean := path.Clean(strings.TrimPrefix(p, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return false
+	}
+	for _, prefix := range prefixes {
+		dir := path.Clean(strings.TrimPrefix(prefix, "/"))
+		if dir == "." || clean == dir || strings.HasPrefix(clean, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// oneOf 按 JSON 值比较，1 与 1.0 相等
+func oneOf(v interface{}, options []interface{}) bool {
+	want, _ := json.Marshal(v)
+	for _, o := range options {
+		if b, _ := json.Marshal(o); string(b) == string(want) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns, names []string) (string, bool) {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+func sortedRuleKeys(m map[string]ToolRule) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedArgKeys(m map[string]ArgConstraint) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// denyToolCall 策略拒绝：不执行，把原因告诉模型
+func (e *AgentEngine) denyToolCall(run *store.Run, tc llm.ToolCallInfo, d policyDecision, emit func(RunStreamEvent)) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"error":  "policy_denied",
+		"tool":   tc.Name,
+		"rule":   d.Rule,
+		"reason": d.Reason,
+		"hint":   "The call was not executed. It is not permitted by this agent's tool policy; do not retry it as-is.",
+	})
+	output := string(b)
+
+	var args map[string]interface{}
+	_ = json.Unmarshal([]byte(tc.Arguments), &args)
+	step := e.createStep(run, "tool_call", tc.Name, args)
+	e.finishStep(step.ID, map[string]interface{}{"tool_call_id": tc.ID, "policy": d.trace(), "output": output}, "denied", d.Reason)
+	emit(RunStreamEvent{Type: "tool_end", Tool: tc.Name, ToolCallID: tc.ID, Content: output})
+	return output
+}
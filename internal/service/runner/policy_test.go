@@ -0,0 +1,243 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+)
+
+func TestParseToolPolicy(t *testing.T) {
+	cases := []struct {
+		name   string
+		cfg    map[string]interface{}
+		errHas string
+		isNil  bool
+	}{
+		{name: "not configured", cfg: map[string]interface{}{"max_steps": 3}, isNil: true},
+		{name: "null", cfg: map[string]interface{}{"tool_policy": nil}, isNil: true},
+		{name: "valid", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{
+			"allow": []interface{}{"read_file", "git_*"},
+			"deny":  []interface{}{"git_commit"},
+			"rules": map[string]interface{}{
+				"read_file":  map[string]interface{}{"args": map[string]interface{}{"path": map[string]interface{}{"prefixes": []interface{}{"docs/"}}}},
+				"git_diff":   map[string]interface{}{"args": map[string]interface{}{"target": map[string]interface{}{"one_of": []interface{}{"HEAD"}, "pattern": "[A-Za-z]+"}}},
+				"write_file": map[string]interface{}{"max_calls": 3},
+			},
+		}}},
+		{name: "unknown field", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{"alow": []interface{}{"x"}}}, errHas: `unknown field "alow"`},
+		{name: "wrong shape", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{"allow": "read_file"}}, errHas: "invalid tool_policy"},
+		{name: "bad allow pattern", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{"allow": []interface{}{"read_[file"}}}, errHas: `bad tool pattern "read_[file"`},
+		{name: "bad deny pattern", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{"deny": []interface{}{"["}}}, errHas: `bad tool pattern "["`},
+		{name: "bad rule pattern", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{"rules": map[string]interface{}{"[": map[string]interface{}{}}}}, errHas: `bad tool pattern "["`},
+		{name: "negative max_calls", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{"rules": map[string]interface{}{"write_file": map[string]interface{}{"max_calls": -1}}}}, errHas: "rules.write_file.max_calls must be >= 0"},
+		{name: "bad arg regexp", cfg: map[string]interface{}{"tool_policy": map[string]interface{}{"rules": map[string]interface{}{"git_diff": map[string]interface{}{"args": map[string]interface{}{"target": map[string]interface{}{"pattern": "("}}}}}}, errHas: "rules.git_diff.args.target.pattern"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := ParseToolPolicy(c.cfg)
+			if c.errHas != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errHas) {
+					t.Fatalf("err = %v, want %q", err, c.errHas)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (p == nil) != c.isNil {
+				t.Fatalf("policy = %+v", p)
+			}
+		})
+	}
+
+	// 参数正则在解析时编译，匹配整个值
+	p, _ := ParseToolPolicy(cases[2].cfg)
+	if c := p.Rules["git_diff"].Args["target"]; c.re == nil || c.re.MatchString("HEAD~1") || !c.re.MatchString("main") {
+		t.Fatalf("pattern not anchored: %+v", c)
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider())
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	env.bindBuiltin(agent, "git", mcp.BuiltinGit, nil)
+	toolset := mcp.LoadToolSet(env.store, agent.ID)
+
+	policy, err := ParseToolPolicy(map[string]interface{}{"tool_policy": map[string]interface{}{
+		// 原名和命名空间名都可以写；deny 优先于 allow
+		"allow": []interface{}{"fs__*", "git_*"},
+		"deny":  []interface{}{"git_commit", "fs__write_file"},
+		"rules": map[string]interface{}{
+			"read_file":     map[string]interface{}{"args": map[string]interface{}{"path": map[string]interface{}{"prefixes": []interface{}{"docs/"}}}},
+			"git__git_diff": map[string]interface{}{"args": map[string]interface{}{"target": map[string]interface{}{"one_of": []interface{}{"HEAD", "main"}}}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe := &policyEnforcer{policy: policy, calls: map[string]int{}}
+
+	cases := []struct {
+		tool, args string
+		allowed    bool
+		rule       string
+	}{
+		{"fs__read_file", `{"path":"docs/a.md"}`, true, "rules[read_file]"},
+		{"fs__read_file", `{"path":"./docs/x"}`, true, "rules[read_file]"},
+		{"fs__read_file", `{"path":"docs"}`, true, "rules[read_file]"},
+		{"fs__read_file", `{"path":"docs/../secret"}`, false, "rules[read_file].args.path"},
+		{"fs__read_file", `{"path":"docsx/a"}`, false, "rules[read_file].args.path"},
+		{"fs__read_file", `{"path":"../docs/a"}`, false, "rules[read_file].args.path"},
+		// 内置工具的原始名解析到同一个工具，按命名空间名写的 allow / deny 同样生效
+		{"read_file", `{"path":"docs/a"}`, true, "rules[read_file]"},
+		{"read_file", `{"path":"secret"}`, false, "rules[read_file].args.path"},
+		{"write_file", `{"path":"docs/a","content":"x"}`, false, "deny[fs__write_file]"},
+		{"fs__list_directory", `{}`, true, "allow[fs__*]"},
+		{"fs__write_file", `{"path":"docs/a","content":"x"}`, false, "deny[fs__write_file]"},
+		// allow 里的 git_* 匹配原名，但 deny 优先
+		{"git__git_commit", `{"message":"wip"}`, false, "deny[git_commit]"},
+		{"git__git_status", `{}`, true, "allow[git_*]"},
+		// 缺省参数按 Schema 默认值 (HEAD) 比较
+		{"git__git_diff", `{}`, true, "rules[git__git_diff]"},
+		{"git__git_diff", `{"target":"main"}`, true, "rules[git__git_diff]"},
+		{"git__git_diff", `{"target":"dev"}`, false, "rules[git__git_diff].args.target"},
+		{"slack__post", `{}`, false, "allow"},
+		{ReadToolOutputTool, `{}`, true, ""},
+	}
+	for _, c := range cases {
+		d := pe.check(toolset, llm.ToolCallInfo{Name: c.tool, Arguments: c.args})
+		if d.Allowed != c.allowed || d.Rule != c.rule {
+			t.Errorf("check(%s %s) = allowed %v rule %q (%s); want %v %q", c.tool, c.args, d.Allowed, d.Rule, d.Reason, c.allowed, c.rule)
+		}
+	}
+
+	// 被 allow / deny 整体禁止的工具不提供给模型，有参数约束的照常提供
+	var visible []string
+	for _, tool := range pe.visible(toolset, toolset.Tools()) {
+		visible = append(visible, tool.Name)
+	}
+	got := strings.Join(visible, ",")
+	for _, name := range []string{"fs__read_file", "git__git_diff", "git__git_status"} {
+		if !strings.Contains(got, name) {
+			t.Errorf("%s should be visible: %s", name, got)
+		}
+	}
+	for _, name := range []string{"fs__write_file", "git__git_commit"} {
+		if strings.Contains(got, name) {
+			t.Errorf("%s should be hidden: %s", name, got)
+		}
+	}
+}
+
+func TestUnderPrefixes(t *testing.T) {
+	cases := []struct {
+		path     string
+		prefixes []string
+		want     bool
+	}{
+		{"docs/a.md", []string{"docs/"}, true},
+		{"./docs/x", []string{"docs/"}, true},
+		{"docs/x", []string{"./docs"}, true},
+		{"docs", []string{"docs/"}, true},
+		{"docs/sub/../b.md", []string{"docs/"}, true},
+		{"docs/../secret", []string{"docs/"}, false},
+		{"docs/../../etc/passwd", []string{"docs/"}, false},
+		{"../docs/a", []string{"docs/"}, false},
+		{"..", []string{"."}, false},
+		{"docsx/a", []string{"docs/"}, false},
+		{"/docs/a", []string{"docs/"}, true},
+		{"src/main.go", []string{"docs/", "src/"}, true},
+		{"anything", []string{"."}, true},
+	}
+	for _, c := range cases {
+		if got := underPrefixes(c.path, c.prefixes); got != c.want {
+			t.Errorf("underPrefixes(%q, %v) = %v, want %v", c.path, c.prefixes, got, c.want)
+		}
+	}
+}
+
+func TestOneOf(t *testing.T) {
+	cases := []struct {
+		value   interface{}
+		options []interface{}
+		want    bool
+	}{
+		{"HEAD", []interface{}{"HEAD", "main"}, true},
+		{"dev", []interface{}{"HEAD", "main"}, false},
+		{float64(1), []interface{}{1}, true},
+		{1.0, []interface{}{float64(1)}, true},
+		{"1", []interface{}{1}, false},
+		{true, []interface{}{true}, true},
+		{false, []interface{}{"false"}, false},
+		{nil, []interface{}{"x"}, false},
+	}
+	for _, c := range cases {
+		if got := oneOf(c.value, c.options); got != c.want {
+			t.Errorf("oneOf(%#v, %#v) = %v, want %v", c.value, c.options, got, c.want)
+		}
+	}
+}
+
+// max_calls 的计数在审批暂停恢复后从 Trace 里接着累计
+func TestPolicyMaxCallsSurvivesPause(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "w1", Name: "fs__write_file", Arguments: `{"path":"a.txt","content":"A"}`}}},
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "r1", Name: "fs__read_file", Arguments: `{"path":"a.txt"}`}}},
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "w2", Name: "fs__write_file", Arguments: `{"path":"b.txt","content":"B"}`}}},
+		llm.ScriptedTurn{Content: "Only one write was allowed."},
+	))
+	agent := env.agent("coder", map[string]interface{}{"tool_policy": map[string]interface{}{
+		"rules": map[string]interface{}{"write_file": map[string]interface{}{"max_calls": 1}},
+	}})
+	env.bindFS(agent, map[string]interface{}{"require_approval": []interface{}{"read_file"}})
+	run := env.startRun(agent, "write two files")
+
+	events := env.stream(run.ID)
+	last := events[len(events)-1]
+	if last.Type != "approval_required" || last.ToolCallID != "r1" {
+		t.Fatalf("expected pause on read_file, got %+v", last)
+	}
+	if status := env.store.GetRun(run.ID).Status; status != "awaiting_approval" {
+		t.Fatalf("status = %s", status)
+	}
+	if !env.store.DecideToolApproval(last.ApprovalID, "approved", nil, "") {
+		t.Fatal("decide approval failed")
+	}
+
+	// 恢复时新建的 policyEnforcer 从 Trace 里读到 write_file 已用掉 1 次
+	events = env.resume(last.ApprovalID)
+	if end := events[len(events)-1]; end.Type != "done" || end.Content != "Only one write was allowed." {
+		t.Fatalf("end = %+v", end)
+	}
+	dir, _ := env.e.Workspaces.Dir(run.SessionID)
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatalf("first write not executed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); !os.IsNotExist(err) {
+		t.Fatalf("second write should be denied, stat err = %v", err)
+	}
+
+	var denied *store.RunStep
+	for _, step := range env.store.ListRunStepsByRun(run.ID) {
+		if step.StepType == "tool_call" && step.Status == "denied" {
+			denied = step
+		}
+	}
+	if denied == nil || denied.OutputPayload["tool_call_id"] != "w2" {
+		t.Fatalf("denied step = %+v", denied)
+	}
+	if p, _ := denied.OutputPayload["policy"].(map[string]interface{}); p["rule"] != "rules[write_file].max_calls" {
+		t.Fatalf("policy trace = %v", denied.OutputPayload["policy"])
+	}
+	for _, m := range env.store.ListChatMessagesBySession(run.SessionID) {
+		if m.ToolCallID == "w2" && !strings.Contains(messageText(m), "policy_denied") {
+			t.Fatalf("model was not told about the denial: %q", messageText(m))
+		}
+	}
+}
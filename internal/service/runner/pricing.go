@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"example.com/agent-server/internal/service/llm"
+)
+
+// 价格表，配置在 LLM_PRICING（JSON 对象），单位为每百万 Token 的美元价格：
+//
+//	{
+//	  "gpt-4o":                   {"input": 2.5, "output": 10},
+//	  "claude/claude-3-5-sonnet": {"input": 3, "output": 15},
+//	  "*":                        {"input": 1, "output": 2}
+//	}
+//
+// 键可以是 "provider/model"（优先）或模型名，"*" 兜底；都匹配不到的模型花费记为 0，不计入预算
+
+// ModelPrice 一个模型每百万 Token 的价格（美元）
+type ModelPrice struct {
+	Input  float64 `json:"input"`  // prompt tokens
+	Output float64 `json:"output"` // completion tokens
+}
+
+// PriceTable 模型 -> 价格
+type PriceTable map[string]ModelPrice
+
+// ParsePriceTable 解析 LLM_PRICING
+func ParsePriceTable(raw string) (PriceTable, error) {
+	var t PriceTable
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return nil, fmt.Errorf("parse price table: %w", err)
+	}
+	for model, p := range t {
+		if p.Input < 0 || p.Output < 0 {
+			return nil, fmt.Errorf("price for %s must be >= 0", model)
+		}
+	}
+	return t, nil
+}
+
+// lookup 按 provider/model、model、* 的顺序查找
+func (t PriceTable) lookup(sel llm.Selection) (ModelPrice, bool) {
+	for _, key := range []string{sel.Provider + "/" + sel.Model, sel.Model, "*"} {
+		if p, ok := t[key]; ok {
+			return p, true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// cost 一次调用的花费；没有定价的模型为 0
+func (t PriceTable) cost(sel llm.Selection, u TokenUsage) float64 {
+	p, ok := t.lookup(sel)
+	if !ok {
+		return 0
+	}
+	return (float64(u.PromptTokens)*p.Input + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// configFloat 同 configInt，读取小数
+func configFloat(cfg map[string]interface{}, key string) float64 {
+	switch v := cfg[key].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"testing"
+
+	"example.com/agent-server/internal/service/llm"
+)
+
+func TestParsePriceTable(t *testing.T) {
+	table, err := ParsePriceTable(`{"gpt-4o": {"input": 2.5, "output": 10}, "*": {"input": 1}}`)
+	if err != nil || table["gpt-4o"] != (ModelPrice{Input: 2.5, Output: 10}) || table["*"] != (ModelPrice{Input: 1}) {
+		t.Fatalf("table = %v, %v", table, err)
+	}
+	for _, raw := range []string{`not json`, `{"gpt-4o": {"input": -1, "output": 1}}`, `{"gpt-4o": {"input": 1, "output": -0.5}}`} {
+		if _, err := ParsePriceTable(raw); err == nil {
+			t.Errorf("%s should be rejected", raw)
+		}
+	}
+}
+
+func TestPriceTableCost(t *testing.T) {
+	table := PriceTable{
+		"openai/gpt-4o": {Input: 2.5, Output: 10},
+		"gpt-4o":        {Input: 5, Output: 15},
+		"claude":        {Input: 3, Output: 15},
+		"*":             {Input: 1, Output: 2},
+	}
+	u := TokenUsage{PromptTokens: 1000, CompletionTokens: 100}
+	cases := []struct {
+		name  string
+		table PriceTable
+		sel   llm.Selection
+		want  float64
+	}{
+		{"provider and model", table, llm.Selection{Provider: "openai", Model: "gpt-4o"}, (1000*2.5 + 100*10) / 1e6},
+		{"model only", table, llm.Selection{Provider: "azure", Model: "gpt-4o"}, (1000*5 + 100*15) / 1e6},
+		{"provider name is not a model", table, llm.Selection{Provider: "claude", Model: "claude-3-5-sonnet"}, (1000*1 + 100*2) / 1e6},
+		{"wildcard", table, llm.Selection{Provider: "ollama", Model: "llama3"}, (1000*1 + 100*2) / 1e6},
+		{"unpriced", PriceTable{"gpt-4o": {Input: 5, Output: 15}}, llm.Selection{Provider: "ollama", Model: "llama3"}, 0},
+		{"no table", nil, llm.Selection{Provider: "openai", Model: "gpt-4o"}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.table.cost(c.sel, u); !approx(got, c.want) {
+				t.Fatalf("cost = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"example.com/agent-server/internal/store"
+)
+
+// 队列的默认配置
+const (
+	DefaultRunWorkers      = 4 // 同时执行的顶层 Run 数
+	DefaultUserConcurrency = 2 // 每个用户同时执行的 Run 数
+	queuePollInterval      = 2 * time.Second
+	queueScanLimit         = 200         // 每次调度最多查看的排队 Run 数
+	feedRetention          = time.Minute // Run 结束后保留事件的时间，晚到的订阅者仍能拿到完整事件
+)
+
+// RunQueue 持久化的 Run 队列
+// 对话接口只把 Run 以 queued 状态写入 Store，由 worker 按入队顺序取出执行；进程重启后 Store 里排队的 Run 照样会被取走
+// 并发限制：Workers 为同时执行的顶层 Run 总数，PerUser 为每个用户的上限，Agent.Concurrency 为每个 Agent 的上限（<= 0 不限制）
+// 计数只在本实例内；多实例部署时 ClaimRun 保证同一个 Run 只被取走一次，限制按实例生效
+// 同一会话的 Run 依次执行，避免两次对话的消息交错；handoff 出来的子 Run 在父 Run 的 worker 里执行，不单独占名额
+// 审批后恢复的 Run 同样经过队列，按顶层 Run 占名额，先于排队的 Run 调度
+type RunQueue struct {
+	Engine       *AgentEngine
+	Workers      int
+	PerUser      int
+	PollInterval time.Duration // 兜底轮询：其他实例写入的 Run 不会唤醒本实例
+
+	mu      sync.Mutex
+	running int
+	byUser  map[string]int
+	byAgent map[string]int
+	busy    map[string]bool     // 正在执行的会话
+	feeds   map[string]*runFeed // runID -> 事件记录，订阅者从这里跟随 Run
+	resumes []*resumeJob        // 审批后等待名额恢复执行的 Run，只在内存里
+
+	wake      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+}
+
+func NewRunQueue(e *AgentEngine) *RunQueue {
+	return &RunQueue{
+		Engine:       e,
+		Workers:      DefaultRunWorkers,
+		PerUser:      DefaultUserConcurrency,
+		PollInterval: queuePollInterval,
+		byUser:       make(map[string]int),
+		byAgent:      make(map[string]int),
+		busy:         make(map[string]bool),
+		feeds:        make(map[string]*runFeed),
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start 启动调度（重复调用无效）
+func (q *RunQueue) Start() {
+	q.startOnce.Do(func() {
+		log.Printf("[Agent] run queue started: workers=%d per_user=%d", q.Workers, q.PerUser)
+		go q.loop()
+	})
+}
+
+// Stop 停止取新的 Run，已经在执行的不受影响
+func (q *RunQueue) Stop() {
+	q.stopOnce.Do(func() { close(q.stop) })
+}
+
+// Enqueue 以 queued 状态写入 Run 并唤醒调度
+func (q *RunQueue) Enqueue(run *store.Run) (*store.Run, error) {
+	run.Status = "queued"
+	created, err := q.Engine.Store.CreateRun(run)
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return created, nil
+}
+
+// resumeJob 一次审批决定后的恢复；root 为 handoff 链最上层的 Run，按它占名额和推送事件
+type resumeJob struct {
+	approvalID string
+	root       *store.Run
+}
+
+// Resume 审批决定后把暂停的 Run 交给队列恢复执行，返回顶层 Run 的 ID，事件可用 Subscribe 跟随
+// 和排队的 Run 受同样的并发限制；等待名额期间 Run 仍是 awaiting_approval，可以取消
+func (q *RunQueue) Resume(approvalID string) (string, error) {
+	a := q.Engine.Store.GetToolApproval(approvalID)
+	if a == nil {
+		return "", fmt.Errorf("approval %s not found", approvalID)
+	}
+	root := q.Engine.Store.GetRun(q.Engine.RootRunID(a.RunID))
+	if root == nil {
+		return "", fmt.Errorf("run %s not found", a.RunID)
+	}
+
+	q.mu.Lock()
+	if f := q.feeds[root.ID]; f == nil || f.isClosed() {
+		q.feeds[root.ID] = newRunFeed()
+	}
+	q.resumes = append(q.resumes, &resumeJob{approvalID: approvalID, root: root})
+	q.mu.Unlock()
+	q.notify()
+	return root.ID, nil
+}
+
+// Subscribe 跟随 Run 的事件：先补发已经推送过的，再实时推送；Run 结束（或暂停等待审批）后关闭 channel
+// 只有排队中、由队列执行中（含审批后等待恢复）或刚结束不久的 Run 可以跟随，否则 ok 为 false
+// 不再读取时调用 cancel
+func (q *RunQueue) Subscribe(runID string) (events <-chan RunStreamEvent, cancel func(), ok bool) {
+	q.mu.Lock()
+	f := q.feeds[runID]
+	if f == nil {
+		// 还在排队的 Run 先建好记录，worker 取走后沿用
+		if r := q.Engine.Store.GetRun(runID); r != nil && r.Status == "queued" {
+			f = newRunFeed()
+			q.feeds[runID] = f
+		}
+	}
+	q.mu.Unlock()
+	if f == nil {
+		return nil, nil, false
+	}
+	events, cancel = f.subscribe()
+	return events, cancel, true
+}
+
+// Wait 阻塞到排队的 Run 结束，返回值同 ExecuteRun
+func (q *RunQueue) Wait(runID string) (string, error) {
+	events, cancel, ok := q.Subscribe(runID)
+	if !ok {
+		return "", fmt.Errorf("run %s is not queued", runID)
+	}
+	defer cancel()
+	return collectRun(events)
+}
+
+// relay 把 worker 里执行（或审批后恢复）的 Run 事件转给订阅者，直到 events 关闭
+func (q *RunQueue) relay(runID string, events <-chan RunStreamEvent) {
+	q.mu.Lock()
+	f := q.feeds[runID]
+	if f == nil || f.isClosed() {
+		f = newRunFeed()
+		q.feeds[runID] = f
+	}
+	q.mu.Unlock()
+
+	for ev := range events {
+		f.publish(ev)
+	}
+	q.closeFeed(runID, f)
+}
+
+func (q *RunQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *RunQueue) loop() {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for {
+		q.dispatch()
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch 按入队顺序取出有名额的 Run；某个用户 / Agent / 会话满额时跳过它的 Run，不挡住后面的
+// 审批后等待恢复的 Run 已经执行过一段，先于排队的 Run
+func (q *RunQueue) dispatch() {
+	agents := map[string]*store.Agent{}
+	agentLimit := func(agentID string) int {
+		agent, cached := agents[agentID]
+		if !cached {
+			agent = q.Engine.Store.GetAgent(agentID)
+			agents[agentID] = agent
+		}
+		if agent == nil {
+			return 0
+		}
+		return agent.Concurrency
+	}
+
+	q.mu.Lock()
+	resumes := append([]*resumeJob(nil), q.resumes...)
+	q.mu.Unlock()
+	for _, job := range resumes {
+		reserved, full := q.reserve(job.root, agentLimit(job.root.AgentID))
+		if full {
+			return
+		}
+		if !reserved {
+			continue
+		}
+		if !q.takeResume(job) {
+			// 等待期间被取消
+			q.release(job.root, true)
+			continue
+		}
+		go q.resume(job)
+	}
+
+	for _, run := range q.Engine.Store.ListQueuedRuns(queueScanLimit) {
+		reserved, full := q.reserve(run, agentLimit(run.AgentID))
+		if full {
+			return
+		}
+		if !reserved {
+			continue
+		}
+		if !q.Engine.Store.ClaimRun(run.ID) {
+			// 刚被取消，或被其他实例取走
+			q.release(run, false)
+			continue
+		}
+		go q.work(run)
+	}
+}
+
+// reserve 占一个名额，并建好事件记录（须在 ClaimRun 之前，保证执行中的 Run 一定能被订阅）
+// full 表示总名额已满，本轮不用再看后面的 Run
+func (q *RunQueue) reserve(run *store.Run, agentLimit int) (reserved, full bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.Workers > 0 && q.running >= q.Workers {
+		return false, true
+	}
+	if q.busy[run.SessionID] {
+		return false, false
+	}
+	if q.PerUser > 0 && q.byUser[run.UserID] >= q.PerUser {
+		return false, false
+	}
+	if agentLimit > 0 && q.byAgent[run.AgentID] >= agentLimit {
+		return false, false
+	}
+	q.running++
+	q.byUser[run.UserID]++
+	q.byAgent[run.AgentID]++
+	q.busy[run.SessionID] = true
+	if f := q.feeds[run.ID]; f == nil || f.isClosed() {
+		q.feeds[run.ID] = newRunFeed()
+	}
+	return true, false
+}
+
+// release 归还名额；没有取到 Run 时结束为它建的事件记录
+func (q *RunQueue) release(run *store.Run, claimed bool) {
+	q.mu.Lock()
+	q.running--
+	if q.byUser[run.UserID]--; q.byUser[run.UserID] <= 0 {
+		delete(q.byUser, run.UserID)
+	}
+	if q.byAgent[run.AgentID]--; q.byAgent[run.AgentID] <= 0 {
+		delete(q.byAgent, run.AgentID)
+	}
+	delete(q.busy, run.SessionID)
+	f := q.feeds[run.ID]
+	q.mu.Unlock()
+
+	if !claimed && f != nil {
+		q.closeFeed(run.ID, f)
+	}
+}
+
+// work 在 worker 里执行一个已取到的 Run，事件写入它的记录
+func (q *RunQueue) work(run *store.Run) {
+	defer func() {
+		q.release(run, true)
+		q.notify()
+	}()
+	fmt.Printf("[Agent] Run %s dequeued (agent=%s, user=%s)\n", run.ID, run.AgentID, run.UserID)
+
+	events := make(chan RunStreamEvent, 10)
+	go q.Engine.ExecuteRunStream(run.ID, events)
+	q.relay(run.ID, events)
+}
+
+// resume 在 worker 里恢复一个审批后的 Run，事件写入顶层 Run 的记录
+func (q *RunQueue) resume(job *resumeJob) {
+	defer func() {
+		q.release(job.root, true)
+		q.notify()
+	}()
+	fmt.Printf("[Agent] Run %s resumed after approval %s\n", job.root.ID, job.approvalID)
+
+	events := make(chan RunStreamEvent, 10)
+	go q.Engine.ResumeRunStream(job.approvalID, events)
+	q.relay(job.root.ID, events)
+}
+
+// takeResume 从等待列表里取出 job，已经被取消时返回 false
+func (q *RunQueue) takeResume(job *resumeJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, j := range q.resumes {
+		if j == job {
+			q.resumes = append(q.resumes[:i], q.resumes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// cancelResume 取消顶层 Run 还在等待名额的恢复，通知已经在跟随的订阅者
+func (q *RunQueue) cancelResume(rootID string) {
+	q.mu.Lock()
+	kept := q.resumes[:0]
+	var cancelled *resumeJob
+	for _, j := range q.resumes {
+		if j.root.ID == rootID {
+			cancelled = j
+			continue
+		}
+		kept = append(kept, j)
+	}
+	q.resumes = kept
+	f := q.feeds[rootID]
+	q.mu.Unlock()
+
+	if cancelled != nil && f != nil {
+		f.publish(RunStreamEvent{Type: "error", Content: ErrRunCancelled.Error(), RunID: rootID, AgentID: cancelled.root.AgentID})
+		q.closeFeed(rootID, f)
+	}
+}
+
+// cancelQueued 取消还没被取走的 Run，通知已经在跟随的订阅者
+func (q *RunQueue) cancelQueued(runID string) bool {
+	if !q.Engine.Store.CancelQueuedRun(runID, map[string]interface{}{"error": ErrRunCancelled.Error()}) {
+		return false
+	}
+	fmt.Printf("[Agent] Run %s cancelled while queued\n", runID)
+
+	q.mu.Lock()
+	f := q.feeds[runID]
+	q.mu.Unlock()
+	if f != nil {
+		end := RunStreamEvent{Type: "error", Content: ErrRunCancelled.Error(), RunID: runID}
+		if r := q.Engine.Store.GetRun(runID); r != nil {
+			end.AgentID = r.AgentID
+		}
+		f.publish(end)
+		q.closeFeed(runID, f)
+	}
+	return true
+}
+
+// closeFeed 结束事件记录，保留一段时间后清理
+func (q *RunQueue) closeFeed(runID string, f *runFeed) {
+	f.close()
+	time.AfterFunc(feedRetention, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.feeds[runID] == f {
+			delete(q.feeds, runID)
+		}
+	})
+}
+
+// runFeed 一个 Run 推送过的事件；每个订阅者各自按进度读取，慢的订阅者不会阻塞 Run
+type runFeed struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []RunStreamEvent
+	closed bool
+}
+
+func newRunFeed() *runFeed {
+	f := &runFeed{}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *runFeed) publish(ev RunStreamEvent) {
+	f.mu.Lock()
+	if !f.closed {
+		f.events = append(f.events, ev)
+	}
+	f.mu.Unlock()
+	f.cond.Broadcast()
+}
+
+func (f *runFeed) close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	f.cond.Broadcast()
+}
+
+func (f *runFeed) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func (f *runFeed) subscribe() (<-chan RunStreamEvent, func()) {
+	out := make(chan RunStreamEvent, 16)
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			f.mu.Lock()
+			f.cond.Broadcast()
+			f.mu.Unlock()
+		})
+	}
+	cancelled := func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(out)
+		for next := 0; ; {
+			f.mu.Lock()
+			for next == len(f.events) && !f.closed && !cancelled() {
+				f.cond.Wait()
+			}
+			// 只会在末尾追加，已有的元素不会再变，解锁后读取是安全的
+			batch := f.events[next:]
+			closed := f.closed
+			f.mu.Unlock()
+
+			for _, ev := range batch {
+				select {
+				case out <- ev:
+				case <-done:
+					return
+				}
+			}
+			next += len(batch)
+			if closed || cancelled() {
+				return
+			}
+		}
+	}()
+	return out, cancel
+}
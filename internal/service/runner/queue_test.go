@@ -0,0 +1,362 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// heldProvider 每次调用先报告开始，然后一直等到 release 关闭才回复 "ok"，让 Run 停在执行中占住名额
+type heldProvider struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newHeldProvider() *heldProvider {
+	return &heldProvider{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (p *heldProvider) ChatCompletion(ctx context.Context, req *llm.ChatRequest) (*llm.ChatResponse, error) {
+	p.started <- struct{}{}
+	select {
+	case <-p.release:
+		return &llm.ChatResponse{Content: "ok"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *heldProvider) ChatStream(ctx context.Context, req *llm.ChatRequest) (<-chan llm.StreamEvent, error) {
+	ch := make(chan llm.StreamEvent, 1)
+	go func() {
+		defer close(ch)
+		p.started <- struct{}{}
+		select {
+		case <-p.release:
+			ch <- llm.StreamEvent{Type: "done", Content: "ok"}
+		case <-ctx.Done():
+			ch <- llm.StreamEvent{Type: "error", Error: ctx.Err().Error()}
+		}
+	}()
+	return ch, nil
+}
+
+// waitStarted 等 n 个 Run 进入模型调用，之后它们的状态不再变化
+func (p *heldProvider) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-p.started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d runs started", i, n)
+		}
+	}
+}
+
+// newQueueEnv 队列不启动调度循环，测试里手动调用 dispatch
+func newQueueEnv(t *testing.T, workers, perUser int) (*testEnv, *heldProvider) {
+	t.Helper()
+	env := newTestEnv(t, llm.NewScriptedProvider())
+	provider := newHeldProvider()
+	env.e.Providers = llm.Fixed(provider)
+	env.e.Queue.Workers = workers
+	env.e.Queue.PerUser = perUser
+	return env, provider
+}
+
+// enqueue 在会话里写入用户消息并把 Run 放进队列；session 为空时新建一个会话
+func (env *testEnv) enqueue(agent *store.Agent, userID, sessionID string) *store.Run {
+	env.t.Helper()
+	if sessionID == "" {
+		sessionID = env.store.CreateChatSession(&store.ChatSession{UserID: userID, AgentID: agent.ID, Title: "test"}).ID
+	}
+	env.store.CreateChatMessage(&store.ChatMessage{SessionID: sessionID, Role: "user", Content: map[string]interface{}{"type": "text", "text": "hi"}, CreatedAt: time.Now()})
+	run, err := env.e.Queue.Enqueue(&store.Run{SessionID: sessionID, UserID: userID, AgentID: agent.ID})
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	// 入队时间决定调度顺序，避免同一时刻入队的 Run 顺序不定
+	time.Sleep(time.Millisecond)
+	return run
+}
+
+func (env *testEnv) statuses(runs ...*store.Run) []string {
+	var got []string
+	for _, r := range runs {
+		got = append(got, env.store.GetRun(r.ID).Status)
+	}
+	return got
+}
+
+// finish 放行所有执行中的 Run 并等它们结束
+func (env *testEnv) finish(provider *heldProvider, runs ...*store.Run) {
+	env.t.Helper()
+	close(provider.release)
+	for _, r := range runs {
+		if _, err := env.e.Queue.Wait(r.ID); err != nil {
+			env.t.Fatalf("run %s: %v", r.ID, err)
+		}
+	}
+}
+
+func TestQueueDispatchLimits(t *testing.T) {
+	t.Run("per user", func(t *testing.T) {
+		env, provider := newQueueEnv(t, 4, 1)
+		agent := env.agent("coder", nil)
+		a1 := env.enqueue(agent, "user-1", "")
+		a2 := env.enqueue(agent, "user-1", "")
+		b1 := env.enqueue(agent, "user-2", "")
+
+		env.e.Queue.dispatch()
+		provider.waitStarted(t, 2)
+		if got := env.statuses(a1, a2, b1); !reflect.DeepEqual(got, []string{"running", "queued", "running"}) {
+			t.Fatalf("statuses = %v", got)
+		}
+		env.finish(provider, a1, b1)
+	})
+
+	t.Run("per agent", func(t *testing.T) {
+		env, provider := newQueueEnv(t, 4, 4)
+		limited := env.agent("limited", nil)
+		limited.Concurrency = 1
+		other := env.agent("other", nil)
+		a1 := env.enqueue(limited, "user-1", "")
+		a2 := env.enqueue(limited, "user-2", "")
+		b1 := env.enqueue(other, "user-2", "")
+
+		env.e.Queue.dispatch()
+		provider.waitStarted(t, 2)
+		if got := env.statuses(a1, a2, b1); !reflect.DeepEqual(got, []string{"running", "queued", "running"}) {
+			t.Fatalf("statuses = %v", got)
+		}
+		env.finish(provider, a1, b1)
+	})
+
+	t.Run("same session runs one at a time", func(t *testing.T) {
+		env, provider := newQueueEnv(t, 4, 4)
+		agent := env.agent("coder", nil)
+		first := env.enqueue(agent, "user-1", "")
+		second := env.enqueue(agent, "user-1", first.SessionID)
+		other := env.enqueue(agent, "user-1", "")
+
+		env.e.Queue.dispatch()
+		provider.waitStarted(t, 2)
+		if got := env.statuses(first, second, other); !reflect.DeepEqual(got, []string{"running", "queued", "running"}) {
+			t.Fatalf("statuses = %v", got)
+		}
+		// 前一个 Run 结束后，同一会话的下一个才被取走
+		env.finish(provider, first, other)
+		env.e.Queue.dispatch()
+		if _, err := env.e.Queue.Wait(second.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got := env.store.GetRun(second.ID).Status; got != "succeeded" {
+			t.Fatalf("second run = %s", got)
+		}
+	})
+}
+
+func TestQueueFullUserDoesNotStarveOthers(t *testing.T) {
+	env, provider := newQueueEnv(t, 2, 1)
+	agent := env.agent("coder", nil)
+	// user-1 先塞满队列，user-2 的 Run 排在最后
+	flood := []*store.Run{env.enqueue(agent, "user-1", ""), env.enqueue(agent, "user-1", ""), env.enqueue(agent, "user-1", "")}
+	late := env.enqueue(agent, "user-2", "")
+
+	env.e.Queue.dispatch()
+	provider.waitStarted(t, 2)
+	want := []string{"running", "queued", "queued", "running"}
+	if got := env.statuses(flood[0], flood[1], flood[2], late); !reflect.DeepEqual(got, want) {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+
+	// 总名额已满时不再取任何 Run，包括其他用户的
+	third := env.enqueue(agent, "user-3", "")
+	env.e.Queue.dispatch()
+	if got := env.store.GetRun(third.ID).Status; got != "queued" {
+		t.Fatalf("run dispatched beyond the worker limit: %s", got)
+	}
+	env.finish(provider, flood[0], late)
+
+	// 名额空出来后按入队顺序继续：user-1 的下一个和 user-3
+	env.e.Queue.dispatch()
+	for _, r := range []*store.Run{flood[1], third} {
+		if _, err := env.e.Queue.Wait(r.ID); err != nil {
+			t.Fatalf("run %s: %v", r.ID, err)
+		}
+	}
+	if got := env.store.GetRun(flood[2].ID).Status; got != "queued" {
+		t.Fatalf("last run = %s", got)
+	}
+}
+
+func TestQueueCancelQueuedClosesSubscribers(t *testing.T) {
+	env, provider := newQueueEnv(t, 1, 1)
+	agent := env.agent("coder", nil)
+	running := env.enqueue(agent, "user-1", "")
+	queued := env.enqueue(agent, "user-2", "")
+	env.e.Queue.dispatch()
+	provider.waitStarted(t, 1)
+
+	events, cancel, ok := env.e.Queue.Subscribe(queued.ID)
+	if !ok {
+		t.Fatal("queued run should be subscribable")
+	}
+	defer cancel()
+	if err := env.e.CancelRun(queued.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, events)
+	if len(got) != 1 || got[0].Type != "error" || got[0].Content != ErrRunCancelled.Error() || got[0].AgentID != agent.ID {
+		t.Fatalf("events = %+v", got)
+	}
+	if status := env.store.GetRun(queued.ID).Status; status != "cancelled" {
+		t.Fatalf("status = %s", status)
+	}
+	// 取消后的 Run 不会再被 worker 取走
+	env.finish(provider, running)
+	env.e.Queue.dispatch()
+	if status := env.store.GetRun(queued.ID).Status; status != "cancelled" {
+		t.Fatalf("status after dispatch = %s", status)
+	}
+}
+
+func TestQueueLateSubscribeReplaysEvents(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider(llm.ScriptedTurn{Content: "Hello there."}))
+	agent := env.agent("coder", nil)
+	run := env.enqueue(agent, "user-1", "")
+
+	early, cancel, ok := env.e.Queue.Subscribe(run.ID)
+	if !ok {
+		t.Fatal("queued run should be subscribable")
+	}
+	defer cancel()
+	env.e.Queue.dispatch()
+	if final, err := env.e.Queue.Wait(run.ID); err != nil || final != "Hello there." {
+		t.Fatalf("wait = %q, %v", final, err)
+	}
+
+	// Run 结束后才订阅，照样从头拿到全部事件
+	late, cancelLate, ok := env.e.Queue.Subscribe(run.ID)
+	if !ok {
+		t.Fatal("finished run should stay subscribable for a while")
+	}
+	defer cancelLate()
+	want := readAll(t, early)
+	got := readAll(t, late)
+	if len(want) == 0 || want[len(want)-1].Type != "done" || !reflect.DeepEqual(got, want) {
+		t.Fatalf("late subscriber got %+v, want %+v", got, want)
+	}
+}
+
+func readAll(t *testing.T, events <-chan RunStreamEvent) []RunStreamEvent {
+	t.Helper()
+	var got []RunStreamEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return got
+			}
+			got = append(got, ev)
+		case <-timeout:
+			t.Fatalf("subscription not closed, got %+v", got)
+		}
+	}
+}
+
+// agentProviders 按 Agent 选择 Provider，让不同 Agent 的 Run 分别受控
+type agentProviders map[string]llm.Provider
+
+func (p agentProviders) Resolve(agent *store.Agent) (llm.Provider, llm.Selection, error) {
+	return p[agent.ID], llm.Selection{Provider: "test", Model: agent.ModelName}, nil
+}
+
+// pausedBehindBusyRun 一个暂停等待审批（已批准）的 Run，和一个占满唯一名额的 Run
+func pausedBehindBusyRun(t *testing.T) (env *testEnv, held *heldProvider, scripted *llm.ScriptedProvider, paused, busy *store.Run, approvalID string) {
+	env, held = newQueueEnv(t, 1, 4)
+	scripted = llm.NewScriptedProvider(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "r1", Name: "fs__read_file", Arguments: `{"path":"a.txt"}`}}},
+		llm.ScriptedTurn{Content: "Read it."},
+	)
+	coder := env.agent("coder", nil)
+	env.bindFS(coder, map[string]interface{}{"require_approval": []interface{}{"read_file"}})
+	other := env.agent("other", nil)
+	env.e.Providers = agentProviders{coder.ID: scripted, other.ID: held}
+
+	paused = env.enqueue(coder, "user-1", "")
+	env.writeFile(paused, "a.txt", "A")
+	env.e.Queue.dispatch()
+	if _, err := env.e.Queue.Wait(paused.ID); !errors.Is(err, ErrAwaitingApproval) {
+		t.Fatalf("first pass = %v", err)
+	}
+	busy = env.enqueue(other, "user-2", "")
+	env.e.Queue.dispatch()
+	held.waitStarted(t, 1)
+
+	approvals := env.store.ListToolApprovalsByRun(paused.ID)
+	if len(approvals) != 1 || !env.store.DecideToolApproval(approvals[0].ID, "approved", nil, "") {
+		t.Fatalf("approvals = %+v", approvals)
+	}
+	return env, held, scripted, paused, busy, approvals[0].ID
+}
+
+func TestQueueResumeWaitsForSlot(t *testing.T) {
+	env, held, scripted, paused, busy, approvalID := pausedBehindBusyRun(t)
+
+	rootID, err := env.e.Queue.Resume(approvalID)
+	if err != nil || rootID != paused.ID {
+		t.Fatalf("resume = %q, %v", rootID, err)
+	}
+	events, cancel, ok := env.e.Queue.Subscribe(rootID)
+	if !ok {
+		t.Fatal("resuming run should be subscribable")
+	}
+	defer cancel()
+
+	// 名额被占满时不恢复
+	env.e.Queue.dispatch()
+	if status := env.store.GetRun(paused.ID).Status; status != "awaiting_approval" || scripted.Remaining() != 1 {
+		t.Fatalf("resumed beyond the worker limit: status %s, %d turns left", status, scripted.Remaining())
+	}
+
+	env.finish(held, busy)
+	env.e.Queue.dispatch()
+	got := readAll(t, events)
+	if len(got) == 0 || got[len(got)-1].Type != "done" || got[len(got)-1].Content != "Read it." {
+		t.Fatalf("events = %+v", got)
+	}
+	if status := env.store.GetRun(paused.ID).Status; status != "succeeded" {
+		t.Fatalf("status = %s", status)
+	}
+}
+
+func TestQueueCancelWaitingResume(t *testing.T) {
+	env, held, scripted, paused, busy, approvalID := pausedBehindBusyRun(t)
+
+	if _, err := env.e.Queue.Resume(approvalID); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel, _ := env.e.Queue.Subscribe(paused.ID)
+	defer cancel()
+	if err := env.e.CancelRun(paused.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, events); len(got) != 1 || got[0].Type != "error" || got[0].Content != ErrRunCancelled.Error() {
+		t.Fatalf("events = %+v", got)
+	}
+
+	// 名额空出来后也不会再执行
+	env.finish(held, busy)
+	env.e.Queue.dispatch()
+	if status := env.store.GetRun(paused.ID).Status; status != "cancelled" || scripted.Remaining() != 1 {
+		t.Fatalf("status %s, %d turns left", status, scripted.Remaining())
+	}
+}
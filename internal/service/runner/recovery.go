@@ -0,0 +1,268 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"example.com/agent-server/internal/store"
+)
+
+// 崩溃恢复
+// runningRuns 只在内存里：进程退出时还在执行的 Run 在库里一直是 running，最后一轮的 tool_calls 可能缺少结果，
+// 下一次请求 LLM 时历史不合法。RecoverRuns 在 worker 启动前逐个处理这些 Run：
+//   - 补齐最后一轮的工具结果：Trace 里已经结束的调用按记录补写；执行到一半的调用写入中断说明，不重试（可能已经生效）
+//   - 顶层 Run 重新入队，从最后一个完成的步骤继续，还没开始执行的调用照常执行，预算消耗接着之前累计
+//   - handoff 出来的子 Run、反复中断的 Run 标记为 failed；等子 Run 的父 Run 按子 Run 的结果收尾
+//
+// 每个 Run 记录一个 step_type = "recovery" 的 Trace 步骤
+// 只适用于单实例：多个实例共用数据库时，其他实例正在执行的 Run 也会被当作中断，这种部署应关闭恢复
+const (
+	RecoveryResume = "resume" // 能恢复的重新入队，其余标记失败（默认）
+	RecoveryFail   = "fail"   // 全部标记失败
+	RecoveryOff    = "off"    // 不处理
+
+	maxRunRecoveries = 2 // 同一个 Run 最多恢复的次数，再次中断说明它可能就是导致崩溃的原因
+)
+
+// 补写给模型的工具结果
+const (
+	interruptedToolOutput = "Tool call interrupted: the server restarted while this call was running and its result was lost. " +
+		"It may or may not have taken effect; check the current state before retrying."
+	skippedToolOutput = "Tool call not executed: the run was interrupted by a server restart."
+)
+
+var errRunInterrupted = errors.New("run interrupted by server restart")
+
+// RecoverRuns 处理上次进程退出时还在执行的 Run，返回重新入队和直接结束的数量
+func (e *AgentEngine) RecoverRuns(mode string) (resumed, finished int) {
+	if mode == RecoveryOff {
+		return 0, 0
+	}
+	for _, run := range e.Store.ListRunsByStatus("running") {
+		if _, live := e.runningRuns.Load(run.ID); live {
+			continue
+		}
+		// 处理父 Run 时可能已经连带结束了它
+		if r := e.Store.GetRun(run.ID); r == nil || r.Status != "running" {
+			continue
+		}
+		if e.recoverRun(run, mode) {
+			resumed++
+		} else {
+			finished++
+		}
+	}
+	if resumed+finished > 0 {
+		log.Printf("[Agent] recovered interrupted runs: %d resumed, %d finished", resumed, finished)
+	}
+	return resumed, finished
+}
+
+// recoverRun 处理一个中断的 Run，重新入队时返回 true
+func (e *AgentEngine) recoverRun(run *store.Run, mode string) bool {
+	step := e.createStep(run, "recovery", "interrupted_run", map[string]interface{}{"mode": mode})
+	out := map[string]interface{}{}
+	// 中断时还在进行的推理没有结果，用量也无从得知
+	for _, s := range e.Store.ListRunStepsByRun(run.ID) {
+		if s.StepType == "llm_call" && s.Status == "running" {
+			e.finishStep(s.ID, nil, "failed", errRunInterrupted.Error())
+		}
+	}
+
+	// 中断时正在 handoff：子 Run 已经成功就按它的结果收尾，否则父 Run 失败；子 Run 自己在遍历到时标记失败
+	if handoff, child := e.interruptedHandoff(run); handoff != nil {
+		resp := ""
+		err := errRunInterrupted
+		if child != nil && child.Status == "succeeded" {
+			resp, _ = child.OutputPayload["response"].(string)
+			err = nil
+		}
+		target, _ := handoff.InputPayload["target_agent_id"].(string)
+		_, err = e.completeHandoff(run, handoff.ID, target, child, resp, err)
+		out["action"] = "completed_handoff"
+		if err != nil {
+			out["action"] = "failed"
+			out["reason"] = err.Error()
+		}
+		e.finishStep(step.ID, out, "completed", "")
+		fmt.Printf("[Agent] Run %s interrupted during handoff: %s\n", run.ID, out["action"])
+		return false
+	}
+
+	reason := ""
+	switch n := e.recoveryCount(run.ID); {
+	case mode != RecoveryResume:
+		reason = errRunInterrupted.Error()
+	case run.ParentRunID != "":
+		// 子 Run 在父 Run 的 worker 里执行，不能单独入队
+		reason = errRunInterrupted.Error() + " during handoff"
+	case n >= maxRunRecoveries:
+		reason = fmt.Sprintf("%s %d times", errRunInterrupted.Error(), n+1)
+	}
+	resume := reason == ""
+
+	// 先按中断时的状态估算消耗，再补写工具结果
+	state := e.interruptedState(run)
+	out["repaired_tool_calls"] = e.repairToolCalls(run, resume)
+
+	if !resume {
+		for _, a := range e.Store.ListToolApprovalsByRun(run.ID) {
+			if a.Status == "pending" && e.Store.DecideToolApproval(a.ID, "cancelled", nil, reason) {
+				a.Status = "cancelled"
+				e.finishApprovalStep(a)
+			}
+		}
+		out["action"] = "failed"
+		out["reason"] = reason
+		e.finishStep(step.ID, out, "completed", "")
+		e.Store.FinishRun(run.ID, map[string]interface{}{"error": reason}, "failed")
+		fmt.Printf("[Agent] Run %s marked failed: %s\n", run.ID, reason)
+		return false
+	}
+
+	out["action"] = "resumed"
+	out["state"] = state
+	e.finishStep(step.ID, out, "completed", "")
+	e.Store.UpdateRunStatus(run.ID, "queued")
+	fmt.Printf("[Agent] Run %s re-queued after interruption\n", run.ID)
+	return true
+}
+
+// interruptedHandoff 还没结束的 handoff 步骤及其子 Run（可能还没创建）
+func (e *AgentEngine) interruptedHandoff(run *store.Run) (*store.RunStep, *store.Run) {
+	var step *store.RunStep
+	for _, s := range e.Store.ListRunStepsByRun(run.ID) {
+		if s.StepType == "handoff" && s.Status == "running" {
+			step = s
+		}
+	}
+	if step == nil {
+		return nil, nil
+	}
+	var child *store.Run
+	for _, r := range e.Store.ListRunsBySession(run.SessionID) {
+		if r.ParentRunID == run.ID && (child == nil || r.StartedAt.After(child.StartedAt)) {
+			child = r
+		}
+	}
+	return step, child
+}
+
+// repairToolCalls 为最后一轮缺少结果的工具调用补写 tool 消息，返回处理明细
+// Trace 里已经结束的调用按记录补写；执行到一半的写入中断说明；还没开始的在恢复时执行（keepPending），否则写入未执行说明
+func (e *AgentEngine) repairToolCalls(run *store.Run, keepPending bool) []map[string]interface{} {
+	calls := e.unfinishedToolCalls(run.ID)
+	if len(calls) == 0 {
+		return nil
+	}
+	steps := map[string]*store.RunStep{}
+	for _, s := range e.Store.ListRunStepsByRun(run.ID) {
+		if id, _ := s.OutputPayload["tool_call_id"].(string); id != "" && s.StepType == "tool_call" {
+			steps[id] = s
+		}
+	}
+	for _, s := range e.Store.ListRunStepsByRun(run.ID) {
+		// 执行中的步骤还没有 output_payload，按 Trace 顺序对应到缺少结果的调用
+		if s.StepType == "tool_call" && s.Status == "running" {
+			for _, tc := range calls {
+				if _, ok := steps[tc.ID]; !ok && tc.Name == s.Name {
+					steps[tc.ID] = s
+					break
+				}
+			}
+		}
+	}
+
+	var res []map[string]interface{}
+	for _, tc := range calls {
+		item := map[string]interface{}{"tool_call_id": tc.ID, "tool": tc.Name}
+		step := steps[tc.ID]
+		switch {
+		case step != nil && step.Status != "running":
+			output, _ := step.OutputPayload["output"].(string)
+			outputID, _ := step.OutputPayload["output_id"].(string)
+			e.saveToolOutput(run, tc.ID, output, outputID)
+			item["action"] = "restored"
+		case step != nil:
+			e.finishStep(step.ID, map[string]interface{}{"tool_call_id": tc.ID, "output": interruptedToolOutput}, "failed", errRunInterrupted.Error())
+			e.saveToolOutput(run, tc.ID, interruptedToolOutput, "")
+			item["action"] = "interrupted"
+		case keepPending:
+			item["action"] = "pending"
+		default:
+			e.saveToolOutput(run, tc.ID, skippedToolOutput, "")
+			item["action"] = "skipped"
+		}
+		res = append(res, item)
+	}
+	return res
+}
+
+// recoveryCount 这个 Run 已经被恢复过的次数
+func (e *AgentEngine) recoveryCount(runID string) int {
+	n := 0
+	for _, s := range e.Store.ListRunStepsByRun(runID) {
+		if s.StepType == "recovery" && s.OutputPayload["action"] == "resumed" {
+			n++
+		}
+	}
+	return n
+}
+
+// interruptedState 按 Trace 估算中断前的预算消耗，格式同审批暂停时保存的 State
+// 推理轮数按带 tool_calls 的 Assistant 消息计，工具调用按执行过的 tool_call 步骤计；
+// Token 取 Run 记录的用量；时长从上次开始执行算到最后一次写入
+func (e *AgentEngine) interruptedState(run *store.Run) map[string]interface{} {
+	base, since := e.savedState(run.ID)
+	if base == nil || since.Before(run.StartedAt) {
+		since = run.StartedAt
+	}
+	last := since
+
+	steps, toolCalls := 0, 0
+	for _, m := range e.Store.ListChatMessagesByRun(run.ID) {
+		if m.Role == "assistant" && len(toolCallsOf(m)) > 0 {
+			steps++
+		}
+		if m.CreatedAt.After(last) {
+			last = m.CreatedAt
+		}
+	}
+	for _, s := range e.Store.ListRunStepsByRun(run.ID) {
+		if s.StepType == "tool_call" && s.Status != "denied" && s.Status != "rejected" {
+			toolCalls++
+		}
+		if s.StepType != "recovery" && s.StartedAt.After(last) {
+			last = s.StartedAt
+		}
+	}
+
+	return map[string]interface{}{
+		"steps":      steps,
+		"tool_calls": toolCalls,
+		"tokens":     runUsage(run).TotalTokens,
+		"elapsed_ms": configInt(base, "elapsed_ms") + int(last.Sub(since).Milliseconds()),
+	}
+}
+
+// savedState 最近一次暂停 / 恢复时保存的预算消耗及其时间：已决定的审批，或重新入队的 recovery 步骤
+func (e *AgentEngine) savedState(runID string) (map[string]interface{}, time.Time) {
+	var state map[string]interface{}
+	var at time.Time
+	for _, a := range e.Store.ListToolApprovalsByRun(runID) {
+		if a.Status != "pending" && a.DecidedAt != nil && !a.DecidedAt.Before(at) {
+			state, at = a.State, *a.DecidedAt
+		}
+	}
+	for _, s := range e.Store.ListRunStepsByRun(runID) {
+		if s.StepType != "recovery" || s.OutputPayload["action"] != "resumed" || s.FinishedAt.Before(at) {
+			continue
+		}
+		if st, ok := s.OutputPayload["state"].(map[string]interface{}); ok {
+			state, at = st, s.FinishedAt
+		}
+	}
+	return state, at
+}
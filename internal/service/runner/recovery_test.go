@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// interruptedRun 模拟进程在一轮工具调用中途退出：Run 停在 running，最后一条 Assistant 消息有三个调用，
+// c1 已执行完（Trace 有结果但还没写回对话），c2 执行到一半，c3 还没开始；推理步骤也停在 running
+func (env *testEnv) interruptedRun(agent *store.Agent, parentRunID string) *store.Run {
+	env.t.Helper()
+	run := env.startRun(agent, "read a.txt and list files")
+	if parentRunID != "" {
+		run.ParentRunID = parentRunID
+	}
+	env.writeFile(run, "a.txt", "A")
+
+	llmStep := env.store.CreateRunStep(&store.RunStep{RunID: run.ID, StepType: "llm_call", Name: "test-model", Status: "running"})
+	calls := []map[string]interface{}{}
+	for _, c := range [][2]string{{"c1", "fs__read_file"}, {"c2", "fs__read_file"}, {"c3", "fs__list_directory"}} {
+		calls = append(calls, map[string]interface{}{"id": c[0], "type": "function", "function": map[string]interface{}{"name": c[1], "arguments": `{"path":"."}`}})
+	}
+	env.store.CreateChatMessage(&store.ChatMessage{SessionID: run.SessionID, RunID: run.ID, Role: "assistant", Content: map[string]interface{}{"text": "", "tool_calls": calls}, CreatedAt: time.Now()})
+	env.store.FinishRunStep(llmStep.ID, map[string]interface{}{}, "completed", 5, "")
+
+	done := env.store.CreateRunStep(&store.RunStep{RunID: run.ID, StepType: "tool_call", Name: "fs__read_file", Status: "running"})
+	env.store.FinishRunStep(done.ID, map[string]interface{}{"tool_call_id": "c1", "output": "A"}, "completed", 3, "")
+	env.store.CreateRunStep(&store.RunStep{RunID: run.ID, StepType: "tool_call", Name: "fs__read_file", Status: "running"})
+	// 中断时正在进行的下一次推理
+	env.store.CreateRunStep(&store.RunStep{RunID: run.ID, StepType: "llm_call", Name: "test-model", Status: "running"})
+	return run
+}
+
+// toolResults 会话里按顺序写入的工具结果：tool_call_id -> 文本
+func (env *testEnv) toolResults(sessionID string) ([]string, map[string]string) {
+	var order []string
+	results := map[string]string{}
+	for _, m := range env.store.ListChatMessagesBySession(sessionID) {
+		if m.Role == "tool" {
+			order = append(order, m.ToolCallID)
+			results[m.ToolCallID] = messageText(m)
+		}
+	}
+	return order, results
+}
+
+func (env *testEnv) recoveryStep(runID string) *store.RunStep {
+	env.t.Helper()
+	for _, s := range env.store.ListRunStepsByRun(runID) {
+		if s.StepType == "recovery" {
+			return s
+		}
+	}
+	env.t.Fatalf("run %s has no recovery step", runID)
+	return nil
+}
+
+func TestRecoverRunsResume(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider(llm.ScriptedTurn{Content: "a.txt contains A."}))
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.interruptedRun(agent, "")
+
+	if resumed, finished := env.e.RecoverRuns(RecoveryResume); resumed != 1 || finished != 0 {
+		t.Fatalf("recovered = %d resumed, %d finished", resumed, finished)
+	}
+	if status := env.store.GetRun(run.ID).Status; status != "queued" {
+		t.Fatalf("status = %s", status)
+	}
+	// 已结束的调用按 Trace 补写，执行到一半的写中断说明，还没开始的留到恢复时执行
+	order, results := env.toolResults(run.SessionID)
+	if !reflect.DeepEqual(order, []string{"c1", "c2"}) || results["c1"] != "A" || results["c2"] != interruptedToolOutput {
+		t.Fatalf("tool results = %v %v", order, results)
+	}
+	for _, s := range env.store.ListRunStepsByRun(run.ID) {
+		if s.Status == "running" {
+			t.Fatalf("step %s (%s) left running", s.ID, s.StepType)
+		}
+	}
+	step := env.recoveryStep(run.ID)
+	if step.OutputPayload["action"] != "resumed" {
+		t.Fatalf("recovery step = %v", step.OutputPayload)
+	}
+	var actions []string
+	for _, item := range step.OutputPayload["repaired_tool_calls"].([]map[string]interface{}) {
+		actions = append(actions, item["tool_call_id"].(string)+":"+item["action"].(string))
+	}
+	if !reflect.DeepEqual(actions, []string{"c1:restored", "c2:interrupted", "c3:pending"}) {
+		t.Fatalf("repaired = %v", actions)
+	}
+	if state := step.OutputPayload["state"].(map[string]interface{}); state["steps"] != 1 || state["tool_calls"] != 2 {
+		t.Fatalf("state = %v", state)
+	}
+
+	// 重新入队后执行剩下的 c3，再请求模型
+	env.e.Queue.dispatch()
+	final, err := env.e.Queue.Wait(run.ID)
+	if err != nil || final != "a.txt contains A." {
+		t.Fatalf("resumed run = %q, %v", final, err)
+	}
+	if status := env.store.GetRun(run.ID).Status; status != "succeeded" {
+		t.Fatalf("status = %s", status)
+	}
+	order, results = env.toolResults(run.SessionID)
+	if !reflect.DeepEqual(order, []string{"c1", "c2", "c3"}) || !strings.Contains(results["c3"], "a.txt") {
+		t.Fatalf("tool results after resume = %v %v", order, results)
+	}
+	// 发给模型的历史里每个调用都有结果
+	if history := env.llm.Requests[0].History; len(history) != 5 || history[4].ToolCallID != "c3" {
+		t.Fatalf("history sent to the model has %d messages", len(history))
+	}
+}
+
+func TestRecoverRunsFail(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider())
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.interruptedRun(agent, "")
+
+	if resumed, finished := env.e.RecoverRuns(RecoveryFail); resumed != 0 || finished != 1 {
+		t.Fatalf("recovered = %d resumed, %d finished", resumed, finished)
+	}
+	got := env.store.GetRun(run.ID)
+	if got.Status != "failed" || got.OutputPayload["error"] != errRunInterrupted.Error() {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	// 不再恢复，还没开始的调用也补一条未执行说明
+	order, results := env.toolResults(run.SessionID)
+	if !reflect.DeepEqual(order, []string{"c1", "c2", "c3"}) || results["c1"] != "A" || results["c2"] != interruptedToolOutput || results["c3"] != skippedToolOutput {
+		t.Fatalf("tool results = %v %v", order, results)
+	}
+	if step := env.recoveryStep(run.ID); step.OutputPayload["action"] != "failed" || step.OutputPayload["reason"] != errRunInterrupted.Error() {
+		t.Fatalf("recovery step = %v", step.OutputPayload)
+	}
+	if len(env.llm.Requests) != 0 {
+		t.Fatalf("failed run called the model %d times", len(env.llm.Requests))
+	}
+}
+
+func TestRecoverRunsFailsHandoffChild(t *testing.T) {
+	env := newTestEnv(t, llm.NewScriptedProvider())
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	// 子 Run 在父 Run 的 worker 里执行，恢复模式下也不能单独入队
+	run := env.interruptedRun(agent, "parent-run")
+
+	if resumed, finished := env.e.RecoverRuns(RecoveryResume); resumed != 0 || finished != 1 {
+		t.Fatalf("recovered = %d resumed, %d finished", resumed, finished)
+	}
+	got := env.store.GetRun(run.ID)
+	if got.Status != "failed" || got.OutputPayload["error"] != errRunInterrupted.Error()+" during handoff" {
+		t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+	}
+	if _, results := env.toolResults(run.SessionID); results["c3"] != skippedToolOutput {
+		t.Fatalf("tool results = %v", results)
+	}
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"example.com/agent-server/internal/store"
+)
+
+// 花费预算
+// 预算按用户、API Key、Agent 设置（store.SpendBudget），窗口为 UTC 自然日 / 自然月，花费取窗口内开始的 Run 的 cost_usd 合计
+// 每次请求 LLM 前检查发起 Run 的用户、API Key 和执行它的 Agent，任何一个用完就结束 Run，状态为 budget_exceeded
+// 检查和花费的写入不是原子的：并发的 Run 可能各自超出一次调用的花费；超出预算后的收尾（summary）不再检查
+const (
+	BudgetScopeUser   = "user"
+	BudgetScopeAPIKey = "api_key"
+	BudgetScopeAgent  = "agent"
+)
+
+// ErrBudgetExceeded 花费预算用完，Run 以 budget_exceeded 结束
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetSpend 一个预算当前窗口内的花费
+type BudgetSpend struct {
+	DailyUSD   float64 `json:"daily_usd"`
+	MonthlyUSD float64 `json:"monthly_usd"`
+}
+
+// budgetBreach 用完的预算
+type budgetBreach struct {
+	Scope   string
+	ScopeID string
+	Window  string // daily / monthly
+	Limit   float64
+	Spent   float64
+}
+
+func (b *budgetBreach) Error() string {
+	return fmt.Sprintf("%s: %s spend for %s %s reached $%.4f (limit $%.4f)", ErrBudgetExceeded.Error(), b.Window, b.Scope, b.ScopeID, b.Spent, b.Limit)
+}
+
+func (b *budgetBreach) Unwrap() error { return ErrBudgetExceeded }
+
+func (b *budgetBreach) trace() map[string]interface{} {
+	return map[string]interface{}{
+		"scope":     b.Scope,
+		"scope_id":  b.ScopeID,
+		"window":    b.Window,
+		"limit_usd": b.Limit,
+		"spent_usd": b.Spent,
+	}
+}
+
+// SpendOf 某个预算范围当前自然日 / 自然月的花费
+func (e *AgentEngine) SpendOf(scope, scopeID string) BudgetSpend {
+	now := time.Now().UTC()
+	q := store.UsageQuery{
+		From:    time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC),
+		GroupBy: []string{"day"},
+	}
+	switch scope {
+	case BudgetScopeUser:
+		q.UserID = scopeID
+	case BudgetScopeAPIKey:
+		q.APIKeyID = scopeID
+	case BudgetScopeAgent:
+		q.AgentID = scopeID
+	default:
+		return BudgetSpend{}
+	}
+	today := now.Format("2006-01-02")
+	var spend BudgetSpend
+	for _, b := range e.Store.AggregateUsage(q) {
+		spend.MonthlyUSD += b.CostUSD
+		if b.Day == today {
+			spend.DailyUSD += b.CostUSD
+		}
+	}
+	return spend
+}
+
+// checkSpend 依次检查用户、API Key、Agent 的预算，返回第一个用完的
+func (e *AgentEngine) checkSpend(run *store.Run) *budgetBreach {
+	scopes := [][2]string{{BudgetScopeUser, run.UserID}, {BudgetScopeAPIKey, run.APIKeyID}, {BudgetScopeAgent, run.AgentID}}
+	for _, s := range scopes {
+		if s[1] == "" {
+			continue
+		}
+		b := e.Store.GetSpendBudget(s[0], s[1])
+		if b == nil || (b.DailyUSD <= 0 && b.MonthlyUSD <= 0) {
+			continue
+		}
+		spend := e.SpendOf(s[0], s[1])
+		if b.DailyUSD > 0 && spend.DailyUSD >= b.DailyUSD {
+			return &budgetBreach{Scope: s[0], ScopeID: s[1], Window: "daily", Limit: b.DailyUSD, Spent: spend.DailyUSD}
+		}
+		if b.MonthlyUSD > 0 && spend.MonthlyUSD >= b.MonthlyUSD {
+			return &budgetBreach{Scope: s[0], ScopeID: s[1], Window: "monthly", Limit: b.MonthlyUSD, Spent: spend.MonthlyUSD}
+		}
+	}
+	return nil
+}
+
+// stopForBudget 预算用完：记录 Trace，以 budget_exceeded 结束 Run
+func (e *AgentEngine) stopForBudget(run *store.Run, b *budgetBreach) (string, error) {
+	info := b.trace()
+	step := e.createStep(run, "termination", "budget_exceeded", info)
+	e.finishStep(step.ID, map[string]interface{}{"outcome": "budget_exceeded"}, "failed", b.Error())
+	e.Store.FinishRun(run.ID, map[string]interface{}{"error": b.Error(), "budget": info}, "budget_exceeded")
+	fmt.Printf("[Agent] Run %s stopped: %s\n", run.ID, b.Error())
+	return "", b
+}
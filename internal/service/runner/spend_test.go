@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// 每个 Token 0.001 美元，第一轮的 40 + 10 个 Token 花掉 0.05
+var spendTurns = []llm.ScriptedTurn{
+	{ToolCalls: []llm.ToolCallInfo{{ID: "c1", Name: "fs__list_directory", Arguments: `{"path":"."}`}}, Usage: map[string]int{"prompt_tokens": 40, "completion_tokens": 10}},
+	{Content: "Done.", Usage: map[string]int{"prompt_tokens": 60, "completion_tokens": 5}},
+}
+
+func newSpendEnv(t *testing.T, turns ...llm.ScriptedTurn) (*testEnv, *store.Agent) {
+	t.Helper()
+	env := newTestEnv(t, llm.NewScriptedProvider(turns...))
+	env.e.Prices = PriceTable{"*": {Input: 1000, Output: 1000}}
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	return env, agent
+}
+
+func TestSpendBudgetStopsRun(t *testing.T) {
+	cases := []struct {
+		name   string
+		budget store.SpendBudget // Agent 范围的 ScopeID 在执行时填入
+		err    string
+	}{
+		{"user daily", store.SpendBudget{Scope: BudgetScopeUser, ScopeID: "user-1", DailyUSD: 0.05},
+			"budget exceeded: daily spend for user user-1 reached $0.0500 (limit $0.0500)"},
+		{"api key monthly", store.SpendBudget{Scope: BudgetScopeAPIKey, ScopeID: "key-1", DailyUSD: 1, MonthlyUSD: 0.04},
+			"budget exceeded: monthly spend for api_key key-1 reached $0.0500 (limit $0.0400)"},
+		{"agent daily", store.SpendBudget{Scope: BudgetScopeAgent, DailyUSD: 0.03},
+			"budget exceeded: daily spend for agent <agent> reached $0.0500 (limit $0.0300)"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			env, agent := newSpendEnv(t, spendTurns...)
+			budget := c.budget
+			if budget.Scope == BudgetScopeAgent {
+				budget.ScopeID = agent.ID
+			}
+			b := env.store.UpsertSpendBudget(&budget)
+			run := env.startRun(agent, "list files")
+			run.APIKeyID = "key-1"
+			wantErr := strings.Replace(c.err, "<agent>", agent.ID, 1)
+
+			// 第一次调用前还没有花费；之后检查时预算已用完，不再请求模型
+			events := env.stream(run.ID)
+			if last := events[len(events)-1]; last.Type != "error" || last.Content != wantErr {
+				t.Fatalf("last event = %+v", last)
+			}
+			if len(env.llm.Requests) != 1 {
+				t.Fatalf("model called %d times", len(env.llm.Requests))
+			}
+			got := env.store.GetRun(run.ID)
+			if got.Status != "budget_exceeded" || got.OutputPayload["error"] != wantErr {
+				t.Fatalf("run = %s %v", got.Status, got.OutputPayload)
+			}
+			if info := got.OutputPayload["budget"].(map[string]interface{}); info["scope"] != b.Scope || info["scope_id"] != b.ScopeID {
+				t.Fatalf("budget = %v", info)
+			}
+			steps := env.store.ListRunStepsByRun(run.ID)
+			last := steps[len(steps)-1]
+			if last.StepType != "termination" || last.Name != "budget_exceeded" || last.Status != "failed" || last.ErrorMessage != wantErr {
+				t.Fatalf("last step = %s %s %s %q", last.StepType, last.Name, last.Status, last.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestSpendBudgetNotReached(t *testing.T) {
+	env, agent := newSpendEnv(t, spendTurns...)
+	// 其它用户的花费、为 0 的窗口都不限制
+	env.store.UpsertSpendBudget(&store.SpendBudget{Scope: BudgetScopeUser, ScopeID: "user-1", DailyUSD: 0.06})
+	env.store.UpsertSpendBudget(&store.SpendBudget{Scope: BudgetScopeUser, ScopeID: "user-2", DailyUSD: 0.01})
+	env.store.UpsertSpendBudget(&store.SpendBudget{Scope: BudgetScopeAgent, ScopeID: agent.ID})
+	env.store.CreateRun(&store.Run{UserID: "user-2", AgentID: agent.ID, Status: "succeeded", CostUSD: 5})
+	run := env.startRun(agent, "list files")
+
+	if final, err := env.e.ExecuteRun(run.ID); err != nil || final != "Done." {
+		t.Fatalf("ExecuteRun = %q, %v", final, err)
+	}
+	if spend := env.e.SpendOf(BudgetScopeUser, "user-1"); !approx(spend.DailyUSD, 0.115) || !approx(spend.MonthlyUSD, 0.115) {
+		t.Fatalf("spend = %+v", spend)
+	}
+}
+
+func TestSpendBudgetExhaustedBeforeRun(t *testing.T) {
+	env, agent := newSpendEnv(t)
+	manager := env.agent("manager", nil)
+	// coder 这个月已经花完了
+	env.store.UpsertSpendBudget(&store.SpendBudget{Scope: BudgetScopeAgent, ScopeID: agent.ID, MonthlyUSD: 1})
+	env.store.CreateRun(&store.Run{UserID: "user-2", AgentID: agent.ID, Status: "succeeded", CostUSD: 1})
+
+	// 直接执行：一次模型都不调用
+	run := env.startRun(agent, "list files")
+	if _, err := env.e.ExecuteRun(run.ID); err == nil || len(env.llm.Requests) != 0 {
+		t.Fatalf("ExecuteRun err = %v, model called %d times", err, len(env.llm.Requests))
+	}
+
+	// handoff 到 coder：子 Run 预算用完，父 Run 也以 budget_exceeded 结束
+	env.llm.Enqueue(llm.ScriptedTurn{Handoff: &llm.HandoffDecision{TargetAgentID: agent.ID}})
+	parent := env.startRun(manager, "delegate")
+	if _, err := env.e.ExecuteRun(parent.ID); err == nil {
+		t.Fatal("handoff to an agent over budget should fail")
+	}
+	got := env.store.GetRun(parent.ID)
+	steps := env.store.ListRunStepsByRun(parent.ID)
+	handoff := steps[len(steps)-1]
+	child := env.store.GetRun(handoff.OutputPayload["child_run_id"].(string))
+	if got.Status != "budget_exceeded" || child.Status != "budget_exceeded" || len(env.llm.Requests) != 1 {
+		t.Fatalf("parent = %s %v, child = %s, model called %d times", got.Status, got.OutputPayload, child.Status, len(env.llm.Requests))
+	}
+}
@@ -0,0 +1,207 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/store"
+)
+
+// 同一轮里互不依赖的工具调用并发执行，Agent.ExtraConfig 可调整并发数：{"max_parallel_tools": 1} 即完全串行
+// 有副作用的工具（MCPTool.SideEffects）单独成批：等前面的调用都结束才开始，结束后才继续后面的调用
+const (
+	cfgMaxParallelTools = "max_parallel_tools"
+
+	defaultMaxParallelTools = 4
+)
+
+// ParallelToolLimit 同一轮内最多同时执行的工具调用数
+func ParallelToolLimit(agent *store.Agent) int {
+	if agent != nil {
+		if v := configInt(agent.ExtraConfig, cfgMaxParallelTools); v > 0 {
+			return v
+		}
+	}
+	return defaultMaxParallelTools
+}
+
+// toolResult 一次工具调用写回对话历史的内容
+type toolResult struct {
+	output   string
+	outputID string // 非空表示 output 被截断，全文另存
+}
+
+// runToolCalls 按批执行一轮的全部工具调用，结果按模型给出的顺序写入对话历史
+// 超出预算时返回终止原因，未执行的调用也补上 tool 消息，保证 tool_calls 与结果一一对应
+// 遇到还没有审批决定的调用时返回 ErrAwaitingApproval，之后的调用留到恢复时再执行
+func (e *AgentEngine) runToolCalls(ctx context.Context, run *store.Run, toolset *mcp.ToolSet, calls []llm.ToolCallInfo, tracker *budgetTracker, policy *policyEnforcer, parallel, outputLimit int, emit func(RunStreamEvent)) (string, error) {
+	done := 0
+	for _, batch := range planToolBatches(toolset, calls) {
+		// 需要审批的调用单独成批；策略不允许的调用不用审批
+		var approval *store.ToolApproval
+		if len(batch) == 1 && policy.check(toolset, batch[0]).Allowed {
+			var paused bool
+			if approval, paused = e.gateToolCall(run, toolset, batch[0], tracker, emit); paused {
+				return "", ErrAwaitingApproval
+			}
+			if approval != nil && approval.Status == "rejected" {
+				e.saveToolOutput(run, batch[0].ID, e.rejectToolCall(run, batch[0], approval, emit), "")
+				done++
+				continue
+			}
+			if approval != nil && approval.Status == "edited" {
+				// 按修改后的参数执行，下面的策略检查也针对修改后的参数
+				tc := batch[0]
+				edited, _ := json.Marshal(approval.EditedArguments)
+				tc.Arguments = string(edited)
+				batch = []llm.ToolCallInfo{tc}
+			}
+		}
+
+		// 按模型给出的顺序逐个评估策略、检查预算：拒绝的调用不执行也不计入预算，
+		// 超出预算的那个及之后的调用都不再执行
+		reason := ""
+		n := len(batch)
+		results := make([]toolResult, n)
+		decisions := make([]policyDecision, n)
+		denied := make([]bool, n)
+		for i, tc := range batch {
+			if decisions[i] = policy.check(toolset, tc); !decisions[i].Allowed {
+				denied[i] = true
+				results[i] = toolResult{output: e.denyToolCall(run, tc, decisions[i], emit)}
+				continue
+			}
+			if reason = tracker.beforeToolCall(); reason != "" {
+				n = i
+				break
+			}
+			policy.record(decisions[i])
+		}
+
+		sem := make(chan struct{}, parallel)
+		var wg sync.WaitGroup
+		for i, tc := range batch[:n] {
+			if denied[i] {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, tc llm.ToolCallInfo) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[i] = e.runToolCall(ctx, run, toolset, tc, approval, decisions[i], outputLimit, emit)
+			}(i, tc)
+		}
+		wg.Wait()
+
+		// 将工具结果存入对话历史 (User 不可见，LLM 可见)
+		for i, tc := range batch[:n] {
+			e.saveToolOutput(run, tc.ID, results[i].output, results[i].outputID)
+		}
+		done += n
+
+		if reason != "" {
+			for _, skipped := range calls[done:] {
+				e.saveToolOutput(run, skipped.ID, fmt.Sprintf("Tool call skipped: run reached %s", limitDescription(reason)), "")
+			}
+			return reason, nil
+		}
+	}
+	return "", nil
+}
+
+// planToolBatches 按顺序切分成批：连续的无副作用调用为一批，每个有副作用 / 需要审批的调用单独一批
+func planToolBatches(toolset *mcp.ToolSet, calls []llm.ToolCallInfo) [][]llm.ToolCallInfo {
+	var batches [][]llm.ToolCallInfo
+	var current []llm.ToolCallInfo
+	for _, tc := range calls {
+		_, gated := approvalRequired(toolset, tc.Name)
+		if !gated && !hasSideEffects(toolset, tc.Name) {
+			current = append(current, tc)
+			continue
+		}
+		if len(current) > 0 {
+			batches = append(batches, current)
+			current = nil
+		}
+		batches = append(batches, []llm.ToolCallInfo{tc})
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
+
+// hasSideEffects 解析不到的工具执行时会直接报错，不影响其它调用，按无副作用处理
+func hasSideEffects(toolset *mcp.ToolSet, name string) bool {
+	if name == ReadToolOutputTool {
+		return false
+	}
+	bt, err := toolset.Resolve(name)
+	return err == nil && bt.Tool.SideEffects
+}
+
+// runToolCall 执行单个工具调用并记录 Trace 步骤；可能与同批的其它调用并发
+// approval 非空表示这是审批通过的调用（修改过参数时 tc 已换成修改后的参数）；decision 是策略评估结果
+func (e *AgentEngine) runToolCall(ctx context.Context, run *store.Run, toolset *mcp.ToolSet, tc llm.ToolCallInfo, approval *store.ToolApproval, decision policyDecision, outputLimit int, emit func(RunStreamEvent)) toolResult {
+	note := ""
+	if approval != nil && approval.Status == "edited" {
+		note = fmt.Sprintf("Note: the user edited the arguments before approving this call. Arguments used: %s\n\n", tc.Arguments)
+	}
+
+	fmt.Printf("[Agent] Executing Tool: %s (ID: %s)\n", tc.Name, tc.ID)
+	emit(RunStreamEvent{Type: "tool_start", Tool: tc.Name, ToolCallID: tc.ID})
+
+	// 解析参数用于 Trace，忽略错误
+	var args map[string]interface{}
+	_ = json.Unmarshal([]byte(tc.Arguments), &args)
+	step := e.createStep(run, "tool_call", tc.Name, args)
+
+	output, err := e.executeToolCall(ctx, run, toolset, tc, outputLimit)
+	status := "completed"
+	errMsg := ""
+	stepOutput := map[string]interface{}{"tool_call_id": tc.ID}
+	if approval != nil {
+		stepOutput["approval_id"] = approval.ID
+		stepOutput["approval"] = approval.Status
+	}
+	if decision.evaluated {
+		stepOutput["policy"] = decision.trace()
+	}
+	var invalid *mcp.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		// 参数不符合 Schema：工具没有执行，把结构化的错误交给 LLM 修正后重试
+		status = "invalid_arguments"
+		errMsg = invalid.Error()
+		output = invalid.ToolResult()
+		stepOutput["issues"] = invalid.Issues
+	case err != nil:
+		status = "failed"
+		errMsg = err.Error()
+		output = fmt.Sprintf("Tool Execution Error: %v", err)
+	}
+	output = note + output
+
+	// 过长的输出只把前缀放进对话，全文另存；read_tool_output 自己按上限分段，不再截断
+	res := toolResult{output: output}
+	if tc.Name != ReadToolOutputTool {
+		var cut *truncation
+		if res.output, cut = e.capToolOutput(run, tc.ID, tc.Name, output, outputLimit); cut != nil {
+			res.outputID = cut.OutputID
+			stepOutput["truncated"] = true
+			stepOutput["original_size"] = cut.OriginalSize
+			stepOutput["output_id"] = cut.OutputID
+		}
+	}
+	stepOutput["output"] = res.output
+
+	e.finishStep(step.ID, stepOutput, status, errMsg)
+	emit(RunStreamEvent{Type: "tool_end", Tool: tc.Name, ToolCallID: tc.ID, Content: res.output})
+	return res
+}
@@ -0,0 +1,175 @@
+//go:build unix
+
+package runner
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// sleepyGrep search_files 的 pattern 是秒数：睡这么久后输出 found <pattern>
+func sleepyGrep(t *testing.T) {
+	fakeGrep(t, "sleep \"$3\"\necho \"found $3\"\n")
+}
+
+func search(id, seconds string) llm.ToolCallInfo {
+	return llm.ToolCallInfo{ID: id, Name: "fs__search_files", Arguments: `{"pattern":"` + seconds + `"}`}
+}
+
+// toolSteps Run 的工具调用步骤，按 tool_call_id 索引
+func (env *testEnv) toolSteps(runID string) map[string]*store.RunStep {
+	steps := map[string]*store.RunStep{}
+	for _, s := range env.store.ListRunStepsByRun(runID) {
+		if s.StepType == "tool_call" {
+			steps[s.OutputPayload["tool_call_id"].(string)] = s
+		}
+	}
+	return steps
+}
+
+// maxOverlap 同一时刻最多有几个步骤在执行
+func maxOverlap(steps map[string]*store.RunStep) int {
+	most := 0
+	for _, s := range steps {
+		n := 0
+		for _, o := range steps {
+			if !o.StartedAt.After(s.StartedAt) && o.FinishedAt.After(s.StartedAt) {
+				n++
+			}
+		}
+		most = max(most, n)
+	}
+	return most
+}
+
+func TestParallelToolCallsKeepCallOrder(t *testing.T) {
+	sleepyGrep(t)
+	env := newTestEnv(t, llm.NewScriptedProvider(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{search("c1", "0.3"), search("c2", "0.1"), search("c3", "0.2"), {ID: "c4", Name: "fs__read_file", Arguments: `{"path":"a.txt"}`}}},
+		llm.ScriptedTurn{Content: "Done."},
+	))
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "search")
+	env.writeFile(run, "a.txt", "A")
+
+	events := env.stream(run.ID)
+
+	// 调用同时执行，先结束的先发 tool_end（read_file 很快，可能在最后一个搜索开始前就结束了）
+	steps := env.toolSteps(run.ID)
+	delete(steps, "c4")
+	if n := maxOverlap(steps); n != 3 {
+		t.Fatalf("at most %d searches overlapped", n)
+	}
+	var ended []string
+	for _, ev := range events {
+		if ev.Type == "tool_end" {
+			ended = append(ended, ev.ToolCallID)
+		}
+	}
+	if ended[len(ended)-1] != "c1" {
+		t.Fatalf("tool_end order = %v", ended)
+	}
+
+	// 写入对话、发给模型的结果仍按调用顺序
+	order, results := env.toolResults(run.SessionID)
+	if !reflect.DeepEqual(order, []string{"c1", "c2", "c3", "c4"}) {
+		t.Fatalf("tool results order = %v", order)
+	}
+	want := map[string]string{"c1": "found 0.3\n", "c2": "found 0.1\n", "c3": "found 0.2\n", "c4": "A"}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("tool results = %v", results)
+	}
+	var sent []string
+	for _, m := range env.llm.Requests[1].History {
+		if m.Role == "tool" {
+			sent = append(sent, m.ToolCallID)
+		}
+	}
+	if !reflect.DeepEqual(sent, order) {
+		t.Fatalf("history sent to the model = %v", sent)
+	}
+}
+
+func TestParallelToolLimit(t *testing.T) {
+	cases := []struct {
+		name  string
+		extra map[string]interface{}
+		want  int
+	}{
+		{"default", nil, 4},
+		{"limited", map[string]interface{}{"max_parallel_tools": 2}, 2},
+		{"serial", map[string]interface{}{"max_parallel_tools": 1}, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sleepyGrep(t)
+			var calls []llm.ToolCallInfo
+			for _, id := range []string{"c1", "c2", "c3", "c4", "c5"} {
+				calls = append(calls, search(id, "0.1"))
+			}
+			env := newTestEnv(t, llm.NewScriptedProvider(llm.ScriptedTurn{ToolCalls: calls}, llm.ScriptedTurn{Content: "Done."}))
+			agent := env.agent("coder", c.extra)
+			env.bindFS(agent, nil)
+			run := env.startRun(agent, "search")
+
+			if _, err := env.e.ExecuteRun(run.ID); err != nil {
+				t.Fatal(err)
+			}
+			if n := maxOverlap(env.toolSteps(run.ID)); n != c.want {
+				t.Fatalf("at most %d calls overlapped, want %d", n, c.want)
+			}
+			if order, _ := env.toolResults(run.SessionID); !reflect.DeepEqual(order, []string{"c1", "c2", "c3", "c4", "c5"}) {
+				t.Fatalf("tool results order = %v", order)
+			}
+		})
+	}
+}
+
+func TestSideEffectingToolsRunInOwnBatch(t *testing.T) {
+	sleepyGrep(t)
+	env := newTestEnv(t, llm.NewScriptedProvider(
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{
+			search("c1", "0.2"),
+			{ID: "c2", Name: "fs__read_file", Arguments: `{"path":"b.txt"}`},
+			{ID: "c3", Name: "fs__write_file", Arguments: `{"path":"b.txt","content":"new"}`},
+			search("c4", "0.1"),
+			{ID: "c5", Name: "fs__read_file", Arguments: `{"path":"b.txt"}`},
+		}},
+		llm.ScriptedTurn{Content: "Done."},
+	))
+	agent := env.agent("coder", nil)
+	env.bindFS(agent, nil)
+	run := env.startRun(agent, "rewrite b.txt")
+	env.writeFile(run, "b.txt", "old")
+
+	if _, err := env.e.ExecuteRun(run.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// write_file 等前面的调用都结束才开始，结束后才开始后面的调用；两侧的读各自并发
+	steps := env.toolSteps(run.ID)
+	write := steps["c3"]
+	for _, id := range []string{"c1", "c2"} {
+		if steps[id].FinishedAt.After(write.StartedAt) {
+			t.Fatalf("%s was still running when write_file started", id)
+		}
+	}
+	for _, id := range []string{"c4", "c5"} {
+		if steps[id].StartedAt.Before(write.FinishedAt) {
+			t.Fatalf("%s started before write_file finished", id)
+		}
+	}
+	if !steps["c2"].StartedAt.Before(steps["c1"].FinishedAt) || !steps["c5"].StartedAt.Before(steps["c4"].FinishedAt) {
+		t.Fatal("reads around the write did not run in parallel")
+	}
+
+	// 写之前的读看到旧内容，之后的读看到新内容
+	order, results := env.toolResults(run.SessionID)
+	if !reflect.DeepEqual(order, []string{"c1", "c2", "c3", "c4", "c5"}) || results["c2"] != "old" || results["c5"] != "new" {
+		t.Fatalf("tool results = %v %v", order, results)
+	}
+}
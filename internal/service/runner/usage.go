@@ -0,0 +1,142 @@
+package runner
+
+import (
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+// Token 用量记在 Run.usage_metadata：
+//
+//	{
+//	  "prompt_tokens": 1200, "completion_tokens": 300, "total_tokens": 1500, "llm_calls": 3, "cost_usd": 0.006,  // 本 Run 自己的调用
+//	  "by_model": {"openai/gpt-4o": {...同上}},
+//	  "children": {...同上},  // handoff 子 Run（含更深的子 Run）的合计，子 Run 结束时计入
+//	  "rollup":   {...同上}   // 本 Run + children
+//	}
+//
+// cost_usd 按价格表（见 pricing.go）折算，本 Run 自己的花费同时写入 Run.cost_usd，用于统计和预算
+// 每次 LLM 调用还记录一个 step_type = "llm_call" 的 Trace 步骤（output_payload.usage），
+// 产生的 Assistant 消息的 token_count 为这次调用的 completion tokens
+
+// TokenUsage 一组 LLM 调用的 Token 用量
+type TokenUsage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	LLMCalls         int     `json:"llm_calls"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// callUsage 一次调用的用量；Provider 没有返回 total 时按两者相加
+func callUsage(usage map[string]int) TokenUsage {
+	u := TokenUsage{
+		PromptTokens:     usage["prompt_tokens"],
+		CompletionTokens: usage["completion_tokens"],
+		TotalTokens:      usage["total_tokens"],
+		LLMCalls:         1,
+	}
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	return u
+}
+
+// usageOf 从 usage_metadata（或其中的子对象）读出用量
+func usageOf(m map[string]interface{}) TokenUsage {
+	return TokenUsage{
+		PromptTokens:     configInt(m, "prompt_tokens"),
+		CompletionTokens: configInt(m, "completion_tokens"),
+		TotalTokens:      configInt(m, "total_tokens"),
+		LLMCalls:         configInt(m, "llm_calls"),
+		CostUSD:          configFloat(m, "cost_usd"),
+	}
+}
+
+func (u *TokenUsage) add(o TokenUsage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.TotalTokens += o.TotalTokens
+	u.LLMCalls += o.LLMCalls
+	u.CostUSD += o.CostUSD
+}
+
+func (u TokenUsage) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"prompt_tokens":     u.PromptTokens,
+		"completion_tokens": u.CompletionTokens,
+		"total_tokens":      u.TotalTokens,
+		"llm_calls":         u.LLMCalls,
+		"cost_usd":          u.CostUSD,
+	}
+}
+
+// recordUsage 把一次 LLM 调用计入 Run 自己的用量、by_model 和 rollup，返回这次调用的用量（含花费）
+// 同一个 Run 同时只有一个 goroutine 在调用 LLM，读改写不需要加锁
+func (e *AgentEngine) recordUsage(runID string, sel llm.Selection, usage map[string]int) TokenUsage {
+	u := callUsage(usage)
+	u.CostUSD = e.Prices.cost(sel, u)
+	run := e.Store.GetRun(runID)
+	if run == nil {
+		return u
+	}
+	meta := copyUsage(run.UsageMetadata)
+
+	own := usageOf(meta)
+	own.add(u)
+	for k, v := range own.toMap() {
+		meta[k] = v
+	}
+	prev, _ := meta["by_model"].(map[string]interface{})
+	models := copyUsage(prev)
+	key := sel.Provider + "/" + sel.Model
+	m, _ := models[key].(map[string]interface{})
+	perModel := usageOf(m)
+	perModel.add(u)
+	models[key] = perModel.toMap()
+	meta["by_model"] = models
+	meta["rollup"] = addUsage(meta["rollup"], u)
+
+	e.Store.UpdateRunUsage(runID, meta, own.CostUSD)
+	return u
+}
+
+// addChildUsage handoff 子 Run 结束后把它的 rollup 计入父 Run 的 children 和 rollup
+func (e *AgentEngine) addChildUsage(parentID string, child *store.Run) {
+	if child == nil {
+		return
+	}
+	parent, fresh := e.Store.GetRun(parentID), e.Store.GetRun(child.ID)
+	if parent == nil || fresh == nil {
+		return
+	}
+	rollup, _ := fresh.UsageMetadata["rollup"].(map[string]interface{})
+	u := usageOf(rollup)
+	if u == (TokenUsage{}) {
+		return
+	}
+	meta := copyUsage(parent.UsageMetadata)
+	meta["children"] = addUsage(meta["children"], u)
+	meta["rollup"] = addUsage(meta["rollup"], u)
+	e.Store.UpdateRunUsage(parentID, meta, parent.CostUSD)
+}
+
+// runUsage Run 自己的用量（不含子 Run）
+func runUsage(run *store.Run) TokenUsage {
+	return usageOf(run.UsageMetadata)
+}
+
+func addUsage(current interface{}, u TokenUsage) map[string]interface{} {
+	m, _ := current.(map[string]interface{})
+	total := usageOf(m)
+	total.add(u)
+	return total.toMap()
+}
+
+// copyUsage 浅拷贝，不直接改 Store 返回的对象（内存 Store 返回的是共享的 map）
+func copyUsage(m map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(m)+4)
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
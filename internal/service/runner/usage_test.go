@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"math"
+	"testing"
+
+	"example.com/agent-server/internal/service/llm"
+	"example.com/agent-server/internal/store"
+)
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-12 }
+
+func sameUsage(got, want TokenUsage) bool {
+	return got.PromptTokens == want.PromptTokens && got.CompletionTokens == want.CompletionTokens && got.TotalTokens == want.TotalTokens &&
+		got.LLMCalls == want.LLMCalls && approx(got.CostUSD, want.CostUSD)
+}
+
+func usageAt(meta map[string]interface{}, key string) TokenUsage {
+	m, _ := meta[key].(map[string]interface{})
+	return usageOf(m)
+}
+
+func TestUsageRollupAcrossHandoffs(t *testing.T) {
+	provider := llm.NewScriptedProvider()
+	env := newTestEnv(t, provider)
+	// Selection.Provider 为 fixed：manager 按 provider/model 定价，lead 按模型名，coder 走 *
+	env.e.Prices = PriceTable{
+		"fixed/big": {Input: 10, Output: 30},
+		"small":     {Input: 1, Output: 2},
+		"*":         {Input: 0.5, Output: 1},
+	}
+	newAgent := func(name, model string) *store.Agent {
+		return env.store.CreateAgent(&store.Agent{Name: name, SystemPrompt: "You are " + name + ".", ModelName: model, Status: "active"})
+	}
+	manager, lead, coder := newAgent("manager", "big"), newAgent("lead", "small"), newAgent("coder", "test-model")
+	env.bindFS(coder, nil)
+	provider.Enqueue(
+		llm.ScriptedTurn{Handoff: &llm.HandoffDecision{TargetAgentID: lead.ID}, Usage: map[string]int{"prompt_tokens": 1000, "completion_tokens": 100}},
+		llm.ScriptedTurn{Handoff: &llm.HandoffDecision{TargetAgentID: coder.ID}, Usage: map[string]int{"prompt_tokens": 2000, "completion_tokens": 200}},
+		llm.ScriptedTurn{ToolCalls: []llm.ToolCallInfo{{ID: "c1", Name: "fs__list_directory", Arguments: `{"path":"."}`}}, Usage: map[string]int{"prompt_tokens": 3000, "completion_tokens": 300}},
+		// Provider 给出的 total 优先
+		llm.ScriptedTurn{Content: "Done.", Usage: map[string]int{"prompt_tokens": 1000, "completion_tokens": 700, "total_tokens": 1800}},
+	)
+	run := env.startRun(manager, "build it")
+
+	if final, err := env.e.ExecuteRun(run.ID); err != nil || final != "Done." {
+		t.Fatalf("ExecuteRun = %q, %v", final, err)
+	}
+
+	parent := env.store.GetRun(run.ID)
+	leadRun := env.store.GetRun(parent.OutputPayload["child_run_id"].(string))
+	coderRun := env.store.GetRun(leadRun.OutputPayload["child_run_id"].(string))
+
+	managerOwn := TokenUsage{PromptTokens: 1000, CompletionTokens: 100, TotalTokens: 1100, LLMCalls: 1, CostUSD: (1000*10 + 100*30) / 1e6}
+	leadOwn := TokenUsage{PromptTokens: 2000, CompletionTokens: 200, TotalTokens: 2200, LLMCalls: 1, CostUSD: (2000*1 + 200*2) / 1e6}
+	coderOwn := TokenUsage{PromptTokens: 4000, CompletionTokens: 1000, TotalTokens: 5100, LLMCalls: 2, CostUSD: (4000*0.5 + 1000*1) / 1e6}
+	sum := func(us ...TokenUsage) TokenUsage {
+		var total TokenUsage
+		for _, u := range us {
+			total.add(u)
+		}
+		return total
+	}
+
+	cases := []struct {
+		name     string
+		run      *store.Run
+		own      TokenUsage
+		children TokenUsage
+	}{
+		{"coder", coderRun, coderOwn, TokenUsage{}},
+		{"lead", leadRun, leadOwn, coderOwn},
+		{"manager", parent, managerOwn, sum(leadOwn, coderOwn)},
+	}
+	for _, c := range cases {
+		meta := c.run.UsageMetadata
+		if got := runUsage(c.run); !sameUsage(got, c.own) {
+			t.Errorf("%s own usage = %+v, want %+v", c.name, got, c.own)
+		}
+		// Run.cost_usd 只是自己的花费，不含子 Run
+		if !approx(c.run.CostUSD, c.own.CostUSD) {
+			t.Errorf("%s cost_usd = %v, want %v", c.name, c.run.CostUSD, c.own.CostUSD)
+		}
+		if got := usageAt(meta, "children"); !sameUsage(got, c.children) {
+			t.Errorf("%s children = %+v, want %+v", c.name, got, c.children)
+		}
+		if got, want := usageAt(meta, "rollup"), sum(c.own, c.children); !sameUsage(got, want) {
+			t.Errorf("%s rollup = %+v, want %+v", c.name, got, want)
+		}
+	}
+	if byModel, _ := parent.UsageMetadata["by_model"].(map[string]interface{}); len(byModel) != 1 || !sameUsage(usageAt(byModel, "fixed/big"), managerOwn) {
+		t.Errorf("manager by_model = %v", parent.UsageMetadata["by_model"])
+	}
+
+	// 每次调用的用量记在 llm_call 步骤上，Assistant 消息记 completion tokens
+	var calls []TokenUsage
+	for _, s := range env.store.ListRunStepsByRun(coderRun.ID) {
+		if s.StepType == "llm_call" {
+			u, _ := s.OutputPayload["usage"].(map[string]interface{})
+			calls = append(calls, usageOf(u))
+		}
+	}
+	if len(calls) != 2 || calls[0].CompletionTokens != 300 || calls[1].TotalTokens != 1800 || !approx(calls[1].CostUSD, (1000*0.5+700*1)/1e6) {
+		t.Errorf("coder llm_call usage = %+v", calls)
+	}
+	var tokens []int
+	for _, m := range env.store.ListChatMessagesBySession(run.SessionID) {
+		if m.Role == "assistant" && m.RunID == coderRun.ID {
+			tokens = append(tokens, m.TokenCount)
+		}
+	}
+	if len(tokens) != 2 || tokens[0] != 300 || tokens[1] != 700 {
+		t.Errorf("coder message token counts = %v", tokens)
+	}
+}
@@ -1,21 +1,31 @@
 package service
 
 import (
+	"example.com/agent-server/internal/service/integration"
 	"example.com/agent-server/internal/service/llm"
 	"example.com/agent-server/internal/service/mcp"
+	"example.com/agent-server/internal/service/workspace"
 	"example.com/agent-server/internal/store"
 )
 
 type Service struct {
-	Store store.Store
-	LLM   *llm.Client
-	MCP   *mcp.MCPService // <--- 新增
+	Store        store.Store
+	LLM          *llm.Registry
+	MCP          *mcp.MCPService // <--- 新增
+	Integrations *integration.Service
+	Workspaces   *workspace.Manager
 }
 
-func NewService(s store.Store, l *llm.Client) *Service {
-	return &Service{
-		Store: s,
-		LLM:   l,
-		MCP:   mcp.NewMCPService(s), // <--- 初始化
+// NewService cipher 为 nil 表示未配置主密钥，集成凭证不可用
+func NewService(s store.Store, l *llm.Registry, cipher *integration.Cipher) *Service {
+	svc := &Service{
+		Store:        s,
+		LLM:          l,
+		MCP:          mcp.NewMCPService(s), // <--- 初始化
+		Integrations: integration.NewService(s, cipher),
+		Workspaces:   workspace.NewManager(workspace.DefaultRoot()),
 	}
+	// 引用了集成平台的 MCP Server 建连时，从这里取调用用户的凭证
+	svc.MCP.Executor.Clients.Credentials = svc.Integrations
+	return svc
 }
@@ -0,0 +1,334 @@
+package workspace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 导入限制，防止压缩炸弹把磁盘写满（测试中调小）
+var (
+	MaxImportBytes   int64 = 512 << 20 // 解压后总大小上限
+	MaxImportEntries       = 50000     // 文件数上限
+)
+
+var ErrTooLarge = errors.New("workspace import exceeds size limit")
+
+// InitFromDir 清空工作区后复制本地目录（如一个 git 仓库）进来
+// 源目录中的符号链接不复制，避免链接到工作区之外
+func (m *Manager) InitFromDir(sessionID, src string) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+
+	unlock := m.lock(sessionID)
+	defer unlock()
+	if err := m.resetLocked(sessionID); err != nil {
+		return err
+	}
+	dir, _ := m.Dir(sessionID)
+
+	lim := &importLimit{}
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		if rel == "." {
+			return nil
+		}
+		target := filepath.Join(dir, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			info, _ := d.Info()
+			return lim.writeFile(target, f, info.Mode())
+		default:
+			// 符号链接 / 设备文件等跳过
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sanitizeGit(dir)
+}
+
+// InitFromArchive 清空工作区后解压上传的归档，支持 .tar / .tar.gz / .tgz / .zip
+func (m *Manager) InitFromArchive(sessionID, filename string, r io.Reader) error {
+	unlock := m.lock(sessionID)
+	defer unlock()
+	if err := m.resetLocked(sessionID); err != nil {
+		return err
+	}
+	dir, _ := m.Dir(sessionID)
+
+	var err error
+	switch name := strings.ToLower(filename); {
+	case strings.HasSuffix(name, ".zip"):
+		err = extractZip(dir, r)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(r); err == nil {
+			err = extractTar(dir, gz)
+		}
+	case strings.HasSuffix(name, ".tar"):
+		err = extractTar(dir, r)
+	default:
+		err = fmt.Errorf("unsupported archive type: %s (want .tar, .tar.gz, .tgz or .zip)", filename)
+	}
+	if err != nil {
+		// 解压失败不留下半成品
+		_ = m.resetLocked(sessionID)
+		return err
+	}
+	return sanitizeGit(dir)
+}
+
+// Export 把工作区打包成 tar.gz 写入 w（不跟随符号链接）
+func (m *Manager) Export(sessionID string, w io.Writer) error {
+	dir, err := m.Ensure(sessionID)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func extractTar(dir string, r io.Reader) error {
+	lim := &importLimit{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := entryPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := lim.writeFile(target, tr, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		default:
+			// 符号链接 / 硬链接 / 设备文件一律跳过
+		}
+	}
+}
+
+func extractZip(dir string, r io.Reader) error {
+	// zip 需要随机读取，先落到临时文件（同样受大小限制）
+	tmp, err := os.CreateTemp("", "workspace-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	n, err := io.Copy(tmp, io.LimitReader(r, MaxImportBytes+1))
+	if err != nil {
+		return err
+	}
+	if n > MaxImportBytes {
+		return ErrTooLarge
+	}
+
+	zr, err := zip.NewReader(tmp, n)
+	if err != nil {
+		return err
+	}
+	lim := &importLimit{}
+	for _, f := range zr.File {
+		target, err := entryPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = lim.writeFile(target, rc, mode)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entryPath 归档条目的落地路径；带 ../ 或绝对路径的条目 (zip slip) 直接拒绝
+func entryPath(dir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: archive entry %q", ErrOutsideWorkspace, name)
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// importLimit 累计导入的文件数和字节数
+type importLimit struct {
+	entries int
+	bytes   int64
+}
+
+func (l *importLimit) writeFile(target string, r io.Reader, mode os.FileMode) error {
+	l.entries++
+	if l.entries > MaxImportEntries {
+		return fmt.Errorf("%w: more than %d files", ErrTooLarge, MaxImportEntries)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	// 只保留可执行位，去掉 setuid 等特殊位
+	perm := os.FileMode(0o644)
+	if mode&0o111 != 0 {
+		perm = 0o755
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, io.LimitReader(r, MaxImportBytes-l.bytes+1))
+	l.bytes += n
+	if err != nil {
+		return err
+	}
+	if l.bytes > MaxImportBytes {
+		return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, MaxImportBytes)
+	}
+	return nil
+}
+
+// git 配置里安全、且仓库正常工作需要的键；其余（hooks、fsmonitor、filter、include 等可执行命令的配置）全部丢弃
+var safeGitConfig = map[string]bool{
+	"core.repositoryformatversion": true,
+	"core.filemode":                true,
+	"core.bare":                    true,
+	"core.logallrefupdates":        true,
+	"core.ignorecase":              true,
+	"core.precomposeunicode":       true,
+}
+
+// sanitizeGit 导入的仓库来源不可信：删除 hooks，配置只保留白名单里的键
+// .git 是文件（gitdir: 指向别处）时直接删掉，避免 git 命令操作工作区之外的仓库
+func sanitizeGit(dir string) error {
+	gitDir := filepath.Join(dir, ".git")
+	st, err := os.Lstat(gitDir)
+	if err != nil {
+		return nil
+	}
+	if !st.IsDir() {
+		return os.Remove(gitDir)
+	}
+	if err := os.RemoveAll(filepath.Join(gitDir, "hooks")); err != nil {
+		return err
+	}
+
+	cfgPath := filepath.Join(gitDir, "config")
+	f, err := os.Open(cfgPath)
+	if err != nil {
+		return nil
+	}
+	var out strings.Builder
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(strings.Trim(line, "[] "))
+			if section == "core" || section == "extensions" {
+				out.WriteString("[" + section + "]\n")
+			}
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(strings.SplitN(line, "=", 2)[0]))
+		// extensions.worktreeconfig 会让 git 额外读取 config.worktree，一并丢弃
+		if (section == "extensions" && key != "worktreeconfig") || safeGitConfig[section+"."+key] {
+			out.WriteString("\t" + line + "\n")
+		}
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return os.WriteFile(cfgPath, []byte(out.String()), 0o644)
+}
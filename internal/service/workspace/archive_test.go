@@ -0,0 +1,336 @@
+package workspace
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry 测试归档里的一个条目；link 非空表示符号链接
+type entry struct {
+	name    string
+	content string
+	mode    int64
+	link    string
+}
+
+func tarOf(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: e.mode, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tgzOf(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(tarOf(t, entries...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipOf(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		mode := os.FileMode(0o644)
+		if e.mode != 0 {
+			mode = os.FileMode(e.mode)
+		}
+		if e.link != "" {
+			mode = os.ModeSymlink | 0o777
+			e.content = e.link
+		}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// archiveFormats 同一组条目打成各种支持的格式
+var archiveFormats = []struct {
+	filename string
+	build    func(*testing.T, ...entry) []byte
+}{
+	{"repo.tar", tarOf},
+	{"repo.tar.gz", tgzOf},
+	{"repo.zip", zipOf},
+}
+
+// limitImport 临时调小导入限制
+func limitImport(t *testing.T, bytes int64, entries int) {
+	oldBytes, oldEntries := MaxImportBytes, MaxImportEntries
+	MaxImportBytes, MaxImportEntries = bytes, entries
+	t.Cleanup(func() { MaxImportBytes, MaxImportEntries = oldBytes, oldEntries })
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestEntryPath(t *testing.T) {
+	dir := "/ws/s1"
+	cases := []struct {
+		name string
+		want string // 空串表示应当拒绝
+	}{
+		{"a.txt", "/ws/s1/a.txt"},
+		{"./src/main.go", "/ws/s1/src/main.go"},
+		{"src/../a.txt", "/ws/s1/a.txt"},
+		{"..", ""},
+		{"../evil", ""},
+		{"src/../../evil", ""},
+		{"../s1-sibling/x", ""},
+		{"/etc/passwd", ""},
+	}
+	for _, c := range cases {
+		got, err := entryPath(dir, c.name)
+		if c.want == "" {
+			if !errors.Is(err, ErrOutsideWorkspace) {
+				t.Errorf("entryPath(%q) = %q, %v; want ErrOutsideWorkspace", c.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("entryPath(%q) = %q, %v; want %q", c.name, got, err, c.want)
+		}
+	}
+}
+
+func TestInitFromArchive(t *testing.T) {
+	for _, f := range archiveFormats {
+		t.Run(f.filename, func(t *testing.T) {
+			m := NewManager(t.TempDir())
+			data := f.build(t,
+				entry{name: "src/"},
+				entry{name: "src/main.go", content: "package main"},
+				entry{name: "run.sh", content: "#!/bin/sh", mode: 0o4755},
+			)
+			if err := m.InitFromArchive("s1", f.filename, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			dir, _ := m.Dir("s1")
+			if got, _ := os.ReadFile(filepath.Join(dir, "src", "main.go")); string(got) != "package main" {
+				t.Fatalf("main.go = %q", got)
+			}
+			// 只保留可执行位，setuid 被去掉
+			if st, err := os.Stat(filepath.Join(dir, "run.sh")); err != nil || st.Mode() != 0o755 {
+				t.Fatalf("run.sh mode = %v, %v", st.Mode(), err)
+			}
+		})
+	}
+}
+
+func TestInitFromArchiveRejectsSlip(t *testing.T) {
+	for _, f := range archiveFormats {
+		for _, name := range []string{"../evil.txt", "src/../../evil.txt", "/evil.txt"} {
+			t.Run(f.filename+"/"+name, func(t *testing.T) {
+				root := t.TempDir()
+				m := NewManager(filepath.Join(root, "workspaces"))
+				data := f.build(t, entry{name: "ok.txt", content: "ok"}, entry{name: name, content: "pwned"})
+				err := m.InitFromArchive("s1", f.filename, bytes.NewReader(data))
+				if !errors.Is(err, ErrOutsideWorkspace) {
+					t.Fatalf("err = %v", err)
+				}
+				for _, p := range []string{filepath.Join(root, "evil.txt"), filepath.Join(root, "workspaces", "evil.txt"), "/evil.txt"} {
+					if _, err := os.Stat(p); err == nil {
+						t.Fatalf("%s written outside the workspace", p)
+					}
+				}
+				// 失败时不留下半成品
+				dir, _ := m.Dir("s1")
+				if files := listFiles(t, dir); len(files) != 0 {
+					t.Fatalf("workspace left with %v", files)
+				}
+			})
+		}
+	}
+}
+
+func TestInitFromArchiveSkipsSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	for _, f := range archiveFormats {
+		t.Run(f.filename, func(t *testing.T) {
+			m := NewManager(t.TempDir())
+			// 先放一个指向外部的链接，再通过链接写文件：链接被跳过，文件落在工作区内的普通目录里
+			data := f.build(t,
+				entry{name: "escape", link: outside},
+				entry{name: "escape/pwned.txt", content: "pwned"},
+			)
+			if err := m.InitFromArchive("s1", f.filename, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			if files := listFiles(t, outside); len(files) != 0 {
+				t.Fatalf("wrote outside the workspace: %v", files)
+			}
+			dir, _ := m.Dir("s1")
+			if st, err := os.Lstat(filepath.Join(dir, "escape")); err != nil || !st.IsDir() {
+				t.Fatalf("escape = %v, %v", st, err)
+			}
+		})
+	}
+}
+
+func TestInitFromArchiveLimits(t *testing.T) {
+	for _, f := range archiveFormats {
+		t.Run(f.filename+"/entries", func(t *testing.T) {
+			limitImport(t, 1<<20, 3)
+			m := NewManager(t.TempDir())
+			data := f.build(t, entry{name: "1"}, entry{name: "2"}, entry{name: "3"}, entry{name: "4"})
+			if err := m.InitFromArchive("s1", f.filename, bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("err = %v", err)
+			}
+			dir, _ := m.Dir("s1")
+			if files := listFiles(t, dir); len(files) != 0 {
+				t.Fatalf("workspace left with %v", files)
+			}
+		})
+		t.Run(f.filename+"/bytes", func(t *testing.T) {
+			m := NewManager(t.TempDir())
+			// 单个文件和多个文件累计都算；压缩后很小的内容解压后照样受限
+			data := f.build(t, entry{name: "a", content: strings.Repeat("x", 600)}, entry{name: "b", content: strings.Repeat("x", 600)})
+			limitImport(t, 1000, 10)
+			if err := m.InitFromArchive("s1", f.filename, bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("err = %v", err)
+			}
+			limitImport(t, 1200, 10)
+			if err := m.InitFromArchive("s1", f.filename, bytes.NewReader(data)); err != nil {
+				t.Fatalf("at the limit: %v", err)
+			}
+		})
+	}
+}
+
+func TestInitFromArchiveSanitizesGit(t *testing.T) {
+	config := strings.Join([]string{
+		"[core]",
+		"\trepositoryformatversion = 0",
+		"\tfilemode = true",
+		"\tfsmonitor = /tmp/evil.sh",
+		"\tsshCommand = /tmp/evil.sh",
+		"\thooksPath = /tmp/hooks",
+		"[extensions]",
+		"\tobjectformat = sha1",
+		"\tworktreeConfig = true",
+		"[include]",
+		"\tpath = /tmp/evil.config",
+		`[filter "lfs"]`,
+		"\tclean = /tmp/evil.sh %f",
+		`[remote "origin"]`,
+		"\turl = https://example.com/repo.git",
+		"",
+	}, "\n")
+	m := NewManager(t.TempDir())
+	data := tarOf(t,
+		entry{name: ".git/HEAD", content: "ref: refs/heads/main\n"},
+		entry{name: ".git/config", content: config},
+		entry{name: ".git/hooks/post-checkout", content: "#!/bin/sh\ntouch /tmp/pwned", mode: 0o755},
+		entry{name: "README.md", content: "hi"},
+	)
+	if err := m.InitFromArchive("s1", "repo.tar", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := m.Dir("s1")
+	if _, err := os.Stat(filepath.Join(dir, ".git", "hooks")); !os.IsNotExist(err) {
+		t.Fatalf("hooks left behind: %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, ".git", "config"))
+	want := "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n[extensions]\n\tobjectformat = sha1\n"
+	if string(got) != want {
+		t.Fatalf("config =\n%s\nwant\n%s", got, want)
+	}
+	if head, _ := os.ReadFile(filepath.Join(dir, ".git", "HEAD")); string(head) != "ref: refs/heads/main\n" {
+		t.Fatalf("HEAD = %q", head)
+	}
+}
+
+func TestInitFromArchiveRemovesGitFile(t *testing.T) {
+	m := NewManager(t.TempDir())
+	// .git 是文件时指向别处的仓库，直接删除
+	data := zipOf(t, entry{name: ".git", content: "gitdir: /srv/other/.git\n"}, entry{name: "a.txt", content: "a"})
+	if err := m.InitFromArchive("s1", "repo.zip", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := m.Dir("s1")
+	if files := listFiles(t, dir); len(files) != 1 || files[0] != "a.txt" {
+		t.Fatalf("files = %v", files)
+	}
+}
+
+func TestInitFromDirSkipsSymlinks(t *testing.T) {
+	src, outside := t.TempDir(), t.TempDir()
+	mustWrite(t, filepath.Join(outside, "secret"), "s")
+	mustWrite(t, filepath.Join(src, "a.txt"), "a")
+	mustWrite(t, filepath.Join(src, ".git", "hooks", "pre-commit"), "#!/bin/sh")
+	if err := os.Symlink(outside, filepath.Join(src, "out")); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(t.TempDir())
+	if err := m.InitFromDir("s1", src); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := m.Dir("s1")
+	if files := listFiles(t, dir); strings.Join(files, ",") != ".git,a.txt" {
+		t.Fatalf("files = %v", files)
+	}
+}
@@ -0,0 +1,186 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrOutsideWorkspace 路径（或其符号链接目标）落在工作区之外
+var ErrOutsideWorkspace = errors.New("path escapes workspace")
+
+// ErrProtectedPath 工具不允许直接改写的路径（.git 内部）
+var ErrProtectedPath = errors.New("path is protected")
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Manager 为每个会话分配一个独立的工作区目录：<Root>/<sessionID>
+// 内置的 git / filesystem 工具只能在这个目录里活动
+type Manager struct {
+	Root string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // sessionID -> 初始化 / 重置时的互斥锁
+}
+
+func NewManager(root string) *Manager {
+	return &Manager{Root: root, locks: make(map[string]*sync.Mutex)}
+}
+
+// DefaultRoot WORKSPACE_ROOT 未配置时使用系统临时目录
+func DefaultRoot() string {
+	if root := os.Getenv("WORKSPACE_ROOT"); root != "" {
+		return root
+	}
+	return filepath.Join(os.TempDir(), "nexus-workspaces")
+}
+
+// Dir 会话工作区的路径（不保证已创建）
+func (m *Manager) Dir(sessionID string) (string, error) {
+	if !idPattern.MatchString(sessionID) {
+		return "", fmt.Errorf("invalid workspace id: %q", sessionID)
+	}
+	return filepath.Join(m.Root, sessionID), nil
+}
+
+// Ensure 返回会话工作区路径，不存在则创建
+func (m *Manager) Ensure(sessionID string) (string, error) {
+	dir, err := m.Dir(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Reset 清空工作区
+func (m *Manager) Reset(sessionID string) error {
+	unlock := m.lock(sessionID)
+	defer unlock()
+	return m.resetLocked(sessionID)
+}
+
+func (m *Manager) resetLocked(sessionID string) error {
+	dir, err := m.Dir(sessionID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0o700)
+}
+
+// Remove 删除工作区（会话删除时调用）
+func (m *Manager) Remove(sessionID string) error {
+	dir, err := m.Dir(sessionID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (m *Manager) lock(sessionID string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[sessionID]
+	if !ok {
+		l = &sync.Mutex{}
+		m.locks[sessionID] = l
+	}
+	m.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// Info 工作区概况
+type Info struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	IsGit bool  `json:"is_git"`
+}
+
+func (m *Manager) Info(sessionID string) (*Info, error) {
+	dir, err := m.Ensure(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{}
+	if st, err := os.Stat(filepath.Join(dir, ".git")); err == nil && st.IsDir() {
+		info.IsGit = true
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info.Files++
+			if fi, err := d.Info(); err == nil {
+				info.Bytes += fi.Size()
+			}
+		}
+		return nil
+	})
+	return info, err
+}
+
+// Resolve 把工具传入的相对路径解析为工作区内的绝对路径
+// 绝对路径和 ../ 都被限制在 root 之内；已存在的部分会解析符号链接，链接指向 root 之外时拒绝
+func Resolve(root, rel string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	// 先在 "/" 下做词法清理，保证结果不会跳出 root
+	target := filepath.Join(realRoot, filepath.Clean("/"+rel))
+
+	// 逐级找到最长的已存在前缀，解析其中的符号链接后再检查一次
+	existing := target
+	var rest []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = append([]string{filepath.Base(existing)}, rest...)
+		existing = parent
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if !within(realRoot, real) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideWorkspace, rel)
+	}
+	return filepath.Join(append([]string{real}, rest...)...), nil
+}
+
+// ResolveWritable 同 Resolve，另外禁止改写 .git 目录（防止写入 hooks / config 在 git 命令中执行）
+func ResolveWritable(root, rel string) (string, error) {
+	path, err := Resolve(root, rel)
+	if err != nil {
+		return "", err
+	}
+	realRoot, _ := filepath.EvalSymlinks(root)
+	relPath, _ := filepath.Rel(realRoot, path)
+	if first := strings.Split(filepath.ToSlash(relPath), "/")[0]; first == ".git" {
+		return "", fmt.Errorf("%w: %s", ErrProtectedPath, rel)
+	}
+	return path, nil
+}
+
+func within(root, path string) bool {
+	if path == root {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
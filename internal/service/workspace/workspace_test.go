@@ -0,0 +1,120 @@
+package workspace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sandbox 工作区 root 和一个工作区之外的目录 outside：
+//
+//	root/sub/a.txt
+//	root/inner -> sub          (链接到工作区内，允许)
+//	root/out -> outside        (链接到工作区外)
+//	root/sub/up -> ../..       (相对链接跳出工作区)
+//	root/gitlink -> .git
+func sandbox(t *testing.T) (root, outside string) {
+	t.Helper()
+	root, _ = filepath.EvalSymlinks(t.TempDir())
+	outside, _ = filepath.EvalSymlinks(t.TempDir())
+	mustWrite(t, filepath.Join(root, "sub", "a.txt"), "a")
+	mustWrite(t, filepath.Join(root, ".git", "config"), "[core]\n")
+	mustWrite(t, filepath.Join(outside, "secret"), "s")
+	for link, target := range map[string]string{
+		"inner":   "sub",
+		"out":     outside,
+		"sub/up":  "../..",
+		"gitlink": ".git",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func mustWrite(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root, _ := sandbox(t)
+	cases := []struct {
+		rel  string
+		want string // 相对 root；空串表示应当拒绝
+	}{
+		{"sub/a.txt", "sub/a.txt"},
+		{"new/file.txt", "new/file.txt"},
+		{".", "."},
+		// ../ 和绝对路径在词法上被钉在 root 之内
+		{"../etc/passwd", "etc/passwd"},
+		{"sub/../../../x", "x"},
+		{"/etc/passwd", "etc/passwd"},
+		{"/", "."},
+		// 符号链接按真实目标检查
+		{"inner/a.txt", "sub/a.txt"},
+		{"out", ""},
+		{"out/secret", ""},
+		{"out/not-yet-created", ""},
+		{"sub/up", ""},
+		{"sub/up/etc/passwd", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.rel, func(t *testing.T) {
+			got, err := Resolve(root, c.rel)
+			if c.want == "" {
+				if !errors.Is(err, ErrOutsideWorkspace) {
+					t.Fatalf("Resolve(%q) = %q, %v; want ErrOutsideWorkspace", c.rel, got, err)
+				}
+				return
+			}
+			if err != nil || got != filepath.Join(root, c.want) {
+				t.Fatalf("Resolve(%q) = %q, %v; want %q", c.rel, got, err, filepath.Join(root, c.want))
+			}
+		})
+	}
+}
+
+func TestResolveWritable(t *testing.T) {
+	root, _ := sandbox(t)
+	cases := []struct {
+		rel string
+		err error
+	}{
+		{"sub/a.txt", nil},
+		{".gitignore", nil},
+		{"sub/.git", nil},
+		{".git", ErrProtectedPath},
+		{".git/config", ErrProtectedPath},
+		{".git/hooks/pre-commit", ErrProtectedPath},
+		{"./.git/HEAD", ErrProtectedPath},
+		{"sub/../.git/config", ErrProtectedPath},
+		{"/.git/config", ErrProtectedPath},
+		{"gitlink/config", ErrProtectedPath},
+		{"out/secret", ErrOutsideWorkspace},
+	}
+	for _, c := range cases {
+		t.Run(c.rel, func(t *testing.T) {
+			_, err := ResolveWritable(root, c.rel)
+			if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+				t.Fatalf("ResolveWritable(%q) err = %v, want %v", c.rel, err, c.err)
+			}
+		})
+	}
+}
+
+func TestManagerDirRejectsBadIDs(t *testing.T) {
+	m := NewManager(t.TempDir())
+	for _, id := range []string{"", "..", "../x", "a/b", "/abs", "a b"} {
+		if dir, err := m.Dir(id); err == nil {
+			t.Errorf("Dir(%q) = %q", id, dir)
+		}
+	}
+}
@@ -77,7 +77,7 @@ type UserIntegration struct {
 	ID                   string    `json:"id"`
 	UserID               string    `json:"user_id"`
 	Provider             string    `json:"provider"`
-	EncryptedCredentials string    `json:"encrypted_credentials"`
+	EncryptedCredentials string    `json:"-"` // AES-GCM 密文，不对外输出
 	DisplayLabel         string    `json:"display_label"`
 	CreatedAt            time.Time `json:"created_at"`
 	UpdatedAt            time.Time `json:"updated_at"`
@@ -101,7 +101,7 @@ type Agent struct {
 	Description      string         `json:"description"`
 	ModelName        string         `json:"model_name"`
 	SystemPrompt     string         `json:"system_prompt"`
-	Temperature      float64        `json:"temperature"`
+	Temperature      *float64       `json:"temperature"` // nil 表示未设置，用 Provider 的默认温度
 	KnowledgeBaseIDs pq.StringArray `json:"knowledge_base_ids" gorm:"type:text[]"`
 	Status           string         `json:"status"`
 	ExtraConfig      JSONMap        `json:"extra_config" gorm:"type:jsonb"`
@@ -116,15 +116,18 @@ type Agent struct {
 }
 
 type MCPServer struct {
-	ID               string    `json:"id"`
-	AgentID          string    `json:"agent_id"`
-	Name             string    `json:"name"`
-	TransportType    string    `json:"transport_type"`
-	ConnectionConfig JSONMap   `json:"connection_config" gorm:"type:jsonb"`
-	IsGlobal         bool      `json:"is_global"` //这是平台预先定义好的几个常用server
-	Status           string    `json:"status"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               string     `json:"id"`
+	AgentID          string     `json:"agent_id"`
+	Name             string     `json:"name"`
+	TransportType    string     `json:"transport_type"`
+	ConnectionConfig JSONMap    `json:"connection_config" gorm:"type:jsonb"`
+	IsGlobal         bool       `json:"is_global"`  //这是平台预先定义好的几个常用server
+	Status           string     `json:"status"`     // active / degraded / disconnected，由健康检查维护
+	LatencyMs        int        `json:"latency_ms"` // 最近一次探测的往返延迟
+	LastError        string     `json:"last_error"` // 最近一次探测失败的原因，成功后清空
+	LastCheckedAt    *time.Time `json:"last_checked_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
 type MCPTool struct {
@@ -133,18 +136,43 @@ type MCPTool struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	InputSchema JSONMap   `json:"input_schema" gorm:"type:jsonb"`
+	SideEffects bool      `json:"side_effects"` // 会修改外部状态（写文件 / 提交等），同一轮里不与其它调用并行
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// MCPResource Server 通过 resources/list 声明的资源，以 (server_id, uri) 唯一
+type MCPResource struct {
+	ID          string    `json:"id"`
+	ServerID    string    `json:"server_id"`
+	URI         string    `json:"uri"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	MimeType    string    `json:"mime_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// MCPPrompt Server 通过 prompts/list 声明的提示模板，以 (server_id, name) 唯一
+type MCPPrompt struct {
+	ID          string    `json:"id"`
+	ServerID    string    `json:"server_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Arguments   JSONMap   `json:"arguments" gorm:"type:jsonb"` // 参数名 -> {"description": "...", "required": true}
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 type ChatSession struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	AgentID   string    `json:"agent_id"`
-	Title     string    `json:"title"`
-	Summary   string    `json:"summary"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	AgentID     string    `json:"agent_id"`
+	Title       string    `json:"title"`
+	Summary     string    `json:"summary"`       // 较早对话的滚动摘要，由 Runner 在上下文超出模型窗口时生成
+	SummaryUpTo string    `json:"summary_up_to"` // Summary 覆盖到的最后一条消息 ID，之后的消息原样发送
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 type Run struct {
@@ -158,6 +186,8 @@ type Run struct {
 	InputPayload  JSONMap   `json:"input_payload" gorm:"type:jsonb"`
 	OutputPayload JSONMap   `json:"output_payload" gorm:"type:jsonb"`
 	UsageMetadata JSONMap   `json:"usage_metadata" gorm:"type:jsonb"`
+	CostUSD       float64   `json:"cost_usd"`   // 本 Run 自己的调用按价格表折算的花费，不含子 Run
+	APIKeyID      string    `json:"api_key_id"` // 经 API Key 发起（MCP）时记录，子 Run 沿用；预算按它统计
 	StartedAt     time.Time `json:"started_at"`
 	FinishedAt    time.Time `json:"finished_at"`
 }
@@ -188,6 +218,74 @@ type ChatMessage struct {
 	CreatedAt  time.Time `json:"created_at"`
 }
 
+// UsageQuery Token 用量统计的条件，空字段不过滤
+type UsageQuery struct {
+	UserID   string
+	AgentID  string
+	APIKeyID string
+	From     time.Time // 含，按 Run.started_at
+	To       time.Time // 不含
+	GroupBy  []string  // "day" / "agent" / "user" 的组合；为空时只返回一个合计
+}
+
+// UsageBucket 一组 Run 的 Token 用量合计
+// 只累加各 Run 自己的调用（usage_metadata 顶层字段），handoff 子 Run 单独计入，不会重复
+type UsageBucket struct {
+	Day              string  `json:"day,omitempty"` // UTC 日期，2006-01-02
+	AgentID          string  `json:"agent_id,omitempty"`
+	UserID           string  `json:"user_id,omitempty"`
+	Runs             int     `json:"runs"`
+	LLMCalls         int     `json:"llm_calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// SpendBudget 花费上限（美元），窗口为 UTC 自然日 / 自然月；为 0 的窗口不限制
+// 每个 Run 的花费计入发起它的用户、API Key 和执行它的 Agent
+type SpendBudget struct {
+	ID         string    `json:"id"`
+	Scope      string    `json:"scope"` // user / api_key / agent
+	ScopeID    string    `json:"scope_id"`
+	DailyUSD   float64   `json:"daily_usd"`
+	MonthlyUSD float64   `json:"monthly_usd"`
+	UpdatedBy  string    `json:"updated_by"` // 最后修改的用户
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ToolApproval 需要人工审批的工具调用；Run 在此暂停，审批后从这个调用继续执行
+type ToolApproval struct {
+	ID              string     `json:"id"`
+	RunID           string     `json:"run_id"`
+	SessionID       string     `json:"session_id"`
+	UserID          string     `json:"user_id"`
+	AgentID         string     `json:"agent_id"`
+	ServerID        string     `json:"server_id"`
+	ToolCallID      string     `json:"tool_call_id"`
+	ToolName        string     `json:"tool_name"`                                    // 暴露给 LLM 的带命名空间的名字
+	Arguments       JSONMap    `json:"arguments" gorm:"type:jsonb"`                  // 模型给出的参数
+	EditedArguments JSONMap    `json:"edited_arguments,omitempty" gorm:"type:jsonb"` // 审批时修改后的参数
+	Status          string     `json:"status"`                                       // pending / approved / edited / rejected / cancelled
+	Reason          string     `json:"reason"`                                       // 拒绝理由
+	State           JSONMap    `json:"-" gorm:"type:jsonb"`                          // 暂停时的预算消耗，恢复时还原
+	CreatedAt       time.Time  `json:"created_at"`
+	DecidedAt       *time.Time `json:"decided_at"`
+}
+
+// ToolOutput 超出长度上限的工具输出全文；对话历史里只保留截断后的前缀，模型需要时按 ID 分段读取
+type ToolOutput struct {
+	ID         string    `json:"id"`
+	SessionID  string    `json:"session_id"`
+	RunID      string    `json:"run_id"`
+	ToolCallID string    `json:"tool_call_id"`
+	ToolName   string    `json:"tool_name"`
+	Content    string    `json:"content"`
+	Size       int       `json:"size"` // 字符数
+	CreatedAt  time.Time `json:"created_at"`
+}
+
 type MemoryStore struct {
 	mu           sync.RWMutex
 	users        map[string]*User
@@ -199,14 +297,19 @@ type MemoryStore struct {
 	kbs          map[string]*KnowledgeBase
 	mcpServers   map[string]*MCPServer
 	mcpTools     map[string]*MCPTool
+	mcpResources map[string]*MCPResource
+	mcpPrompts   map[string]*MCPPrompt
 	sessions     map[string]*ChatSession
 	runs         map[string]*Run
 	runSteps     map[string]*RunStep
 	messages     map[string]*ChatMessage
+	toolOutputs  map[string]*ToolOutput
+	approvals    map[string]*ToolApproval
+	budgets      map[string]*SpendBudget // scope + ":" + scope_id
 }
 
 func init() {
-	Set(&MemoryStore{users: map[string]*User{}, usersByE: map[string]*User{}, refresh: map[string]*RefreshToken{}, agents: map[string]*Agent{}, apiKeys: map[string]*APIKey{}, integrations: map[string]*UserIntegration{}, kbs: map[string]*KnowledgeBase{}, mcpServers: map[string]*MCPServer{}, mcpTools: map[string]*MCPTool{}, sessions: map[string]*ChatSession{}, runs: map[string]*Run{}, runSteps: map[string]*RunStep{}, messages: map[string]*ChatMessage{}})
+	Set(&MemoryStore{users: map[string]*User{}, usersByE: map[string]*User{}, refresh: map[string]*RefreshToken{}, agents: map[string]*Agent{}, apiKeys: map[string]*APIKey{}, integrations: map[string]*UserIntegration{}, kbs: map[string]*KnowledgeBase{}, mcpServers: map[string]*MCPServer{}, mcpTools: map[string]*MCPTool{}, mcpResources: map[string]*MCPResource{}, mcpPrompts: map[string]*MCPPrompt{}, sessions: map[string]*ChatSession{}, runs: map[string]*Run{}, runSteps: map[string]*RunStep{}, messages: map[string]*ChatMessage{}, toolOutputs: map[string]*ToolOutput{}, approvals: map[string]*ToolApproval{}, budgets: map[string]*SpendBudget{}})
 }
 
 func randID() string {
@@ -343,6 +446,26 @@ func (m *MemoryStore) GetAPIKey(id string) *APIKey {
 	return m.apiKeys[id]
 }
 
+func (m *MemoryStore) FindAPIKeyByHash(keyHash string) *APIKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range m.apiKeys {
+		if k.KeyHash == keyHash {
+			return k
+		}
+	}
+	return nil
+}
+
+// TouchAPIKey 记录最近使用时间
+func (m *MemoryStore) TouchAPIKey(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k, ok := m.apiKeys[id]; ok {
+		k.LastUsedAt = time.Now()
+	}
+}
+
 func (m *MemoryStore) DeleteAPIKey(id string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -376,6 +499,44 @@ func (m *MemoryStore) ListIntegrationsByUser(userID string) []*UserIntegration {
 	return res
 }
 
+func (m *MemoryStore) GetIntegration(id string) *UserIntegration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.integrations[id]
+}
+
+func (m *MemoryStore) GetIntegrationByProvider(userID, provider string) *UserIntegration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, in := range m.integrations {
+		if in.UserID == userID && in.Provider == provider {
+			return in
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateIntegration(id string, f func(*UserIntegration)) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if in, ok := m.integrations[id]; ok {
+		f(in)
+		in.UpdatedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+func (m *MemoryStore) DeleteIntegration(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.integrations[id]; ok {
+		delete(m.integrations, id)
+		return true
+	}
+	return false
+}
+
 func (m *MemoryStore) CreateKnowledgeBase(kb *KnowledgeBase) *KnowledgeBase {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -452,6 +613,24 @@ func (m *MemoryStore) GetMCPServer(id string) *MCPServer {
 	return m.mcpServers[id]
 }
 
+// UpdateMCPServerHealth 写入健康检查结果
+// 不改 UpdatedAt（连接池按配置判断是否需要重连）；替换为新对象，避免改到调用方手里的指针
+func (m *MemoryStore) UpdateMCPServerHealth(id, status string, latencyMs int, lastErr string, checkedAt time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.mcpServers[id]
+	if !ok {
+		return false
+	}
+	cp := *old
+	cp.Status = status
+	cp.LatencyMs = latencyMs
+	cp.LastError = lastErr
+	cp.LastCheckedAt = &checkedAt
+	m.mcpServers[id] = &cp
+	return true
+}
+
 // ListAllMCPServers (可选，用于 List 时不传参的情况)
 func (m *MemoryStore) ListAllMCPServers() []*MCPServer {
 	m.mu.RLock()
@@ -510,6 +689,40 @@ func (m *MemoryStore) CreateMCPTool(t *MCPTool) *MCPTool {
 	return t
 }
 
+// UpsertMCPTool 按 (server_id, name) 插入或更新工具定义，已存在时保留原 ID
+func (m *MemoryStore) UpsertMCPTool(t *MCPTool) *MCPTool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if t.InputSchema == nil {
+		t.InputSchema = map[string]interface{}{}
+	}
+	for _, existing := range m.mcpTools {
+		if existing.ServerID == t.ServerID && existing.Name == t.Name {
+			existing.Description = t.Description
+			existing.InputSchema = t.InputSchema
+			existing.SideEffects = t.SideEffects
+			existing.UpdatedAt = now
+			return existing
+		}
+	}
+	t.ID = randID()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	m.mcpTools[t.ID] = t
+	return t
+}
+
+func (m *MemoryStore) DeleteMCPTool(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mcpTools[id]; ok {
+		delete(m.mcpTools, id)
+		return true
+	}
+	return false
+}
+
 func (m *MemoryStore) ListMCPToolsByServer(serverID string) []*MCPTool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -522,6 +735,94 @@ func (m *MemoryStore) ListMCPToolsByServer(serverID string) []*MCPTool {
 	return res
 }
 
+// UpsertMCPResource 按 (server_id, uri) 插入或更新，已存在时保留原 ID
+func (m *MemoryStore) UpsertMCPResource(r *MCPResource) *MCPResource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, existing := range m.mcpResources {
+		if existing.ServerID == r.ServerID && existing.URI == r.URI {
+			existing.Name = r.Name
+			existing.Description = r.Description
+			existing.MimeType = r.MimeType
+			existing.UpdatedAt = now
+			return existing
+		}
+	}
+	r.ID = randID()
+	r.CreatedAt = now
+	r.UpdatedAt = now
+	m.mcpResources[r.ID] = r
+	return r
+}
+
+func (m *MemoryStore) DeleteMCPResource(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mcpResources[id]; ok {
+		delete(m.mcpResources, id)
+		return true
+	}
+	return false
+}
+
+func (m *MemoryStore) ListMCPResourcesByServer(serverID string) []*MCPResource {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*MCPResource{}
+	for _, r := range m.mcpResources {
+		if r.ServerID == serverID {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// UpsertMCPPrompt 按 (server_id, name) 插入或更新，已存在时保留原 ID
+func (m *MemoryStore) UpsertMCPPrompt(p *MCPPrompt) *MCPPrompt {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if p.Arguments == nil {
+		p.Arguments = map[string]interface{}{}
+	}
+	for _, existing := range m.mcpPrompts {
+		if existing.ServerID == p.ServerID && existing.Name == p.Name {
+			existing.Description = p.Description
+			existing.Arguments = p.Arguments
+			existing.UpdatedAt = now
+			return existing
+		}
+	}
+	p.ID = randID()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	m.mcpPrompts[p.ID] = p
+	return p
+}
+
+func (m *MemoryStore) DeleteMCPPrompt(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mcpPrompts[id]; ok {
+		delete(m.mcpPrompts, id)
+		return true
+	}
+	return false
+}
+
+func (m *MemoryStore) ListMCPPromptsByServer(serverID string) []*MCPPrompt {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*MCPPrompt{}
+	for _, p := range m.mcpPrompts {
+		if p.ServerID == serverID {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
 func (m *MemoryStore) ListMCPToolsByAgent(agentID string) []*MCPTool {
 	//必须是显式绑定的server，globalserver不能直接加上来
 	m.mu.RLock()
@@ -598,6 +899,19 @@ func (m *MemoryStore) DeleteChatSession(id string) bool {
 	return false
 }
 
+// UpdateSessionSummary 替换会话的滚动摘要
+func (m *MemoryStore) UpdateSessionSummary(id, summary, upToMessageID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok {
+		s.Summary = summary
+		s.SummaryUpTo = upToMessageID
+		s.UpdatedAt = time.Now()
+		return true
+	}
+	return false
+}
+
 func (m *MemoryStore) CreateRun(r *Run) (*Run, error) {
 	//添加外键检查
 
@@ -630,6 +944,145 @@ func (m *MemoryStore) FinishRun(id string, output map[string]interface{}, status
 	return false
 }
 
+// UpdateRunStatus 只改状态（暂停 / 恢复），不结束 Run
+func (m *MemoryStore) UpdateRunStatus(id, status string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.runs[id]; ok {
+		r.Status = status
+		return true
+	}
+	return false
+}
+
+// ListQueuedRuns 排队中的 Run，先入队的在前
+func (m *MemoryStore) ListQueuedRuns(limit int) []*Run {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*Run{}
+	for _, r := range m.runs {
+		if r.Status == "queued" {
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].StartedAt.Before(res[j].StartedAt) })
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// ListRunsByStatus 某个状态的全部 Run（所有用户）
+func (m *MemoryStore) ListRunsByStatus(status string) []*Run {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*Run{}
+	for _, r := range m.runs {
+		if r.Status == status {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// ClaimRun queued -> running，started_at 改为开始执行的时间；Run 已被取走或取消时返回 false
+func (m *MemoryStore) ClaimRun(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.runs[id]; ok && r.Status == "queued" {
+		r.Status = "running"
+		r.StartedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// CancelQueuedRun 取消还没开始执行的 Run；已被取走时返回 false
+func (m *MemoryStore) CancelQueuedRun(id string, output map[string]interface{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.runs[id]; ok && r.Status == "queued" {
+		r.OutputPayload = output
+		r.Status = "cancelled"
+		r.FinishedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// UpdateRunUsage 整体替换 usage_metadata 和花费
+func (m *MemoryStore) UpdateRunUsage(id string, usage map[string]interface{}, cost float64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.runs[id]; ok {
+		r.UsageMetadata = usage
+		r.CostUSD = cost
+		return true
+	}
+	return false
+}
+
+func (m *MemoryStore) AggregateUsage(q UsageQuery) []*UsageBucket {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	buckets := map[UsageBucket]*UsageBucket{}
+	for _, r := range m.runs {
+		if (q.UserID != "" && r.UserID != q.UserID) || (q.AgentID != "" && r.AgentID != q.AgentID) || (q.APIKeyID != "" && r.APIKeyID != q.APIKeyID) ||
+			(!q.From.IsZero() && r.StartedAt.Before(q.From)) || (!q.To.IsZero() && !r.StartedAt.Before(q.To)) {
+			continue
+		}
+		var key UsageBucket
+		for _, g := range q.GroupBy {
+			switch g {
+			case "day":
+				key.Day = r.StartedAt.UTC().Format("2006-01-02")
+			case "agent":
+				key.AgentID = r.AgentID
+			case "user":
+				key.UserID = r.UserID
+			}
+		}
+		b := buckets[key]
+		if b == nil {
+			cp := key
+			b = &cp
+			buckets[key] = b
+		}
+		b.Runs++
+		b.LLMCalls += usageInt(r.UsageMetadata, "llm_calls")
+		b.PromptTokens += usageInt(r.UsageMetadata, "prompt_tokens")
+		b.CompletionTokens += usageInt(r.UsageMetadata, "completion_tokens")
+		b.TotalTokens += usageInt(r.UsageMetadata, "total_tokens")
+		b.CostUSD += r.CostUSD
+	}
+
+	res := make([]*UsageBucket, 0, len(buckets))
+	for _, b := range buckets {
+		res = append(res, b)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Day != res[j].Day {
+			return res[i].Day < res[j].Day
+		}
+		if res[i].AgentID != res[j].AgentID {
+			return res[i].AgentID < res[j].AgentID
+		}
+		return res[i].UserID < res[j].UserID
+	})
+	return res
+}
+
+// usageInt usage_metadata 里的数值（内存里是 int，经过 JSON 后是 float64）
+func usageInt(m JSONMap, key string) int {
+	switch v := m[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
 func (m *MemoryStore) ListRunsBySession(sessionID string) []*Run {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -757,3 +1210,158 @@ func (m *MemoryStore) ListChatMessagesByRun(runID string) []*ChatMessage {
 	}
 	return res
 }
+
+func (m *MemoryStore) CreateToolOutput(o *ToolOutput) *ToolOutput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o.ID = randID()
+	o.CreatedAt = time.Now()
+	m.toolOutputs[o.ID] = o
+	return o
+}
+
+func (m *MemoryStore) GetToolOutput(id string) *ToolOutput {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.toolOutputs[id]
+}
+
+func (m *MemoryStore) CreateToolApproval(a *ToolApproval) *ToolApproval {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.ID = randID()
+	a.CreatedAt = time.Now()
+	if a.Status == "" {
+		a.Status = "pending"
+	}
+	m.approvals[a.ID] = a
+	return a
+}
+
+func (m *MemoryStore) GetToolApproval(id string) *ToolApproval {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if a, ok := m.approvals[id]; ok {
+		cp := *a
+		return &cp
+	}
+	return nil
+}
+
+// FindToolApproval 某次工具调用的审批记录
+func (m *MemoryStore) FindToolApproval(runID, toolCallID string) *ToolApproval {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, a := range m.approvals {
+		if a.RunID == runID && a.ToolCallID == toolCallID {
+			cp := *a
+			return &cp
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListToolApprovalsByRun(runID string) []*ToolApproval {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*ToolApproval{}
+	for _, a := range m.approvals {
+		if a.RunID == runID {
+			cp := *a
+			res = append(res, &cp)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res
+}
+
+// ListToolApprovalsByUser status 为空时返回全部，按创建时间倒序
+func (m *MemoryStore) ListToolApprovalsByUser(userID, status string) []*ToolApproval {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*ToolApproval{}
+	for _, a := range m.approvals {
+		if a.UserID == userID && (status == "" || a.Status == status) {
+			cp := *a
+			res = append(res, &cp)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.After(res[j].CreatedAt)
+	})
+	return res
+}
+
+// DecideToolApproval 只有 pending 的审批能被决定，保证并发请求下只有一个生效
+func (m *MemoryStore) DecideToolApproval(id, status string, edited map[string]interface{}, reason string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.approvals[id]
+	if !ok || a.Status != "pending" {
+		return false
+	}
+	now := time.Now()
+	a.Status = status
+	a.EditedArguments = edited
+	a.Reason = reason
+	a.DecidedAt = &now
+	return true
+}
+
+// ==========================================
+// Spend Budget Implementation
+// ==========================================
+
+// UpsertSpendBudget 按 (scope, scope_id) 新建或覆盖
+func (m *MemoryStore) UpsertSpendBudget(b *SpendBudget) *SpendBudget {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	key := b.Scope + ":" + b.ScopeID
+	if existing, ok := m.budgets[key]; ok {
+		b.ID, b.CreatedAt = existing.ID, existing.CreatedAt
+	} else {
+		b.ID, b.CreatedAt = randID(), now
+	}
+	b.UpdatedAt = now
+	cp := *b
+	m.budgets[key] = &cp
+	return b
+}
+
+func (m *MemoryStore) GetSpendBudget(scope, scopeID string) *SpendBudget {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if b, ok := m.budgets[scope+":"+scopeID]; ok {
+		cp := *b
+		return &cp
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListSpendBudgets() []*SpendBudget {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := []*SpendBudget{}
+	for _, b := range m.budgets {
+		cp := *b
+		res = append(res, &cp)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res
+}
+
+func (m *MemoryStore) DeleteSpendBudget(scope, scopeID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := scope + ":" + scopeID
+	if _, ok := m.budgets[key]; !ok {
+		return false
+	}
+	delete(m.budgets, key)
+	return true
+}
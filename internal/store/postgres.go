@@ -2,6 +2,7 @@ package store
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,10 +32,15 @@ func NewPostgresStore(dsn string) (*PostgresStore, error) {
 		&Agent{},
 		&MCPServer{},
 		&MCPTool{},
+		&MCPResource{},
+		&MCPPrompt{},
 		&ChatSession{},
 		&Run{},
 		&RunStep{},
 		&ChatMessage{},
+		&ToolOutput{},
+		&ToolApproval{},
+		&SpendBudget{},
 	)
 	if err != nil {
 		return nil, fmt.Errorf("auto migrate failed: %w", err)
@@ -141,6 +147,19 @@ func (s *PostgresStore) GetAPIKey(id string) *APIKey {
 	return &key
 }
 
+func (s *PostgresStore) FindAPIKeyByHash(keyHash string) *APIKey {
+	var key APIKey
+	if err := s.db.Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		return nil
+	}
+	return &key
+}
+
+// TouchAPIKey 记录最近使用时间
+func (s *PostgresStore) TouchAPIKey(id string) {
+	s.db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", time.Now())
+}
+
 func (s *PostgresStore) DeleteAPIKey(id string) bool {
 	res := s.db.Where("id = ?", id).Delete(&APIKey{})
 	return res.Error == nil && res.RowsAffected > 0
@@ -186,6 +205,16 @@ func (s *PostgresStore) DeleteChatSession(id string) bool {
 	return res.Error == nil && res.RowsAffected > 0
 }
 
+// UpdateSessionSummary 替换会话的滚动摘要
+func (s *PostgresStore) UpdateSessionSummary(id, summary, upToMessageID string) bool {
+	res := s.db.Model(&ChatSession{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"summary":       summary,
+		"summary_up_to": upToMessageID,
+		"updated_at":    time.Now(),
+	})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
 // ==========================================
 // Run Implementation
 // ==========================================
@@ -228,6 +257,110 @@ func (s *PostgresStore) FinishRun(id string, output map[string]interface{}, stat
 	return res.Error == nil && res.RowsAffected > 0
 }
 
+// UpdateRunStatus 只改状态（暂停 / 恢复），不结束 Run
+func (s *PostgresStore) UpdateRunStatus(id, status string) bool {
+	res := s.db.Model(&Run{}).Where("id = ?", id).Update("status", status)
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// ListQueuedRuns 排队中的 Run，先入队的在前
+func (s *PostgresStore) ListQueuedRuns(limit int) []*Run {
+	var runs []*Run
+	q := s.db.Where("status = ?", "queued").Order("started_at asc")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	q.Find(&runs)
+	return runs
+}
+
+// ListRunsByStatus 某个状态的全部 Run（所有用户）
+func (s *PostgresStore) ListRunsByStatus(status string) []*Run {
+	var runs []*Run
+	s.db.Where("status = ?", status).Find(&runs)
+	return runs
+}
+
+// ClaimRun queued -> running，条件更新保证多个实例不会取到同一个 Run
+func (s *PostgresStore) ClaimRun(id string) bool {
+	res := s.db.Model(&Run{}).Where("id = ? AND status = ?", id, "queued").Updates(map[string]interface{}{
+		"status":     "running",
+		"started_at": time.Now(),
+	})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// CancelQueuedRun 取消还没开始执行的 Run；已被取走时返回 false
+func (s *PostgresStore) CancelQueuedRun(id string, output map[string]interface{}) bool {
+	res := s.db.Model(&Run{}).Where("id = ? AND status = ?", id, "queued").Updates(map[string]interface{}{
+		"output_payload": output,
+		"status":         "cancelled",
+		"finished_at":    time.Now(),
+	})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// UpdateRunUsage 整体替换 usage_metadata 和花费
+func (s *PostgresStore) UpdateRunUsage(id string, usage map[string]interface{}, cost float64) bool {
+	res := s.db.Model(&Run{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"usage_metadata": JSONMap(usage),
+		"cost_usd":       cost,
+	})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+func (s *PostgresStore) AggregateUsage(q UsageQuery) []*UsageBucket {
+	var groups []string
+	selects := []string{}
+	for _, g := range q.GroupBy {
+		switch g {
+		case "day":
+			selects = append(selects, "to_char(started_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day")
+			groups = append(groups, "day")
+		case "agent":
+			selects = append(selects, "COALESCE(agent_id::text, '') AS agent_id")
+			groups = append(groups, "agent_id")
+		case "user":
+			selects = append(selects, "COALESCE(user_id::text, '') AS user_id")
+			groups = append(groups, "user_id")
+		}
+	}
+	selects = append(selects,
+		"COUNT(*) AS runs",
+		"COALESCE(SUM((usage_metadata->>'llm_calls')::bigint), 0) AS llm_calls",
+		"COALESCE(SUM((usage_metadata->>'prompt_tokens')::bigint), 0) AS prompt_tokens",
+		"COALESCE(SUM((usage_metadata->>'completion_tokens')::bigint), 0) AS completion_tokens",
+		"COALESCE(SUM((usage_metadata->>'total_tokens')::bigint), 0) AS total_tokens",
+		"COALESCE(SUM(cost_usd), 0) AS cost_usd",
+	)
+
+	tx := s.db.Model(&Run{}).Select(strings.Join(selects, ", "))
+	if q.UserID != "" {
+		tx = tx.Where("user_id = ?", q.UserID)
+	}
+	if q.AgentID != "" {
+		tx = tx.Where("agent_id = ?", q.AgentID)
+	}
+	if q.APIKeyID != "" {
+		tx = tx.Where("api_key_id = ?", q.APIKeyID)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("started_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("started_at < ?", q.To)
+	}
+	if len(groups) > 0 {
+		tx = tx.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+	}
+
+	var res []*UsageBucket
+	if err := tx.Scan(&res).Error; err != nil {
+		return []*UsageBucket{}
+	}
+	return res
+}
+
 func (s *PostgresStore) ListRunsBySession(sessionID string) []*Run {
 	var runs []*Run
 	s.db.Where("session_id = ?", sessionID).Order("started_at desc").Find(&runs)
@@ -320,6 +453,29 @@ func (s *PostgresStore) ListChatMessagesByRun(runID string) []*ChatMessage {
 	return msgs
 }
 
+// ==========================================
+// Tool Output Implementation
+// ==========================================
+
+func (s *PostgresStore) CreateToolOutput(o *ToolOutput) *ToolOutput {
+	if o.ID == "" {
+		o.ID = uuid.New().String()
+	}
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = time.Now()
+	}
+	s.db.Create(o)
+	return o
+}
+
+func (s *PostgresStore) GetToolOutput(id string) *ToolOutput {
+	var o ToolOutput
+	if err := s.db.Where("id = ?", id).First(&o).Error; err != nil {
+		return nil
+	}
+	return &o
+}
+
 // ==========================================
 // Agent Implementation
 // ==========================================
@@ -411,6 +567,37 @@ func (s *PostgresStore) ListIntegrationsByUser(userID string) []*UserIntegration
 	return ins
 }
 
+func (s *PostgresStore) GetIntegration(id string) *UserIntegration {
+	var in UserIntegration
+	if err := s.db.Where("id = ?", id).First(&in).Error; err != nil {
+		return nil
+	}
+	return &in
+}
+
+func (s *PostgresStore) GetIntegrationByProvider(userID, provider string) *UserIntegration {
+	var in UserIntegration
+	if err := s.db.Where("user_id = ? AND provider = ?", userID, provider).First(&in).Error; err != nil {
+		return nil
+	}
+	return &in
+}
+
+func (s *PostgresStore) UpdateIntegration(id string, f func(*UserIntegration)) bool {
+	var in UserIntegration
+	if err := s.db.Where("id = ?", id).First(&in).Error; err != nil {
+		return false
+	}
+	f(&in)
+	in.UpdatedAt = time.Now()
+	return s.db.Save(&in).Error == nil
+}
+
+func (s *PostgresStore) DeleteIntegration(id string) bool {
+	res := s.db.Where("id = ?", id).Delete(&UserIntegration{})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
 // ==========================================
 // KnowledgeBase Implementation
 // ==========================================
@@ -498,6 +685,17 @@ func (s *PostgresStore) GetMCPServer(id string) *MCPServer {
 	return &ms
 }
 
+// UpdateMCPServerHealth 用 UpdateColumns，不刷新 updated_at
+func (s *PostgresStore) UpdateMCPServerHealth(id, status string, latencyMs int, lastErr string, checkedAt time.Time) bool {
+	res := s.db.Model(&MCPServer{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"status":          status,
+		"latency_ms":      latencyMs,
+		"last_error":      lastErr,
+		"last_checked_at": checkedAt,
+	})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
 func (s *PostgresStore) ListAllMCPServers() []*MCPServer {
 	var servers []*MCPServer
 	s.db.Find(&servers)
@@ -551,12 +749,101 @@ func (s *PostgresStore) CreateMCPTool(t *MCPTool) *MCPTool {
 	return t
 }
 
+// UpsertMCPTool 按 (server_id, name) 插入或更新
+// 注意：AutoMigrate 建的表上没有 (server_id, name) 唯一索引，所以这里先查再写，而不是 ON CONFLICT
+func (s *PostgresStore) UpsertMCPTool(t *MCPTool) *MCPTool {
+	var existing MCPTool
+	if err := s.db.Where("server_id = ? AND name = ?", t.ServerID, t.Name).First(&existing).Error; err != nil {
+		return s.CreateMCPTool(t)
+	}
+	if t.InputSchema == nil {
+		t.InputSchema = make(map[string]interface{})
+	}
+	existing.Description = t.Description
+	existing.InputSchema = t.InputSchema
+	existing.SideEffects = t.SideEffects
+	existing.UpdatedAt = time.Now()
+	s.db.Save(&existing)
+	return &existing
+}
+
+func (s *PostgresStore) DeleteMCPTool(id string) bool {
+	res := s.db.Where("id = ?", id).Delete(&MCPTool{})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
 func (s *PostgresStore) ListMCPToolsByServer(serverID string) []*MCPTool {
 	var tools []*MCPTool
 	s.db.Where("server_id = ?", serverID).Find(&tools)
 	return tools
 }
 
+// UpsertMCPResource 按 (server_id, uri) 插入或更新，同 UpsertMCPTool 先查再写
+func (s *PostgresStore) UpsertMCPResource(r *MCPResource) *MCPResource {
+	now := time.Now()
+	var existing MCPResource
+	if err := s.db.Where("server_id = ? AND uri = ?", r.ServerID, r.URI).First(&existing).Error; err != nil {
+		if r.ID == "" {
+			r.ID = uuid.New().String()
+		}
+		r.CreatedAt = now
+		r.UpdatedAt = now
+		s.db.Create(r)
+		return r
+	}
+	existing.Name = r.Name
+	existing.Description = r.Description
+	existing.MimeType = r.MimeType
+	existing.UpdatedAt = now
+	s.db.Save(&existing)
+	return &existing
+}
+
+func (s *PostgresStore) DeleteMCPResource(id string) bool {
+	res := s.db.Where("id = ?", id).Delete(&MCPResource{})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+func (s *PostgresStore) ListMCPResourcesByServer(serverID string) []*MCPResource {
+	var resources []*MCPResource
+	s.db.Where("server_id = ?", serverID).Find(&resources)
+	return resources
+}
+
+// UpsertMCPPrompt 按 (server_id, name) 插入或更新
+func (s *PostgresStore) UpsertMCPPrompt(p *MCPPrompt) *MCPPrompt {
+	now := time.Now()
+	if p.Arguments == nil {
+		p.Arguments = make(map[string]interface{})
+	}
+	var existing MCPPrompt
+	if err := s.db.Where("server_id = ? AND name = ?", p.ServerID, p.Name).First(&existing).Error; err != nil {
+		if p.ID == "" {
+			p.ID = uuid.New().String()
+		}
+		p.CreatedAt = now
+		p.UpdatedAt = now
+		s.db.Create(p)
+		return p
+	}
+	existing.Description = p.Description
+	existing.Arguments = p.Arguments
+	existing.UpdatedAt = now
+	s.db.Save(&existing)
+	return &existing
+}
+
+func (s *PostgresStore) DeleteMCPPrompt(id string) bool {
+	res := s.db.Where("id = ?", id).Delete(&MCPPrompt{})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+func (s *PostgresStore) ListMCPPromptsByServer(serverID string) []*MCPPrompt {
+	var prompts []*MCPPrompt
+	s.db.Where("server_id = ?", serverID).Find(&prompts)
+	return prompts
+}
+
 func (s *PostgresStore) ListMCPToolsByAgent(agentID string) []*MCPTool {
 	// GORM Join Query
 	// SELECT t.* FROM mcp_tools t JOIN mcp_servers s ON t.server_id = s.id WHERE s.agent_id = ?
@@ -584,3 +871,105 @@ func (s *PostgresStore) FindMCPToolByName(name string) *MCPTool {
 	}
 	return &t
 }
+
+// ==========================================
+// Tool Approval Implementation
+// ==========================================
+
+func (s *PostgresStore) CreateToolApproval(a *ToolApproval) *ToolApproval {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	if a.Status == "" {
+		a.Status = "pending"
+	}
+	s.db.Create(a)
+	return a
+}
+
+func (s *PostgresStore) GetToolApproval(id string) *ToolApproval {
+	var a ToolApproval
+	if err := s.db.Where("id = ?", id).First(&a).Error; err != nil {
+		return nil
+	}
+	return &a
+}
+
+func (s *PostgresStore) FindToolApproval(runID, toolCallID string) *ToolApproval {
+	var a ToolApproval
+	if err := s.db.Where("run_id = ? AND tool_call_id = ?", runID, toolCallID).First(&a).Error; err != nil {
+		return nil
+	}
+	return &a
+}
+
+func (s *PostgresStore) ListToolApprovalsByRun(runID string) []*ToolApproval {
+	var list []*ToolApproval
+	s.db.Where("run_id = ?", runID).Order("created_at asc").Find(&list)
+	return list
+}
+
+func (s *PostgresStore) ListToolApprovalsByUser(userID, status string) []*ToolApproval {
+	var list []*ToolApproval
+	q := s.db.Where("user_id = ?", userID)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	q.Order("created_at desc").Find(&list)
+	return list
+}
+
+// DecideToolApproval 带 status = 'pending' 条件更新，并发请求只有一个生效
+func (s *PostgresStore) DecideToolApproval(id, status string, edited map[string]interface{}, reason string) bool {
+	res := s.db.Model(&ToolApproval{}).Where("id = ? AND status = ?", id, "pending").Updates(map[string]interface{}{
+		"status":           status,
+		"edited_arguments": JSONMap(edited),
+		"reason":           reason,
+		"decided_at":       time.Now(),
+	})
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// ==========================================
+// Spend Budget Implementation
+// ==========================================
+
+// UpsertSpendBudget 按 (scope, scope_id) 新建或覆盖
+// 注意：AutoMigrate 建的表上没有唯一索引，所以先查再写，同 UpsertMCPTool
+func (s *PostgresStore) UpsertSpendBudget(b *SpendBudget) *SpendBudget {
+	now := time.Now()
+	b.UpdatedAt = now
+	if existing := s.GetSpendBudget(b.Scope, b.ScopeID); existing != nil {
+		b.ID, b.CreatedAt = existing.ID, existing.CreatedAt
+		s.db.Save(b)
+		return b
+	}
+	if b.ID == "" {
+		b.ID = uuid.New().String()
+	}
+	b.CreatedAt = now
+	s.db.Create(b)
+	return b
+}
+
+func (s *PostgresStore) GetSpendBudget(scope, scopeID string) *SpendBudget {
+	var b SpendBudget
+	if err := s.db.Where("scope = ? AND scope_id = ?", scope, scopeID).First(&b).Error; err != nil {
+		return nil
+	}
+	return &b
+}
+
+func (s *PostgresStore) ListSpendBudgets() []*SpendBudget {
+	var list []*SpendBudget
+	s.db.Order("created_at asc").Find(&list)
+	return list
+}
+
+func (s *PostgresStore) DeleteSpendBudget(scope, scopeID string) bool {
+	res := s.db.Where("scope = ? AND scope_id = ?", scope, scopeID).Delete(&SpendBudget{})
+	return res.Error == nil && res.RowsAffected > 0
+}
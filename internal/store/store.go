@@ -1,5 +1,7 @@
 package store
 
+import "time"
+
 type Store interface {
 	RandToken() string
 
@@ -21,9 +23,15 @@ type Store interface {
 	ListAPIKeysByUser(userID string) []*APIKey
 	DeleteAPIKey(id string) bool
 	GetAPIKey(id string) *APIKey
+	FindAPIKeyByHash(keyHash string) *APIKey
+	TouchAPIKey(id string)
 
 	CreateIntegration(in *UserIntegration) *UserIntegration
 	ListIntegrationsByUser(userID string) []*UserIntegration
+	GetIntegration(id string) *UserIntegration
+	GetIntegrationByProvider(userID, provider string) *UserIntegration
+	UpdateIntegration(id string, f func(*UserIntegration)) bool
+	DeleteIntegration(id string) bool
 
 	CreateKnowledgeBase(kb *KnowledgeBase) *KnowledgeBase
 	GetKnowledgeBase(id string) *KnowledgeBase
@@ -36,20 +44,39 @@ type Store interface {
 	ListGlobalMCPServers() []*MCPServer
 	GetMCPServer(id string) *MCPServer
 	FindMCPServerByName(agentID string, name string) *MCPServer
+	UpdateMCPServerHealth(id, status string, latencyMs int, lastErr string, checkedAt time.Time) bool
 
 	CreateMCPTool(t *MCPTool) *MCPTool
+	UpsertMCPTool(t *MCPTool) *MCPTool
+	DeleteMCPTool(id string) bool
 	ListMCPToolsByServer(serverID string) []*MCPTool
 	ListMCPToolsByAgent(agentID string) []*MCPTool
 	ListGlobalMCPTools() []*MCPTool
 	FindMCPToolByName(name string) *MCPTool
 
+	UpsertMCPResource(r *MCPResource) *MCPResource
+	DeleteMCPResource(id string) bool
+	ListMCPResourcesByServer(serverID string) []*MCPResource
+
+	UpsertMCPPrompt(p *MCPPrompt) *MCPPrompt
+	DeleteMCPPrompt(id string) bool
+	ListMCPPromptsByServer(serverID string) []*MCPPrompt
+
 	CreateChatSession(s *ChatSession) *ChatSession
 	ListChatSessionsByUser(userID string) []*ChatSession
 	GetChatSession(id string) *ChatSession
 	DeleteChatSession(id string) bool
+	UpdateSessionSummary(id, summary, upToMessageID string) bool
 
 	CreateRun(r *Run) (*Run, error)
 	FinishRun(id string, output map[string]interface{}, status string) bool
+	UpdateRunStatus(id, status string) bool
+	UpdateRunUsage(id string, usage map[string]interface{}, cost float64) bool
+	AggregateUsage(q UsageQuery) []*UsageBucket
+	ListQueuedRuns(limit int) []*Run
+	ListRunsByStatus(status string) []*Run
+	ClaimRun(id string) bool
+	CancelQueuedRun(id string, output map[string]interface{}) bool
 	ListRunsBySession(sessionID string) []*Run
 	ListRunsByUser(userID string) []*Run
 	GetRun(runID string) *Run
@@ -62,6 +89,21 @@ type Store interface {
 	CreateChatMessage(cm *ChatMessage) *ChatMessage
 	ListChatMessagesBySession(sessionID string) []*ChatMessage
 	ListChatMessagesByRun(runID string) []*ChatMessage
+
+	CreateToolOutput(o *ToolOutput) *ToolOutput
+	GetToolOutput(id string) *ToolOutput
+
+	CreateToolApproval(a *ToolApproval) *ToolApproval
+	GetToolApproval(id string) *ToolApproval
+	FindToolApproval(runID, toolCallID string) *ToolApproval
+	ListToolApprovalsByRun(runID string) []*ToolApproval
+	ListToolApprovalsByUser(userID, status string) []*ToolApproval
+	DecideToolApproval(id, status string, edited map[string]interface{}, reason string) bool
+
+	UpsertSpendBudget(b *SpendBudget) *SpendBudget
+	GetSpendBudget(scope, scopeID string) *SpendBudget
+	ListSpendBudgets() []*SpendBudget
+	DeleteSpendBudget(scope, scopeID string) bool
 }
 
 var current Store
@@ -82,10 +124,15 @@ func NewMemoryStore() *MemoryStore {
 		agents:       make(map[string]*Agent),
 		mcpServers:   make(map[string]*MCPServer),
 		mcpTools:     make(map[string]*MCPTool),
+		mcpResources: make(map[string]*MCPResource),
+		mcpPrompts:   make(map[string]*MCPPrompt),
 		sessions:     make(map[string]*ChatSession),
 		runs:         make(map[string]*Run),
 		runSteps:     make(map[string]*RunStep),
 		messages:     make(map[string]*ChatMessage),
+		toolOutputs:  make(map[string]*ToolOutput),
+		approvals:    make(map[string]*ToolApproval),
+		budgets:      make(map[string]*SpendBudget),
 	}
 }
 
@@ -43,6 +43,17 @@ func Created(ctx *app.RequestContext, data interface{}) {
 	})
 }
 
+// Accepted 已受理、异步处理 (HTTP 202 Accepted)
+func Accepted(ctx *app.RequestContext, data interface{}) {
+	rid := ctx.Response.Header.Get("X-Request-ID")
+	ctx.JSON(http.StatusAccepted, &Response{
+		Code:      0,
+		Message:   "accepted",
+		Data:      data,
+		RequestID: rid,
+	})
+}
+
 // Error 错误响应 (自定义 HTTP 状态码)
 func Error(ctx *app.RequestContext, httpStatus int, bizCode int, message string) {
 	rid := ctx.Response.Header.Get("X-Request-ID")
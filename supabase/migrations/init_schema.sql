@@ -107,7 +107,7 @@ CREATE TABLE agents (
     
     -- 模型配置
     model_name TEXT NOT NULL DEFAULT 'gpt-4o',
-    system_prompt TEXT,
+    temperature FLOAT, -- NULL 表示用 Provider 的默认温度，0 是有效取值
     temperature FLOAT DEFAULT 0.7,
     
     -- 关联的知识库 IDs (Array of UUIDs)
@@ -138,7 +138,11 @@ CREATE TABLE mcp_servers (
     connection_config JSONB NOT NULL, 
     
     is_global BOOLEAN DEFAULT FALSE, -- 是否为平台公共服务
-    status TEXT DEFAULT 'active' CHECK (status IN ('active', 'maintenance', 'disabled')),
+    -- 健康检查维护：active / degraded / disconnected
+    status TEXT DEFAULT 'active' CHECK (status IN ('active', 'degraded', 'disconnected', 'maintenance', 'disabled')),
+    latency_ms INT DEFAULT 0,
+    last_error TEXT DEFAULT '',
+    last_checked_at TIMESTAMPTZ,
     
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
@@ -152,6 +156,36 @@ CREATE TABLE mcp_tools (
     name TEXT NOT NULL, -- e.g. "git_commit"
     description TEXT,
     input_schema JSONB NOT NULL, -- JSON Schema
+    side_effects BOOLEAN NOT NULL DEFAULT FALSE, -- 有副作用的工具不与其它调用并行执行
+    
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    
+    UNIQUE(server_id, name)
+);
+
+-- MCP Resources (资源缓存)
+CREATE TABLE mcp_resources (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    server_id UUID NOT NULL REFERENCES mcp_servers(id) ON DELETE CASCADE,
+    uri TEXT NOT NULL, -- e.g. "file:///docs/guide.md"
+    name TEXT,
+    description TEXT,
+    mime_type TEXT,
+    
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    
+    UNIQUE(server_id, uri)
+);
+
+-- MCP Prompts (提示模板缓存)
+CREATE TABLE mcp_prompts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    server_id UUID NOT NULL REFERENCES mcp_servers(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT,
+    arguments JSONB DEFAULT '{}'::jsonb, -- 参数名 -> {"description", "required"}
     
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
@@ -170,7 +204,8 @@ CREATE TABLE chat_sessions (
     agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
     
     title TEXT,
-    summary TEXT, -- 长对话压缩后的摘要 (Memory)
+    summary TEXT, -- 长对话压缩后的摘要 (Memory)，超出模型上下文窗口时由 Runner 滚动更新
+    summary_up_to TEXT, -- summary 覆盖到的最后一条消息 ID（不设外键），之后的消息原样发送给模型
     
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
@@ -187,14 +222,16 @@ CREATE TABLE runs (
     parent_run_id UUID REFERENCES runs(id) ON DELETE SET NULL, -- 创建这个agent的父节点
     trace_id UUID NOT NULL, -- 全链路共享ID
     
-    status TEXT NOT NULL CHECK (status IN ('queued', 'running', 'succeeded', 'failed', 'cancelled')),
+    status TEXT NOT NULL CHECK (status IN ('queued', 'running', 'awaiting_approval', 'succeeded', 'failed', 'cancelled', 'budget_exceeded')),
     
     -- 输入输出与成本
     input_payload JSONB,  -- 父agent传进来的参数
     output_payload JSONB, -- 子agent返回给父agent的结果
-    usage_metadata JSONB DEFAULT '{}', -- 【新增建议】存Token消耗 {"total": 500}
+    usage_metadata JSONB DEFAULT '{}', -- Token 用量：{"prompt_tokens", "completion_tokens", "total_tokens", "llm_calls", "cost_usd", "by_model", "children", "rollup"}
+    cost_usd NUMERIC(14, 6) NOT NULL DEFAULT 0, -- 本 Run 自己的花费（不含子 Run），用于统计和预算
+    api_key_id UUID REFERENCES api_keys(id) ON DELETE SET NULL, -- 经 API Key（MCP）发起时记录，子 Run 沿用
     
-    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(), -- 排队时为入队时间，开始执行时更新
     finished_at TIMESTAMPTZ
 );
 
@@ -210,7 +247,7 @@ CREATE TABLE run_steps (
     input_payload JSONB,
     output_payload JSONB,
     
-    status TEXT DEFAULT 'completed',
+    status TEXT DEFAULT 'completed', -- completed / failed / invalid_arguments（参数未通过 Schema 校验，工具未执行）/ rejected（审批被拒）/ denied（工具权限策略拒绝）
     error_message TEXT,
     latency_ms INT, 
     
@@ -234,6 +271,53 @@ CREATE TABLE chat_messages (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- 5. 超出长度上限的工具输出全文（对话历史里只保留截断后的前缀）
+CREATE TABLE tool_outputs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id UUID REFERENCES chat_sessions(id) ON DELETE CASCADE,
+    run_id UUID REFERENCES runs(id) ON DELETE SET NULL,
+    tool_call_id TEXT,
+    tool_name TEXT,
+    content TEXT NOT NULL,
+    size INT NOT NULL, -- 字符数
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- 6. 需要人工审批的工具调用（Run 在此暂停，审批后从该调用继续）
+CREATE TABLE tool_approvals (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    run_id UUID NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+    session_id UUID REFERENCES chat_sessions(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    agent_id UUID REFERENCES agents(id) ON DELETE SET NULL,
+    server_id UUID REFERENCES mcp_servers(id) ON DELETE SET NULL,
+    tool_call_id TEXT NOT NULL,
+    tool_name TEXT NOT NULL,
+    arguments JSONB,
+    edited_arguments JSONB,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'edited', 'rejected', 'cancelled')),
+    reason TEXT,
+    state JSONB, -- 暂停时的预算消耗
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    decided_at TIMESTAMPTZ,
+
+    UNIQUE(run_id, tool_call_id)
+);
+
+-- 花费预算：每日 / 每月上限（美元，UTC 自然日 / 月），0 表示不限制
+CREATE TABLE spend_budgets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    scope TEXT NOT NULL CHECK (scope IN ('user', 'api_key', 'agent')),
+    scope_id UUID NOT NULL,
+    daily_usd NUMERIC(14, 6) NOT NULL DEFAULT 0,
+    monthly_usd NUMERIC(14, 6) NOT NULL DEFAULT 0,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    UNIQUE(scope, scope_id)
+);
+
 -- =============================================================================
 -- F. Indexes & Triggers
 -- =============================================================================
@@ -243,6 +327,7 @@ CREATE TRIGGER update_users_modtime BEFORE UPDATE ON users FOR EACH ROW EXECUTE
 CREATE TRIGGER update_agents_modtime BEFORE UPDATE ON agents FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
 CREATE TRIGGER update_mcp_servers_modtime BEFORE UPDATE ON mcp_servers FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
 CREATE TRIGGER update_chat_sessions_modtime BEFORE UPDATE ON chat_sessions FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
+CREATE TRIGGER update_spend_budgets_modtime BEFORE UPDATE ON spend_budgets FOR EACH ROW EXECUTE PROCEDURE update_updated_at_column();
 
 -- 2. 性能索引
 -- Users
@@ -270,6 +355,11 @@ CREATE INDEX idx_runs_user_status ON runs(user_id, status);
 CREATE INDEX idx_run_steps_run_id ON run_steps(run_id, created_at ASC);
 CREATE INDEX idx_runs_parent ON runs(parent_run_id);
 CREATE INDEX idx_runs_trace ON runs(trace_id);
+CREATE INDEX idx_runs_queued ON runs(started_at) WHERE status = 'queued'; -- worker 按入队顺序取任务
+CREATE INDEX idx_runs_user_started ON runs(user_id, started_at); -- 按用户、日期统计用量
+CREATE INDEX idx_runs_agent_started ON runs(agent_id, started_at); -- Agent 预算
+CREATE INDEX idx_runs_api_key_started ON runs(api_key_id, started_at) WHERE api_key_id IS NOT NULL; -- API Key 预算
+CREATE INDEX idx_tool_approvals_user_status ON tool_approvals(user_id, status);
 
 -- JSONB GIN 索引
 CREATE INDEX idx_run_steps_input ON run_steps USING gin(input_payload);